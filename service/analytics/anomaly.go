@@ -0,0 +1,171 @@
+// file: service/analytics/anomaly.go
+// Streaming models that score float values against their expected behaviour
+package analytics
+
+import (
+	"math"
+	"time"
+)
+
+// Anomaly model kinds.
+const (
+	ModelEWMA     = "ewma"
+	ModelZScore   = "zscore"
+	ModelSeasonal = "seasonal"
+)
+
+const (
+	// anomalyWarmup is how many values the EWMA and rolling z-score models
+	// learn before they score.
+	anomalyWarmup = 30
+	// seasonalWarmup is how many values a seasonal slot learns before it
+	// scores.
+	seasonalWarmup = 10
+)
+
+// AnomalyModel learns the behaviour of a stream of values and scores new
+// values against it.
+type AnomalyModel interface {
+	// Expect returns the value expected at t and its standard deviation,
+	// or false while the model has too little data.
+	Expect(t time.Time) (expected, sigma float64, ok bool)
+	// Learn adds the value v seen at t to the model.
+	Learn(t time.Time, v float64)
+}
+
+// AnomalyScore returns how many standard deviations v lies from expected,
+// with sigma raised to minSigma. It returns false if the deviation cannot
+// be scaled because sigma is zero.
+func AnomalyScore(v, expected, sigma, minSigma float64) (float64, bool) {
+	sigma = math.Max(sigma, minSigma)
+	if sigma <= 0 {
+		return 0, false
+	}
+	return (v - expected) / sigma, true
+}
+
+// EWMA expects the exponentially weighted moving average of past values,
+// with an exponentially weighted variance. Alpha is the weight of each new
+// value.
+type EWMA struct {
+	alpha    float64
+	n        int
+	mean     float64
+	variance float64
+}
+
+// NewEWMA returns an EWMA model giving each new value the weight alpha.
+func NewEWMA(alpha float64) *EWMA {
+	return &EWMA{alpha: alpha}
+}
+
+// Expect implements AnomalyModel.
+func (m *EWMA) Expect(time.Time) (float64, float64, bool) {
+	return m.mean, math.Sqrt(m.variance), m.n >= anomalyWarmup
+}
+
+// Learn implements AnomalyModel.
+func (m *EWMA) Learn(_ time.Time, v float64) {
+	if m.n == 0 {
+		m.mean = v
+	}
+	diff := v - m.mean
+	m.mean += m.alpha * diff
+	m.variance = (1 - m.alpha) * (m.variance + m.alpha*diff*diff)
+	m.n++
+}
+
+// RollingZScore expects the mean of the last window values, with their
+// standard deviation.
+type RollingZScore struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+// NewRollingZScore returns a rolling z-score model over window values.
+func NewRollingZScore(window int) *RollingZScore {
+	return &RollingZScore{values: make([]float64, window)}
+}
+
+// Expect implements AnomalyModel.
+func (m *RollingZScore) Expect(time.Time) (float64, float64, bool) {
+	n := m.next
+	if m.full {
+		n = len(m.values)
+	}
+	if n < min(anomalyWarmup, len(m.values)) || n < 2 {
+		return 0, 0, false
+	}
+	var sum float64
+	for _, v := range m.values[:n] {
+		sum += v
+	}
+	mean := sum / float64(n)
+	var squares float64
+	for _, v := range m.values[:n] {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(n-1)), true
+}
+
+// Learn implements AnomalyModel.
+func (m *RollingZScore) Learn(_ time.Time, v float64) {
+	m.values[m.next] = v
+	m.next++
+	if m.next == len(m.values) {
+		m.next, m.full = 0, true
+	}
+}
+
+// Seasonal expects the mean of past values seen at the same point of a
+// repeating period, such as the same quarter hour of the day. The period is
+// split into slots of equal width aligned to the Unix epoch, and each slot
+// keeps the mean and variance of every value it has seen.
+type Seasonal struct {
+	period time.Duration
+	slot   time.Duration
+	slots  []welford
+}
+
+// welford accumulates a mean and variance one value at a time.
+type welford struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func (w *welford) add(v float64) {
+	w.n++
+	diff := v - w.mean
+	w.mean += diff / float64(w.n)
+	w.m2 += diff * (v - w.mean)
+}
+
+// NewSeasonal returns a seasonal model of period split into slots of width
+// slot. period must be a whole multiple of slot.
+func NewSeasonal(period, slot time.Duration) *Seasonal {
+	return &Seasonal{period: period, slot: slot, slots: make([]welford, period/slot)}
+}
+
+func (m *Seasonal) slotAt(t time.Time) *welford {
+	offset := time.Duration(t.UnixNano() % int64(m.period))
+	if offset < 0 {
+		offset += m.period
+	}
+	return &m.slots[offset/m.slot]
+}
+
+// Expect implements AnomalyModel.
+func (m *Seasonal) Expect(t time.Time) (float64, float64, bool) {
+	w := m.slotAt(t)
+	if w.n < seasonalWarmup {
+		return 0, 0, false
+	}
+	return w.mean, math.Sqrt(w.m2 / float64(w.n-1)), true
+}
+
+// Learn implements AnomalyModel.
+func (m *Seasonal) Learn(t time.Time, v float64) {
+	m.slotAt(t).add(v)
+}
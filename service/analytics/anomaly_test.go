@@ -0,0 +1,101 @@
+// file: service/analytics/anomaly_test.go
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAnomalyScore(t *testing.T) {
+	tests := []struct {
+		name                      string
+		v, expected, sigma, floor float64
+		want                      float64
+		wantOK                    bool
+	}{
+		{name: "above", v: 12, expected: 10, sigma: 1, want: 2, wantOK: true},
+		{name: "below", v: 8, expected: 10, sigma: 1, want: -2, wantOK: true},
+		{name: "sigma raised to the floor", v: 12, expected: 10, sigma: 0.5, floor: 2, want: 1, wantOK: true},
+		{name: "floor below sigma", v: 12, expected: 10, sigma: 2, floor: 1, want: 1, wantOK: true},
+		{name: "zero sigma", v: 12, expected: 10},
+		{name: "zero sigma on the expected value", v: 10, expected: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AnomalyScore(tt.v, tt.expected, tt.sigma, tt.floor)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got %g, %v; want %g, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// expect checks what m expects at t.
+func expect(t *testing.T, name string, m AnomalyModel, at time.Time, wantOK bool, mean, sigma float64) {
+	t.Helper()
+	got, s, ok := m.Expect(at)
+	if ok != wantOK || ok && (math.Abs(got-mean) > 1e-9 || math.Abs(s-sigma) > 1e-9) {
+		t.Errorf("%s: expected %g ± %g, %v; want %g ± %g, %v", name, got, s, ok, mean, sigma, wantOK)
+	}
+}
+
+func TestEWMA(t *testing.T) {
+	m := NewEWMA(0.5)
+	for i := 0; i < anomalyWarmup-1; i++ {
+		m.Learn(t0, 10)
+	}
+	expect(t, "warming up", m, t0, false, 0, 0)
+	m.Learn(t0, 10)
+	expect(t, "steady", m, t0, true, 10, 0)
+	// diff 10: mean 10 + 0.5*10, variance 0.5 * (0 + 0.5*100).
+	m.Learn(t0, 20)
+	expect(t, "step", m, t0, true, 15, 5)
+}
+
+func TestRollingZScore(t *testing.T) {
+	m := NewRollingZScore(3)
+	m.Learn(t0, 1)
+	m.Learn(t0, 2)
+	expect(t, "short window warming up", m, t0, false, 0, 0)
+	m.Learn(t0, 3)
+	expect(t, "full", m, t0, true, 2, 1)
+	// 6 replaces the oldest value, 1.
+	m.Learn(t0, 6)
+	expect(t, "rolled", m, t0, true, 11.0/3, math.Sqrt(13.0/3))
+
+	long := NewRollingZScore(100)
+	for i := 0; i < anomalyWarmup-1; i++ {
+		long.Learn(t0, float64(i%2))
+	}
+	expect(t, "long window warming up", long, t0, false, 0, 0)
+	long.Learn(t0, 1)
+	expect(t, "long window after warmup", long, t0, true, 0.5, math.Sqrt(30*0.25/29))
+
+	one := NewRollingZScore(1)
+	one.Learn(t0, 5)
+	expect(t, "window of one", one, t0, false, 0, 0)
+}
+
+func TestSeasonal(t *testing.T) {
+	m := NewSeasonal(time.Hour, 15*time.Minute)
+	// t0 is on the hour, so each value lands in the first slot.
+	for k := 1; k <= seasonalWarmup; k++ {
+		if k == seasonalWarmup {
+			expect(t, "slot warming up", m, t0, false, 0, 0)
+		}
+		m.Learn(t0.Add(time.Duration(k)*time.Hour), float64(k))
+	}
+	// 1..10 has mean 5.5 and sample variance 55/6.
+	expect(t, "same slot", m, t0.Add(14*time.Minute+59*time.Second), true, 5.5, math.Sqrt(55.0/6))
+	expect(t, "next slot", m, t0.Add(15*time.Minute), false, 0, 0)
+	expect(t, "a period later", m, t0.Add(-5*time.Hour), true, 5.5, math.Sqrt(55.0/6))
+
+	// Before the epoch, a minute to the hour is still the last slot.
+	before := NewSeasonal(time.Hour, 15*time.Minute)
+	for k := 0; k < seasonalWarmup; k++ {
+		before.Learn(time.Unix(-60, 0), 7)
+	}
+	expect(t, "before the epoch", before, time.Unix(3600-60, 0), true, 7, 0)
+	expect(t, "before the epoch, first slot", before, time.Unix(0, 0), false, 0, 0)
+}
@@ -0,0 +1,105 @@
+// file: service/analytics/booleans.go
+// Time-weighted statistics of boolean fields
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// BooleanStats is the time a boolean field spent true over a range. Time
+// before the field's first known value is left out.
+type BooleanStats struct {
+	PercentTrue  float64 `json:"percent_true"`
+	SecondsTrue  float64 `json:"seconds_true"`
+	SecondsKnown float64 `json:"seconds_known"`
+	// Rises counts the times the field became true, including a field
+	// already true at the start of the range.
+	Rises int `json:"rises"`
+}
+
+// BooleanStats returns the time-weighted statistics of each boolean field of
+// h with a known state, taking each value to hold until the next one.
+func (h *History) BooleanStats() map[string]BooleanStats {
+	stats := make(map[string]BooleanStats)
+	for field, intervals := range h.Timelines() {
+		if len(intervals) == 0 {
+			continue
+		}
+		var s BooleanStats
+		for _, iv := range intervals {
+			s.SecondsKnown += iv.Seconds
+			if iv.State {
+				s.SecondsTrue += iv.Seconds
+				s.Rises++
+			}
+		}
+		if s.SecondsKnown > 0 {
+			s.PercentTrue = s.SecondsTrue / s.SecondsKnown * 100
+		}
+		stats[field] = s
+	}
+	return stats
+}
+
+// SummarisedBooleanStats returns the statistics of the boolean fields of q,
+// as History.BooleanStats does, from the rollups of a store that keeps them.
+// The state each field held when the range began is read from the lookback
+// period before it. ok is false if the store cannot summarise the range and
+// the statistics must come from a History instead.
+func SummarisedBooleanStats(ctx context.Context, st store.Store, q store.Query, lookback time.Duration) (stats map[string]BooleanStats, ok bool, err error) {
+	summariser, ok := st.(store.BooleanSummariser)
+	if !ok || len(q.Fields) == 0 {
+		return nil, false, nil
+	}
+	summaries, ok, err := summariser.SummariseBooleans(ctx, q)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	before := q
+	before.Start, before.Stop = q.Start.Add(-lookback), q.Start
+	initial, err := st.LatestSamples(ctx, before)
+	if err != nil {
+		return nil, true, err
+	}
+
+	stats = make(map[string]BooleanStats)
+	for _, field := range q.Fields {
+		sum, sampled := summaries[field]
+		init, known := initial[field].Value.(bool)
+		if sampled && !sum.FirstTime.After(q.Start) {
+			known = false
+		}
+		var s BooleanStats
+		switch {
+		case known && !sampled:
+			s.SecondsKnown = q.Stop.Sub(q.Start).Seconds()
+			if init {
+				s.SecondsTrue, s.Rises = s.SecondsKnown, 1
+			}
+		case sampled:
+			s.SecondsKnown = q.Stop.Sub(sum.FirstTime).Seconds()
+			s.SecondsTrue, s.Rises = sum.SecondsTrue, sum.Rises
+			if known {
+				lead := sum.FirstTime.Sub(q.Start).Seconds()
+				s.SecondsKnown += lead
+				if init {
+					s.SecondsTrue += lead
+					s.Rises++
+				}
+			}
+			if sum.First && !(known && init) {
+				s.Rises++
+			}
+		default:
+			continue
+		}
+		if s.SecondsKnown > 0 {
+			s.PercentTrue = s.SecondsTrue / s.SecondsKnown * 100
+		}
+		stats[field] = s
+	}
+	return stats, true, nil
+}
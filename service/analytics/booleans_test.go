@@ -0,0 +1,139 @@
+// file: service/analytics/booleans_test.go
+package analytics
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+var t0 = time.Date(2026, 10, 14, 6, 0, 0, 0, time.UTC)
+
+// at returns a sample of field at t0 plus the given minutes.
+func at(minutes float64, field string, v interface{}) store.Sample {
+	return store.Sample{Time: t0.Add(time.Duration(minutes * float64(time.Minute))), Field: field, Value: v}
+}
+
+// summaryStore summarises its samples directly, as a store with rollups
+// would, and returns its initial samples as the latest before any range.
+type summaryStore struct {
+	store.Store
+	initial map[string]store.Sample
+	samples []store.Sample
+}
+
+func (s *summaryStore) LatestSamples(ctx context.Context, q store.Query) (map[string]store.Sample, error) {
+	return s.initial, nil
+}
+
+func (s *summaryStore) SummariseBooleans(ctx context.Context, q store.Query) (map[string]store.BooleanSummary, bool, error) {
+	summaries := make(map[string]store.BooleanSummary)
+	last := make(map[string]time.Time)
+	for _, smp := range s.samples {
+		b := smp.Value.(bool)
+		sum, seen := summaries[smp.Field]
+		if !seen {
+			sum = store.BooleanSummary{FirstTime: smp.Time, First: b}
+		} else {
+			if sum.Last {
+				sum.SecondsTrue += smp.Time.Sub(last[smp.Field]).Seconds()
+			}
+			if b && !sum.Last {
+				sum.Rises++
+			}
+		}
+		sum.Last, last[smp.Field] = b, smp.Time
+		summaries[smp.Field] = sum
+	}
+	for f, sum := range summaries {
+		if sum.Last {
+			sum.SecondsTrue += q.Stop.Sub(last[f]).Seconds()
+			summaries[f] = sum
+		}
+	}
+	return summaries, true, nil
+}
+
+func TestSummarisedBooleanStatsMatchHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial map[string]store.Sample
+		samples []store.Sample
+	}{
+		{name: "no initial state", samples: []store.Sample{at(10, "A", true), at(20, "A", false), at(30, "A", true)}},
+		{name: "initial true", initial: map[string]store.Sample{"A": at(-5, "A", true)},
+			samples: []store.Sample{at(10, "A", false), at(20, "A", true)}},
+		{name: "initial true then true", initial: map[string]store.Sample{"A": at(-5, "A", true)},
+			samples: []store.Sample{at(10, "A", true), at(20, "A", false)}},
+		{name: "initial false then true", initial: map[string]store.Sample{"A": at(-5, "A", false)},
+			samples: []store.Sample{at(10, "A", true)}},
+		{name: "initial only", initial: map[string]store.Sample{"A": at(-5, "A", true), "B": at(-1, "B", false)}},
+		{name: "sample at the start", initial: map[string]store.Sample{"A": at(-5, "A", true)},
+			samples: []store.Sample{at(0, "A", false), at(30, "A", true)}},
+		{name: "repeated values", samples: []store.Sample{at(1, "A", true), at(2, "A", true), at(3, "A", false), at(4, "A", false)}},
+		{name: "no data"},
+	}
+	q := store.Query{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"A", "B"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &summaryStore{initial: tt.initial, samples: tt.samples}
+			got, ok, err := SummarisedBooleanStats(context.Background(), st, q, time.Hour)
+			if err != nil || !ok {
+				t.Fatalf("ok %v, err %v", ok, err)
+			}
+			history := &History{Start: q.Start, Stop: q.Stop, Fields: q.Fields, Initial: tt.initial, Samples: tt.samples}
+			want := history.BooleanStats()
+			if len(got) != len(want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+			for f, w := range want {
+				g := got[f]
+				if math.Abs(g.SecondsTrue-w.SecondsTrue) > 1e-9 || math.Abs(g.SecondsKnown-w.SecondsKnown) > 1e-9 ||
+					math.Abs(g.PercentTrue-w.PercentTrue) > 1e-9 || g.Rises != w.Rises {
+					t.Errorf("%s: got %+v, want %+v", f, g, w)
+				}
+			}
+		})
+	}
+}
+
+// rawStore is a store without rollups.
+type rawStore struct{ store.Store }
+
+func TestSummarisedBooleanStatsNeedRollups(t *testing.T) {
+	q := store.Query{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"A"}}
+	if _, ok, err := SummarisedBooleanStats(context.Background(), rawStore{}, q, time.Hour); ok || err != nil {
+		t.Errorf("ok %v, err %v for a store without rollups", ok, err)
+	}
+}
+
+func TestBooleanStats(t *testing.T) {
+	h := &History{
+		Start:   t0,
+		Stop:    t0.Add(time.Hour),
+		Fields:  []string{"Run", "Idle", "Unknown"},
+		Initial: map[string]store.Sample{"Run": at(-30, "Run", true)},
+		Samples: []store.Sample{
+			at(15, "Run", false), at(15, "Idle", true),
+			at(45, "Run", true), at(45, "Idle", false),
+			at(50, "Run", "bad"),
+		},
+	}
+	got := h.BooleanStats()
+	want := map[string]BooleanStats{
+		"Run":  {PercentTrue: 50, SecondsTrue: 1800, SecondsKnown: 3600, Rises: 2},
+		"Idle": {PercentTrue: 2.0 / 3 * 100, SecondsTrue: 1800, SecondsKnown: 2700, Rises: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for f, w := range want {
+		g := got[f]
+		if math.Abs(g.PercentTrue-w.PercentTrue) > 1e-9 || g.SecondsTrue != w.SecondsTrue || g.SecondsKnown != w.SecondsKnown || g.Rises != w.Rises {
+			t.Errorf("%s: got %+v, want %+v", f, g, w)
+		}
+	}
+}
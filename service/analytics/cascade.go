@@ -0,0 +1,122 @@
+// file: service/analytics/cascade.go
+// Fault cascades: faults that come on together, led by a first-out fault
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// FaultActivation is a fault coming on at Time.
+type FaultActivation struct {
+	Field string
+	Time  time.Time
+}
+
+// Cascade is a group of faults that came on within a window of the first
+// one, the first-out or root fault. RootTied marks a root seen on the same
+// poll as another fault of the cascade, so their order is unknown and the
+// root is the one listed first in architect.yaml.
+type Cascade struct {
+	Start    time.Time      `json:"start"`
+	Root     string         `json:"root"`
+	RootTied bool           `json:"root_tied"`
+	Faults   []CascadeFault `json:"faults"`
+}
+
+// CascadeFault is one fault of a cascade, OffsetSeconds after the root.
+type CascadeFault struct {
+	Field         string  `json:"field"`
+	OffsetSeconds float64 `json:"offset_seconds"`
+}
+
+// CascadeGrouper groups fault activations, added in time order, into
+// cascades. An activation joins the open cascade if it comes at most window
+// after the cascade's root, and otherwise starts a new one.
+type CascadeGrouper struct {
+	window time.Duration
+	open   *Cascade
+}
+
+// NewCascadeGrouper returns a grouper with the given window.
+func NewCascadeGrouper(window time.Duration) *CascadeGrouper {
+	return &CascadeGrouper{window: window}
+}
+
+// Add adds an activation, and returns the cascade it closed, if any.
+func (g *CascadeGrouper) Add(a FaultActivation) *Cascade {
+	closed := g.Close(a.Time)
+	if g.open == nil {
+		g.open = &Cascade{Start: a.Time, Root: a.Field}
+	} else if a.Time.Equal(g.open.Start) {
+		g.open.RootTied = true
+	}
+	g.open.Faults = append(g.open.Faults, CascadeFault{Field: a.Field, OffsetSeconds: a.Time.Sub(g.open.Start).Seconds()})
+	return closed
+}
+
+// Close returns the open cascade and closes it if no activation at t or
+// later can still join it.
+func (g *CascadeGrouper) Close(t time.Time) *Cascade {
+	if g.open == nil || t.Sub(g.open.Start) <= g.window {
+		return nil
+	}
+	return g.Flush()
+}
+
+// Open returns the cascade still open to new activations, or nil.
+func (g *CascadeGrouper) Open() *Cascade {
+	return g.open
+}
+
+// Flush returns the open cascade, if any, and closes it.
+func (g *CascadeGrouper) Flush() *Cascade {
+	c := g.open
+	g.open = nil
+	return c
+}
+
+// FaultCascades groups the fault fields of h that came on within the range
+// into cascades of the given window, ordered by start. Faults already active
+// when the range began are left out. Faults that came on at the same time
+// are ordered as in faults.
+func (h *History) FaultCascades(faults []string, window time.Duration) []Cascade {
+	order := make(map[string]int, len(faults))
+	for i, f := range faults {
+		order[f] = i
+	}
+	var activations []FaultActivation
+	for _, ev := range h.FaultEvents(nil) {
+		if _, ok := order[ev.Field]; ok && !ev.StartedBefore {
+			activations = append(activations, FaultActivation{Field: ev.Field, Time: ev.Start})
+		}
+	}
+	sort.SliceStable(activations, func(i, j int) bool {
+		a, b := activations[i], activations[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return order[a.Field] < order[b.Field]
+	})
+
+	cascades := []Cascade{}
+	g := NewCascadeGrouper(window)
+	for _, a := range activations {
+		if c := g.Add(a); c != nil {
+			cascades = append(cascades, *c)
+		}
+	}
+	if c := g.Flush(); c != nil {
+		cascades = append(cascades, *c)
+	}
+	return cascades
+}
+
+// RootCounts returns how many of the cascades each fault was the root of.
+func RootCounts(cascades []Cascade) map[string]float64 {
+	counts := make(map[string]float64)
+	for _, c := range cascades {
+		counts[c.Root]++
+	}
+	return counts
+}
@@ -0,0 +1,102 @@
+// file: service/analytics/cascade_test.go
+package analytics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// cascades lists cascades as root, a star if tied, minutes after t0 and the
+// offset in seconds of each fault, such as "Jam@10[Jam+0 Air+12]".
+func cascades(cs []Cascade) string {
+	var parts []string
+	for _, c := range cs {
+		var faults []string
+		for _, f := range c.Faults {
+			faults = append(faults, fmt.Sprintf("%s+%g", f.Field, f.OffsetSeconds))
+		}
+		tied := ""
+		if c.RootTied {
+			tied = "*"
+		}
+		parts = append(parts, fmt.Sprintf("%s%s@%g[%s]", c.Root, tied, c.Start.Sub(t0).Minutes(), strings.Join(faults, " ")))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestFaultCascades(t *testing.T) {
+	faults := []string{"Estop", "Jam", "Air"}
+	tests := []struct {
+		name    string
+		initial map[string]store.Sample
+		samples []store.Sample
+		want    string
+		roots   map[string]float64
+	}{
+		{name: "root and followers",
+			samples: []store.Sample{at(10, "Jam", true), at(10.2, "Air", true), at(10.5, "Estop", true)},
+			want:    "Jam@10[Jam+0 Air+12 Estop+30]",
+			roots:   map[string]float64{"Jam": 1}},
+		{name: "window closes after its last second",
+			samples: []store.Sample{at(10, "Jam", true), at(10.5, "Air", true), at(10.5+1.0/60, "Estop", true)},
+			want:    "Jam@10[Jam+0 Air+30] Estop@10.516666666666667[Estop+0]",
+			roots:   map[string]float64{"Jam": 1, "Estop": 1}},
+		{name: "tie goes to the first in the list",
+			samples: []store.Sample{at(5, "Air", true), at(5, "Estop", true), at(5.25, "Jam", true)},
+			want:    "Estop*@5[Estop+0 Air+0 Jam+15]",
+			roots:   map[string]float64{"Estop": 1}},
+		{name: "faults active at the start are left out",
+			initial: map[string]store.Sample{"Air": at(-5, "Air", true)},
+			samples: []store.Sample{at(0.1, "Jam", true), at(3, "Air", false), at(4, "Air", true)},
+			want:    "Jam@0.1[Jam+0] Air@4[Air+0]",
+			roots:   map[string]float64{"Jam": 1, "Air": 1}},
+		{name: "repeat activations",
+			samples: []store.Sample{at(1, "Jam", true), at(2, "Jam", false), at(3, "Jam", true), at(3.1, "Jam", false), at(3.2, "Jam", true)},
+			want:    "Jam@1[Jam+0] Jam@3[Jam+0 Jam+12]",
+			roots:   map[string]float64{"Jam": 2}},
+		{name: "other fields ignored",
+			samples: []store.Sample{at(1, "Guard", true), at(1.1, "Air", true)},
+			want:    "Air@1.1[Air+0]",
+			roots:   map[string]float64{"Air": 1}},
+		{name: "no faults", roots: map[string]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: append(faults, "Guard"), Initial: tt.initial, Samples: tt.samples}
+			got := h.FaultCascades(faults, 30*time.Second)
+			if got == nil {
+				t.Fatal("nil cascades")
+			}
+			if s := cascades(got); s != tt.want {
+				t.Errorf("got %q, want %q", s, tt.want)
+			}
+			if roots := RootCounts(got); fmt.Sprint(roots) != fmt.Sprint(tt.roots) {
+				t.Errorf("root counts %v, want %v", roots, tt.roots)
+			}
+		})
+	}
+}
+
+func TestCascadeGrouper(t *testing.T) {
+	g := NewCascadeGrouper(30 * time.Second)
+	if c := g.Add(FaultActivation{Field: "Jam", Time: t0}); c != nil {
+		t.Fatalf("closed %+v on the first activation", c)
+	}
+	if c := g.Close(t0.Add(30 * time.Second)); c != nil {
+		t.Fatalf("closed %+v inside the window", c)
+	}
+	if g.Open() == nil || g.Open().Root != "Jam" {
+		t.Fatalf("open cascade %+v", g.Open())
+	}
+	c := g.Close(t0.Add(31 * time.Second))
+	if c == nil || c.Root != "Jam" || len(c.Faults) != 1 {
+		t.Fatalf("closed %+v after the window", c)
+	}
+	if g.Open() != nil || g.Flush() != nil {
+		t.Error("cascade still open after closing")
+	}
+}
@@ -0,0 +1,58 @@
+// file: service/analytics/faults.go
+// Fault occurrences derived from fault bit transitions
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// FaultEvent is one occurrence of a fault: the time its bit was true.
+type FaultEvent struct {
+	Field    string    `json:"field"`
+	Severity string    `json:"severity,omitempty"`
+	Start    time.Time `json:"start"`
+	// End is nil for a fault still active at the end of the range.
+	End     *time.Time `json:"end"`
+	Seconds float64    `json:"duration_seconds"`
+	Active  bool       `json:"active"`
+	// StartedBefore marks a fault already active when the range began, so
+	// Start is the range start rather than the time the fault came on.
+	StartedBefore bool `json:"started_before_range"`
+}
+
+// FaultEvents returns the occurrences of each fault field of h within the
+// range, ordered by start time. Durations of faults active at either end of
+// the range are clipped to it. severities, which may be nil, supplies the
+// severity of each field.
+func (h *History) FaultEvents(severities map[string]string) []FaultEvent {
+	events := []FaultEvent{}
+	for field, intervals := range h.Timelines() {
+		initial, _ := h.Initial[field].Value.(bool)
+		for _, iv := range intervals {
+			if !iv.State {
+				continue
+			}
+			ev := FaultEvent{
+				Field:         field,
+				Severity:      severities[field],
+				Start:         iv.Start,
+				Seconds:       iv.Seconds,
+				Active:        iv.End.Equal(h.Stop),
+				StartedBefore: initial && iv.Start.Equal(h.Start),
+			}
+			if !ev.Active {
+				end := iv.End
+				ev.End = &end
+			}
+			events = append(events, ev)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Start.Equal(events[j].Start) {
+			return events[i].Start.Before(events[j].Start)
+		}
+		return events[i].Field < events[j].Field
+	})
+	return events
+}
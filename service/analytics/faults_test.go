@@ -0,0 +1,72 @@
+// file: service/analytics/faults_test.go
+package analytics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// events lists fault events as field, severity and minutes after t0, with
+// "..." for an end still active and "<" for a start before the range.
+func events(evs []FaultEvent) string {
+	var parts []string
+	for _, ev := range evs {
+		end := "..."
+		if ev.End != nil {
+			end = fmt.Sprint(ev.End.Sub(t0).Minutes())
+		}
+		if ev.Active != (ev.End == nil) {
+			end += "?"
+		}
+		before := ""
+		if ev.StartedBefore {
+			before = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s/%s@%s%g-%s(%gs)", ev.Field, ev.Severity, before, ev.Start.Sub(t0).Minutes(), end, ev.Seconds))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestFaultEvents(t *testing.T) {
+	severities := map[string]string{"Estop": "fault", "LowAir": "warning"}
+	tests := []struct {
+		name    string
+		initial map[string]store.Sample
+		samples []store.Sample
+		want    string
+	}{
+		{name: "cleared within the range", samples: []store.Sample{at(10, "Estop", true), at(12, "Estop", false)},
+			want: "Estop/fault@10-12(120s)"},
+		{name: "active at the end", samples: []store.Sample{at(50, "LowAir", true)},
+			want: "LowAir/warning@50-...(600s)"},
+		{name: "active at the start", initial: map[string]store.Sample{"Estop": at(-30, "Estop", true)},
+			samples: []store.Sample{at(5, "Estop", false)},
+			want:    "Estop/fault@<0-5(300s)"},
+		{name: "came on at the start", initial: map[string]store.Sample{"Estop": at(-30, "Estop", false)},
+			samples: []store.Sample{at(0, "Estop", true), at(1, "Estop", false)},
+			want:    "Estop/fault@0-1(60s)"},
+		{name: "ordered by start then field", samples: []store.Sample{
+			at(20, "LowAir", true), at(20, "Estop", true), at(25, "Estop", false), at(30, "LowAir", false),
+			at(40, "Estop", true), at(41, "Estop", false), at(42, "Jam", true), at(43, "Jam", false)},
+			want: "Estop/fault@20-25(300s) LowAir/warning@20-30(600s) Estop/fault@40-41(60s) Jam/@42-43(60s)"},
+		{name: "whole range", initial: map[string]store.Sample{"Jam": at(-1, "Jam", true)},
+			want: "Jam/@<0-...(3600s)"},
+		{name: "no faults", samples: []store.Sample{at(10, "Estop", false)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"Estop", "LowAir", "Jam"}, Initial: tt.initial, Samples: tt.samples}
+			got := h.FaultEvents(severities)
+			if got == nil {
+				t.Fatal("nil events")
+			}
+			if s := events(got); s != tt.want {
+				t.Errorf("got  %s\nwant %s", s, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// file: service/analytics/floats.go
+// Distribution statistics of float fields
+package analytics
+
+import (
+	"math"
+	"sort"
+
+	"vtarchitect/store"
+)
+
+// FloatStats describes the stored samples of a float field over a range.
+// StdDev is the sample standard deviation, zero for a single sample.
+// Percentiles interpolate linearly between the nearest samples.
+type FloatStats struct {
+	Count     int             `json:"count"`
+	Min       float64         `json:"min"`
+	Max       float64         `json:"max"`
+	Mean      float64         `json:"mean"`
+	StdDev    float64         `json:"stddev"`
+	P50       float64         `json:"p50"`
+	P90       float64         `json:"p90"`
+	P99       float64         `json:"p99"`
+	First     store.TimeValue `json:"first"`
+	Last      store.TimeValue `json:"last"`
+	Histogram *Histogram      `json:"histogram,omitempty"`
+}
+
+// HistogramSpec sets the bins of a histogram: Bins bins of equal width from
+// Min to Max. A nil Min or Max is taken from each field's own samples.
+type HistogramSpec struct {
+	Bins int
+	Min  *float64
+	Max  *float64
+}
+
+// Histogram counts the samples in each bin. Below and Above count samples
+// outside the bins; the last bin includes its upper edge.
+type Histogram struct {
+	Bins  []HistogramBin `json:"bins"`
+	Below int            `json:"below"`
+	Above int            `json:"above"`
+}
+
+// HistogramBin is one bin of a histogram, covering [Lower, Upper).
+type HistogramBin struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int     `json:"count"`
+}
+
+// FloatStatistics returns the statistics of the numeric samples of each
+// field, with a histogram if spec is not nil. samples must be ordered by
+// time; booleans and non-finite values are skipped.
+func FloatStatistics(samples []store.Sample, spec *HistogramSpec) map[string]FloatStats {
+	values := make(map[string][]float64)
+	stats := make(map[string]FloatStats)
+	for _, s := range samples {
+		if _, isBool := s.Value.(bool); isBool {
+			continue
+		}
+		v, ok := store.AsFloat(s.Value)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		fs, seen := stats[s.Field]
+		if !seen {
+			fs.First = store.TimeValue{Time: s.Time, Value: v}
+		}
+		fs.Last = store.TimeValue{Time: s.Time, Value: v}
+		stats[s.Field] = fs
+		values[s.Field] = append(values[s.Field], v)
+	}
+
+	for field, vs := range values {
+		fs := stats[field]
+		sort.Float64s(vs)
+		fs.Count = len(vs)
+		fs.Min, fs.Max = vs[0], vs[len(vs)-1]
+		var sum float64
+		for _, v := range vs {
+			sum += v
+		}
+		fs.Mean = sum / float64(len(vs))
+		if len(vs) > 1 {
+			var squares float64
+			for _, v := range vs {
+				squares += (v - fs.Mean) * (v - fs.Mean)
+			}
+			fs.StdDev = math.Sqrt(squares / float64(len(vs)-1))
+		}
+		fs.P50 = percentile(vs, 50)
+		fs.P90 = percentile(vs, 90)
+		fs.P99 = percentile(vs, 99)
+		if spec != nil {
+			fs.Histogram = histogram(vs, *spec)
+		}
+		stats[field] = fs
+	}
+	return stats
+}
+
+// percentile returns the p-th percentile of the sorted values, interpolating
+// linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	if lo+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (rank-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+// histogram counts the sorted values into the bins of spec. When every
+// value is equal and no range is given, or a given limit lies beyond every
+// value, it has one bin of zero width at that value or limit.
+func histogram(sorted []float64, spec HistogramSpec) *Histogram {
+	lo, hi := sorted[0], sorted[len(sorted)-1]
+	if spec.Min != nil {
+		lo = *spec.Min
+	}
+	if spec.Max != nil {
+		hi = *spec.Max
+	}
+	if hi < lo {
+		if spec.Min != nil {
+			hi = lo
+		} else {
+			lo = hi
+		}
+	}
+	bins := spec.Bins
+	if hi <= lo {
+		bins = 1
+	}
+	width := (hi - lo) / float64(bins)
+	h := &Histogram{Bins: make([]HistogramBin, bins)}
+	for i := range h.Bins {
+		h.Bins[i] = HistogramBin{Lower: lo + float64(i)*width, Upper: lo + float64(i+1)*width}
+	}
+	h.Bins[bins-1].Upper = hi
+	for _, v := range sorted {
+		switch {
+		case v < lo:
+			h.Below++
+		case v > hi:
+			h.Above++
+		case width == 0:
+			h.Bins[0].Count++
+		default:
+			i := int((v - lo) / width)
+			if i >= bins {
+				i = bins - 1
+			}
+			h.Bins[i].Count++
+		}
+	}
+	return h
+}
@@ -0,0 +1,126 @@
+// file: service/analytics/floats_test.go
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"vtarchitect/store"
+)
+
+func fp(v float64) *float64 { return &v }
+
+func TestFloatStatistics(t *testing.T) {
+	samples := []store.Sample{
+		at(1, "Speed", 4.0), at(2, "Speed", int64(1)), at(3, "Speed", 3.0), at(4, "Speed", math.NaN()),
+		at(5, "Speed", 2.0), at(6, "Speed", true), at(7, "Speed", math.Inf(1)), at(8, "Speed", uint64(5)),
+		at(9, "Temp", 20.5),
+		at(10, "Mode", "auto"),
+	}
+	got := FloatStatistics(samples, nil)
+	if len(got) != 2 {
+		t.Fatalf("got stats for %d fields: %+v", len(got), got)
+	}
+
+	speed := got["Speed"]
+	if speed.Count != 5 || speed.Min != 1 || speed.Max != 5 || speed.Mean != 3 || speed.P50 != 3 {
+		t.Errorf("Speed %+v", speed)
+	}
+	if math.Abs(speed.StdDev-math.Sqrt(2.5)) > 1e-12 {
+		t.Errorf("Speed stddev %g, want %g", speed.StdDev, math.Sqrt(2.5))
+	}
+	// Ranks 3.6 and 3.96 of 1..5.
+	if math.Abs(speed.P90-4.6) > 1e-12 || math.Abs(speed.P99-4.96) > 1e-12 {
+		t.Errorf("Speed p90 %g, p99 %g", speed.P90, speed.P99)
+	}
+	if speed.First.Value != 4.0 || !speed.First.Time.Equal(at(1, "", nil).Time) || speed.Last.Value != 5.0 || !speed.Last.Time.Equal(at(8, "", nil).Time) {
+		t.Errorf("Speed first %+v, last %+v", speed.First, speed.Last)
+	}
+	if speed.Histogram != nil {
+		t.Error("histogram without a spec")
+	}
+
+	temp := got["Temp"]
+	if temp.Count != 1 || temp.StdDev != 0 || temp.P50 != 20.5 || temp.P99 != 20.5 || temp.Min != 20.5 || temp.Max != 20.5 {
+		t.Errorf("Temp %+v", temp)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{[]float64{7}, 50, 7},
+		{[]float64{7}, 99, 7},
+		{[]float64{1, 2}, 0, 1},
+		{[]float64{1, 2}, 50, 1.5},
+		{[]float64{1, 2}, 100, 2},
+		{[]float64{10, 20, 30, 40}, 25, 17.5},
+		{[]float64{5, 5, 5}, 90, 5},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("p%g of %v is %g, want %g", tt.p, tt.sorted, got, tt.want)
+		}
+	}
+}
+
+// bins lists a histogram as its bins and the counts below and above them,
+// such as "<0 [0,5):3 [5,10]:2 >1".
+func bins(h *Histogram) string {
+	s := fmt.Sprintf("<%d", h.Below)
+	for i, b := range h.Bins {
+		end := ")"
+		if i == len(h.Bins)-1 {
+			end = "]"
+		}
+		s += fmt.Sprintf(" [%g,%g%s:%d", b.Lower, b.Upper, end, b.Count)
+	}
+	return s + fmt.Sprintf(" >%d", h.Above)
+}
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []float64
+		spec   HistogramSpec
+		want   string
+	}{
+		{name: "range from the data", sorted: []float64{0, 1, 4, 5, 9, 10}, spec: HistogramSpec{Bins: 2},
+			want: "<0 [0,5):3 [5,10]:3 >0"},
+		{name: "upper edge in the last bin", sorted: []float64{0, 2, 4}, spec: HistogramSpec{Bins: 4},
+			want: "<0 [0,1):1 [1,2):0 [2,3):1 [3,4]:1 >0"},
+		{name: "outside a given range", sorted: []float64{-1, 0, 3, 6, 7}, spec: HistogramSpec{Bins: 3, Min: fp(0), Max: fp(6)},
+			want: "<1 [0,2):1 [2,4):1 [4,6]:1 >1"},
+		{name: "given minimum only", sorted: []float64{1, 2, 3}, spec: HistogramSpec{Bins: 2, Min: fp(-1)},
+			want: "<0 [-1,1):0 [1,3]:3 >0"},
+		{name: "equal values", sorted: []float64{5, 5, 5}, spec: HistogramSpec{Bins: 10},
+			want: "<0 [5,5]:3 >0"},
+		{name: "equal limits", sorted: []float64{4, 5, 6}, spec: HistogramSpec{Bins: 10, Min: fp(5), Max: fp(5)},
+			want: "<1 [5,5]:1 >1"},
+		{name: "minimum above every value", sorted: []float64{4, 5, 6}, spec: HistogramSpec{Bins: 10, Min: fp(7)},
+			want: "<3 [7,7]:0 >0"},
+		{name: "maximum below every value", sorted: []float64{4, 5, 6}, spec: HistogramSpec{Bins: 10, Max: fp(3)},
+			want: "<0 [3,3]:0 >3"},
+		{name: "maximum at the lowest value", sorted: []float64{4, 5, 6}, spec: HistogramSpec{Bins: 10, Max: fp(4)},
+			want: "<0 [4,4]:1 >2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := histogram(tt.sorted, tt.spec)
+			if got := bins(h); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+			total := h.Below + h.Above
+			for _, b := range h.Bins {
+				total += b.Count
+			}
+			if total > len(tt.sorted) {
+				t.Errorf("counted %d of %d values", total, len(tt.sorted))
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// file: service/analytics/history.go
+// Package analytics derives machine-state metrics, such as the intervals each
+// boolean held a state, from the raw samples of any storage backend.
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// History holds the samples of a set of fields over a range, along with the
+// latest sample of each field from before the range.
+type History struct {
+	Start   time.Time
+	Stop    time.Time
+	Fields  []string
+	Initial map[string]store.Sample
+	Samples []store.Sample
+}
+
+// LoadHistory reads the samples of the fields in q over its range. The value
+// each field held when the range began is taken from its latest sample in the
+// lookback period before q.Start; with change-only writes that is the last
+// change or full-state write.
+func LoadHistory(ctx context.Context, st store.Store, q store.Query, lookback time.Duration) (*History, error) {
+	h := &History{Start: q.Start.UTC(), Stop: q.Stop.UTC(), Fields: q.Fields}
+	if len(q.Fields) == 0 {
+		h.Initial = map[string]store.Sample{}
+		return h, nil
+	}
+	before := q
+	before.Start, before.Stop = q.Start.Add(-lookback), q.Start
+	var err error
+	if h.Initial, err = st.LatestSamples(ctx, before); err != nil {
+		return nil, err
+	}
+	if h.Samples, err = st.QueryRaw(ctx, q); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,193 @@
+// file: service/analytics/oee.go
+// Overall equipment effectiveness from running, planned stop and part counts
+package analytics
+
+import (
+	"time"
+
+	"vtarchitect/store"
+)
+
+// OEEConfig names the fields OEE is computed from. PlannedStopField and
+// either count field other than TotalCountField may be empty.
+type OEEConfig struct {
+	RunningField     string
+	PlannedStopField string
+	TotalCountField  string
+	GoodCountField   string
+	RejectCountField string
+	IdealCycle       time.Duration
+}
+
+// Fields returns every field the calculation reads.
+func (c OEEConfig) Fields() []string {
+	var fields []string
+	for _, f := range []string{c.RunningField, c.PlannedStopField, c.TotalCountField, c.GoodCountField, c.RejectCountField} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// OEE is the overall equipment effectiveness over one period. Planned time
+// is the time the running field was known less planned stops; run time is
+// the time it was true outside planned stops. Availability, Performance,
+// Quality and OEE are percentages, nil when they would divide by zero.
+type OEE struct {
+	Start          time.Time `json:"start"`
+	Stop           time.Time `json:"stop"`
+	PlannedSeconds float64   `json:"planned_seconds"`
+	RunSeconds     float64   `json:"run_seconds"`
+	TotalCount     float64   `json:"total_count"`
+	GoodCount      float64   `json:"good_count"`
+	Availability   *float64  `json:"availability"`
+	Performance    *float64  `json:"performance"`
+	Quality        *float64  `json:"quality"`
+	OEE            *float64  `json:"oee"`
+	Losses         OEELosses `json:"losses"`
+}
+
+// OEELosses splits planned time into the time lost to each OEE factor and
+// the fully productive time left, the ideal cycle time of the good parts.
+// Performance loss is negative if parts were made faster than the ideal.
+type OEELosses struct {
+	AvailabilitySeconds float64 `json:"availability_seconds"`
+	PerformanceSeconds  float64 `json:"performance_seconds"`
+	QualitySeconds      float64 `json:"quality_seconds"`
+	ProductiveSeconds   float64 `json:"productive_seconds"`
+}
+
+// OEE computes the OEE of h over its whole range.
+func (h *History) OEE(cfg OEEConfig) OEE {
+	return h.OEEIntervals(cfg, 0)[0]
+}
+
+// OEEIntervals computes the OEE of h over consecutive windows of length
+// every, aligned to the Unix epoch and clipped to the range. A zero every
+// gives one window covering the whole range.
+func (h *History) OEEIntervals(cfg OEEConfig, every time.Duration) []OEE {
+	timelines := h.Timelines()
+	total := h.counterIncrements(cfg.TotalCountField)
+	good := h.counterIncrements(cfg.GoodCountField)
+	reject := h.counterIncrements(cfg.RejectCountField)
+
+	var results []OEE
+	for start := h.Start; start.Before(h.Stop); {
+		stop := h.Stop
+		if every > 0 {
+			if end := floorTime(start, every).Add(every); end.Before(stop) {
+				stop = end
+			}
+		}
+		running := clip(timelines[cfg.RunningField], start, stop)
+		planned := clip(timelines[cfg.PlannedStopField], start, stop)
+		o := OEE{Start: start, Stop: stop}
+		o.PlannedSeconds = secondsIn(running, true) + secondsIn(running, false) -
+			overlapSeconds(running, true, planned, true) - overlapSeconds(running, false, planned, true)
+		o.RunSeconds = secondsIn(running, true) - overlapSeconds(running, true, planned, true)
+		o.TotalCount = sumBetween(total, start, stop)
+		switch {
+		case cfg.GoodCountField != "":
+			o.GoodCount = sumBetween(good, start, stop)
+		case cfg.RejectCountField != "":
+			o.GoodCount = o.TotalCount - sumBetween(reject, start, stop)
+		default:
+			o.GoodCount = o.TotalCount
+		}
+		o.score(cfg.IdealCycle.Seconds())
+		results = append(results, o)
+		start = stop
+	}
+	if results == nil {
+		results = []OEE{{Start: h.Start, Stop: h.Stop}}
+	}
+	return results
+}
+
+// score fills in the OEE factors and losses from the times and counts.
+func (o *OEE) score(idealCycle float64) {
+	ideal := idealCycle * o.TotalCount
+	o.Losses = OEELosses{
+		AvailabilitySeconds: o.PlannedSeconds - o.RunSeconds,
+		PerformanceSeconds:  o.RunSeconds - ideal,
+		QualitySeconds:      idealCycle * (o.TotalCount - o.GoodCount),
+		ProductiveSeconds:   idealCycle * o.GoodCount,
+	}
+	if o.PlannedSeconds > 0 {
+		o.Availability = ratio(o.RunSeconds*100, o.PlannedSeconds)
+	}
+	if o.RunSeconds > 0 {
+		o.Performance = ratio(ideal*100, o.RunSeconds)
+	}
+	if o.TotalCount > 0 {
+		o.Quality = ratio(o.GoodCount*100, o.TotalCount)
+	}
+	if o.Availability != nil && o.Performance != nil && o.Quality != nil {
+		o.OEE = ratio(*o.Availability**o.Performance**o.Quality, 100*100)
+	}
+}
+
+// counterIncrements returns the increase of a cumulative counter at each of
+// its samples in the range, starting from its value before the range. A
+// decrease is taken as a reset to zero, so the new value is all increase.
+func (h *History) counterIncrements(field string) []store.TimeValue {
+	if field == "" {
+		return nil
+	}
+	prev, known := store.AsFloat(h.Initial[field].Value)
+	var increments []store.TimeValue
+	for _, s := range h.Samples {
+		if s.Field != field {
+			continue
+		}
+		v, ok := store.AsFloat(s.Value)
+		if !ok {
+			continue
+		}
+		switch {
+		case !known:
+		case v >= prev:
+			increments = append(increments, store.TimeValue{Time: s.Time, Value: v - prev})
+		default:
+			increments = append(increments, store.TimeValue{Time: s.Time, Value: v})
+		}
+		prev, known = v, true
+	}
+	return increments
+}
+
+// sumBetween adds the values stamped in [start, stop).
+func sumBetween(values []store.TimeValue, start, stop time.Time) float64 {
+	var total float64
+	for _, tv := range values {
+		if !tv.Time.Before(start) && tv.Time.Before(stop) {
+			total += tv.Value.(float64)
+		}
+	}
+	return total
+}
+
+// clip returns the parts of tl within [start, stop).
+func clip(tl []Interval, start, stop time.Time) []Interval {
+	var clipped []Interval
+	for _, iv := range tl {
+		if !iv.End.After(start) || !iv.Start.Before(stop) {
+			continue
+		}
+		if iv.Start.Before(start) {
+			iv.Start = start
+		}
+		if iv.End.After(stop) {
+			iv.End = stop
+		}
+		clipped = append(clipped, newInterval(iv.State, iv.Start, iv.End))
+	}
+	return clipped
+}
+
+// floorTime rounds t down to a multiple of d since the Unix epoch.
+func floorTime(t time.Time, d time.Duration) time.Time {
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(d)).UTC()
+}
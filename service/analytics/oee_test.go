@@ -0,0 +1,139 @@
+// file: service/analytics/oee_test.go
+package analytics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// oeeHistory runs for 50 of 60 minutes with a planned stop from 40 to 45,
+// and makes 35 parts, 5 of them rejects, with the total counter reset at 35.
+func oeeHistory() *History {
+	return &History{
+		Start:  t0,
+		Stop:   t0.Add(time.Hour),
+		Fields: []string{"Run", "Planned", "Total", "Good", "Rejects"},
+		Initial: map[string]store.Sample{
+			"Run": at(-1, "Run", true), "Planned": at(-1, "Planned", false),
+			"Total": at(-1, "Total", int64(100)), "Good": at(-1, "Good", 7.0), "Rejects": at(-1, "Rejects", int64(0)),
+		},
+		Samples: []store.Sample{
+			at(10, "Total", int64(110)), at(10, "Good", 17.0),
+			at(20, "Run", false), at(30, "Run", true),
+			at(35, "Total", int64(5)), at(35, "Good", 22.0),
+			at(40, "Planned", true), at(45, "Planned", false),
+			at(50, "Total", int64(25)), at(50, "Good", 37.0), at(50, "Rejects", int64(5)),
+		},
+	}
+}
+
+func checkOEE(t *testing.T, name string, got OEE, planned, run, total, good float64) {
+	t.Helper()
+	if got.PlannedSeconds != planned || got.RunSeconds != run || got.TotalCount != total || got.GoodCount != good {
+		t.Errorf("%s: planned %g s, run %g s, %g parts, %g good; want %g, %g, %g, %g",
+			name, got.PlannedSeconds, got.RunSeconds, got.TotalCount, got.GoodCount, planned, run, total, good)
+	}
+}
+
+func TestOEE(t *testing.T) {
+	cfg := OEEConfig{RunningField: "Run", PlannedStopField: "Planned", TotalCountField: "Total", RejectCountField: "Rejects", IdealCycle: time.Minute}
+	o := oeeHistory().OEE(cfg)
+	checkOEE(t, "rejects", o, 3300, 2700, 35, 30)
+	a, p, q := 2700.0/3300*100, 2100.0/2700*100, 30.0/35*100
+	if !near(o.Availability, a) || !near(o.Performance, p) || !near(o.Quality, q) || !near(o.OEE, a*p*q/10000) {
+		t.Errorf("availability %v, performance %v, quality %v, oee %v", deref(o.Availability), deref(o.Performance), deref(o.Quality), deref(o.OEE))
+	}
+	if want := (OEELosses{AvailabilitySeconds: 600, PerformanceSeconds: 600, QualitySeconds: 300, ProductiveSeconds: 1800}); o.Losses != want {
+		t.Errorf("losses %+v, want %+v", o.Losses, want)
+	}
+	if sum := o.Losses.AvailabilitySeconds + o.Losses.PerformanceSeconds + o.Losses.QualitySeconds + o.Losses.ProductiveSeconds; sum != o.PlannedSeconds {
+		t.Errorf("losses add up to %g s of %g s planned", sum, o.PlannedSeconds)
+	}
+
+	good := cfg
+	good.GoodCountField, good.RejectCountField = "Good", ""
+	checkOEE(t, "good count", oeeHistory().OEE(good), 3300, 2700, 35, 30)
+
+	totalOnly := cfg
+	totalOnly.RejectCountField, totalOnly.PlannedStopField = "", ""
+	o = oeeHistory().OEE(totalOnly)
+	checkOEE(t, "total only", o, 3600, 3000, 35, 35)
+	if !near(o.Quality, 100) {
+		t.Errorf("quality %v without rejects", deref(o.Quality))
+	}
+
+	o = (&History{Start: t0, Stop: t0.Add(time.Hour)}).OEE(cfg)
+	if o.Availability != nil || o.Performance != nil || o.Quality != nil || o.OEE != nil {
+		t.Errorf("scores without data: %+v", o)
+	}
+}
+
+func TestOEEIntervals(t *testing.T) {
+	cfg := OEEConfig{RunningField: "Run", PlannedStopField: "Planned", TotalCountField: "Total", RejectCountField: "Rejects", IdealCycle: time.Minute}
+	tests := []struct {
+		name        string
+		start, stop time.Time
+		every       time.Duration
+		want        string
+	}{
+		{name: "aligned", start: t0, stop: t0.Add(time.Hour), every: 30 * time.Minute,
+			want: "[0-30 1800/1200 10/10] [30-60 1500/1500 25/20]"},
+		{name: "clipped to the range", start: t0.Add(10 * time.Minute), stop: t0.Add(50 * time.Minute), every: 30 * time.Minute,
+			want: "[10-30 1200/600 10/10] [30-50 900/900 5/5]"},
+		{name: "whole range", start: t0, stop: t0.Add(time.Hour),
+			want: "[0-60 3300/2700 35/30]"},
+		{name: "empty range", start: t0, stop: t0, every: time.Minute,
+			want: "[0-0 0/0 0/0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := oeeHistory()
+			h.Start, h.Stop = tt.start, tt.stop
+			var got string
+			for i, o := range h.OEEIntervals(cfg, tt.every) {
+				if i > 0 {
+					got += " "
+				}
+				got += fmt.Sprintf("[%g-%g %g/%g %g/%g]", o.Start.Sub(t0).Minutes(), o.Stop.Sub(t0).Minutes(), o.PlannedSeconds, o.RunSeconds, o.TotalCount, o.GoodCount)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCounterIncrements(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial map[string]store.Sample
+		samples []store.Sample
+		want    string
+	}{
+		{name: "from the value before the range", initial: map[string]store.Sample{"C": at(-1, "C", 10.0)},
+			samples: []store.Sample{at(1, "C", 12.0), at(2, "C", 12.0), at(3, "C", 15.0)},
+			want:    "[1:2 2:0 3:3]"},
+		{name: "reset", initial: map[string]store.Sample{"C": at(-1, "C", uint64(10))},
+			samples: []store.Sample{at(1, "C", uint64(3)), at(2, "C", uint64(4))},
+			want:    "[1:3 2:1]"},
+		{name: "no value before the range", samples: []store.Sample{at(1, "C", int64(5)), at(2, "C", int64(8))},
+			want: "[2:3]"},
+		{name: "strings skipped", samples: []store.Sample{at(1, "C", 1.0), at(2, "C", "x"), at(3, "C", 3.0)},
+			want: "[3:2]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"C"}, Initial: tt.initial, Samples: tt.samples}
+			var parts []string
+			for _, tv := range h.counterIncrements("C") {
+				parts = append(parts, fmt.Sprintf("%g:%g", tv.Time.Sub(t0).Minutes(), tv.Value))
+			}
+			if got := fmt.Sprint(parts); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// file: service/analytics/pareto.go
+// Downtime attributed to the fault or reason that stopped the machine
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// Unattributed is the reason given to stops no fault or reason explains.
+const Unattributed = "Unattributed"
+
+// Kinds of downtime reason.
+const (
+	ReasonFault        = "fault"
+	ReasonBit          = "reason"
+	ReasonUnattributed = "unattributed"
+)
+
+// DowntimeReason is the downtime blamed on one fault or reason field.
+type DowntimeReason struct {
+	Reason      string  `json:"reason"`
+	Kind        string  `json:"kind"`
+	Seconds     float64 `json:"downtime_seconds"`
+	Occurrences int     `json:"occurrences"`
+	// Percent is this reason's share of all downtime, and
+	// CumulativePercent the share of it and every reason above it.
+	Percent           float64 `json:"percent"`
+	CumulativePercent float64 `json:"cumulative_percent"`
+}
+
+// DowntimePareto lists downtime reasons, most downtime first.
+type DowntimePareto struct {
+	Stops           int              `json:"stops"`
+	DowntimeSeconds float64          `json:"downtime_seconds"`
+	Reasons         []DowntimeReason `json:"reasons"`
+}
+
+// DowntimePareto attributes each interval in which running was false to the
+// fault that came on first among those active when the machine stopped, or
+// that came on within window after. Stops no fault explains go to the reason
+// field chosen the same way, and stops nothing explains are Unattributed. h
+// must hold running, faults and reasons.
+func (h *History) DowntimePareto(running string, faults, reasons []string, window time.Duration) DowntimePareto {
+	timelines := h.Timelines()
+	type candidate struct {
+		field string
+		kind  string
+	}
+	var candidates []candidate
+	for _, f := range faults {
+		candidates = append(candidates, candidate{f, ReasonFault})
+	}
+	for _, f := range reasons {
+		candidates = append(candidates, candidate{f, ReasonBit})
+	}
+
+	pareto := DowntimePareto{Reasons: []DowntimeReason{}}
+	byReason := make(map[string]*DowntimeReason)
+	for _, stop := range timelines[running] {
+		if stop.State {
+			continue
+		}
+		blamed := candidate{Unattributed, ReasonUnattributed}
+		var first time.Time
+		for _, c := range candidates {
+			if blamed.kind == ReasonFault && c.kind == ReasonBit {
+				break
+			}
+			on, ok := activation(timelines[c.field], stop.Start, stop.Start.Add(window))
+			if ok && (blamed.kind == ReasonUnattributed || on.Before(first)) {
+				blamed, first = c, on
+			}
+		}
+		r := byReason[blamed.field]
+		if r == nil {
+			r = &DowntimeReason{Reason: blamed.field, Kind: blamed.kind}
+			byReason[blamed.field] = r
+		}
+		r.Seconds += stop.Seconds
+		r.Occurrences++
+		pareto.Stops++
+		pareto.DowntimeSeconds += stop.Seconds
+	}
+
+	for _, r := range byReason {
+		pareto.Reasons = append(pareto.Reasons, *r)
+	}
+	sort.Slice(pareto.Reasons, func(i, j int) bool {
+		a, b := pareto.Reasons[i], pareto.Reasons[j]
+		if a.Seconds != b.Seconds {
+			return a.Seconds > b.Seconds
+		}
+		return a.Reason < b.Reason
+	})
+	var cumulative float64
+	for i := range pareto.Reasons {
+		if pareto.DowntimeSeconds > 0 {
+			pareto.Reasons[i].Percent = pareto.Reasons[i].Seconds / pareto.DowntimeSeconds * 100
+		}
+		cumulative += pareto.Reasons[i].Percent
+		pareto.Reasons[i].CumulativePercent = cumulative
+	}
+	return pareto
+}
+
+// activation returns when tl last became true if it was true at t, or when
+// it first became true after t and no later than deadline.
+func activation(tl []Interval, t, deadline time.Time) (time.Time, bool) {
+	for _, iv := range tl {
+		if !iv.State || !iv.End.After(t) {
+			continue
+		}
+		if !iv.Start.After(t) || !iv.Start.After(deadline) {
+			return iv.Start, true
+		}
+		return time.Time{}, false
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,97 @@
+// file: service/analytics/pareto_test.go
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// ms is m minutes and s seconds, in minutes.
+func ms(m, s float64) float64 { return m + s/60 }
+
+func TestDowntimePareto(t *testing.T) {
+	h := &History{
+		Start:   t0,
+		Stop:    t0.Add(time.Hour),
+		Fields:  []string{"Run", "A", "B", "R"},
+		Initial: map[string]store.Sample{"Run": at(-1, "Run", true)},
+		Samples: []store.Sample{
+			at(9, "A", true),
+			// Stopped with A active and B coming on within the window: A.
+			at(10, "Run", false), at(ms(10, 5), "B", true), at(11, "B", false), at(15, "Run", true), at(16, "A", false),
+			// No fault, reason R within the window: R.
+			at(20, "Run", false), at(ms(20, 3), "R", true), at(22, "Run", true), at(25, "R", false),
+			// B comes on only after the window: unattributed.
+			at(30, "Run", false), at(ms(30, 20), "B", true), at(31, "B", false), at(40, "Run", true),
+			// Reason R active, fault B within the window: faults come first.
+			at(49, "R", true), at(50, "Run", false), at(ms(50, 5), "B", true), at(ms(50, 30), "B", false), at(51, "Run", true), at(52, "R", false),
+			// Stopped at the moment A came on, until the end of the range: A.
+			at(58, "Run", false), at(58, "A", true),
+		},
+	}
+	p := h.DowntimePareto("Run", []string{"A", "B"}, []string{"R"}, 10*time.Second)
+	if p.Stops != 5 || p.DowntimeSeconds != 1200 {
+		t.Errorf("%d stops, %g s down", p.Stops, p.DowntimeSeconds)
+	}
+	var got []string
+	for _, r := range p.Reasons {
+		got = append(got, fmt.Sprintf("%s/%s %gs x%d %.0f%% %.0f%%", r.Reason, r.Kind, r.Seconds, r.Occurrences, r.Percent, r.CumulativePercent))
+	}
+	want := []string{
+		"Unattributed/unattributed 600s x1 50% 50%",
+		"A/fault 420s x2 35% 85%",
+		"R/reason 120s x1 10% 95%",
+		"B/fault 60s x1 5% 100%",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if last := p.Reasons[len(p.Reasons)-1].CumulativePercent; math.Abs(last-100) > 1e-9 {
+		t.Errorf("cumulative percent ends at %g", last)
+	}
+}
+
+func TestDowntimeParetoWithoutStops(t *testing.T) {
+	h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"Run", "A"},
+		Initial: map[string]store.Sample{"Run": at(-1, "Run", true)},
+		Samples: []store.Sample{at(10, "A", true)}}
+	p := h.DowntimePareto("Run", []string{"A"}, nil, time.Minute)
+	if p.Stops != 0 || p.DowntimeSeconds != 0 || p.Reasons == nil || len(p.Reasons) != 0 {
+		t.Errorf("pareto %+v", p)
+	}
+}
+
+func TestActivation(t *testing.T) {
+	tl := []Interval{
+		newInterval(false, at(0, "", nil).Time, at(10, "", nil).Time),
+		newInterval(true, at(10, "", nil).Time, at(20, "", nil).Time),
+		newInterval(false, at(20, "", nil).Time, at(30, "", nil).Time),
+		newInterval(true, at(30, "", nil).Time, at(40, "", nil).Time),
+	}
+	tests := []struct {
+		name        string
+		t, deadline float64
+		want        float64
+		wantOK      bool
+	}{
+		{name: "already true", t: 15, deadline: 16, want: 10, wantOK: true},
+		{name: "true from t", t: 10, deadline: 10, want: 10, wantOK: true},
+		{name: "comes on by the deadline", t: 25, deadline: 30, want: 30, wantOK: true},
+		{name: "comes on after the deadline", t: 25, deadline: 29},
+		{name: "ended at t", t: 20, deadline: 25},
+		{name: "never again", t: 40, deadline: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := activation(tl, at(tt.t, "", nil).Time, at(tt.deadline, "", nil).Time)
+			if ok != tt.wantOK || ok && !got.Equal(at(tt.want, "", nil).Time) {
+				t.Errorf("got %v, %v", got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// file: service/analytics/reliability.go
+// Mean time between failures, mean time to repair and availability
+package analytics
+
+import "sort"
+
+// Reliability summarises how often a set of faults stopped the machine and
+// how long it took to recover. A failure is a time the set went from no
+// fault active to at least one; downtime is the time any fault of the set
+// was active. Uptime is the time the machine was running without a fault of
+// the set active or, without a running field, the time no fault of the set
+// was active. Pointer values are nil when they would divide by zero.
+type Reliability struct {
+	Failures        int      `json:"failures"`
+	DowntimeSeconds float64  `json:"downtime_seconds"`
+	UptimeSeconds   float64  `json:"uptime_seconds"`
+	MTBFSeconds     *float64 `json:"mtbf_seconds"`
+	MTTRSeconds     *float64 `json:"mttr_seconds"`
+	// Availability is the percentage of uptime plus downtime that was
+	// uptime.
+	Availability *float64 `json:"availability"`
+}
+
+// ReliabilityReport holds the reliability of each fault field, each fault
+// category and the machine as a whole, which counts every fault field.
+type ReliabilityReport struct {
+	Machine    Reliability            `json:"machine"`
+	Categories map[string]Reliability `json:"categories"`
+	Faults     map[string]Reliability `json:"faults"`
+}
+
+// Reliability computes the reliability of the faults of h, which must also
+// hold running if it is not empty. categories maps each fault field to its
+// category; fields without one are left out of the category totals.
+func (h *History) Reliability(faults []string, categories map[string]string, running string) ReliabilityReport {
+	timelines := h.Timelines()
+	var runningTimeline []Interval
+	if running != "" {
+		runningTimeline = timelines[running]
+	}
+	measure := func(fields []string) Reliability {
+		sets := make([][]Interval, 0, len(fields))
+		startedBefore := false
+		for _, f := range fields {
+			sets = append(sets, timelines[f])
+			if b, _ := h.Initial[f].Value.(bool); b {
+				startedBefore = true
+			}
+		}
+		return h.reliability(anyTrue(sets...), startedBefore, runningTimeline, running != "")
+	}
+
+	report := ReliabilityReport{
+		Machine:    measure(faults),
+		Categories: make(map[string]Reliability),
+		Faults:     make(map[string]Reliability, len(faults)),
+	}
+	byCategory := make(map[string][]string)
+	for _, f := range faults {
+		report.Faults[f] = measure([]string{f})
+		if c, ok := categories[f]; ok {
+			byCategory[c] = append(byCategory[c], f)
+		}
+	}
+	for c, fields := range byCategory {
+		sort.Strings(fields)
+		report.Categories[c] = measure(fields)
+	}
+	return report
+}
+
+// reliability measures one combined fault timeline. startedBefore reports
+// whether a fault was already active when the range began, in which case an
+// occurrence starting at the range start is a repair but not a failure.
+func (h *History) reliability(faulted []Interval, startedBefore bool, running []Interval, hasRunning bool) Reliability {
+	var r Reliability
+	repairs := 0
+	for _, iv := range faulted {
+		if !iv.State {
+			continue
+		}
+		repairs++
+		if !(startedBefore && iv.Start.Equal(h.Start)) {
+			r.Failures++
+		}
+	}
+	r.DowntimeSeconds = secondsIn(faulted, true)
+	if hasRunning {
+		r.UptimeSeconds = secondsIn(running, true) - overlapSeconds(running, true, faulted, true)
+	} else {
+		r.UptimeSeconds = secondsIn(faulted, false)
+	}
+	if r.Failures > 0 {
+		r.MTBFSeconds = ratio(r.UptimeSeconds, float64(r.Failures))
+	}
+	if repairs > 0 {
+		r.MTTRSeconds = ratio(r.DowntimeSeconds, float64(repairs))
+	}
+	if total := r.UptimeSeconds + r.DowntimeSeconds; total > 0 {
+		r.Availability = ratio(r.UptimeSeconds*100, total)
+	}
+	return r
+}
+
+// ratio returns a pointer to a divided by b.
+func ratio(a, b float64) *float64 {
+	v := a / b
+	return &v
+}
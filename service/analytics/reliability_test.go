@@ -0,0 +1,79 @@
+// file: service/analytics/reliability_test.go
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+func TestReliability(t *testing.T) {
+	faults := []string{"A", "B", "C", "D"}
+	categories := map[string]string{"A": "Motion", "B": "Motion", "C": "Air"}
+	initial := map[string]store.Sample{
+		"A": at(-1, "A", false), "B": at(-1, "B", false), "C": at(-1, "C", false), "D": at(-1, "D", false),
+		"Run": at(-1, "Run", true),
+	}
+	samples := []store.Sample{
+		at(10, "A", true), at(12, "B", true), at(15, "A", false), at(20, "B", false),
+		at(30, "C", true), at(32, "C", false),
+		at(50, "Run", false),
+	}
+	type want struct {
+		failures         int
+		down, up         float64
+		mtbf, mttr, avai *float64
+	}
+	check := func(t *testing.T, name string, got Reliability, w want) {
+		t.Helper()
+		if got.Failures != w.failures || got.DowntimeSeconds != w.down || got.UptimeSeconds != w.up {
+			t.Errorf("%s: %d failures, %gs down, %gs up; want %d, %g, %g", name, got.Failures, got.DowntimeSeconds, got.UptimeSeconds, w.failures, w.down, w.up)
+		}
+		for _, m := range []struct {
+			name      string
+			got, want *float64
+		}{{"mtbf", got.MTBFSeconds, w.mtbf}, {"mttr", got.MTTRSeconds, w.mttr}, {"availability", got.Availability, w.avai}} {
+			if (m.got == nil) != (m.want == nil) || m.want != nil && !near(m.got, *m.want) {
+				t.Errorf("%s: %s %v, want %v", name, m.name, deref(m.got), deref(m.want))
+			}
+		}
+	}
+
+	t.Run("with a running field", func(t *testing.T) {
+		h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: append(faults, "Run"), Initial: initial, Samples: samples}
+		r := h.Reliability(faults, categories, "Run")
+		check(t, "machine", r.Machine, want{2, 720, 2280, fp(1140), fp(360), fp(76)})
+		check(t, "A", r.Faults["A"], want{1, 300, 2700, fp(2700), fp(300), fp(90)})
+		check(t, "B", r.Faults["B"], want{1, 480, 2520, fp(2520), fp(480), fp(84)})
+		check(t, "D", r.Faults["D"], want{0, 0, 3000, nil, nil, fp(100)})
+		check(t, "Motion", r.Categories["Motion"], want{1, 600, 2400, fp(2400), fp(600), fp(80)})
+		check(t, "Air", r.Categories["Air"], want{1, 120, 2880, fp(2880), fp(120), fp(96)})
+		if len(r.Categories) != 2 || len(r.Faults) != 4 {
+			t.Errorf("%d categories and %d faults", len(r.Categories), len(r.Faults))
+		}
+	})
+
+	t.Run("without a running field", func(t *testing.T) {
+		h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: faults, Initial: initial, Samples: samples}
+		r := h.Reliability(faults, categories, "")
+		check(t, "machine", r.Machine, want{2, 720, 2880, fp(1440), fp(360), fp(80)})
+		check(t, "A", r.Faults["A"], want{1, 300, 3300, fp(3300), fp(300), fp(100 * 3300.0 / 3600)})
+	})
+
+	t.Run("fault active at the start", func(t *testing.T) {
+		h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"A"},
+			Initial: map[string]store.Sample{"A": at(-5, "A", true)},
+			Samples: []store.Sample{at(5, "A", false), at(30, "A", true)}}
+		r := h.Reliability([]string{"A"}, nil, "")
+		// The fault active at the start is no failure, but both times the
+		// fault was down count towards MTTR.
+		check(t, "A", r.Faults["A"], want{1, 2100, 1500, fp(1500), fp(1050), fp(100 * 1500.0 / 3600)})
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"A"}}
+		r := h.Reliability([]string{"A"}, nil, "")
+		check(t, "A", r.Faults["A"], want{0, 0, 0, nil, nil, nil})
+	})
+}
@@ -0,0 +1,327 @@
+// file: service/analytics/spc.go
+// Control charts, run rules and process capability of float fields
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// Control chart types.
+const (
+	ChartXbarR = "xbar_r"
+	ChartIMR   = "i_mr"
+)
+
+// Run rule sets.
+const (
+	RulesNelson          = "nelson"
+	RulesWesternElectric = "western_electric"
+)
+
+// d2, D3 and D4 are the control chart constants for subgroups of n samples,
+// indexed by n.
+var (
+	chartD2 = []float64{2: 1.128, 1.693, 2.059, 2.326, 2.534, 2.704, 2.847, 2.970, 3.078}
+	chartD3 = []float64{2: 0, 0, 0, 0, 0, 0.076, 0.136, 0.184, 0.223}
+	chartD4 = []float64{2: 3.267, 2.574, 2.282, 2.114, 2.004, 1.924, 1.864, 1.816, 1.777}
+)
+
+// SPCConfig sets how a float field is charted. SubgroupSize is 1 for an I-MR
+// chart or 2 to 10 for X-bar/R. Either specification limit may be nil.
+type SPCConfig struct {
+	SubgroupSize int
+	Rules        string
+	LSL, USL     *float64
+	Target       *float64
+}
+
+// ControlLimits are the center line and control limits of one chart.
+type ControlLimits struct {
+	Center float64 `json:"center"`
+	UCL    float64 `json:"ucl"`
+	LCL    float64 `json:"lcl"`
+}
+
+// SPCPoint is one plotted point: a subgroup mean and range, or an individual
+// value and its moving range, stamped with the time of its last sample.
+// Range is null for the first individual. Rules lists the rules the point
+// violates.
+type SPCPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Range *float64  `json:"range"`
+	Rules []string  `json:"rules,omitempty"`
+}
+
+// Violation is a rule broken at a point. Chart is "x" for the chart of means
+// or individuals and "range" for the range chart.
+type Violation struct {
+	Time        time.Time `json:"time"`
+	Value       float64   `json:"value"`
+	Chart       string    `json:"chart"`
+	Rule        string    `json:"rule"`
+	Description string    `json:"description"`
+}
+
+// SPCChart is a control chart of one float field with its capability. The
+// limits are estimated from the charted data, and are null with fewer than
+// two points. Cp needs both specification limits and Cpk at least one; Cpm,
+// which also penalizes a mean off target, needs both limits and a target.
+type SPCChart struct {
+	Chart        string         `json:"chart"`
+	SubgroupSize int            `json:"subgroup_size"`
+	Rules        string         `json:"rules"`
+	Samples      int            `json:"samples"`
+	Mean         *float64       `json:"mean"`
+	Sigma        *float64       `json:"sigma"`
+	X            *ControlLimits `json:"x_limits"`
+	Range        *ControlLimits `json:"range_limits"`
+	Cp           *float64       `json:"cp"`
+	Cpk          *float64       `json:"cpk"`
+	Cpm          *float64       `json:"cpm"`
+	Points       []SPCPoint     `json:"points"`
+	Violations   []Violation    `json:"violations"`
+}
+
+// runRule is one run rule, checked at point i of the standardized values z
+// of the points x.
+type runRule struct {
+	id          string
+	description string
+	check       func(x, z []float64, i int) bool
+}
+
+var (
+	ruleBeyond3 = runRule{"", "One point beyond 3 sigma", func(x, z []float64, i int) bool {
+		return math.Abs(z[i]) > 3
+	}}
+	ruleTwoOfThree = runRule{"", "Two of three points beyond 2 sigma on one side", func(x, z []float64, i int) bool {
+		return countOnSide(z, i, 3, 2) >= 2
+	}}
+	ruleFourOfFive = runRule{"", "Four of five points beyond 1 sigma on one side", func(x, z []float64, i int) bool {
+		return countOnSide(z, i, 5, 1) >= 4
+	}}
+)
+
+// sameSide returns a rule for n points in a row on one side of the center.
+func sameSide(n int) runRule {
+	return runRule{"", fmt.Sprintf("%d points in a row on one side of the center", n), func(x, z []float64, i int) bool {
+		if i < n-1 {
+			return false
+		}
+		above, below := true, true
+		for _, v := range z[i-n+1 : i+1] {
+			above = above && v > 0
+			below = below && v < 0
+		}
+		return above || below
+	}}
+}
+
+// ruleSets are the run rules of each set, each named by its number in it.
+var ruleSets = map[string][]runRule{
+	RulesWesternElectric: {
+		named("WE1", ruleBeyond3), named("WE2", ruleTwoOfThree), named("WE3", ruleFourOfFive), named("WE4", sameSide(8)),
+	},
+	RulesNelson: {
+		named("N1", ruleBeyond3),
+		named("N2", sameSide(9)),
+		{"N3", "Six points in a row steadily increasing or decreasing", func(x, z []float64, i int) bool {
+			if i < 5 {
+				return false
+			}
+			up, down := true, true
+			for j := i - 4; j <= i; j++ {
+				up = up && x[j] > x[j-1]
+				down = down && x[j] < x[j-1]
+			}
+			return up || down
+		}},
+		{"N4", "Fourteen points in a row alternating up and down", func(x, z []float64, i int) bool {
+			if i < 13 {
+				return false
+			}
+			for j := i - 11; j <= i; j++ {
+				if (x[j]-x[j-1])*(x[j-1]-x[j-2]) >= 0 {
+					return false
+				}
+			}
+			return true
+		}},
+		named("N5", ruleTwoOfThree),
+		named("N6", ruleFourOfFive),
+		{"N7", "Fifteen points in a row within 1 sigma of the center", func(x, z []float64, i int) bool {
+			return runWithin(z, i, 15, func(v float64) bool { return math.Abs(v) < 1 })
+		}},
+		{"N8", "Eight points in a row beyond 1 sigma on either side", func(x, z []float64, i int) bool {
+			return runWithin(z, i, 8, func(v float64) bool { return math.Abs(v) > 1 })
+		}},
+	},
+}
+
+// named returns rule under the identifier it has in a rule set.
+func named(id string, rule runRule) runRule {
+	rule.id = id
+	return rule
+}
+
+// ValidRules reports whether rules names a run rule set.
+func ValidRules(rules string) bool {
+	_, ok := ruleSets[rules]
+	return ok
+}
+
+// countOnSide returns how many of the n points ending at i lie beyond limit
+// sigma on the side of point i, or 0 if point i itself does not.
+func countOnSide(z []float64, i, n int, limit float64) int {
+	if i < n-1 || math.Abs(z[i]) <= limit {
+		return 0
+	}
+	count := 0
+	for _, v := range z[i-n+1 : i+1] {
+		if v*z[i] > 0 && math.Abs(v) > limit {
+			count++
+		}
+	}
+	return count
+}
+
+// runWithin reports whether the n points ending at i all satisfy ok.
+func runWithin(z []float64, i, n int, ok func(float64) bool) bool {
+	if i < n-1 {
+		return false
+	}
+	for _, v := range z[i-n+1 : i+1] {
+		if !ok(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SPC charts the numeric samples of field in time order. Consecutive samples
+// form subgroups of cfg.SubgroupSize, and a trailing incomplete subgroup is
+// left out. Process sigma is estimated from the mean range.
+func SPC(samples []store.Sample, field string, cfg SPCConfig) SPCChart {
+	n := cfg.SubgroupSize
+	chart := SPCChart{Chart: ChartXbarR, SubgroupSize: n, Rules: cfg.Rules, Points: []SPCPoint{}, Violations: []Violation{}}
+	if n <= 1 {
+		n = 1
+		chart.Chart, chart.SubgroupSize = ChartIMR, 1
+	}
+
+	var values []float64
+	var times []time.Time
+	for _, s := range samples {
+		if s.Field != field {
+			continue
+		}
+		if _, isBool := s.Value.(bool); isBool {
+			continue
+		}
+		if v, ok := store.AsFloat(s.Value); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			values = append(values, v)
+			times = append(times, s.Time)
+		}
+	}
+	chart.Samples = len(values)
+
+	// Plot subgroup means and ranges, or individuals and moving ranges.
+	var ranges []float64
+	for i := 0; i+n <= len(values); i += n {
+		group := values[i : i+n]
+		p := SPCPoint{Time: times[i+n-1]}
+		lo, hi, sum := group[0], group[0], 0.0
+		for _, v := range group {
+			lo, hi, sum = math.Min(lo, v), math.Max(hi, v), sum+v
+		}
+		p.Value = sum / float64(n)
+		if n > 1 {
+			r := hi - lo
+			p.Range = &r
+		} else if len(chart.Points) > 0 {
+			r := math.Abs(p.Value - chart.Points[len(chart.Points)-1].Value)
+			p.Range = &r
+		}
+		if p.Range != nil {
+			ranges = append(ranges, *p.Range)
+		}
+		chart.Points = append(chart.Points, p)
+	}
+	if len(chart.Points) < 2 {
+		return chart
+	}
+
+	var grand, rbar float64
+	for _, p := range chart.Points {
+		grand += p.Value
+	}
+	grand /= float64(len(chart.Points))
+	for _, r := range ranges {
+		rbar += r
+	}
+	rbar /= float64(len(ranges))
+	d := max(n, 2)
+	sigma := rbar / chartD2[d]
+	spread := 3 * sigma / math.Sqrt(float64(n))
+	chart.Mean, chart.Sigma = &grand, &sigma
+	chart.X = &ControlLimits{Center: grand, UCL: grand + spread, LCL: grand - spread}
+	chart.Range = &ControlLimits{Center: rbar, UCL: chartD4[d] * rbar, LCL: chartD3[d] * rbar}
+
+	if sigma > 0 {
+		if cfg.LSL != nil && cfg.USL != nil {
+			cp := (*cfg.USL - *cfg.LSL) / (6 * sigma)
+			chart.Cp = &cp
+		}
+		cpk := math.Inf(1)
+		if cfg.USL != nil {
+			cpk = math.Min(cpk, (*cfg.USL-grand)/(3*sigma))
+		}
+		if cfg.LSL != nil {
+			cpk = math.Min(cpk, (grand-*cfg.LSL)/(3*sigma))
+		}
+		if !math.IsInf(cpk, 1) {
+			chart.Cpk = &cpk
+		}
+		if cfg.LSL != nil && cfg.USL != nil && cfg.Target != nil {
+			cpm := (*cfg.USL - *cfg.LSL) / (6 * math.Hypot(sigma, grand-*cfg.Target))
+			chart.Cpm = &cpm
+		}
+	}
+
+	chart.flag(spread / 3)
+	return chart
+}
+
+// flag checks the points against the run rules on the chart of means, with
+// pointSigma the standard deviation of a point, and against the range
+// limits on the range chart.
+func (c *SPCChart) flag(pointSigma float64) {
+	x := make([]float64, len(c.Points))
+	z := make([]float64, len(c.Points))
+	for i, p := range c.Points {
+		x[i] = p.Value
+		if pointSigma > 0 {
+			z[i] = (p.Value - c.X.Center) / pointSigma
+		}
+	}
+	for i := range c.Points {
+		p := &c.Points[i]
+		if pointSigma > 0 {
+			for _, rule := range ruleSets[c.Rules] {
+				if rule.check(x, z, i) {
+					p.Rules = append(p.Rules, rule.id)
+					c.Violations = append(c.Violations, Violation{Time: p.Time, Value: p.Value, Chart: "x", Rule: rule.id, Description: rule.description})
+				}
+			}
+		}
+		if p.Range != nil && (*p.Range > c.Range.UCL || *p.Range < c.Range.LCL) {
+			p.Rules = append(p.Rules, "R1")
+			c.Violations = append(c.Violations, Violation{Time: p.Time, Value: *p.Range, Chart: "range", Rule: "R1", Description: "Range beyond its control limits"})
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// file: service/analytics/spc_test.go
+package analytics
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"vtarchitect/store"
+)
+
+// series returns samples of field with the given values, one a minute.
+func series(field string, values ...interface{}) []store.Sample {
+	samples := make([]store.Sample, len(values))
+	for i, v := range values {
+		samples[i] = at(float64(i), field, v)
+	}
+	return samples
+}
+
+func near(a *float64, b float64) bool {
+	return a != nil && math.Abs(*a-b) < 1e-9
+}
+
+func TestSPCLimits(t *testing.T) {
+	tests := []struct {
+		name                string
+		samples             []store.Sample
+		cfg                 SPCConfig
+		chart               string
+		count, points       int
+		mean, sigma, spread float64
+		rbar, rUCL, rLCL    float64
+	}{
+		{name: "individuals", samples: series("X", 10.0, 12.0, 11.0, 13.0, 10.0), cfg: SPCConfig{SubgroupSize: 1},
+			chart: ChartIMR, count: 5, points: 5, mean: 11.2, sigma: 2 / 1.128, spread: 3 * 2 / 1.128,
+			rbar: 2, rUCL: 3.267 * 2, rLCL: 0},
+		{name: "subgroups of two", samples: series("X", 1.0, 3.0, 2.0, 4.0, 3.0, 5.0, 7.0), cfg: SPCConfig{SubgroupSize: 2},
+			chart: ChartXbarR, count: 7, points: 3, mean: 3, sigma: 2 / 1.128, spread: 3 * 2 / 1.128 / math.Sqrt2,
+			rbar: 2, rUCL: 3.267 * 2, rLCL: 0},
+		{name: "subgroups of seven", samples: series("X", 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0), cfg: SPCConfig{SubgroupSize: 7},
+			chart: ChartXbarR, count: 14, points: 2, mean: 4.5, sigma: 6 / 2.704, spread: 3 * 6 / 2.704 / math.Sqrt(7),
+			rbar: 6, rUCL: 1.924 * 6, rLCL: 0.076 * 6},
+		{name: "other fields and non-numbers skipped",
+			samples: append(series("X", 10.0, int64(12), true, math.NaN(), "on", uint64(11)), at(1, "Y", 100.0)), cfg: SPCConfig{},
+			chart: ChartIMR, count: 3, points: 3, mean: 11, sigma: 1.5 / 1.128, spread: 3 * 1.5 / 1.128,
+			rbar: 1.5, rUCL: 3.267 * 1.5, rLCL: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := SPC(tt.samples, "X", tt.cfg)
+			if c.Chart != tt.chart || c.Samples != tt.count || len(c.Points) != tt.points {
+				t.Fatalf("chart %s of %d samples and %d points", c.Chart, c.Samples, len(c.Points))
+			}
+			if !near(c.Mean, tt.mean) || !near(c.Sigma, tt.sigma) {
+				t.Errorf("mean %v, sigma %v", *c.Mean, *c.Sigma)
+			}
+			if math.Abs(c.X.Center-tt.mean) > 1e-9 || math.Abs(c.X.UCL-tt.mean-tt.spread) > 1e-9 || math.Abs(c.X.LCL-tt.mean+tt.spread) > 1e-9 {
+				t.Errorf("x limits %+v", *c.X)
+			}
+			if math.Abs(c.Range.Center-tt.rbar) > 1e-9 || math.Abs(c.Range.UCL-tt.rUCL) > 1e-9 || math.Abs(c.Range.LCL-tt.rLCL) > 1e-9 {
+				t.Errorf("range limits %+v", *c.Range)
+			}
+			if tt.chart == ChartIMR && c.Points[0].Range != nil {
+				t.Errorf("first individual has range %v", *c.Points[0].Range)
+			}
+		})
+	}
+}
+
+func TestSPCCapability(t *testing.T) {
+	samples := series("X", 10.0, 12.0, 11.0, 13.0, 10.0)
+	sigma := 2 / 1.128
+	tests := []struct {
+		name         string
+		cfg          SPCConfig
+		cp, cpk, cpm *float64
+	}{
+		{name: "both limits and target", cfg: SPCConfig{LSL: fp(5), USL: fp(20), Target: fp(12)},
+			cp: fp(15 / (6 * sigma)), cpk: fp((11.2 - 5) / (3 * sigma)), cpm: fp(15 / (6 * math.Hypot(sigma, -0.8)))},
+		{name: "upper limit only", cfg: SPCConfig{USL: fp(14)}, cpk: fp((14 - 11.2) / (3 * sigma))},
+		{name: "lower limit only", cfg: SPCConfig{LSL: fp(8)}, cpk: fp((11.2 - 8) / (3 * sigma))},
+		{name: "no limits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := SPC(samples, "X", tt.cfg)
+			for _, m := range []struct {
+				name      string
+				got, want *float64
+			}{{"cp", c.Cp, tt.cp}, {"cpk", c.Cpk, tt.cpk}, {"cpm", c.Cpm, tt.cpm}} {
+				if (m.got == nil) != (m.want == nil) || m.want != nil && !near(m.got, *m.want) {
+					t.Errorf("%s %v, want %v", m.name, deref(m.got), deref(m.want))
+				}
+			}
+		})
+	}
+}
+
+func deref(p *float64) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func TestSPCTooFewPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []store.Sample
+		cfg     SPCConfig
+	}{
+		{name: "no samples"},
+		{name: "one individual", samples: series("X", 1.0)},
+		{name: "one full subgroup", samples: series("X", 1.0, 2.0, 3.0, 4.0, 5.0), cfg: SPCConfig{SubgroupSize: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := SPC(tt.samples, "X", tt.cfg)
+			if c.Mean != nil || c.Sigma != nil || c.X != nil || c.Range != nil || c.Cpk != nil {
+				t.Errorf("limits from too few points: %+v", c)
+			}
+			if c.Points == nil || c.Violations == nil {
+				t.Error("nil points or violations")
+			}
+		})
+	}
+}
+
+func TestSPCRules(t *testing.T) {
+	// stable alternates between 10 and 11, so the moving range is 1.
+	stable := func(n int) []interface{} {
+		var vs []interface{}
+		for i := 0; i < n; i++ {
+			vs = append(vs, 10+float64(i%2))
+		}
+		return vs
+	}
+	tests := []struct {
+		name   string
+		values []interface{}
+		rules  string
+		want   map[string][]int // the points breaking each rule checked
+	}{
+		{name: "constant", values: []interface{}{5.0, 5.0, 5.0, 5.0}, rules: RulesNelson, want: map[string][]int{}},
+		{name: "beyond three sigma", values: append(stable(20), 30.0), rules: RulesWesternElectric,
+			want: map[string][]int{"WE1": {20}, "R1": {20}}},
+		{name: "beyond three sigma, Nelson", values: append(stable(20), 30.0), rules: RulesNelson,
+			want: map[string][]int{"N1": {20}, "R1": {20}}},
+		{name: "steady rise", values: append(stable(20), 10.1, 10.2, 10.3, 10.4, 10.5, 10.6), rules: RulesNelson,
+			want: map[string][]int{"N3": {25}}},
+		{name: "alternating", values: stable(16), rules: RulesNelson,
+			want: map[string][]int{"N4": {13, 14, 15}, "N1": nil, "R1": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := SPC(series("X", tt.values...), "X", SPCConfig{SubgroupSize: 1, Rules: tt.rules})
+			got := make(map[string][]int)
+			for i, p := range c.Points {
+				for _, rule := range p.Rules {
+					got[rule] = append(got[rule], i)
+				}
+			}
+			if len(tt.want) == 0 && len(c.Violations) != 0 {
+				t.Errorf("violations %+v", c.Violations)
+			}
+			for rule, want := range tt.want {
+				if !slices.Equal(got[rule], want) {
+					t.Errorf("%s broken at %v, want %v", rule, got[rule], want)
+				}
+			}
+			n := 0
+			for _, points := range got {
+				n += len(points)
+			}
+			if len(c.Violations) != n {
+				t.Errorf("%d violations for %d broken rules", len(c.Violations), n)
+			}
+		})
+	}
+}
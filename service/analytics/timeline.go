@@ -0,0 +1,158 @@
+// file: service/analytics/timeline.go
+// State timelines of boolean fields
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval is a span of time during which a boolean field held one state.
+type Interval struct {
+	State   bool      `json:"state"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Seconds float64   `json:"duration_seconds"`
+}
+
+// timeline accumulates the intervals of one field as its values arrive.
+type timeline struct {
+	known     bool
+	state     bool
+	since     time.Time
+	intervals []Interval
+}
+
+// set records that the field took state at t. Values that repeat the current
+// state extend it; a state that lasts no time at all is dropped.
+func (tl *timeline) set(t time.Time, state bool) {
+	if tl.known && state == tl.state {
+		return
+	}
+	if tl.known && t.After(tl.since) {
+		tl.intervals = append(tl.intervals, newInterval(tl.state, tl.since, t))
+	} else if n := len(tl.intervals); n > 0 && tl.intervals[n-1].State == state && tl.intervals[n-1].End.Equal(t) {
+		t = tl.intervals[n-1].Start
+		tl.intervals = tl.intervals[:n-1]
+	}
+	tl.known, tl.state, tl.since = true, state, t
+}
+
+// close ends the current state at stop and returns the intervals.
+func (tl *timeline) close(stop time.Time) []Interval {
+	if tl.known && stop.After(tl.since) {
+		tl.intervals = append(tl.intervals, newInterval(tl.state, tl.since, stop))
+		tl.known = false
+	}
+	if tl.intervals == nil {
+		return []Interval{}
+	}
+	return tl.intervals
+}
+
+func newInterval(state bool, start, end time.Time) Interval {
+	return Interval{State: state, Start: start, End: end, Seconds: end.Sub(start).Seconds()}
+}
+
+// Timelines returns, for each boolean field of h, the consecutive intervals
+// it held each state, clipped to the range. A field starts in the state of
+// its last value before the range; if it has none, its first interval begins
+// at its first value in the range. Fields without values get no intervals.
+func (h *History) Timelines() map[string][]Interval {
+	timelines := make(map[string]*timeline, len(h.Fields))
+	for _, f := range h.Fields {
+		tl := &timeline{}
+		if b, ok := h.Initial[f].Value.(bool); ok {
+			tl.set(h.Start, b)
+		}
+		timelines[f] = tl
+	}
+	for _, s := range h.Samples {
+		b, ok := s.Value.(bool)
+		tl := timelines[s.Field]
+		if !ok || tl == nil {
+			continue
+		}
+		tl.set(s.Time, b)
+	}
+	result := make(map[string][]Interval, len(timelines))
+	for f, tl := range timelines {
+		result[f] = tl.close(h.Stop)
+	}
+	return result
+}
+
+// anyTrue combines timelines into one that is true while any of them is
+// true, and known while any of them is known.
+func anyTrue(timelines ...[]Interval) []Interval {
+	trues := make(map[int64]int)
+	known := make(map[int64]int)
+	var times []time.Time
+	mark := func(t time.Time, k, v int) {
+		n := t.UnixNano()
+		if _, ok := known[n]; !ok {
+			times = append(times, t)
+		}
+		known[n] += k
+		trues[n] += v
+	}
+	for _, tl := range timelines {
+		for _, iv := range tl {
+			v := 0
+			if iv.State {
+				v = 1
+			}
+			mark(iv.Start, 1, v)
+			mark(iv.End, -1, -v)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	combined := &timeline{}
+	nTrue, nKnown := 0, 0
+	for _, t := range times {
+		nTrue += trues[t.UnixNano()]
+		nKnown += known[t.UnixNano()]
+		if nKnown > 0 {
+			combined.set(t, nTrue > 0)
+		} else {
+			combined.close(t)
+		}
+	}
+	return combined.intervals
+}
+
+// secondsIn returns the time tl spent in state.
+func secondsIn(tl []Interval, state bool) float64 {
+	var total float64
+	for _, iv := range tl {
+		if iv.State == state {
+			total += iv.Seconds
+		}
+	}
+	return total
+}
+
+// overlapSeconds returns the time a spent in stateA while b was in stateB.
+func overlapSeconds(a []Interval, stateA bool, b []Interval, stateB bool) float64 {
+	var total float64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i].State == stateA && b[j].State == stateB {
+			start, end := a[i].Start, a[i].End
+			if b[j].Start.After(start) {
+				start = b[j].Start
+			}
+			if b[j].End.Before(end) {
+				end = b[j].End
+			}
+			if end.After(start) {
+				total += end.Sub(start).Seconds()
+			}
+		}
+		if a[i].End.Before(b[j].End) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return total
+}
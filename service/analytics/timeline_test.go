@@ -0,0 +1,130 @@
+// file: service/analytics/timeline_test.go
+package analytics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// intervals lists a timeline as states and minutes after t0, such as
+// "T0-15 F15-60".
+func intervals(tl []Interval) string {
+	var parts []string
+	for _, iv := range tl {
+		state := "F"
+		if iv.State {
+			state = "T"
+		}
+		if iv.Seconds != iv.End.Sub(iv.Start).Seconds() {
+			state += "?"
+		}
+		parts = append(parts, fmt.Sprintf("%s%g-%g", state, iv.Start.Sub(t0).Minutes(), iv.End.Sub(t0).Minutes()))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestTimelines(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial map[string]store.Sample
+		samples []store.Sample
+		want    string
+	}{
+		{name: "initial state", initial: map[string]store.Sample{"A": at(-30, "A", true)},
+			samples: []store.Sample{at(15, "A", false), at(45, "A", true)},
+			want:    "T0-15 F15-45 T45-60"},
+		{name: "no initial state", samples: []store.Sample{at(10, "A", true), at(20, "A", false)},
+			want: "T10-20 F20-60"},
+		{name: "repeated values", initial: map[string]store.Sample{"A": at(-1, "A", false)},
+			samples: []store.Sample{at(5, "A", false), at(10, "A", true), at(20, "A", true), at(30, "A", false)},
+			want:    "F0-10 T10-30 F30-60"},
+		{name: "sample at the start replaces the initial state", initial: map[string]store.Sample{"A": at(-5, "A", true)},
+			samples: []store.Sample{at(0, "A", false)},
+			want:    "F0-60"},
+		{name: "state lasting no time", initial: map[string]store.Sample{"A": at(-5, "A", true)},
+			samples: []store.Sample{at(20, "A", false), at(20, "A", true)},
+			want:    "T0-60"},
+		{name: "sample at the stop", samples: []store.Sample{at(30, "A", true), at(60, "A", false)},
+			want: "T30-60"},
+		{name: "non-boolean values", samples: []store.Sample{at(10, "A", 1.0), at(20, "A", "on"), at(30, "A", true)},
+			want: "T30-60"},
+		{name: "no values"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &History{Start: t0, Stop: t0.Add(time.Hour), Fields: []string{"A"}, Initial: tt.initial, Samples: tt.samples}
+			got := h.Timelines()
+			if got["A"] == nil {
+				t.Fatal("no timeline for A")
+			}
+			if s := intervals(got["A"]); s != tt.want {
+				t.Errorf("got %q, want %q", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnyTrue(t *testing.T) {
+	h := &History{
+		Start:  t0,
+		Stop:   t0.Add(time.Hour),
+		Fields: []string{"A", "B", "C"},
+		Samples: []store.Sample{
+			at(0, "A", false), at(10, "A", true), at(20, "A", false),
+			at(15, "B", true), at(25, "B", false), at(30, "B", true), at(40, "B", false),
+			at(50, "C", false),
+		},
+	}
+	tl := h.Timelines()
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{name: "overlapping", fields: []string{"A", "B"}, want: "F0-10 T10-25 F25-30 T30-40 F40-60"},
+		{name: "one unknown until later", fields: []string{"B", "C"}, want: "T15-25 F25-30 T30-40 F40-60"},
+		{name: "single", fields: []string{"A"}, want: "F0-10 T10-20 F20-60"},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var timelines [][]Interval
+			for _, f := range tt.fields {
+				timelines = append(timelines, tl[f])
+			}
+			if got := intervals(anyTrue(timelines...)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(tt.fields) == 2 {
+				a, b := tl[tt.fields[0]], tl[tt.fields[1]]
+				if got, want := overlapSeconds(a, true, b, true), overlapTrue(t, a, b); got != want {
+					t.Errorf("overlap %g s, want %g s", got, want)
+				}
+			}
+		})
+	}
+}
+
+// overlapTrue returns the seconds a and b were both true, minute by minute.
+func overlapTrue(t *testing.T, a, b []Interval) float64 {
+	t.Helper()
+	stateAt := func(tl []Interval, m time.Time) bool {
+		for _, iv := range tl {
+			if !m.Before(iv.Start) && m.Before(iv.End) {
+				return iv.State
+			}
+		}
+		return false
+	}
+	var total float64
+	for m := t0; m.Before(t0.Add(time.Hour)); m = m.Add(time.Minute) {
+		if stateAt(a, m) && stateAt(b, m) {
+			total += 60
+		}
+	}
+	return total
+}
@@ -3,19 +3,26 @@
 package api
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"math"
 	"net/http"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"vtarchitect/analytics"
 	"vtarchitect/config"
 	"vtarchitect/data"
-	"vtarchitect/influx"
+	"vtarchitect/store"
+	"vtarchitect/utils"
 )
 
 //go:embed static/* static/**/*
@@ -26,40 +33,162 @@ type StatsResponse struct {
 	ProjectMeta        map[string]string  `json:"project_meta,omitempty"`
 	SystemStatus       map[string]bool    `json:"system_status"`
 	BooleanPercentages map[string]float64 `json:"boolean_percentages"`
-	FaultCounts        map[string]float64 `json:"fault_counts"`
-	FloatAverages      map[string]float64 `json:"float_averages"`
+	// BooleanStats weights each boolean state by how long it held, rather
+	// than by how many samples recorded it.
+	BooleanStats map[string]analytics.BooleanStats `json:"boolean_stats"`
+	FaultCounts  map[string]float64                `json:"fault_counts"`
+	// FaultRootCounts counts the fault cascades each fault led as the
+	// first-out fault, leaving out the times it followed another.
+	FaultRootCounts map[string]float64 `json:"fault_root_counts"`
+	FloatAverages   map[string]float64 `json:"float_averages"`
 }
 
 // ---
 
-func isValidFluxTime(input string) bool {
-	if input == "now()" || (len(input) > 1 && input[0] == '-') {
-		return true
+// relativeTimeUnit matches one component of a relative time such as "-1h30m".
+var relativeTimeUnit = regexp.MustCompile(`(\d{1,9})(ns|us|µs|ms|mo|s|m|h|d|w|y)`)
+
+// parseTimeParam resolves a time query parameter against now. It accepts
+// "now()", an RFC3339 timestamp, or a negative relative duration made of
+// Flux-style units, e.g. "-1h", "-7d" or "-1mo2w".
+func parseTimeParam(input string, now time.Time) (time.Time, error) {
+	if input == "now()" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, input); err == nil {
+		return t, nil
+	}
+	rel, ok := strings.CutPrefix(input, "-")
+	if !ok || rel == "" {
+		return time.Time{}, fmt.Errorf("unrecognised time '%s'", input)
+	}
+	t := now
+	matches := relativeTimeUnit.FindAllStringSubmatchIndex(rel, -1)
+	consumed := 0
+	for _, m := range matches {
+		if m[0] != consumed {
+			return time.Time{}, fmt.Errorf("unrecognised time '%s'", input)
+		}
+		consumed = m[1]
+		n, err := strconv.Atoi(rel[m[2]:m[3]])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unrecognised time '%s'", input)
+		}
+		var unit time.Duration
+		switch rel[m[4]:m[5]] {
+		case "y":
+			t = t.AddDate(-n, 0, 0)
+		case "mo":
+			t = t.AddDate(0, -n, 0)
+		case "w":
+			t = t.AddDate(0, 0, -7*n)
+		case "d":
+			t = t.AddDate(0, 0, -n)
+		case "h":
+			unit = time.Hour
+		case "m":
+			unit = time.Minute
+		case "s":
+			unit = time.Second
+		case "ms":
+			unit = time.Millisecond
+		case "us", "µs":
+			unit = time.Microsecond
+		case "ns":
+			unit = time.Nanosecond
+		}
+		if unit != 0 {
+			if time.Duration(n) > math.MaxInt64/unit {
+				return time.Time{}, fmt.Errorf("time '%s' is out of range", input)
+			}
+			t = t.Add(-time.Duration(n) * unit)
+		}
+	}
+	if consumed != len(rel) {
+		return time.Time{}, fmt.Errorf("unrecognised time '%s'", input)
 	}
-	_, err := time.Parse(time.RFC3339, input)
-	return err == nil
+	return t, nil
 }
 
 // parseTimeRange extracts and validates 'start' and 'stop' query parameters from
 // an HTTP request. It provides default values ("-1h" for start, "now()" for stop)
-// if they are not present. It returns an error if the time formats are invalid.
-func parseTimeRange(r *http.Request) (start, stop string, err error) {
-	start = r.URL.Query().Get("start")
-	if start == "" {
-		start = "-1h" // Default start time
-	} else if !isValidFluxTime(start) {
-		return "", "", fmt.Errorf("invalid start time format")
+// if they are not present. A 'shift' parameter gives the range as a shift from
+// the shift calendar instead, and cannot be combined with 'start' or 'stop'.
+// It returns an error if the time formats are invalid or the range is empty.
+func parseTimeRange(r *http.Request) (start, stop time.Time, err error) {
+	now := time.Now()
+	if shiftParam := r.URL.Query().Get("shift"); shiftParam != "" {
+		if r.URL.Query().Get("start") != "" || r.URL.Query().Get("stop") != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("'shift' cannot be combined with 'start' or 'stop'")
+		}
+		return parseShiftParam(shiftParam, now)
+	}
+	startParam := r.URL.Query().Get("start")
+	if startParam == "" {
+		startParam = "-1h" // Default start time
+	}
+	start, err = parseTimeParam(startParam, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time format")
 	}
 
-	stop = r.URL.Query().Get("stop")
-	if stop == "" {
-		stop = "now()" // Default stop time
-	} else if !isValidFluxTime(stop) {
-		return "", "", fmt.Errorf("invalid stop time format")
+	stopParam := r.URL.Query().Get("stop")
+	if stopParam == "" {
+		stopParam = "now()" // Default stop time
+	}
+	stop, err = parseTimeParam(stopParam, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid stop time format")
+	}
+	if !start.Before(stop) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start time must be before stop time")
 	}
 	return start, stop, nil
 }
 
+// dataMeasurement returns the measurement the acquisition loops write to.
+func dataMeasurement(cfg *config.Config) string {
+	if m := cfg.Values["INFLUXDB_MEASUREMENT"]; m != "" {
+		return m
+	}
+	return "status_data"
+}
+
+// parseQuery builds a store query from the common 'bucket', 'start', 'stop'
+// and 'tag' request parameters, over the data measurement.
+func parseQuery(cfg *config.Config, r *http.Request) (store.Query, error) {
+	q := store.Query{
+		Bucket:      r.URL.Query().Get("bucket"),
+		Measurement: dataMeasurement(cfg),
+	}
+	if q.Bucket == "" {
+		q.Bucket = cfg.Values["INFLUXDB_BUCKET"]
+	}
+	var err error
+	if q.Start, q.Stop, err = parseTimeRange(r); err != nil {
+		return store.Query{}, err
+	}
+	if q.Tags, err = parseTagFilters(r); err != nil {
+		return store.Query{}, err
+	}
+	return q, nil
+}
+
+// parseTagFilters extracts the optional 'tag' query parameters, each of the
+// form key:value, used to limit queries to points carrying those tags. Repeat
+// the parameter to filter on several tags.
+func parseTagFilters(r *http.Request) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, param := range r.URL.Query()["tag"] {
+		key, value, ok := strings.Cut(param, ":")
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid tag filter '%s', expected key:value", param)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
 // respondWithError is a helper to send a JSON error message with a status code.
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -67,29 +196,119 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	json.NewEncoder(w).Encode(map[string]string{"message": message})
 }
 
+// latestSystemStatus returns the latest value of each SystemStatusBits field
+// and the comm status, looking back a day from now regardless of the range
+// of q.
+func latestSystemStatus(ctx context.Context, st store.Store, arch *data.ArchitectYAML, q store.Query) (map[string]bool, error) {
+	// Get the system status fields
+	systemStatusFields := make([]string, 0)
+	for _, f := range arch.BooleanFields {
+		if strings.HasPrefix(f.Name, "SystemStatusBits") {
+			systemStatusFields = append(systemStatusFields, f.Name)
+		}
+	}
+	// Data freshness reported by the acquisition loop
+	systemStatusFields = append(systemStatusFields, store.CommStatusField)
+
+	statusQuery := q
+	statusQuery.Fields = systemStatusFields
+	statusQuery.Stop = time.Now()
+	statusQuery.Start = statusQuery.Stop.Add(-24 * time.Hour)
+	systemStatus, err := st.GetSystemStatus(ctx, statusQuery)
+	if err != nil {
+		return nil, fmt.Errorf("System status error: %w", err)
+	}
+	return systemStatus, nil
+}
+
+// collectStats runs the /api/stats aggregations over the range of q.
+func collectStats(ctx context.Context, cfg *config.Config, st store.Store, arch *data.ArchitectYAML, q store.Query, systemStatus map[string]bool) (StatsResponse, error) {
+	// Always use the field names from YAML cache for queries
+	booleanFields := make([]string, 0, len(arch.BooleanFields))
+	for _, f := range arch.BooleanFields {
+		booleanFields = append(booleanFields, f.Name)
+	}
+	faultFields := make([]string, 0, len(arch.FaultFields))
+	for _, f := range arch.FaultFields {
+		faultFields = append(faultFields, f.Name)
+	}
+	// Generate the combined/namespaced float field names
+	floatFields := data.GetCombinedFloatFields(arch)
+
+	// Aggregate booleans (percentage true)
+	q.Fields = booleanFields
+	boolResults, err := st.AggregateBooleanPercentages(ctx, q)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("Boolean aggregation error: %w", err)
+	}
+	// Aggregate faults (count true)
+	q.Fields = faultFields
+	faultResults, err := st.AggregateFaultCounts(ctx, q)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("Fault aggregation error: %w", err)
+	}
+	// Time-weighted boolean statistics come from the rollups over long
+	// ranges, leaving only the fault transitions to read from raw data for
+	// the first-out counts. Otherwise one raw read serves both.
+	q.Fields = booleanFields
+	boolStats, summarised, err := analytics.SummarisedBooleanStats(ctx, st, q, utils.GetStateLookback(cfg))
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("Boolean statistics error: %w", err)
+	}
+	q.Fields = faultFields
+	if !summarised {
+		q.Fields = uniqueStrings(append(append([]string{}, booleanFields...), faultFields...))
+	}
+	history, err := analytics.LoadHistory(ctx, st, q, utils.GetStateLookback(cfg))
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("State history error: %w", err)
+	}
+	if !summarised {
+		allStats := history.BooleanStats()
+		boolStats = make(map[string]analytics.BooleanStats, len(booleanFields))
+		for _, f := range booleanFields {
+			if s, ok := allStats[f]; ok {
+				boolStats[f] = s
+			}
+		}
+	}
+	// Aggregate floats (mean)
+	q.Fields = floatFields
+	floatResults, err := st.AggregateFloatMeans(ctx, q)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("Float aggregation error: %w", err)
+	}
+
+	return StatsResponse{
+		ProjectMeta:        arch.ProjectMeta,
+		SystemStatus:       systemStatus,
+		BooleanPercentages: boolResults,
+		BooleanStats:       boolStats,
+		FaultCounts:        faultResults,
+		FaultRootCounts:    analytics.RootCounts(history.FaultCascades(faultFields, arch.CascadeWindow())),
+		FloatAverages:      floatResults,
+	}, nil
+}
+
 // StartAPIServer initializes and starts the HTTP server. It sets up all API
 // handlers for querying data and uploading configurations, and also serves the
 // static frontend application. This function blocks and should typically be run
-// in a separate goroutine.
-func StartAPIServer(cfg *config.Config, client *influx.Client) {
+// in a separate goroutine. When ctx is cancelled the server is shut down,
+// giving in-flight requests up to SHUTDOWN_TIMEOUT_SECONDS to complete. It
+// returns nil after a clean shutdown.
+func StartAPIServer(ctx context.Context, cfg *config.Config, st store.Store, batchWriter *store.ChannelBatchWriter, detector *data.AnomalyDetector) error {
 	http.HandleFunc("/api/percentages", func(w http.ResponseWriter, r *http.Request) {
-		bucket := r.URL.Query().Get("bucket")
-		if bucket == "" {
-			bucket = cfg.Values["INFLUXDB_BUCKET"]
-		}
-
-		start, stop, err := parseTimeRange(r)
+		q, err := parseQuery(cfg, r)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		fields, err := data.GetBooleanFieldNames()
+		q.Fields, err = data.GetBooleanFieldNames()
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Failed to load boolean field names")
 			return
 		}
-		measurement := cfg.Values["INFLUXDB_MEASUREMENT"]
-		results, err := client.AggregateBooleanPercentages(measurement, bucket, fields, start, stop)
+		results, err := st.AggregateBooleanPercentages(r.Context(), q)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -100,12 +319,7 @@ func StartAPIServer(cfg *config.Config, client *influx.Client) {
 	})
 
 	http.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
-		bucket := r.URL.Query().Get("bucket")
-		if bucket == "" {
-			bucket = cfg.Values["INFLUXDB_BUCKET"]
-		}
-
-		start, stop, err := parseTimeRange(r)
+		q, err := parseQuery(cfg, r)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
@@ -116,90 +330,37 @@ func StartAPIServer(cfg *config.Config, client *influx.Client) {
 			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
 			return
 		}
-
-		// Always use the Influx field/tag names from YAML cache for queries
-		booleanFields := make([]string, 0, len(arch.BooleanFields))
-		for _, f := range arch.BooleanFields {
-			booleanFields = append(booleanFields, f.Name)
-		}
-		faultFields := make([]string, 0, len(arch.FaultFields))
-		for _, f := range arch.FaultFields {
-			faultFields = append(faultFields, f.Name)
-		}
-		// Generate the combined/namespaced float field names for InfluxDB
-		floatFields := data.GetCombinedFloatFields(arch)
-
-		// Get the system status fields
-		systemStatusFields := make([]string, 0)
-		for _, f := range arch.BooleanFields {
-			if strings.HasPrefix(f.Name, "SystemStatusBits") {
-				systemStatusFields = append(systemStatusFields, f.Name)
-			}
-		}
-
-		measurement := cfg.Values["INFLUXDB_MEASUREMENT"]
-		if measurement == "" {
-			measurement = "status_data"
-		}
-
-		// Get the latest system status
-		systemStatus, err := client.GetSystemStatus(measurement, bucket, systemStatusFields)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "System status error: "+err.Error())
-			return
-		}
-
-		// Aggregate booleans (percentage true)
-		boolResults, err := client.AggregateBooleanPercentages(measurement, bucket, booleanFields, start, stop)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Boolean aggregation error: "+err.Error())
-			return
-		}
-		// Aggregate faults (count true)
-		faultResults, err := client.AggregateFaultCounts(measurement, bucket, faultFields, start, stop)
+		systemStatus, err := latestSystemStatus(r.Context(), st, arch, q)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Fault aggregation error: "+err.Error())
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		// Aggregate floats (mean)
-		floatResults, err := client.AggregateFloatMeans(measurement, bucket, floatFields, start, stop)
+		results, err := collectStats(r.Context(), cfg, st, arch, q, systemStatus)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Float aggregation error: "+err.Error())
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		results := StatsResponse{
-			ProjectMeta:        arch.ProjectMeta,
-			SystemStatus:       systemStatus,
-			BooleanPercentages: boolResults,
-			FaultCounts:        faultResults,
-			FloatAverages:      floatResults,
-		}
-
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(results)
 	})
 
 	http.HandleFunc("/api/float-range", func(w http.ResponseWriter, r *http.Request) {
-		bucket := r.URL.Query().Get("bucket")
-		if bucket == "" {
-			bucket = cfg.Values["INFLUXDB_BUCKET"]
-		}
-
 		field := r.URL.Query().Get("field")
 		if field == "" {
 			respondWithError(w, http.StatusBadRequest, "Missing required 'field' query parameter")
 			return
 		}
 
-		start, stop, err := parseTimeRange(r)
+		q, err := parseQuery(cfg, r)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		q.Fields = []string{field}
 
-		// Call the InfluxDB client to get the float range data
-		rangeData, err := client.GetFloatRange(bucket, field, start, stop)
+		// Query the storage backend for the float range data
+		rangeData, err := st.GetFloatRange(r.Context(), q)
 		if err != nil {
 			log.Printf("ERROR: Error getting float range data for field '%s': %v", field, err)
 			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve float range data: "+err.Error())
@@ -210,6 +371,38 @@ func StartAPIServer(cfg *config.Config, client *influx.Client) {
 		json.NewEncoder(w).Encode(rangeData)
 	})
 
+	http.HandleFunc("/api/series", handleSeries(cfg, st))
+	http.HandleFunc("/api/timeline", handleTimeline(cfg, st))
+	http.HandleFunc("/api/boolean-stats", handleBooleanStats(cfg, st))
+	http.HandleFunc("/api/fault-events", handleFaultEvents(cfg, st))
+	http.HandleFunc("/api/reliability", handleReliability(cfg, st))
+	http.HandleFunc("/api/oee", handleOEE(cfg, st))
+	http.HandleFunc("/api/downtime-pareto", handleDowntimePareto(cfg, st))
+	http.HandleFunc("/api/shifts", handleShifts(cfg, st))
+	http.HandleFunc("/api/compare", handleCompare(cfg, st))
+	http.HandleFunc("/api/float-stats", handleFloatStats(cfg, st))
+	http.HandleFunc("/api/spc", handleSPC(cfg, st, batchWriter))
+	http.HandleFunc("/api/fault-cascades", handleFaultCascades(cfg, st))
+	http.HandleFunc("/api/anomalies", func(w http.ResponseWriter, r *http.Request) {
+		states, ok := detector.States()
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "No anomaly detection is configured in architect.yaml")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	})
+
+	http.HandleFunc("/api/time-sync", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data.GetClockSyncStatus())
+	})
+
+	http.HandleFunc("/api/writer-status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batchWriter.Stats())
+	})
+
 	http.HandleFunc("/api/upload-csv", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -268,6 +461,23 @@ func StartAPIServer(cfg *config.Config, client *influx.Client) {
 	}
 	http.Handle("/", http.FileServer(http.FS(subFS)))
 
+	server := &http.Server{Addr: ":8080"}
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		log.Println("API: Shutting down API server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), utils.GetShutdownTimeout(cfg))
+		defer cancel()
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	}()
+
 	log.Println("API: API server listening on :8080")
-	http.ListenAndServe(":8080", nil)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if err := <-shutdownErr; err != nil {
+		return fmt.Errorf("API server shutdown: %w", err)
+	}
+	log.Println("API: API server stopped")
+	return nil
 }
@@ -0,0 +1,124 @@
+// file: service/api/cascades.go
+// Fault cascade endpoint
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/influx/flux"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// CascadesResponse defines the structure for the /api/fault-cascades
+// endpoint response. FaultCounts counts every time each fault came on in
+// the range, and RootCounts the times it led a cascade; the difference is
+// the times it followed another fault. Total is the number of cascades
+// matching the filters, of which Cascades holds one page, newest first.
+type CascadesResponse struct {
+	Start         time.Time           `json:"start"`
+	Stop          time.Time           `json:"stop"`
+	WindowSeconds float64             `json:"window_seconds"`
+	FaultCounts   map[string]float64  `json:"fault_counts"`
+	RootCounts    map[string]float64  `json:"root_counts"`
+	Total         int                 `json:"total"`
+	Offset        int                 `json:"offset"`
+	Limit         int                 `json:"limit"`
+	Cascades      []analytics.Cascade `json:"cascades"`
+}
+
+// handleFaultCascades serves /api/fault-cascades: the faults that came on
+// over the range grouped into cascades, each led by its first-out fault.
+func handleFaultCascades(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		window := arch.CascadeWindow()
+		if param := r.URL.Query().Get("window"); param != "" {
+			if window, err = flux.ParseDuration(param); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		roots := make(map[string]bool)
+		for _, root := range r.URL.Query()["root"] {
+			if !data.IsFaultField(root) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("'%s' is not a fault field", root))
+				return
+			}
+			roots[root] = true
+		}
+		minSize, err := parseIntParam(r, "min_size", 1, 1, 1000)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, err := parseIntParam(r, "limit", defaultEventLimit, 1, maxEventLimit)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		offset, err := parseIntParam(r, "offset", 0, 0, math.MaxInt)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		faults, err := data.GetFaultFieldNames()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to load fault field names")
+			return
+		}
+		q.Fields = faults
+
+		history, err := analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err != nil {
+			log.Printf("ERROR: Error getting fault cascade data: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve fault data: "+err.Error())
+			return
+		}
+		cascades := history.FaultCascades(faults, window)
+
+		resp := CascadesResponse{
+			Start:         history.Start,
+			Stop:          history.Stop,
+			WindowSeconds: window.Seconds(),
+			FaultCounts:   make(map[string]float64),
+			RootCounts:    analytics.RootCounts(cascades),
+			Offset:        offset,
+			Limit:         limit,
+			Cascades:      []analytics.Cascade{},
+		}
+		var matching []analytics.Cascade
+		for i := len(cascades) - 1; i >= 0; i-- {
+			c := cascades[i]
+			for _, f := range c.Faults {
+				resp.FaultCounts[f.Field]++
+			}
+			if len(c.Faults) >= minSize && (len(roots) == 0 || roots[c.Root]) {
+				matching = append(matching, c)
+			}
+		}
+		resp.Total = len(matching)
+		if offset < len(matching) {
+			resp.Cascades = matching[offset:min(offset+limit, len(matching))]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
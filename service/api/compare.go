@@ -0,0 +1,229 @@
+// file: service/api/compare.go
+// Period-over-period comparison endpoint
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+)
+
+// TimeRange is a [start, stop) range in a response.
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	Stop  time.Time `json:"stop"`
+}
+
+// FieldDelta compares one value between the baseline and current periods.
+// PercentDelta is the change as a percentage of the baseline, and is null
+// when the baseline is zero.
+type FieldDelta struct {
+	Current      float64  `json:"current"`
+	Baseline     float64  `json:"baseline"`
+	Delta        float64  `json:"delta"`
+	PercentDelta *float64 `json:"percent_delta"`
+	Regression   bool     `json:"regression,omitempty"`
+}
+
+// Regression is a change flagged by a comparison threshold. Metric names the
+// map of the response the field is in.
+type Regression struct {
+	Metric string `json:"metric"`
+	Field  string `json:"field"`
+	FieldDelta
+}
+
+// CompareResponse defines the structure for the /api/compare endpoint
+// response.
+type CompareResponse struct {
+	Current            TimeRange             `json:"current"`
+	Baseline           TimeRange             `json:"baseline"`
+	BooleanPercentages map[string]FieldDelta `json:"boolean_percentages"`
+	BooleanPercentTrue map[string]FieldDelta `json:"boolean_percent_true"`
+	FaultCounts        map[string]FieldDelta `json:"fault_counts"`
+	FaultRootCounts    map[string]FieldDelta `json:"fault_root_counts"`
+	FloatAverages      map[string]FieldDelta `json:"float_averages"`
+	Regressions        []Regression          `json:"regressions"`
+}
+
+// parseBaseline resolves the period the range [start, stop) is compared
+// with. 'baseline_start' and 'baseline_stop' give it explicitly; otherwise
+// 'baseline' is "previous" (the default) for the period of equal length just
+// before, "day" or "week" for the same range a day or a week earlier. For a
+// range given as a shift, "previous" is the shift before it, cut to the same
+// length so a running shift is compared with the same part of the last one.
+func parseBaseline(r *http.Request, start, stop time.Time) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	mode := query.Get("baseline")
+	startParam, stopParam := query.Get("baseline_start"), query.Get("baseline_stop")
+	if startParam != "" || stopParam != "" {
+		if mode != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("'baseline' cannot be combined with 'baseline_start' or 'baseline_stop'")
+		}
+		if startParam == "" || stopParam == "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("'baseline_start' and 'baseline_stop' must be given together")
+		}
+		now := time.Now()
+		bStart, err := parseTimeParam(startParam, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid baseline_start time format")
+		}
+		bStop, err := parseTimeParam(stopParam, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid baseline_stop time format")
+		}
+		if !bStart.Before(bStop) {
+			return time.Time{}, time.Time{}, fmt.Errorf("baseline_start must be before baseline_stop")
+		}
+		return bStart, bStop, nil
+	}
+
+	length := stop.Sub(start)
+	switch mode {
+	case "", "previous":
+		if query.Get("shift") == "" {
+			return start.Add(-length), start, nil
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		prev, err := arch.ShiftCalendar.Previous(start)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		bStop := prev.End
+		if cut := prev.Start.Add(length); cut.Before(bStop) {
+			bStop = cut
+		}
+		return prev.Start, bStop, nil
+	case "day":
+		return start.AddDate(0, 0, -1), stop.AddDate(0, 0, -1), nil
+	case "week":
+		return start.AddDate(0, 0, -7), stop.AddDate(0, 0, -7), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid baseline '%s', expected previous, day or week", mode)
+}
+
+// compareValues returns the delta of each field in both current and
+// baseline. Missing values count as zero when zeroMissing is set, as for
+// counts; otherwise fields missing from either period are left out.
+func compareValues(current, baseline map[string]float64, zeroMissing bool) map[string]FieldDelta {
+	fields := make(map[string]bool, len(current))
+	for f := range current {
+		fields[f] = true
+	}
+	for f := range baseline {
+		fields[f] = true
+	}
+	deltas := make(map[string]FieldDelta, len(fields))
+	for f := range fields {
+		cur, okCur := current[f]
+		base, okBase := baseline[f]
+		if (!okCur || !okBase) && !zeroMissing {
+			continue
+		}
+		d := FieldDelta{Current: cur, Baseline: base, Delta: cur - base}
+		if base != 0 {
+			pct := d.Delta / base * 100
+			d.PercentDelta = &pct
+		}
+		deltas[f] = d
+	}
+	return deltas
+}
+
+// flagRegressions marks the deltas that reach their comparison threshold
+// and returns them.
+func flagRegressions(metric string, deltas map[string]FieldDelta, cmp *data.ComparisonYAML, fault bool) []Regression {
+	var flagged []Regression
+	for field, d := range deltas {
+		t, ok := cmp.Threshold(field, fault)
+		if !ok || !t.Regressed(d.Baseline, d.Current) {
+			continue
+		}
+		d.Regression = true
+		deltas[field] = d
+		flagged = append(flagged, Regression{Metric: metric, Field: field, FieldDelta: d})
+	}
+	return flagged
+}
+
+// handleCompare serves /api/compare: the /api/stats aggregations over the
+// range and a baseline period, with the change in each value and the
+// changes flagged as regressions.
+func handleCompare(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		bq := q
+		if bq.Start, bq.Stop, err = parseBaseline(r, q.Start, q.Stop); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		if err := arch.Comparison.Validate(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+
+		current, err := collectStats(r.Context(), cfg, st, arch, q, nil)
+		if err != nil {
+			log.Printf("ERROR: Error getting statistics for comparison: %v", err)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		baseline, err := collectStats(r.Context(), cfg, st, arch, bq, nil)
+		if err != nil {
+			log.Printf("ERROR: Error getting baseline statistics for comparison: %v", err)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		percentTrue := func(stats map[string]analytics.BooleanStats) map[string]float64 {
+			values := make(map[string]float64, len(stats))
+			for f, s := range stats {
+				values[f] = s.PercentTrue
+			}
+			return values
+		}
+		resp := CompareResponse{
+			Current:            TimeRange{Start: q.Start.UTC(), Stop: q.Stop.UTC()},
+			Baseline:           TimeRange{Start: bq.Start.UTC(), Stop: bq.Stop.UTC()},
+			BooleanPercentages: compareValues(current.BooleanPercentages, baseline.BooleanPercentages, false),
+			BooleanPercentTrue: compareValues(percentTrue(current.BooleanStats), percentTrue(baseline.BooleanStats), false),
+			FaultCounts:        compareValues(current.FaultCounts, baseline.FaultCounts, true),
+			FaultRootCounts:    compareValues(current.FaultRootCounts, baseline.FaultRootCounts, true),
+			FloatAverages:      compareValues(current.FloatAverages, baseline.FloatAverages, false),
+			Regressions:        []Regression{},
+		}
+		resp.Regressions = append(resp.Regressions, flagRegressions("boolean_percent_true", resp.BooleanPercentTrue, arch.Comparison, false)...)
+		resp.Regressions = append(resp.Regressions, flagRegressions("fault_counts", resp.FaultCounts, arch.Comparison, true)...)
+		resp.Regressions = append(resp.Regressions, flagRegressions("float_averages", resp.FloatAverages, arch.Comparison, false)...)
+		sort.Slice(resp.Regressions, func(i, j int) bool {
+			a, b := resp.Regressions[i], resp.Regressions[j]
+			if a.Metric != b.Metric {
+				return a.Metric < b.Metric
+			}
+			return a.Field < b.Field
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
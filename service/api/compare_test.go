@@ -0,0 +1,75 @@
+// file: service/api/compare_test.go
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBaseline(t *testing.T) {
+	withShiftCalendar(t, roundTheClock)
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	h := func(hours float64) time.Time { return day.Add(time.Duration(hours * float64(time.Hour))) }
+
+	tests := []struct {
+		name              string
+		query             string
+		start, stop       time.Time
+		wantStart, wantTo time.Time
+		wantErr           string
+	}{
+		{name: "previous by default", start: h(10), stop: h(12), wantStart: h(8), wantTo: h(10)},
+		{name: "previous", query: "baseline=previous", start: h(10), stop: h(12), wantStart: h(8), wantTo: h(10)},
+		{name: "day", query: "baseline=day", start: h(10), stop: h(12), wantStart: h(-14), wantTo: h(-12)},
+		{name: "week", query: "baseline=week", start: h(10), stop: h(12), wantStart: h(-158), wantTo: h(-156)},
+		{name: "explicit", query: "baseline_start=2026-10-01T00:00:00Z&baseline_stop=2026-10-02T00:00:00Z", start: h(10), stop: h(12),
+			wantStart: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), wantTo: time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "previous shift", query: "shift=Late@2026-10-14", start: h(14), stop: h(22), wantStart: h(6), wantTo: h(14)},
+		{name: "previous shift across midnight", query: "shift=Early@2026-10-14", start: h(6), stop: h(14), wantStart: h(-2), wantTo: h(6)},
+		{name: "running shift cut to the same length", query: "shift=current", start: h(14), stop: h(16.5), wantStart: h(6), wantTo: h(8.5)},
+		{name: "shift with another baseline", query: "shift=Late@2026-10-14&baseline=day", start: h(14), stop: h(22), wantStart: h(-10), wantTo: h(-2)},
+		{name: "unknown mode", query: "baseline=month", start: h(10), stop: h(12), wantErr: "invalid baseline 'month'"},
+		{name: "mode with explicit range", query: "baseline=day&baseline_start=-2d&baseline_stop=-1d", start: h(10), stop: h(12),
+			wantErr: "cannot be combined"},
+		{name: "explicit start only", query: "baseline_start=-2d", start: h(10), stop: h(12), wantErr: "must be given together"},
+		{name: "invalid explicit start", query: "baseline_start=yesterday&baseline_stop=-1d", start: h(10), stop: h(12),
+			wantErr: "invalid baseline_start"},
+		{name: "invalid explicit stop", query: "baseline_start=-2d&baseline_stop=today", start: h(10), stop: h(12),
+			wantErr: "invalid baseline_stop"},
+		{name: "explicit range backwards", query: "baseline_start=-1d&baseline_stop=-2d", start: h(10), stop: h(12),
+			wantErr: "must be before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/compare?"+tt.query, nil)
+			start, stop, err := parseBaseline(r, tt.start, tt.stop)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !start.Equal(tt.wantStart) || !stop.Equal(tt.wantTo) {
+				t.Errorf("baseline %v to %v, want %v to %v", start, stop, tt.wantStart, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestParseBaselineRelative(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/compare?baseline_start=-2d&baseline_stop=-1d", nil)
+	before := time.Now()
+	start, stop, err := parseBaseline(r, before.Add(-time.Hour), before)
+	after := time.Now()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Sub(start) != 24*time.Hour || stop.Before(before.Add(-24*time.Hour)) || stop.After(after.Add(-24*time.Hour)) {
+		t.Errorf("baseline %v to %v", start, stop)
+	}
+}
@@ -0,0 +1,69 @@
+// file: service/api/downtime.go
+// Downtime Pareto endpoint
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// DowntimeResponse defines the structure for the /api/downtime-pareto
+// endpoint response.
+type DowntimeResponse struct {
+	Start        time.Time `json:"start"`
+	Stop         time.Time `json:"stop"`
+	RunningField string    `json:"running_field"`
+	analytics.DowntimePareto
+}
+
+// handleDowntimePareto serves /api/downtime-pareto: the downtime and stops
+// blamed on each fault and reason field over the range.
+func handleDowntimePareto(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		running := arch.RunningField()
+		if running == "" {
+			respondWithError(w, http.StatusNotFound, "No running field is configured in machine_state")
+			return
+		}
+		faults, err := data.GetFaultFieldNames()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to load fault field names")
+			return
+		}
+		reasons := arch.MachineState.ReasonFields
+		q.Fields = append(append([]string{running}, faults...), reasons...)
+
+		history, err := analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err != nil {
+			log.Printf("ERROR: Error getting downtime data: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve downtime data: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DowntimeResponse{
+			Start:          history.Start,
+			Stop:           history.Stop,
+			RunningField:   running,
+			DowntimePareto: history.DowntimePareto(running, faults, reasons, arch.MachineState.ReasonWindow()),
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// file: service/api/faults.go
+// Fault event log endpoint
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/influx/flux"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+const (
+	// defaultEventLimit is the page size of the fault event log when no
+	// 'limit' is given.
+	defaultEventLimit = 100
+	// maxEventLimit caps the page size of the fault event log.
+	maxEventLimit = 1000
+)
+
+// faultEventOrders are the accepted 'sort' values of the fault event log,
+// each a function reporting whether event a comes before event b.
+var faultEventOrders = map[string]func(a, b analytics.FaultEvent) bool{
+	"start":     func(a, b analytics.FaultEvent) bool { return a.Start.Before(b.Start) },
+	"-start":    func(a, b analytics.FaultEvent) bool { return a.Start.After(b.Start) },
+	"duration":  func(a, b analytics.FaultEvent) bool { return a.Seconds < b.Seconds },
+	"-duration": func(a, b analytics.FaultEvent) bool { return a.Seconds > b.Seconds },
+	"field":     func(a, b analytics.FaultEvent) bool { return a.Field < b.Field },
+}
+
+// FaultEventsResponse defines the structure for the /api/fault-events
+// endpoint response.
+type FaultEventsResponse struct {
+	Total  int                    `json:"total"`
+	Offset int                    `json:"offset"`
+	Limit  int                    `json:"limit"`
+	Events []analytics.FaultEvent `json:"events"`
+}
+
+// parseFaultFields extracts the optional 'field' query parameters, each of
+// which must name a fault field in architect.yaml. Without any, every fault
+// field is returned.
+func parseFaultFields(r *http.Request) ([]string, error) {
+	fields := uniqueStrings(r.URL.Query()["field"])
+	if len(fields) == 0 {
+		return data.GetFaultFieldNames()
+	}
+	for _, f := range fields {
+		if !data.IsFaultField(f) {
+			return nil, fmt.Errorf("'%s' is not a fault field", f)
+		}
+	}
+	return fields, nil
+}
+
+// parseIntParam parses an optional integer query parameter in [lo, hi],
+// returning def if it is absent.
+func parseIntParam(r *http.Request, name string, def, lo, hi int) (int, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("invalid %s '%s', expected %d to %d", name, param, lo, hi)
+	}
+	return n, nil
+}
+
+// handleFaultEvents serves /api/fault-events: each occurrence of the fault
+// fields over the range, filtered, sorted and paged, as JSON or CSV.
+func handleFaultEvents(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if q.Fields, err = parseFaultFields(r); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var minDuration time.Duration
+		if param := r.URL.Query().Get("min_duration"); param != "" {
+			if minDuration, err = flux.ParseDuration(param); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		order := r.URL.Query().Get("sort")
+		if order == "" {
+			order = "-start"
+		}
+		less, ok := faultEventOrders[order]
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid sort '%s', expected start, -start, duration, -duration or field", order))
+			return
+		}
+		limit, err := parseIntParam(r, "limit", defaultEventLimit, 1, maxEventLimit)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		offset, err := parseIntParam(r, "offset", 0, 0, math.MaxInt)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid format '%s', expected json or csv", format))
+			return
+		}
+
+		severities, err := data.GetFaultSeverities()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		history, err := analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err != nil {
+			log.Printf("ERROR: Error getting fault events: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve fault events: "+err.Error())
+			return
+		}
+
+		wanted := make(map[string]bool)
+		for _, s := range r.URL.Query()["severity"] {
+			wanted[s] = true
+		}
+		var events []analytics.FaultEvent
+		for _, ev := range history.FaultEvents(severities) {
+			if len(wanted) > 0 && !wanted[ev.Severity] {
+				continue
+			}
+			if ev.Seconds < minDuration.Seconds() {
+				continue
+			}
+			events = append(events, ev)
+		}
+		sort.SliceStable(events, func(i, j int) bool { return less(events[i], events[j]) })
+
+		if format == "csv" {
+			writeFaultEventsCSV(w, events)
+			return
+		}
+		page := []analytics.FaultEvent{}
+		if offset < len(events) {
+			page = events[offset:min(offset+limit, len(events))]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FaultEventsResponse{
+			Total:  len(events),
+			Offset: offset,
+			Limit:  limit,
+			Events: page,
+		})
+	}
+}
+
+// writeFaultEventsCSV writes every event as a CSV attachment.
+func writeFaultEventsCSV(w http.ResponseWriter, events []analytics.FaultEvent) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="fault-events.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"field", "severity", "start", "end", "duration_seconds", "active", "started_before_range"})
+	for _, ev := range events {
+		end := ""
+		if ev.End != nil {
+			end = ev.End.Format(time.RFC3339Nano)
+		}
+		cw.Write([]string{
+			ev.Field,
+			ev.Severity,
+			ev.Start.Format(time.RFC3339Nano),
+			end,
+			strconv.FormatFloat(ev.Seconds, 'f', -1, 64),
+			strconv.FormatBool(ev.Active),
+			strconv.FormatBool(ev.StartedBefore),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("API: Error writing fault events CSV: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// file: service/api/floats.go
+// Float distribution statistics endpoint
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// maxHistogramBins caps the bins a histogram may be split into.
+const maxHistogramBins = 1000
+
+// FloatStatsResponse defines the structure for the /api/float-stats endpoint
+// response. Fields without samples in the range are left out.
+type FloatStatsResponse struct {
+	Start  time.Time                       `json:"start"`
+	Stop   time.Time                       `json:"stop"`
+	Fields map[string]analytics.FloatStats `json:"fields"`
+}
+
+// parseFloatFields returns the 'field' parameters, each of which must be a
+// float field, or every float field if none is given.
+func parseFloatFields(r *http.Request) ([]string, error) {
+	fields := uniqueStrings(r.URL.Query()["field"])
+	if len(fields) == 0 {
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			return nil, err
+		}
+		fields = data.GetCombinedFloatFields(arch)
+		sort.Strings(fields)
+		return fields, nil
+	}
+	if len(fields) > maxSeriesFields {
+		return nil, fmt.Errorf("At most %d fields may be requested", maxSeriesFields)
+	}
+	for _, f := range fields {
+		if !data.IsFloatField(f) {
+			return nil, fmt.Errorf("'%s' is not a float field", f)
+		}
+	}
+	return fields, nil
+}
+
+// checkRawSpan refuses ranges longer than RAW_QUERY_MAX_HOURS for endpoints
+// that load every raw sample of the range into memory.
+func checkRawSpan(cfg *config.Config, q store.Query) error {
+	if max := utils.GetMaxRawSpan(cfg); q.Stop.Sub(q.Start) > max {
+		return fmt.Errorf("Range is longer than %s; this endpoint reads raw samples and allows at most RAW_QUERY_MAX_HOURS", max)
+	}
+	return nil
+}
+
+// parseHistogramSpec returns the histogram asked for by 'bins', with its
+// range optionally fixed by 'bin_min' and 'bin_max', or nil if 'bins' is
+// not given.
+func parseHistogramSpec(r *http.Request) (*analytics.HistogramSpec, error) {
+	query := r.URL.Query()
+	if query.Get("bins") == "" {
+		if query.Get("bin_min") != "" || query.Get("bin_max") != "" {
+			return nil, fmt.Errorf("'bin_min' and 'bin_max' need 'bins'")
+		}
+		return nil, nil
+	}
+	bins, err := parseIntParam(r, "bins", 0, 1, maxHistogramBins)
+	if err != nil {
+		return nil, err
+	}
+	spec := &analytics.HistogramSpec{Bins: bins}
+	for _, bound := range []struct {
+		name string
+		dst  **float64
+	}{{"bin_min", &spec.Min}, {"bin_max", &spec.Max}} {
+		param := query.Get(bound.name)
+		if param == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s'", bound.name, param)
+		}
+		*bound.dst = &v
+	}
+	if spec.Min != nil && spec.Max != nil && *spec.Min >= *spec.Max {
+		return nil, fmt.Errorf("bin_min must be less than bin_max")
+	}
+	return spec, nil
+}
+
+// handleFloatStats serves /api/float-stats: the count, extremes, spread,
+// percentiles and first and last samples of float fields over the range,
+// with a histogram if 'bins' is given.
+func handleFloatStats(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := checkRawSpan(cfg, q); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if q.Fields, err = parseFloatFields(r); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		spec, err := parseHistogramSpec(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(q.Fields) == 0 {
+			respondWithError(w, http.StatusNotFound, "No float fields are configured in architect.yaml")
+			return
+		}
+
+		samples, err := st.QueryRaw(r.Context(), q)
+		if err != nil {
+			log.Printf("ERROR: Error getting float statistics for fields %v: %v", q.Fields, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve float data: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FloatStatsResponse{
+			Start:  q.Start.UTC(),
+			Stop:   q.Stop.UTC(),
+			Fields: analytics.FloatStatistics(samples, spec),
+		})
+	}
+}
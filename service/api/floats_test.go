@@ -0,0 +1,39 @@
+// file: service/api/floats_test.go
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+func TestCheckRawSpan(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		limit   string
+		span    time.Duration
+		wantErr bool
+	}{
+		{name: "within the default", span: 24 * time.Hour},
+		{name: "beyond the default", span: 24*time.Hour + time.Second, wantErr: true},
+		{name: "within a configured limit", limit: "168", span: 7 * 24 * time.Hour},
+		{name: "beyond a configured limit", limit: "2", span: 3 * time.Hour, wantErr: true},
+		{name: "invalid limit uses the default", limit: "week", span: 25 * time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Values: map[string]string{"RAW_QUERY_MAX_HOURS": tt.limit}}
+			err := checkRawSpan(cfg, store.Query{Start: start, Stop: start.Add(tt.span)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "RAW_QUERY_MAX_HOURS") {
+				t.Errorf("error %q does not name the setting", err)
+			}
+		})
+	}
+}
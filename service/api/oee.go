@@ -0,0 +1,113 @@
+// file: service/api/oee.go
+// OEE endpoint
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/influx/flux"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// maxOEEIntervals caps the windows an OEE request may break its range into.
+const maxOEEIntervals = 1000
+
+// OEEResponse defines the structure for the /api/oee endpoint response.
+// Intervals is only present when 'every' is given.
+type OEEResponse struct {
+	Start        time.Time       `json:"start"`
+	Stop         time.Time       `json:"stop"`
+	EverySeconds float64         `json:"every_seconds,omitempty"`
+	Total        analytics.OEE   `json:"total"`
+	Intervals    []analytics.OEE `json:"intervals,omitempty"`
+}
+
+// oeeConfig returns the OEE settings from architect.yaml, or an error if OEE
+// is not configured.
+func oeeConfig(arch *data.ArchitectYAML) (analytics.OEEConfig, error) {
+	if arch.OEE == nil {
+		return analytics.OEEConfig{}, fmt.Errorf("OEE is not configured in architect.yaml")
+	}
+	cfg := analytics.OEEConfig{
+		RunningField:     arch.OEERunningField(),
+		PlannedStopField: arch.OEE.PlannedStopField,
+		TotalCountField:  arch.OEE.TotalCountField,
+		GoodCountField:   arch.OEE.GoodCountField,
+		RejectCountField: arch.OEE.RejectCountField,
+		IdealCycle:       time.Duration(arch.OEE.IdealCycleSecs * float64(time.Second)),
+	}
+	switch {
+	case cfg.RunningField == "":
+		return cfg, fmt.Errorf("OEE needs a running field in oee or machine_state")
+	case cfg.TotalCountField == "":
+		return cfg, fmt.Errorf("OEE needs oee.total_count_field")
+	case cfg.IdealCycle <= 0:
+		return cfg, fmt.Errorf("OEE needs a positive oee.ideal_cycle_seconds")
+	}
+	return cfg, nil
+}
+
+// handleOEE serves /api/oee: availability, performance, quality and OEE over
+// the range, and over windows of 'every' if given.
+func handleOEE(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var every time.Duration
+		if param := r.URL.Query().Get("every"); param != "" {
+			if every, err = flux.ParseDuration(param); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if q.Stop.Sub(q.Start)/every >= maxOEEIntervals {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("'every' of %s gives more than %d intervals", param, maxOEEIntervals))
+				return
+			}
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		oee, err := oeeConfig(arch)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if arch.OEE == nil {
+				code = http.StatusNotFound
+			}
+			respondWithError(w, code, err.Error())
+			return
+		}
+		q.Fields = oee.Fields()
+
+		history, err := analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err != nil {
+			log.Printf("ERROR: Error getting OEE data: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve OEE data: "+err.Error())
+			return
+		}
+
+		resp := OEEResponse{
+			Start: history.Start,
+			Stop:  history.Stop,
+			Total: history.OEE(oee),
+		}
+		if every > 0 {
+			resp.EverySeconds = every.Seconds()
+			resp.Intervals = history.OEEIntervals(oee, every)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
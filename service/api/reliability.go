@@ -0,0 +1,72 @@
+// file: service/api/reliability.go
+// Reliability endpoint: MTBF, MTTR and availability
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// ReliabilityResponse defines the structure for the /api/reliability endpoint
+// response.
+type ReliabilityResponse struct {
+	Start        time.Time `json:"start"`
+	Stop         time.Time `json:"stop"`
+	RunningField string    `json:"running_field,omitempty"`
+	analytics.ReliabilityReport
+}
+
+// handleReliability serves /api/reliability: failure counts, MTBF, MTTR and
+// availability for each fault field, each fault category and the machine.
+func handleReliability(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		faults, err := data.GetFaultFieldNames()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to load fault field names")
+			return
+		}
+		categories, err := data.GetFaultCategories()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to load fault categories")
+			return
+		}
+		running := arch.RunningField()
+		q.Fields = faults
+		if running != "" {
+			q.Fields = append(append([]string{}, faults...), running)
+		}
+
+		history, err := analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err != nil {
+			log.Printf("ERROR: Error getting reliability data: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve reliability data: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReliabilityResponse{
+			Start:             history.Start,
+			Stop:              history.Stop,
+			RunningField:      running,
+			ReliabilityReport: history.Reliability(faults, categories, running),
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// file: service/api/series.go
+// Multi-field time-series endpoint with windows sized to the requested range
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/influx/flux"
+	"vtarchitect/store"
+)
+
+const (
+	// defaultMaxPoints is the number of windows a series aims for when
+	// neither 'every' nor 'max_points' is given.
+	defaultMaxPoints = 500
+	// maxSeriesPoints caps the windows per field a request may ask for.
+	maxSeriesPoints = 10000
+	// maxSeriesFields caps the fields per request.
+	maxSeriesFields = 50
+)
+
+// seriesWindows are the window lengths chosen from 'max_points', shortest
+// first. Longer ranges use whole multiples of the last one.
+var seriesWindows = []time.Duration{
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour, 14 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// SeriesResponse defines the structure for the /api/series endpoint response.
+// Time holds the end of each window; every field has one value per entry in
+// Time, null where the field has no data in that window.
+type SeriesResponse struct {
+	Fn           string                   `json:"fn"`
+	EverySeconds float64                  `json:"every_seconds"`
+	Time         []time.Time              `json:"time"`
+	Fields       map[string][]interface{} `json:"fields"`
+}
+
+// handleSeries serves /api/series: the 'field' parameters aggregated with
+// 'fn' over windows of 'every', or of the shortest round length giving at
+// most 'max_points' windows across the range.
+func handleSeries(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields := uniqueStrings(r.URL.Query()["field"])
+		if len(fields) == 0 {
+			respondWithError(w, http.StatusBadRequest, "Missing required 'field' query parameter")
+			return
+		}
+		if len(fields) > maxSeriesFields {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d fields may be requested", maxSeriesFields))
+			return
+		}
+		fn := r.URL.Query().Get("fn")
+		if fn == "" {
+			fn = store.AggMean
+		}
+		if !store.ValidAggregate(fn) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid fn '%s', expected mean, min, max, first or last", fn))
+			return
+		}
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q.Fields = fields
+		every, err := parseSeriesWindow(r, q.Stop.Sub(q.Start))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		series, err := st.GetSeries(r.Context(), q, every, fn)
+		if err != nil {
+			log.Printf("ERROR: Error getting series for fields %v: %v", fields, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve series data: "+err.Error())
+			return
+		}
+
+		times, columns := alignSeries(fields, series)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SeriesResponse{
+			Fn:           fn,
+			EverySeconds: every.Seconds(),
+			Time:         times,
+			Fields:       columns,
+		})
+	}
+}
+
+// parseSeriesWindow returns the window length for a range of span, from the
+// 'every' parameter or, failing that, from 'max_points' (default 500).
+func parseSeriesWindow(r *http.Request, span time.Duration) (time.Duration, error) {
+	everyParam := r.URL.Query().Get("every")
+	maxParam := r.URL.Query().Get("max_points")
+	if everyParam != "" && maxParam != "" {
+		return 0, fmt.Errorf("give either 'every' or 'max_points', not both")
+	}
+	if everyParam != "" {
+		every, err := flux.ParseDuration(everyParam)
+		if err != nil {
+			return 0, err
+		}
+		if span/every >= maxSeriesPoints {
+			return 0, fmt.Errorf("'every' of %s gives more than %d points", everyParam, maxSeriesPoints)
+		}
+		return every, nil
+	}
+	maxPoints := defaultMaxPoints
+	if maxParam != "" {
+		n, err := strconv.Atoi(maxParam)
+		if err != nil || n <= 0 || n > maxSeriesPoints {
+			return 0, fmt.Errorf("invalid max_points '%s', expected 1 to %d", maxParam, maxSeriesPoints)
+		}
+		maxPoints = n
+	}
+	return seriesWindow(span, maxPoints), nil
+}
+
+// seriesWindow returns the shortest round window length that splits span
+// into at most maxPoints windows.
+func seriesWindow(span time.Duration, maxPoints int) time.Duration {
+	target := (span + time.Duration(maxPoints) - 1) / time.Duration(maxPoints)
+	for _, d := range seriesWindows {
+		if d >= target {
+			return d
+		}
+	}
+	longest := seriesWindows[len(seriesWindows)-1]
+	return (target + longest - 1) / longest * longest
+}
+
+// alignSeries merges the series of each field onto one sorted time column,
+// filling windows a field has no value for with nil.
+func alignSeries(fields []string, series map[string][]store.TimeValue) ([]time.Time, map[string][]interface{}) {
+	index := make(map[int64]int)
+	var times []time.Time
+	for _, f := range fields {
+		for _, tv := range series[f] {
+			if _, ok := index[tv.Time.UnixNano()]; !ok {
+				index[tv.Time.UnixNano()] = 0
+				times = append(times, tv.Time.UTC())
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i, t := range times {
+		index[t.UnixNano()] = i
+	}
+	columns := make(map[string][]interface{}, len(fields))
+	for _, f := range fields {
+		column := make([]interface{}, len(times))
+		for _, tv := range series[f] {
+			if v, ok := tv.Value.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+				continue
+			}
+			column[index[tv.Time.UnixNano()]] = tv.Value
+		}
+		columns[f] = column
+	}
+	if times == nil {
+		times = []time.Time{}
+	}
+	return times, columns
+}
+
+// uniqueStrings returns the non-empty values of ss in order, without repeats.
+func uniqueStrings(ss []string) []string {
+	seen := make(map[string]bool, len(ss))
+	var out []string
+	for _, s := range ss {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
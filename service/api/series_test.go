@@ -0,0 +1,155 @@
+// file: service/api/series_test.go
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+func TestSeriesWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		span      time.Duration
+		maxPoints int
+		want      time.Duration
+	}{
+		{name: "short range", span: time.Minute, maxPoints: 500, want: time.Second},
+		{name: "exact fit", span: 500 * time.Second, maxPoints: 500, want: time.Second},
+		{name: "just over a round length", span: 501 * time.Second, maxPoints: 500, want: 2 * time.Second},
+		{name: "hour", span: time.Hour, maxPoints: 500, want: 10 * time.Second},
+		{name: "day", span: 24 * time.Hour, maxPoints: 500, want: 5 * time.Minute},
+		{name: "one point", span: 24 * time.Hour, maxPoints: 1, want: 24 * time.Hour},
+		{name: "beyond the longest", span: 365 * 24 * time.Hour, maxPoints: 5, want: 90 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seriesWindow(tt.span, tt.maxPoints); got != tt.want {
+				t.Errorf("window %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSeriesWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		span    time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default points", span: 24 * time.Hour, want: 5 * time.Minute},
+		{name: "max_points", query: "max_points=24", span: 24 * time.Hour, want: time.Hour},
+		{name: "every", query: "every=15m", span: 24 * time.Hour, want: 15 * time.Minute},
+		{name: "every in days", query: "every=1d", span: 30 * 24 * time.Hour, want: 24 * time.Hour},
+		{name: "both given", query: "every=1m&max_points=10", span: time.Hour, wantErr: true},
+		{name: "invalid every", query: "every=soon", span: time.Hour, wantErr: true},
+		{name: "calendar every", query: "every=1mo", span: time.Hour, wantErr: true},
+		{name: "every gives too many points", query: "every=1s", span: 24 * time.Hour, wantErr: true},
+		{name: "zero max_points", query: "max_points=0", span: time.Hour, wantErr: true},
+		{name: "too many max_points", query: "max_points=10001", span: time.Hour, wantErr: true},
+		{name: "non-numeric max_points", query: "max_points=lots", span: time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/series?"+tt.query, nil)
+			got, err := parseSeriesWindow(r, tt.span)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("window %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlignSeries(t *testing.T) {
+	at := func(min int) time.Time { return time.Date(2026, 10, 14, 6, min, 0, 0, time.UTC) }
+	series := map[string][]store.TimeValue{
+		"A": {{Time: at(1), Value: 1.5}, {Time: at(3), Value: math.NaN()}, {Time: at(2), Value: 2.5}},
+		"B": {{Time: at(0), Value: true}, {Time: at(2), Value: false}},
+	}
+	times, columns := alignSeries([]string{"A", "B", "C"}, series)
+	if want := []time.Time{at(0), at(1), at(2), at(3)}; !reflect.DeepEqual(times, want) {
+		t.Errorf("times %v, want %v", times, want)
+	}
+	want := map[string][]interface{}{
+		"A": {nil, 1.5, 2.5, nil},
+		"B": {true, nil, false, nil},
+		"C": {nil, nil, nil, nil},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns %v, want %v", columns, want)
+	}
+
+	times, columns = alignSeries([]string{"A"}, nil)
+	if times == nil || len(times) != 0 || len(columns["A"]) != 0 {
+		t.Errorf("no data gave %v, %v", times, columns)
+	}
+}
+
+// seriesStore records the GetSeries call it answers.
+type seriesStore struct {
+	store.Store
+	every time.Duration
+	fn    string
+}
+
+func (s *seriesStore) GetSeries(ctx context.Context, q store.Query, every time.Duration, fn string) (map[string][]store.TimeValue, error) {
+	s.every, s.fn = every, fn
+	return map[string][]store.TimeValue{"A": {{Time: q.Stop, Value: 1.0}}}, nil
+}
+
+func TestHandleSeries(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantFn    string
+		wantEvery time.Duration
+	}{
+		{name: "defaults", query: "field=A", wantCode: http.StatusOK, wantFn: store.AggMean, wantEvery: 10 * time.Second},
+		{name: "fn and every", query: "field=A&fn=max&every=5m", wantCode: http.StatusOK, wantFn: store.AggMax, wantEvery: 5 * time.Minute},
+		{name: "missing field", query: "fn=max", wantCode: http.StatusBadRequest},
+		{name: "invalid fn", query: "field=A&fn=median", wantCode: http.StatusBadRequest},
+		{name: "invalid window", query: "field=A&every=0s", wantCode: http.StatusBadRequest},
+		{name: "invalid range", query: "field=A&start=-1h&stop=-2h", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &seriesStore{}
+			cfg := &config.Config{Values: map[string]string{}}
+			rec := httptest.NewRecorder()
+			handleSeries(cfg, st)(rec, httptest.NewRequest(http.MethodGet, "/api/series?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if st.fn != "" {
+					t.Error("store queried for a bad request")
+				}
+				return
+			}
+			if st.fn != tt.wantFn || st.every != tt.wantEvery {
+				t.Errorf("queried %s over %s, want %s over %s", st.fn, st.every, tt.wantFn, tt.wantEvery)
+			}
+			var resp SeriesResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Fn != tt.wantFn || resp.EverySeconds != tt.wantEvery.Seconds() || len(resp.Time) != 1 || len(resp.Fields["A"]) != 1 {
+				t.Errorf("response %+v", resp)
+			}
+		})
+	}
+}
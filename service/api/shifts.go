@@ -0,0 +1,156 @@
+// file: service/api/shifts.go
+// Shift time ranges and the per-shift statistics endpoint
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// maxShifts caps the shifts a /api/shifts request may cover.
+const maxShifts = 200
+
+// ShiftStats is the /api/stats payload for one shift, with its OEE when OEE
+// is configured. The statistics cover the part of the shift within the
+// requested range and before now.
+type ShiftStats struct {
+	data.Shift
+	Stats StatsResponse  `json:"stats"`
+	OEE   *analytics.OEE `json:"oee,omitempty"`
+}
+
+// ShiftsResponse defines the structure for the /api/shifts endpoint response.
+type ShiftsResponse struct {
+	Start  time.Time    `json:"start"`
+	Stop   time.Time    `json:"stop"`
+	Shifts []ShiftStats `json:"shifts"`
+}
+
+// parseShiftParam resolves a 'shift' parameter to a time range: "current"
+// for the shift running now, "previous" for the last one to end, or
+// "<name>@<YYYY-MM-DD>" for the named shift starting on that date. Ranges
+// are cut off at now.
+func parseShiftParam(param string, now time.Time) (start, stop time.Time, err error) {
+	arch, err := data.GetArchitectYAML()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	cal := arch.ShiftCalendar
+	if cal == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("'shift' needs a shift calendar in architect.yaml")
+	}
+	var shift data.Shift
+	switch param {
+	case "current":
+		shift, err = cal.Current(now)
+	case "previous":
+		shift, err = cal.Previous(now)
+	default:
+		name, date, ok := strings.Cut(param, "@")
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid shift '%s', expected current, previous or <name>@<YYYY-MM-DD>", param)
+		}
+		shift, err = cal.Find(name, date)
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	stop = shift.End
+	if stop.After(now) {
+		stop = now
+	}
+	if !shift.Start.Before(stop) {
+		return time.Time{}, time.Time{}, fmt.Errorf("shift '%s' has not started", param)
+	}
+	return shift.Start, stop, nil
+}
+
+// handleShifts serves /api/shifts: the /api/stats payload for each shift
+// overlapping the range.
+func handleShifts(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		if arch.ShiftCalendar == nil {
+			respondWithError(w, http.StatusNotFound, "No shift calendar is configured in architect.yaml")
+			return
+		}
+		shifts, err := arch.ShiftCalendar.Between(q.Start, q.Stop)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Shift calendar error: "+err.Error())
+			return
+		}
+		if len(shifts) > maxShifts {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("The range covers more than %d shifts", maxShifts))
+			return
+		}
+		systemStatus, err := latestSystemStatus(r.Context(), st, arch, q)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		oee, oeeErr := oeeConfig(arch)
+
+		now := time.Now()
+		results := make([]ShiftStats, 0, len(shifts))
+		for _, shift := range shifts {
+			sq := q
+			if shift.Start.After(sq.Start) {
+				sq.Start = shift.Start
+			}
+			if shift.End.Before(sq.Stop) {
+				sq.Stop = shift.End
+			}
+			if now.Before(sq.Stop) {
+				sq.Stop = now
+			}
+			if !sq.Start.Before(sq.Stop) {
+				continue
+			}
+			stats, err := collectStats(r.Context(), cfg, st, arch, sq, systemStatus)
+			if err != nil {
+				log.Printf("ERROR: Error getting statistics for shift %s@%s: %v", shift.Name, shift.Date, err)
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			result := ShiftStats{Shift: shift, Stats: stats}
+			if oeeErr == nil {
+				sq.Fields = oee.Fields()
+				history, err := analytics.LoadHistory(r.Context(), st, sq, utils.GetStateLookback(cfg))
+				if err != nil {
+					log.Printf("ERROR: Error getting OEE for shift %s@%s: %v", shift.Name, shift.Date, err)
+					respondWithError(w, http.StatusInternalServerError, "Failed to retrieve OEE data: "+err.Error())
+					return
+				}
+				shiftOEE := history.OEE(oee)
+				result.OEE = &shiftOEE
+			}
+			results = append(results, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ShiftsResponse{
+			Start:  q.Start.UTC(),
+			Stop:   q.Stop.UTC(),
+			Shifts: results,
+		})
+	}
+}
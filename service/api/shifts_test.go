@@ -0,0 +1,80 @@
+// file: service/api/shifts_test.go
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vtarchitect/data"
+)
+
+// withShiftCalendar caches an architect.yaml holding only cal for the
+// duration of the test.
+func withShiftCalendar(t *testing.T, cal *data.ShiftCalendarYAML) {
+	t.Helper()
+	prev := data.CachedArchitectYAML
+	data.CachedArchitectYAML = &data.ArchitectYAML{ShiftCalendar: cal}
+	t.Cleanup(func() { data.CachedArchitectYAML = prev })
+}
+
+// roundTheClock is a calendar of three eight hour shifts every day.
+var roundTheClock = &data.ShiftCalendarYAML{
+	Shifts: []data.ShiftYAML{
+		{Name: "Early", Start: "06:00", End: "14:00"},
+		{Name: "Late", Start: "14:00", End: "22:00"},
+		{Name: "Night", Start: "22:00", End: "06:00"},
+	},
+}
+
+func TestParseTimeRangeShift(t *testing.T) {
+	withShiftCalendar(t, roundTheClock)
+
+	start, stop, err := parseTimeRange(httptest.NewRequest("GET", "/api/stats?shift=Night@2026-10-14", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("start %v, want %v", start, want)
+	}
+	if want := time.Date(2026, 10, 15, 6, 0, 0, 0, time.UTC); !stop.Equal(want) {
+		t.Errorf("stop %v, want %v", stop, want)
+	}
+
+	before := time.Now()
+	start, stop, err = parseTimeRange(httptest.NewRequest("GET", "/api/stats?shift=current", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.After(before) || stop.Before(before) || stop.Sub(start) > 8*time.Hour {
+		t.Errorf("current shift %v to %v does not hold %v", start, stop, before)
+	}
+	prevStart, prevStop, err := parseTimeRange(httptest.NewRequest("GET", "/api/stats?shift=previous", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prevStop.Equal(start) || prevStop.Sub(prevStart) != 8*time.Hour {
+		t.Errorf("previous shift %v to %v does not end at the current shift start %v", prevStart, prevStop, start)
+	}
+}
+
+func TestParseTimeRangeShiftErrors(t *testing.T) {
+	withShiftCalendar(t, roundTheClock)
+	for _, query := range []string{
+		"shift=current&start=-1h",
+		"shift=current&stop=now()",
+		"shift=Night",
+		"shift=Swing@2026-10-14",
+		"shift=Night@2026-13-01",
+		"shift=Night@2999-01-01",
+	} {
+		if _, _, err := parseTimeRange(httptest.NewRequest("GET", "/api/stats?"+query, nil)); err == nil {
+			t.Errorf("%s: got no error", query)
+		}
+	}
+
+	withShiftCalendar(t, nil)
+	if _, _, err := parseTimeRange(httptest.NewRequest("GET", "/api/stats?shift=current", nil)); err == nil {
+		t.Error("shift accepted without a shift calendar")
+	}
+}
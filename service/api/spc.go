@@ -0,0 +1,163 @@
+// file: service/api/spc.go
+// Statistical process control endpoint
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+)
+
+// spcEventName names the events written for out-of-control points.
+const spcEventName = "spc_out_of_control"
+
+// spcWritten remembers, per field, the latest out-of-control point written
+// back as an event, so charting an overlapping range again does not repeat
+// events. It is not kept across restarts.
+var spcWritten = struct {
+	sync.Mutex
+	latest map[string]time.Time
+}{latest: make(map[string]time.Time)}
+
+// SPCResponse defines the structure for the /api/spc endpoint response.
+type SPCResponse struct {
+	Start  time.Time `json:"start"`
+	Stop   time.Time `json:"stop"`
+	Field  string    `json:"field"`
+	LSL    *float64  `json:"lsl"`
+	USL    *float64  `json:"usl"`
+	Target *float64  `json:"target"`
+	analytics.SPCChart
+	EventsWritten int `json:"events_written,omitempty"`
+}
+
+// parseSPCConfig returns the chart settings from a field's spc settings in
+// architect.yaml, with the subgroup size overridden by 'subgroup_size' and
+// the run rules chosen by 'rules'.
+func parseSPCConfig(r *http.Request, spec data.SPCFieldYAML) (analytics.SPCConfig, error) {
+	size, err := parseIntParam(r, "subgroup_size", max(spec.SubgroupSize, 1), 1, data.MaxSubgroupSize)
+	if err != nil {
+		return analytics.SPCConfig{}, err
+	}
+	rules := r.URL.Query().Get("rules")
+	if rules == "" {
+		rules = analytics.RulesNelson
+	}
+	if !analytics.ValidRules(rules) {
+		return analytics.SPCConfig{}, fmt.Errorf("invalid rules '%s', expected nelson or western_electric", rules)
+	}
+	return analytics.SPCConfig{SubgroupSize: size, Rules: rules, LSL: spec.LSL, USL: spec.USL, Target: spec.Target}, nil
+}
+
+// writeSPCEvents writes the violations of chart newer than the last one
+// written for field as events, and returns how many it wrote.
+func writeSPCEvents(cfg *config.Config, arch *data.ArchitectYAML, field string, chart analytics.SPCChart, batchWriter *store.ChannelBatchWriter) int {
+	var measurement string
+	if arch.SPC != nil {
+		measurement = arch.SPC.EventMeasurement
+	}
+	spcWritten.Lock()
+	defer spcWritten.Unlock()
+	latest := spcWritten.latest[field]
+	written := 0
+	for _, v := range chart.Violations {
+		if !v.Time.After(latest) {
+			continue
+		}
+		limits := chart.X
+		if v.Chart == "range" {
+			limits = chart.Range
+		}
+		tags := map[string]string{"field": field, "chart": v.Chart, "rule": v.Rule}
+		fields := map[string]interface{}{
+			"value":       v.Value,
+			"center":      limits.Center,
+			"ucl":         limits.UCL,
+			"lcl":         limits.LCL,
+			"description": v.Description,
+		}
+		store.ProcessAndLogEvent(cfg, measurement, spcEventName, tags, fields, v.Time, batchWriter)
+		written++
+	}
+	if n := len(chart.Violations); n > 0 && chart.Violations[n-1].Time.After(latest) {
+		spcWritten.latest[field] = chart.Violations[n-1].Time
+	}
+	return written
+}
+
+// handleSPC serves /api/spc: the control chart of a float field over the
+// range, with its capability and run rule violations. A POST with
+// 'write_events' also writes out-of-control points back as events.
+func handleSPC(cfg *config.Config, st store.Store, batchWriter *store.ChannelBatchWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		field := r.URL.Query().Get("field")
+		if field == "" {
+			respondWithError(w, http.StatusBadRequest, "Missing required 'field' query parameter")
+			return
+		}
+		if !data.IsFloatField(field) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("'%s' is not a float field", field))
+			return
+		}
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := checkRawSpan(cfg, q); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q.Fields = []string{field}
+		writeEvents := r.URL.Query().Get("write_events") == "true"
+		if writeEvents && r.Method != http.MethodPost {
+			respondWithError(w, http.StatusMethodNotAllowed, "Writing events needs a POST request")
+			return
+		}
+		arch, err := data.GetArchitectYAML()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		spec, _ := arch.SPCField(field)
+		if err := spec.Validate(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Server configuration error: "+err.Error())
+			return
+		}
+		spc, err := parseSPCConfig(r, spec)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		samples, err := st.QueryRaw(r.Context(), q)
+		if err != nil {
+			log.Printf("ERROR: Error getting SPC data for field %s: %v", field, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve SPC data: "+err.Error())
+			return
+		}
+
+		resp := SPCResponse{
+			Start:    q.Start.UTC(),
+			Stop:     q.Stop.UTC(),
+			Field:    field,
+			LSL:      spc.LSL,
+			USL:      spc.USL,
+			Target:   spc.Target,
+			SPCChart: analytics.SPC(samples, field, spc),
+		}
+		if writeEvents {
+			resp.EventsWritten = writeSPCEvents(cfg, arch, field, resp.SPCChart, batchWriter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,129 @@
+// file: service/api/timeline.go
+// State timeline and time-weighted statistics endpoints for boolean and
+// fault fields
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+	"vtarchitect/utils"
+)
+
+// TimelineResponse defines the structure for the /api/timeline endpoint
+// response.
+type TimelineResponse struct {
+	Start  time.Time                       `json:"start"`
+	Stop   time.Time                       `json:"stop"`
+	Fields map[string][]analytics.Interval `json:"fields"`
+}
+
+// parseStateFields extracts the required 'field' query parameters, each of
+// which must name a boolean or fault field in architect.yaml.
+func parseStateFields(r *http.Request) ([]string, error) {
+	fields := uniqueStrings(r.URL.Query()["field"])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Missing required 'field' query parameter")
+	}
+	if len(fields) > maxSeriesFields {
+		return nil, fmt.Errorf("At most %d fields may be requested", maxSeriesFields)
+	}
+	for _, f := range fields {
+		if !data.IsBooleanField(f) && !data.IsFaultField(f) {
+			return nil, fmt.Errorf("'%s' is not a boolean or fault field", f)
+		}
+	}
+	return fields, nil
+}
+
+// handleTimeline serves /api/timeline: the intervals each requested boolean
+// or fault field spent in each state over the range.
+func handleTimeline(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields, err := parseStateFields(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q.Fields = fields
+
+		history, err := analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err != nil {
+			log.Printf("ERROR: Error getting timeline for fields %v: %v", fields, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve timeline data: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TimelineResponse{
+			Start:  history.Start,
+			Stop:   history.Stop,
+			Fields: history.Timelines(),
+		})
+	}
+}
+
+// BooleanStatsResponse defines the structure for the /api/boolean-stats
+// endpoint response.
+type BooleanStatsResponse struct {
+	Start  time.Time                         `json:"start"`
+	Stop   time.Time                         `json:"stop"`
+	Fields map[string]analytics.BooleanStats `json:"fields"`
+}
+
+// handleBooleanStats serves /api/boolean-stats: the time each requested
+// boolean or fault field spent true over the range. Without 'field'
+// parameters every boolean field is reported.
+func handleBooleanStats(cfg *config.Config, st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var fields []string
+		var err error
+		if len(r.URL.Query()["field"]) == 0 {
+			if fields, err = data.GetBooleanFieldNames(); err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Failed to load boolean field names")
+				return
+			}
+		} else if fields, err = parseStateFields(r); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q, err := parseQuery(cfg, r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q.Fields = fields
+
+		stats, summarised, err := analytics.SummarisedBooleanStats(r.Context(), st, q, utils.GetStateLookback(cfg))
+		if err == nil && !summarised {
+			var history *analytics.History
+			if history, err = analytics.LoadHistory(r.Context(), st, q, utils.GetStateLookback(cfg)); err == nil {
+				stats = history.BooleanStats()
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: Error getting boolean statistics for fields %v: %v", fields, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve boolean statistics: "+err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(BooleanStatsResponse{
+			Start:  q.Start.UTC(),
+			Stop:   q.Stop.UTC(),
+			Fields: stats,
+		})
+	}
+}
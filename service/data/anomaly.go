@@ -0,0 +1,363 @@
+// file: service/data/anomaly.go
+// Online anomaly detection on float fields as they are polled
+package data
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+// AnomalyYAML configures online anomaly detection. Anomalies are written as
+// events to Measurement, which defaults to INFLUXDB_ANOMALY_MEASUREMENT or
+// "anomaly_data".
+type AnomalyYAML struct {
+	Measurement string             `yaml:"measurement,omitempty"`
+	Fields      []AnomalyFieldYAML `yaml:"fields"`
+}
+
+// AnomalyFieldYAML sets the model and sensitivity for one namespaced float
+// field. A value is scored by how many standard deviations it lies from what
+// the model expects; an anomaly starts when the score reaches Threshold and
+// ends when it falls below ClearThreshold.
+type AnomalyFieldYAML struct {
+	Field string `yaml:"field"`
+	// Model is "ewma" (the default), "zscore" or "seasonal".
+	Model          string  `yaml:"model,omitempty"`
+	Threshold      float64 `yaml:"threshold,omitempty"`       // default 4
+	ClearThreshold float64 `yaml:"clear_threshold,omitempty"` // default 3/4 of Threshold
+	// MinSigma floors the standard deviation, so a signal that has held
+	// steady does not flag the smallest change.
+	MinSigma float64 `yaml:"min_sigma,omitempty"`
+	// Alpha is the weight the ewma model gives each new value.
+	Alpha float64 `yaml:"alpha,omitempty"` // default 0.01
+	// Window is how many values the zscore model keeps.
+	Window int `yaml:"window,omitempty"` // default 300
+	// Period and Slot set the repeating period of the seasonal model and
+	// the width of the slots it is split into, as Go durations.
+	Period string `yaml:"period,omitempty"` // default "24h"
+	Slot   string `yaml:"slot,omitempty"`   // default "15m"
+	// HistoryDays is how much stored history the seasonal model learns
+	// from when it is built.
+	HistoryDays int `yaml:"history_days,omitempty"` // default 7
+}
+
+// maxAnomalyMemory caps the values a zscore window or the slots a seasonal
+// period may hold.
+const maxAnomalyMemory = 100000
+
+// thresholds returns the start and clear thresholds of the field.
+func (f AnomalyFieldYAML) thresholds() (start, clear float64, err error) {
+	start, clear = f.Threshold, f.ClearThreshold
+	if start == 0 {
+		start = 4
+	}
+	if clear == 0 {
+		clear = start * 3 / 4
+	}
+	if start < 0 || clear < 0 || clear > start {
+		return 0, 0, fmt.Errorf("needs 0 < clear_threshold <= threshold")
+	}
+	return start, clear, nil
+}
+
+// newModel builds the model the field asks for.
+func (f AnomalyFieldYAML) newModel() (analytics.AnomalyModel, error) {
+	switch f.Model {
+	case "", analytics.ModelEWMA:
+		alpha := f.Alpha
+		if alpha == 0 {
+			alpha = 0.01
+		}
+		if alpha < 0 || alpha > 1 {
+			return nil, fmt.Errorf("needs an alpha from 0 to 1")
+		}
+		return analytics.NewEWMA(alpha), nil
+	case analytics.ModelZScore:
+		window := f.Window
+		if window == 0 {
+			window = 300
+		}
+		if window < 2 || window > maxAnomalyMemory {
+			return nil, fmt.Errorf("needs a window from 2 to %d", maxAnomalyMemory)
+		}
+		return analytics.NewRollingZScore(window), nil
+	case analytics.ModelSeasonal:
+		period, slot := 24*time.Hour, 15*time.Minute
+		var err error
+		if f.Period != "" {
+			if period, err = time.ParseDuration(f.Period); err != nil {
+				return nil, fmt.Errorf("invalid period '%s'", f.Period)
+			}
+		}
+		if f.Slot != "" {
+			if slot, err = time.ParseDuration(f.Slot); err != nil {
+				return nil, fmt.Errorf("invalid slot '%s'", f.Slot)
+			}
+		}
+		if slot <= 0 || period < slot || period%slot != 0 || period/slot > maxAnomalyMemory {
+			return nil, fmt.Errorf("needs a period that is a whole multiple of its slot, with at most %d slots", maxAnomalyMemory)
+		}
+		return analytics.NewSeasonal(period, slot), nil
+	}
+	return nil, fmt.Errorf("unknown model '%s', expected ewma, zscore or seasonal", f.Model)
+}
+
+// AnomalyState is the latest verdict on one field. Expected, Sigma and Score
+// are null until the model has learned enough to score. Since and PeakScore
+// describe the current anomaly while Active is set.
+type AnomalyState struct {
+	Field           string     `json:"field"`
+	Model           string     `json:"model"`
+	Threshold       float64    `json:"threshold"`
+	ClearThreshold  float64    `json:"clear_threshold"`
+	LearningHistory bool       `json:"learning_history,omitempty"`
+	Updated         *time.Time `json:"updated"`
+	Value           *float64   `json:"value"`
+	Expected        *float64   `json:"expected"`
+	Sigma           *float64   `json:"sigma"`
+	Score           *float64   `json:"score"`
+	Active          bool       `json:"active"`
+	Since           *time.Time `json:"since,omitempty"`
+	PeakScore       *float64   `json:"peak_score,omitempty"`
+}
+
+// anomalyField is the model and state of one detected field.
+type anomalyField struct {
+	conf  AnomalyFieldYAML
+	model analytics.AnomalyModel
+	state AnomalyState
+}
+
+// AnomalyDetector scores each freshly polled value of the fields in
+// anomaly_detection against its model, and writes an event when a field
+// enters or leaves an anomaly. Every value is learned, so a lasting change
+// clears once the model has adapted to it: quickly for ewma and zscore,
+// slowly for a seasonal baseline built on days of history. Models are
+// rebuilt, and their learning lost, when architect.yaml is reloaded.
+type AnomalyDetector struct {
+	ctx    context.Context
+	cfg    *config.Config
+	st     store.Store
+	mu     sync.Mutex
+	arch   *ArchitectYAML
+	fields []*anomalyField
+}
+
+// NewAnomalyDetector creates a detector that learns seasonal baselines from
+// st. History is loaded in the background until ctx is cancelled.
+func NewAnomalyDetector(ctx context.Context, cfg *config.Config, st store.Store) *AnomalyDetector {
+	return &AnomalyDetector{ctx: ctx, cfg: cfg, st: st}
+}
+
+// refresh rebuilds the models if architect.yaml has been reloaded since they
+// were built. The caller holds d.mu.
+func (d *AnomalyDetector) refresh() (*ArchitectYAML, error) {
+	arch, err := GetArchitectYAML()
+	if err != nil {
+		return nil, err
+	}
+	if arch == d.arch {
+		return arch, nil
+	}
+	d.arch, d.fields = arch, nil
+	if arch.AnomalyDetection == nil {
+		return arch, nil
+	}
+	floats := make(map[string]bool)
+	for _, f := range GetCombinedFloatFields(arch) {
+		floats[f] = true
+	}
+	for _, conf := range arch.AnomalyDetection.Fields {
+		if !floats[conf.Field] {
+			log.Printf("DATA: Anomaly detection skipped for '%s': not a float field", conf.Field)
+			continue
+		}
+		start, clear, err := conf.thresholds()
+		if err != nil {
+			log.Printf("DATA: Anomaly detection skipped for '%s': %v", conf.Field, err)
+			continue
+		}
+		model, err := conf.newModel()
+		if err != nil {
+			log.Printf("DATA: Anomaly detection skipped for '%s': %v", conf.Field, err)
+			continue
+		}
+		if conf.Model == "" {
+			conf.Model = analytics.ModelEWMA
+		}
+		f := &anomalyField{conf: conf, model: model, state: AnomalyState{
+			Field:          conf.Field,
+			Model:          conf.Model,
+			Threshold:      start,
+			ClearThreshold: clear,
+		}}
+		if conf.Model == analytics.ModelSeasonal {
+			f.state.LearningHistory = true
+			go d.learnHistory(arch, f, time.Now())
+		}
+		d.fields = append(d.fields, f)
+	}
+	log.Printf("DATA: Anomaly detection built for %d field(s)", len(d.fields))
+	return arch, nil
+}
+
+// learnHistory teaches the seasonal model of f the stored values of its
+// field from before stop, unless the models are rebuilt in the meantime.
+func (d *AnomalyDetector) learnHistory(arch *ArchitectYAML, f *anomalyField, stop time.Time) {
+	days := f.conf.HistoryDays
+	if days <= 0 {
+		days = 7
+	}
+	measurement := d.cfg.Values["INFLUXDB_MEASUREMENT"]
+	if measurement == "" {
+		measurement = "status_data"
+	}
+	samples, err := d.st.QueryRaw(d.ctx, store.Query{
+		Bucket:      d.cfg.Values["INFLUXDB_BUCKET"],
+		Measurement: measurement,
+		Fields:      []string{f.conf.Field},
+		Start:       stop.AddDate(0, 0, -days),
+		Stop:        stop,
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f.state.LearningHistory = false
+	if err != nil {
+		log.Printf("DATA: Failed to load history for anomaly detection on '%s': %v", f.conf.Field, err)
+		return
+	}
+	if d.arch != arch {
+		return
+	}
+	learned := 0
+	for _, s := range samples {
+		if v, ok := anomalyValue(s.Value); ok {
+			f.model.Learn(s.Time, v)
+			learned++
+		}
+	}
+	log.Printf("DATA: Anomaly detection on '%s' learned %d value(s) from %d day(s) of history", f.conf.Field, learned, days)
+}
+
+// anomalyValue returns v as a finite float.
+func anomalyValue(v interface{}) (float64, bool) {
+	if _, isBool := v.(bool); isBool {
+		return 0, false
+	}
+	f, ok := store.AsFloat(v)
+	return f, ok && !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// Observe scores the fields freshly read in a poll cycle, stamped t, and
+// writes an event tagged with tags for each anomaly that starts or ends.
+// Events are written after d.mu is released, so a batch writer blocked on a
+// slow backend does not hold up States.
+func (d *AnomalyDetector) Observe(values map[string]interface{}, tags map[string]string, t time.Time, batchWriter *store.ChannelBatchWriter) {
+	measurement, events := d.score(values, tags, t)
+	for _, e := range events {
+		batchWriter.AddPoint(measurement, e.Tags, e.Fields, t)
+	}
+}
+
+// score scores and learns values under d.mu and returns the measurement and
+// the anomaly events to write.
+func (d *AnomalyDetector) score(values map[string]interface{}, tags map[string]string, t time.Time) (string, []store.Point) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	arch, err := d.refresh()
+	if err != nil || len(d.fields) == 0 {
+		return "", nil
+	}
+	measurement := arch.AnomalyDetection.Measurement
+	if measurement == "" {
+		measurement = d.cfg.Values["INFLUXDB_ANOMALY_MEASUREMENT"]
+	}
+	if measurement == "" {
+		measurement = "anomaly_data"
+	}
+	var events []store.Point
+	for _, f := range d.fields {
+		raw, ok := values[f.conf.Field]
+		if !ok {
+			continue
+		}
+		v, ok := anomalyValue(raw)
+		if !ok {
+			continue
+		}
+		if fields := f.observe(v, t); fields != nil {
+			eventTags := map[string]string{"field": f.conf.Field, "model": f.conf.Model}
+			for k, tv := range tags {
+				if _, taken := eventTags[k]; !taken {
+					eventTags[k] = tv
+				}
+			}
+			events = append(events, store.Point{Tags: eventTags, Fields: fields})
+		}
+	}
+	return measurement, events
+}
+
+// observe scores v at t, learns it, and returns the event fields if an anomaly started or ended.
+func (f *anomalyField) observe(v float64, t time.Time) map[string]interface{} {
+	s := &f.state
+	s.Updated, s.Value = &t, &v
+	s.Expected, s.Sigma, s.Score = nil, nil, nil
+	expected, sigma, ready := f.model.Expect(t)
+	var score float64
+	if ready {
+		if score, ready = analytics.AnomalyScore(v, expected, sigma, f.conf.MinSigma); ready {
+			sigma = math.Max(sigma, f.conf.MinSigma)
+			s.Expected, s.Sigma, s.Score = &expected, &sigma, &score
+		}
+	}
+
+	var event map[string]interface{}
+	switch {
+	case !s.Active && ready && math.Abs(score) >= s.Threshold:
+		s.Active, s.Since, s.PeakScore = true, &t, &score
+		event = map[string]interface{}{"active": true, "value": v, "expected": expected, "score": score}
+		log.Printf("DATA: Anomaly on '%s': value %g, expected %g, score %.2f", f.conf.Field, v, expected, score)
+	case s.Active && (!ready || math.Abs(score) < s.ClearThreshold):
+		event = map[string]interface{}{
+			"active":           false,
+			"value":            v,
+			"peak_score":       *s.PeakScore,
+			"duration_seconds": t.Sub(*s.Since).Seconds(),
+		}
+		if ready {
+			event["expected"], event["score"] = expected, score
+		}
+		log.Printf("DATA: Anomaly on '%s' cleared after %s", f.conf.Field, t.Sub(*s.Since).Round(time.Second))
+		s.Active, s.Since, s.PeakScore = false, nil, nil
+	case s.Active && math.Abs(score) > math.Abs(*s.PeakScore):
+		s.PeakScore = &score
+	}
+	f.model.Learn(t, v)
+	return event
+}
+
+// States returns the current state of each detected field, in the order
+// they are configured. It reports false if anomaly detection is not
+// configured.
+func (d *AnomalyDetector) States() ([]AnomalyState, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	arch, err := d.refresh()
+	if err != nil || arch.AnomalyDetection == nil {
+		return nil, false
+	}
+	states := make([]AnomalyState, 0, len(d.fields))
+	for _, f := range d.fields {
+		states = append(states, f.state)
+	}
+	return states, true
+}
@@ -0,0 +1,233 @@
+// file: service/data/anomaly_test.go
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+func TestAnomalyThresholds(t *testing.T) {
+	tests := []struct {
+		name             string
+		conf             AnomalyFieldYAML
+		wantStart, clear float64
+		wantErr          bool
+	}{
+		{name: "defaults", wantStart: 4, clear: 3},
+		{name: "clear from threshold", conf: AnomalyFieldYAML{Threshold: 6}, wantStart: 6, clear: 4.5},
+		{name: "both", conf: AnomalyFieldYAML{Threshold: 5, ClearThreshold: 5}, wantStart: 5, clear: 5},
+		{name: "clear above threshold", conf: AnomalyFieldYAML{Threshold: 3, ClearThreshold: 4}, wantErr: true},
+		{name: "negative threshold", conf: AnomalyFieldYAML{Threshold: -1, ClearThreshold: -2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, clear, err := tt.conf.thresholds()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v", err)
+			}
+			if !tt.wantErr && (start != tt.wantStart || clear != tt.clear) {
+				t.Errorf("thresholds %g/%g, want %g/%g", start, clear, tt.wantStart, tt.clear)
+			}
+		})
+	}
+}
+
+func TestAnomalyNewModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    AnomalyFieldYAML
+		want    string
+		wantErr string
+	}{
+		{name: "ewma by default", want: "*analytics.EWMA"},
+		{name: "ewma", conf: AnomalyFieldYAML{Model: "ewma", Alpha: 1}, want: "*analytics.EWMA"},
+		{name: "alpha out of range", conf: AnomalyFieldYAML{Alpha: 1.5}, wantErr: "alpha"},
+		{name: "zscore", conf: AnomalyFieldYAML{Model: "zscore"}, want: "*analytics.RollingZScore"},
+		{name: "window too small", conf: AnomalyFieldYAML{Model: "zscore", Window: 1}, wantErr: "window"},
+		{name: "window too large", conf: AnomalyFieldYAML{Model: "zscore", Window: maxAnomalyMemory + 1}, wantErr: "window"},
+		{name: "seasonal", conf: AnomalyFieldYAML{Model: "seasonal"}, want: "*analytics.Seasonal"},
+		{name: "seasonal week", conf: AnomalyFieldYAML{Model: "seasonal", Period: "168h", Slot: "1h"}, want: "*analytics.Seasonal"},
+		{name: "invalid period", conf: AnomalyFieldYAML{Model: "seasonal", Period: "day"}, wantErr: "invalid period 'day'"},
+		{name: "invalid slot", conf: AnomalyFieldYAML{Model: "seasonal", Slot: "15"}, wantErr: "invalid slot '15'"},
+		{name: "period not a multiple", conf: AnomalyFieldYAML{Model: "seasonal", Period: "1h", Slot: "7m"}, wantErr: "whole multiple"},
+		{name: "slot longer than period", conf: AnomalyFieldYAML{Model: "seasonal", Period: "1h", Slot: "2h"}, wantErr: "whole multiple"},
+		{name: "too many slots", conf: AnomalyFieldYAML{Model: "seasonal", Period: "24h", Slot: "100ms"}, wantErr: "whole multiple"},
+		{name: "unknown", conf: AnomalyFieldYAML{Model: "arima"}, wantErr: "unknown model 'arima'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.conf.newModel()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprintf("%T", m); got != tt.want {
+				t.Errorf("model %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// fixedModel always expects the same value once ready, and counts what it
+// learns.
+type fixedModel struct {
+	expected, sigma float64
+	ready           bool
+	learned         int
+}
+
+func (m *fixedModel) Expect(time.Time) (float64, float64, bool) {
+	return m.expected, m.sigma, m.ready
+}
+
+func (m *fixedModel) Learn(time.Time, float64) { m.learned++ }
+
+func TestAnomalyFieldObserve(t *testing.T) {
+	t0 := time.Date(2026, 10, 14, 6, 0, 0, 0, time.UTC)
+	model := &fixedModel{expected: 10, sigma: 1}
+	f := &anomalyField{
+		conf:  AnomalyFieldYAML{Field: "Temp", MinSigma: 0.5},
+		model: model,
+		state: AnomalyState{Field: "Temp", Threshold: 4, ClearThreshold: 3},
+	}
+	steps := []struct {
+		name       string
+		v          float64
+		ready      bool
+		wantEvent  string
+		wantActive bool
+		wantPeak   float64
+	}{
+		{name: "warming up", v: 100},
+		{name: "normal", v: 11, ready: true},
+		{name: "starts", v: 15, ready: true, wantEvent: "start", wantActive: true, wantPeak: 5},
+		{name: "peak", v: 3, ready: true, wantActive: true, wantPeak: -7},
+		{name: "within the hysteresis", v: 13.5, ready: true, wantActive: true, wantPeak: -7},
+		{name: "clears", v: 12, ready: true, wantEvent: "clear"},
+		{name: "starts below", v: 6, ready: true, wantEvent: "start", wantActive: true, wantPeak: -4},
+		{name: "clears when the model is not ready", v: 6, wantEvent: "clear"},
+	}
+	for i, s := range steps {
+		at := t0.Add(time.Duration(i) * time.Minute)
+		model.ready = s.ready
+		event := f.observe(s.v, at)
+		got := ""
+		switch {
+		case event == nil:
+		case event["active"] == true:
+			got = "start"
+		default:
+			got = "clear"
+		}
+		if got != s.wantEvent {
+			t.Errorf("%s: event %v, want %q", s.name, event, s.wantEvent)
+		}
+		st := f.state
+		if st.Active != s.wantActive || st.Active && *st.PeakScore != s.wantPeak {
+			t.Errorf("%s: active %v, peak %v", s.name, st.Active, st.PeakScore)
+		}
+		if (st.Score != nil) != s.ready || *st.Value != s.v || !st.Updated.Equal(at) {
+			t.Errorf("%s: state %+v", s.name, st)
+		}
+		if s.name == "clears" {
+			if event["peak_score"] != -7.0 || event["duration_seconds"] != 180.0 || event["score"] != 2.0 {
+				t.Errorf("clear event %v", event)
+			}
+		}
+		if s.name == "clears when the model is not ready" {
+			if _, scored := event["score"]; scored {
+				t.Errorf("clear event scored without a model: %v", event)
+			}
+		}
+	}
+	if model.learned != len(steps) {
+		t.Errorf("learned %d values, want %d", model.learned, len(steps))
+	}
+}
+
+func TestAnomalyFieldMinSigma(t *testing.T) {
+	f := &anomalyField{
+		conf:  AnomalyFieldYAML{Field: "Temp", MinSigma: 2},
+		model: &fixedModel{expected: 10, ready: true},
+		state: AnomalyState{Field: "Temp", Threshold: 4, ClearThreshold: 3},
+	}
+	// Steady at sigma 0, a change of 6 scores 3 against the floor of 2.
+	if event := f.observe(16, time.Now()); event != nil || *f.state.Score != 3 || *f.state.Sigma != 2 {
+		t.Errorf("event %v, score %v, sigma %v", event, *f.state.Score, *f.state.Sigma)
+	}
+	f.conf.MinSigma = 0
+	if event := f.observe(16, time.Now()); event != nil || f.state.Score != nil {
+		t.Errorf("scored without a sigma: event %v, score %v", event, f.state.Score)
+	}
+}
+
+// hangingStore never finishes a write until its context ends.
+type hangingStore struct {
+	store.Store
+}
+
+func (hangingStore) WritePoints(ctx context.Context, points []store.Point) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestObserveWritesOutsideLock(t *testing.T) {
+	prev := CachedArchitectYAML
+	CachedArchitectYAML = &ArchitectYAML{AnomalyDetection: &AnomalyYAML{}}
+	t.Cleanup(func() { CachedArchitectYAML = prev })
+	d := NewAnomalyDetector(context.Background(), &config.Config{Values: map[string]string{}}, nil)
+	d.arch = CachedArchitectYAML
+	d.fields = []*anomalyField{{
+		conf:  AnomalyFieldYAML{Field: "Temp", Model: "ewma"},
+		model: &fixedModel{expected: 10, sigma: 1, ready: true},
+		state: AnomalyState{Field: "Temp", Threshold: 4, ClearThreshold: 3},
+	}}
+
+	// One event in flight, one waiting to be dispatched and one in the channel
+	// fill the writer, so the fourth blocks.
+	opts := store.BatchWriterOptions{BatchSize: 1, FlushInterval: time.Hour, QueueSize: 1, MaxInFlight: 1, Overflow: store.OverflowBlock}
+	batchWriter := store.NewChannelBatchWriter(hangingStore{}, opts, nil)
+	t0 := time.Date(2026, 10, 14, 6, 0, 0, 0, time.UTC)
+	observed := make(chan struct{})
+	go func() {
+		defer close(observed)
+		for i := 0; i < 8; i++ {
+			// Alternately starts and clears an anomaly.
+			d.Observe(map[string]interface{}{"Temp": 10 + float64(i%2)*10}, nil, t0.Add(time.Duration(i)*time.Second), batchWriter)
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for batchWriter.Stats().PointsAccepted < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("events not written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	states := make(chan []AnomalyState)
+	go func() {
+		s, _ := d.States()
+		states <- s
+	}()
+	select {
+	case s := <-states:
+		if len(s) != 1 || s[0].Field != "Temp" {
+			t.Errorf("states %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("States blocked by a pending write")
+	}
+	batchWriter.Close(0)
+	<-observed
+}
@@ -17,37 +17,115 @@ import (
 type ArchitectYAML struct {
 	ProjectMeta   map[string]string `yaml:"project_meta,omitempty"`
 	BooleanFields []struct {
-		Name    string `yaml:"name"`
-		Address int    `yaml:"address"`
-		Bit     *int   `yaml:"bit,omitempty"`
+		Name      string `yaml:"name"`
+		Address   int    `yaml:"address"`
+		Bit       *int   `yaml:"bit,omitempty"`
+		ScanClass string `yaml:"scan_class,omitempty"`
 	} `yaml:"boolean_fields"`
 	FaultFields []struct {
-		Name    string `yaml:"name"`
-		Address int    `yaml:"address"`
-		Bit     *int   `yaml:"bit,omitempty"`
+		Name      string `yaml:"name"`
+		Address   int    `yaml:"address"`
+		Bit       *int   `yaml:"bit,omitempty"`
+		ScanClass string `yaml:"scan_class,omitempty"`
+		// Severity classifies the fault in reports. Defaults to "warning"
+		// for WarningBits fields and "fault" otherwise.
+		Severity string `yaml:"severity,omitempty"`
+		// Category groups faults in reliability reports. Defaults to the
+		// name up to its last dot, e.g. "FaultBits.Hopper".
+		Category string `yaml:"category,omitempty"`
 	} `yaml:"fault_fields"`
 	// FloatFields are grouped by subgroup (e.g., "Performance", "HopperVibratory")
 	FloatFields map[string][]struct {
-		Name    string `yaml:"name"`
-		Address int    `yaml:"address"`
+		Name      string `yaml:"name"`
+		Address   int    `yaml:"address"`
+		ScanClass string `yaml:"scan_class,omitempty"`
 	} `yaml:"float_fields"`
+	// ScanClasses define named poll rates that fields or float groups can be
+	// assigned to. Unassigned fields use the default class at PLC_POLL_MS.
+	ScanClasses []ScanClassYAML `yaml:"scan_classes,omitempty"`
+	// TimestampField locates a PLC-supplied timestamp, used when
+	// PLC_TIMESTAMP_SOURCE is "field".
+	TimestampField *TimestampFieldYAML `yaml:"timestamp_field,omitempty"`
+	// EventCaptures define trigger/acknowledge handshakes that snapshot a set
+	// of fields as a single record each time the trigger goes true.
+	EventCaptures []EventCaptureYAML `yaml:"event_captures,omitempty"`
+	// TagFields are read from the PLC on every scan and attached as tags to
+	// every point written.
+	TagFields []TagFieldYAML `yaml:"tag_fields,omitempty"`
+	// MachineState names the fields describing what the machine as a whole
+	// is doing.
+	MachineState MachineStateYAML `yaml:"machine_state,omitempty"`
+	// OEE configures the OEE calculation; without it OEE is not reported.
+	OEE *OEEYAML `yaml:"oee,omitempty"`
+	// ShiftCalendar defines the shifts that time ranges can be given as.
+	ShiftCalendar *ShiftCalendarYAML `yaml:"shift_calendar,omitempty"`
+	// Comparison sets the thresholds at which /api/compare flags a change
+	// between two periods as a regression.
+	Comparison *ComparisonYAML `yaml:"comparison,omitempty"`
+	// SPC declares the specification limits and control charts of float
+	// fields.
+	SPC *SPCYAML `yaml:"spc,omitempty"`
+	// AnomalyDetection sets the float fields scored for anomalies as they
+	// are polled.
+	AnomalyDetection *AnomalyYAML `yaml:"anomaly_detection,omitempty"`
+	// FaultCascade groups faults that come on together into cascades led by
+	// a first-out fault, and records each one.
+	FaultCascade *FaultCascadeYAML `yaml:"fault_cascade,omitempty"`
+}
+
+// EventCaptureYAML describes one handshake-based event capture.
+type EventCaptureYAML struct {
+	Name        string           `yaml:"name"`
+	Measurement string           `yaml:"measurement,omitempty"`
+	Trigger     HandshakeBitYAML `yaml:"trigger"`
+	Ack         HandshakeBitYAML `yaml:"ack"`
+	Fields      []EventFieldYAML `yaml:"fields"`
+}
+
+// HandshakeBitYAML locates a handshake bit either by register address and bit
+// (relative to the configured register block) or, for EtherNet/IP, by tag name.
+type HandshakeBitYAML struct {
+	Address *int   `yaml:"address,omitempty"`
+	Bit     *int   `yaml:"bit,omitempty"`
+	Tag     string `yaml:"tag,omitempty"`
+}
+
+// EventFieldYAML names a field to include in an event record. Without a tag
+// the value is taken from the parsed register data; with a tag (EtherNet/IP
+// only) it is read directly from the PLC using the given type.
+type EventFieldYAML struct {
+	Name string `yaml:"name"`
+	Tag  string `yaml:"tag,omitempty"`
+	Type string `yaml:"type,omitempty"`
 }
 
 // ParsePLCDataFromRegisters uses the cached architect.yaml mapping to parse raw
 // register data into a map of field names to their corresponding values.
 // It is optimized to avoid file I/O on every call by using an in-memory cache.
 func ParsePLCDataFromRegisters(registers []uint16) (map[string]interface{}, error) {
+	return ParsePLCDataForScanClasses(registers, nil)
+}
+
+// ParsePLCDataForScanClasses parses only the fields assigned to the given scan
+// classes. A nil set parses every field.
+func ParsePLCDataForScanClasses(registers []uint16, classes map[string]bool) (map[string]interface{}, error) {
 	arch, err := GetArchitectYAML()
 	if err != nil {
 		return nil, err
 	}
 
 	result := make(map[string]interface{})
+	include := func(fieldClass, group string) bool {
+		return classes == nil || classes[arch.scanClassFor(fieldClass, group)]
+	}
 
 	// The ProjectMeta field from `arch` is intentionally ignored here,
 	// as this function is only concerned with parsing PLC register data.
 	// Booleans
 	for _, field := range arch.BooleanFields {
+		if !include(field.ScanClass, "") {
+			continue
+		}
 		reg := registers[field.Address]
 		bit := 0
 		if field.Bit != nil {
@@ -59,6 +137,9 @@ func ParsePLCDataFromRegisters(registers []uint16) (map[string]interface{}, erro
 
 	// Faults
 	for _, field := range arch.FaultFields {
+		if !include(field.ScanClass, "") {
+			continue
+		}
 		reg := registers[field.Address]
 		bit := 0
 		if field.Bit != nil {
@@ -72,6 +153,9 @@ func ParsePLCDataFromRegisters(registers []uint16) (map[string]interface{}, erro
 	for groupName, fields := range arch.FloatFields {
 		floatPairs := make(map[string]struct{ High, Low *int })
 		for i := 0; i < len(fields); i++ {
+			if !include(fields[i].ScanClass, groupName) {
+				continue
+			}
 			name := fields[i].Name
 			addr := fields[i].Address
 			if strings.HasSuffix(name, "(HighINT)") {
@@ -146,5 +230,23 @@ func LoadArchitectYAMLFromPath(path string) (*ArchitectYAML, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := arch.validate(); err != nil {
+		return nil, err
+	}
 	return &arch, nil
 }
+
+// validate rejects definitions that would fail or misread at run time.
+func (arch *ArchitectYAML) validate() error {
+	if arch.TimestampField != nil {
+		if err := arch.TimestampField.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, ev := range arch.EventCaptures {
+		if err := ev.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
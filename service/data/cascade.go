@@ -0,0 +1,123 @@
+// file: service/data/cascade.go
+// First-out fault tracking: cascade records written as faults come on
+package data
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/store"
+)
+
+// FaultCascadeYAML configures the grouping of faults into cascades.
+type FaultCascadeYAML struct {
+	// WindowSeconds is how long after the first-out fault another fault
+	// may come on and still belong to its cascade. Defaults to 1.
+	WindowSeconds float64 `yaml:"window_seconds,omitempty"`
+	// Measurement receives a record of each cascade as it closes.
+	// Defaults to "fault_cascades".
+	Measurement string `yaml:"measurement,omitempty"`
+}
+
+// CascadeWindow returns fault_cascade.window_seconds as a duration, or the
+// default of one second if fault cascades are not configured.
+func (arch *ArchitectYAML) CascadeWindow() time.Duration {
+	if arch.FaultCascade == nil || arch.FaultCascade.WindowSeconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(arch.FaultCascade.WindowSeconds * float64(time.Second))
+}
+
+// cascadeTracker watches the fault fields of each poll for faults coming on,
+// stamped with the poll time, and writes a record of each cascade once its
+// window has passed. Faults already on when the tracker starts, or when
+// architect.yaml is reloaded, are not activations.
+type cascadeTracker struct {
+	arch    *ArchitectYAML
+	last    map[string]bool
+	grouper *analytics.CascadeGrouper
+	tags    map[string]string
+}
+
+// newCascadeTracker creates a tracker with no known fault states.
+func newCascadeTracker() *cascadeTracker {
+	return &cascadeTracker{}
+}
+
+// Poll checks the fault fields freshly read in a poll cycle, stamped t, and
+// writes any cascade that can no longer grow, tagged with the tags of the
+// poll its root was seen on. Faults coming on in the same poll are taken in
+// architect.yaml order.
+func (ct *cascadeTracker) Poll(plcData map[string]interface{}, tags map[string]string, t time.Time, batchWriter *store.ChannelBatchWriter) {
+	arch, err := GetArchitectYAML()
+	if err != nil || arch.FaultCascade == nil {
+		return
+	}
+	if arch != ct.arch {
+		// The cascade open under the old mapping is written as it stands.
+		ct.Flush(batchWriter)
+		ct.arch = arch
+		ct.last = make(map[string]bool)
+		ct.grouper = analytics.NewCascadeGrouper(arch.CascadeWindow())
+	}
+	if c := ct.grouper.Close(t); c != nil {
+		ct.write(*c, batchWriter)
+	}
+	for _, f := range arch.FaultFields {
+		on, ok := plcData[f.Name].(bool)
+		if !ok {
+			continue
+		}
+		was, known := ct.last[f.Name]
+		ct.last[f.Name] = on
+		if !on || !known || was {
+			continue
+		}
+		if c := ct.grouper.Add(analytics.FaultActivation{Field: f.Name, Time: t}); c != nil {
+			ct.write(*c, batchWriter)
+		}
+		if len(ct.grouper.Open().Faults) == 1 {
+			ct.tags = tags
+		}
+	}
+}
+
+// Flush writes the cascade still open, if any, without waiting for its
+// window to pass. The cycles call it when they stop, so the cascade that
+// stopped the line is not lost at shutdown.
+func (ct *cascadeTracker) Flush(batchWriter *store.ChannelBatchWriter) {
+	if ct.grouper == nil {
+		return
+	}
+	if c := ct.grouper.Flush(); c != nil {
+		ct.write(*c, batchWriter)
+	}
+}
+
+// write stores the record of a closed cascade, tagged with its root.
+func (ct *cascadeTracker) write(c analytics.Cascade, batchWriter *store.ChannelBatchWriter) {
+	measurement := ct.arch.FaultCascade.Measurement
+	if measurement == "" {
+		measurement = "fault_cascades"
+	}
+	faults := make([]string, len(c.Faults))
+	for i, f := range c.Faults {
+		faults[i] = f.Field
+	}
+	recordTags := map[string]string{"root": c.Root}
+	for k, v := range ct.tags {
+		if k != "root" {
+			recordTags[k] = v
+		}
+	}
+	fields := map[string]interface{}{
+		"faults":       strings.Join(faults, ","),
+		"size":         len(c.Faults),
+		"span_seconds": c.Faults[len(c.Faults)-1].OffsetSeconds,
+		"root_tied":    c.RootTied,
+	}
+	batchWriter.AddPoint(measurement, recordTags, fields, c.Start)
+	log.Printf("DATA: Fault cascade led by '%s' with %d fault(s)", c.Root, len(c.Faults))
+}
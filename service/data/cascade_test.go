@@ -0,0 +1,81 @@
+// file: service/data/cascade_test.go
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useArchitect caches the architect.yaml given as text for the rest of the
+// test.
+func useArchitect(t *testing.T, yaml string) *ArchitectYAML {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "architect.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := LoadArchitectYAMLFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := CachedArchitectYAML
+	CachedArchitectYAML = arch
+	t.Cleanup(func() { CachedArchitectYAML = prev })
+	return arch
+}
+
+const cascadeArchitect = `fault_fields:
+  - name: "Jam"
+    address: 0
+    bit: 0
+  - name: "Overload"
+    address: 0
+    bit: 1
+fault_cascade:
+  window_seconds: 5
+`
+
+func TestCascadeTrackerFlush(t *testing.T) {
+	useArchitect(t, cascadeArchitect)
+	rec, batchWriter := newRecorder()
+	ct := newCascadeTracker()
+	t0 := time.Date(2026, 10, 14, 6, 0, 0, 0, time.UTC)
+	tags := map[string]string{"line": "1"}
+	ct.Poll(map[string]interface{}{"Jam": false, "Overload": false}, tags, t0, batchWriter)
+	ct.Poll(map[string]interface{}{"Jam": true, "Overload": false}, tags, t0.Add(time.Second), batchWriter)
+	ct.Poll(map[string]interface{}{"Jam": true, "Overload": true}, tags, t0.Add(2*time.Second), batchWriter)
+	// The window has not passed, so the cascade is still open when the cycle
+	// stops.
+	ct.Flush(batchWriter)
+	ct.Flush(batchWriter)
+	if err := batchWriter.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.points) != 1 {
+		t.Fatalf("%d records written, want 1: %+v", len(rec.points), rec.points)
+	}
+	p := rec.points[0]
+	if p.Measurement != "fault_cascades" || p.Tags["root"] != "Jam" || p.Tags["line"] != "1" ||
+		p.Fields["faults"] != "Jam,Overload" || p.Fields["size"] != 2 || !p.Time.Equal(t0.Add(time.Second)) {
+		t.Errorf("wrote %+v", p)
+	}
+}
+
+func TestCascadeTrackerReload(t *testing.T) {
+	useArchitect(t, cascadeArchitect)
+	rec, batchWriter := newRecorder()
+	ct := newCascadeTracker()
+	t0 := time.Date(2026, 10, 14, 6, 0, 0, 0, time.UTC)
+	ct.Poll(map[string]interface{}{"Jam": false}, nil, t0, batchWriter)
+	ct.Poll(map[string]interface{}{"Jam": true}, nil, t0.Add(time.Second), batchWriter)
+	useArchitect(t, cascadeArchitect)
+	ct.Poll(map[string]interface{}{"Jam": true}, nil, t0.Add(2*time.Second), batchWriter)
+	if err := batchWriter.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.points) != 1 || rec.points[0].Tags["root"] != "Jam" {
+		t.Errorf("wrote %+v, want the cascade open before the reload", rec.points)
+	}
+}
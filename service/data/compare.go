@@ -0,0 +1,109 @@
+// file: service/data/compare.go
+// Thresholds for flagging regressions between two periods
+package data
+
+import (
+	"fmt"
+	"math"
+)
+
+// Directions in which a field's value gets better.
+const (
+	BetterHigher = "higher"
+	BetterLower  = "lower"
+	BetterEither = "either"
+)
+
+// ComparisonYAML configures when a change between two periods is flagged
+// as a regression.
+type ComparisonYAML struct {
+	// FaultCountPercent flags a rise in any fault count of at least this
+	// percentage, unless the fault has its own threshold. 0 disables it.
+	FaultCountPercent float64         `yaml:"fault_count_percent,omitempty"`
+	Thresholds        []ThresholdYAML `yaml:"thresholds,omitempty"`
+}
+
+// ThresholdYAML flags a change in Field for the worse of at least Percent
+// of the baseline value, or at least Absolute in the field's own units. A
+// field is compared by its time-weighted percent true if it is a boolean,
+// its count if it is a fault, and its mean if it is a float.
+type ThresholdYAML struct {
+	Field string `yaml:"field"`
+	// Better is "higher", "lower" or "either", where any change beyond the
+	// threshold is flagged. Defaults to "lower" for faults and "either"
+	// otherwise.
+	Better   string  `yaml:"better,omitempty"`
+	Percent  float64 `yaml:"percent,omitempty"`
+	Absolute float64 `yaml:"absolute,omitempty"`
+}
+
+// Threshold returns the threshold configured for field, falling back to
+// fault_count_percent for faults. It reports false if there is none.
+func (c *ComparisonYAML) Threshold(field string, fault bool) (ThresholdYAML, bool) {
+	if c == nil {
+		return ThresholdYAML{}, false
+	}
+	for _, t := range c.Thresholds {
+		if t.Field == field {
+			if t.Better == "" {
+				t.Better = BetterEither
+				if fault {
+					t.Better = BetterLower
+				}
+			}
+			return t, true
+		}
+	}
+	if fault && c.FaultCountPercent > 0 {
+		return ThresholdYAML{Field: field, Better: BetterLower, Percent: c.FaultCountPercent}, true
+	}
+	return ThresholdYAML{}, false
+}
+
+// Validate checks that each threshold names a field, a known direction and
+// a positive limit.
+func (c *ComparisonYAML) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.FaultCountPercent < 0 {
+		return fmt.Errorf("comparison.fault_count_percent must not be negative")
+	}
+	for _, t := range c.Thresholds {
+		if t.Field == "" {
+			return fmt.Errorf("comparison threshold without a field")
+		}
+		switch t.Better {
+		case "", BetterHigher, BetterLower, BetterEither:
+		default:
+			return fmt.Errorf("comparison threshold for '%s' has unknown direction '%s'", t.Field, t.Better)
+		}
+		if t.Percent < 0 || t.Absolute < 0 || t.Percent == 0 && t.Absolute == 0 {
+			return fmt.Errorf("comparison threshold for '%s' needs a positive percent or absolute", t.Field)
+		}
+	}
+	return nil
+}
+
+// Regressed reports whether the change from baseline to current is for the
+// worse and reaches the threshold. A worsening from a baseline of zero
+// reaches any percent threshold.
+func (t ThresholdYAML) Regressed(baseline, current float64) bool {
+	delta := current - baseline
+	switch t.Better {
+	case BetterHigher:
+		delta = -delta
+	case BetterEither:
+		delta = math.Abs(delta)
+	}
+	if delta <= 0 {
+		return false
+	}
+	if t.Absolute > 0 && delta >= t.Absolute {
+		return true
+	}
+	if t.Percent > 0 {
+		return baseline == 0 || delta/math.Abs(baseline)*100 >= t.Percent
+	}
+	return false
+}
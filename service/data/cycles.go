@@ -3,18 +3,21 @@
 package data
 
 import (
+	"context"
 	"log"
+	"maps"
 	"strconv"
 	"time"
 	"vtarchitect/config"
-	"vtarchitect/influx"
+	"vtarchitect/store"
 	"vtarchitect/utils"
-
-	"github.com/tbrandon/mbserver"
 )
 
 // runEthernetIPCycle connects to the PLC via Ethernet/IP and continuously polls for data changes.
-func RunEthernetIPCycle(cfg *config.Config, batchWriter *influx.ChannelBatchWriter) {
+// Each scan class only reads the span of the array tag its fields occupy, so
+// slow classes add little load to the PLC. Freshly read values are passed to
+// detector. It returns when ctx is cancelled.
+func RunEthernetIPCycle(ctx context.Context, cfg *config.Config, detector *AnomalyDetector, batchWriter *store.ChannelBatchWriter) {
 	ip := cfg.Values["ETHERNET_IP_ADDRESS"]
 	eth := NewPLC(ip)
 
@@ -22,7 +25,9 @@ func RunEthernetIPCycle(cfg *config.Config, batchWriter *influx.ChannelBatchWrit
 		err := eth.Connect()
 		if err != nil {
 			log.Printf("DATA: PLC connection failed, retrying in 5 seconds: %v", err)
-			time.Sleep(5 * time.Second)
+			if !utils.SleepContext(ctx, 5*time.Second) {
+				return
+			}
 			continue
 		}
 		break
@@ -33,30 +38,92 @@ func RunEthernetIPCycle(cfg *config.Config, batchWriter *influx.ChannelBatchWrit
 	fullWriteInterval := utils.GetFullWriteInterval(cfg)
 	fullWriteTicker := time.NewTicker(fullWriteInterval)
 	defer fullWriteTicker.Stop()
+	events := NewEthernetIPEventCapturer(cfg, eth)
+	cascades := newCascadeTracker()
+	defer cascades.Flush(batchWriter)
+	stamps := newTimestamper(cfg, eth)
+	static := parseStaticTags(cfg)
+	metaKeys := parseMetaTags(cfg)
+	registers := make([]uint16, ethernetIPLength(cfg))
+	current := make(map[string]interface{})
+	var sched *scanScheduler
 	var last map[string]interface{}
-	for {
-		plcData, err := LoadFromEthernetIP(cfg, eth)
+	var lastTags map[string]string
+	for ctx.Err() == nil {
+		prev := sched
+		var err error
+		sched, err = refreshScanScheduler(cfg, sched)
 		if err != nil {
 			log.Printf("DATA:Error loading PLC data from Ethernet/IP YAML: %v", err)
-			time.Sleep(pollInterval)
+			utils.SleepContext(ctx, pollInterval)
 			continue
 		}
+		if sched != prev {
+			// Fields may have been added or removed by a mapping reload.
+			current = make(map[string]interface{})
+		}
+		full := false
 		select {
 		case <-fullWriteTicker.C:
-			influx.ProcessAndLogFullData(cfg, plcData, batchWriter)
-			last = plcData
+			full = true
 		default:
-			if !utils.MapsEqual(last, plcData) {
-				influx.ProcessAndLogChangedData(cfg, plcData, last, batchWriter)
-				last = plcData
+		}
+		due := sched.Due(time.Now())
+		if full {
+			due = sched.All()
+		}
+		if len(due) == 0 {
+			utils.SleepContext(ctx, sched.UntilNext())
+			continue
+		}
+
+		readStart := time.Now()
+		var readErr error
+		for _, r := range sched.arch.registerRanges(due) {
+			if readErr = ReadEthernetIPRegisterSpan(cfg, eth, registers, r.lo, r.hi); readErr != nil {
+				break
 			}
 		}
-		time.Sleep(pollInterval)
+		if readErr != nil {
+			log.Printf("DATA:Error loading PLC data from Ethernet/IP YAML: %v", readErr)
+			utils.SleepContext(ctx, sched.UntilNext())
+			continue
+		}
+		plcData, err := ParsePLCDataForScanClasses(registers, due)
+		if err != nil {
+			log.Printf("DATA:Error loading PLC data from Ethernet/IP YAML: %v", err)
+			utils.SleepContext(ctx, sched.UntilNext())
+			continue
+		}
+		mergeScan(current, plcData)
+		tags := pointTags(static, metaKeys, registers)
+		stamp := stamps.Stamp(cfg, registers, tags, readStart, batchWriter)
+		detector.Observe(plcData, tags, stamp, batchWriter)
+		cascades.Poll(plcData, tags, stamp, batchWriter)
+		if due[DefaultScanClass] {
+			events.Poll(cfg, registers, current, tags, stamp, batchWriter)
+		}
+		if full || !maps.Equal(tags, lastTags) {
+			// A tag change starts a new series, so it begins with a full snapshot.
+			store.ProcessAndLogFullData(cfg, current, tags, stamp, batchWriter)
+			last = cloneState(current)
+			lastTags = tags
+		} else if !utils.MapsEqual(last, current) {
+			store.ProcessAndLogChangedData(cfg, current, last, tags, stamp, batchWriter)
+			last = cloneState(current)
+		}
+		utils.SleepContext(ctx, sched.UntilNext())
 	}
+	log.Println("DATA: Ethernet/IP cycle stopped")
 }
 
-// runModbusCycle connects to the Modbus TCP server and continuously polls for data changes.
-func RunModbusCycle(cfg *config.Config, server *mbserver.Server, batchWriter *influx.ChannelBatchWriter) {
+// runModbusCycle reads the holding registers written by the PLC master and
+// continuously polls them for data changes. Logging is suspended while any
+// register the master writes in the configured range has gone unwritten for
+// longer than MODBUS_STALE_SECONDS, and the transition is recorded as a
+// comm_status field. Freshly read values are passed to detector. It returns
+// when ctx is cancelled.
+func RunModbusCycle(ctx context.Context, cfg *config.Config, server *ModbusServer, detector *AnomalyDetector, batchWriter *store.ChannelBatchWriter) {
 	startStr := cfg.Values["MODBUS_REGISTER_START"]
 	endStr := cfg.Values["MODBUS_REGISTER_END"]
 	start, err := strconv.Atoi(startStr)
@@ -69,33 +136,95 @@ func RunModbusCycle(cfg *config.Config, server *mbserver.Server, batchWriter *in
 	}
 
 	pollInterval := utils.GetPollInterval(cfg)
+	comm := &commMonitor{server: server, start: start, end: end, timeout: utils.GetStaleTimeout(cfg)}
 	fullWriteInterval := utils.GetFullWriteInterval(cfg)
 	fullWriteTicker := time.NewTicker(fullWriteInterval)
 	defer fullWriteTicker.Stop()
+	events := NewModbusEventCapturer(server, start)
+	cascades := newCascadeTracker()
+	defer cascades.Flush(batchWriter)
+	stamps := newTimestamper(cfg, nil)
+	static := parseStaticTags(cfg)
+	metaKeys := parseMetaTags(cfg)
+	current := make(map[string]interface{})
+	var sched *scanScheduler
 	var last map[string]interface{}
-	for {
+	var lastTags map[string]string
+	for ctx.Err() == nil {
 		if len(server.HoldingRegisters) <= end {
 			log.Println("DATA: Insufficient register length, skipping cycle")
-			time.Sleep(5 * time.Second)
+			utils.SleepContext(ctx, 5*time.Second)
 			continue
 		}
-		readSlice := server.HoldingRegisters[start : end+1]
-		plcData, err := ParsePLCDataFromRegisters(readSlice)
+		prev := sched
+		sched, err = refreshScanScheduler(cfg, sched)
 		if err != nil {
 			log.Printf("ERROR: Error loading PLC data from Modbus YAML: %v", err)
-			time.Sleep(pollInterval)
+			utils.SleepContext(ctx, pollInterval)
 			continue
 		}
+		if sched != prev {
+			// Fields may have been added or removed by a mapping reload.
+			current = make(map[string]interface{})
+		}
+		commTags := lastTags
+		if commTags == nil {
+			commTags = pointTags(static, metaKeys, nil)
+		}
+		fresh, resumed := comm.Check(cfg, time.Now(), commTags, batchWriter)
+		if !fresh {
+			// Force a full snapshot once writes resume.
+			last = nil
+			utils.SleepContext(ctx, pollInterval)
+			continue
+		}
+		if resumed {
+			log.Println("DATA: Modbus writes resumed, data marked fresh")
+			sched.Reset()
+		}
+
+		full := false
 		select {
 		case <-fullWriteTicker.C:
-			influx.ProcessAndLogFullData(cfg, plcData, batchWriter)
-			last = plcData
+			full = true
 		default:
-			if !utils.MapsEqual(last, plcData) {
-				influx.ProcessAndLogChangedData(cfg, plcData, last, batchWriter)
-				last = plcData
-			}
 		}
-		time.Sleep(pollInterval)
+		due := sched.Due(time.Now())
+		if full {
+			due = sched.All()
+		}
+		if len(due) == 0 {
+			utils.SleepContext(ctx, sched.UntilNext())
+			continue
+		}
+
+		readStart := time.Now()
+		readSlice := server.ReadHoldingRegisters(start, end)
+		plcData, err := ParsePLCDataForScanClasses(readSlice, due)
+		if err != nil {
+			log.Printf("ERROR: Error loading PLC data from Modbus YAML: %v", err)
+			utils.SleepContext(ctx, sched.UntilNext())
+			continue
+		}
+		mergeScan(current, plcData)
+		tags := pointTags(static, metaKeys, readSlice)
+		stamp := stamps.Stamp(cfg, readSlice, tags, readStart, batchWriter)
+		detector.Observe(plcData, tags, stamp, batchWriter)
+		cascades.Poll(plcData, tags, stamp, batchWriter)
+		if due[DefaultScanClass] {
+			events.Poll(cfg, readSlice, current, tags, stamp, batchWriter)
+		}
+		current[store.CommStatusField] = true
+		if full || !maps.Equal(tags, lastTags) {
+			// A tag change starts a new series, so it begins with a full snapshot.
+			store.ProcessAndLogFullData(cfg, current, tags, stamp, batchWriter)
+			last = cloneState(current)
+			lastTags = tags
+		} else if !utils.MapsEqual(last, current) {
+			store.ProcessAndLogChangedData(cfg, current, last, tags, stamp, batchWriter)
+			last = cloneState(current)
+		}
+		utils.SleepContext(ctx, sched.UntilNext())
 	}
+	log.Println("DATA: Modbus cycle stopped")
 }
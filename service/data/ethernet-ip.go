@@ -6,12 +6,19 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"vtarchitect/config"
 
 	"github.com/danomagnum/gologix"
 )
 
+// CIP WallClockTime object class and its CurrentUTCValue attribute.
+const (
+	wallClockTimeClass  gologix.CIPClass     = 0x8B
+	wallClockCurrentUTC gologix.CIPAttribute = 0x0B
+)
+
 type PLC struct {
 	client *gologix.Client
 }
@@ -72,20 +79,7 @@ func (plc *PLC) ReadTag(tagName string, tagType string, length int) (any, error)
 		err := plc.client.Read(tagName, &tagValue)
 		return tagValue, err
 	case "[]int":
-		values := make([]uint16, length)
-		for i := 0; i < length; i++ {
-			elementName := fmt.Sprintf("%s[%d]", tagName, i)
-			value, err := plc.ReadTag(elementName, "int", 0)
-			if err != nil {
-				return nil, fmt.Errorf("problem reading element %d of %s: %v", i, tagName, err)
-			}
-			intValue, ok := value.(int16)
-			if !ok {
-				return nil, fmt.Errorf("element %d of %s has incorrect type: %T", i, tagName, value)
-			}
-			values[i] = uint16(intValue)
-		}
-		return values, nil
+		return plc.ReadIntRange(tagName, 0, length)
 	case "[]dint":
 		values := make([]int32, length)
 		for i := 0; i < length; i++ {
@@ -121,6 +115,24 @@ func (plc *PLC) ReadTag(tagName string, tagType string, length int) (any, error)
 	}
 }
 
+// ReadIntRange reads count elements of an INT array tag starting at offset.
+func (plc *PLC) ReadIntRange(tagName string, offset, count int) ([]uint16, error) {
+	values := make([]uint16, count)
+	for i := 0; i < count; i++ {
+		elementName := fmt.Sprintf("%s[%d]", tagName, offset+i)
+		value, err := plc.ReadTag(elementName, "int", 0)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading element %d of %s: %v", offset+i, tagName, err)
+		}
+		intValue, ok := value.(int16)
+		if !ok {
+			return nil, fmt.Errorf("element %d of %s has incorrect type: %T", offset+i, tagName, value)
+		}
+		values[i] = uint16(intValue)
+	}
+	return values, nil
+}
+
 func (plc *PLC) WriteTag(tagName string, tagType string, tagValue interface{}) (any, error) {
 	switch tagType {
 	case "bool":
@@ -139,15 +151,22 @@ func (plc *PLC) WriteTag(tagName string, tagType string, tagValue interface{}) (
 	return nil, fmt.Errorf("unsupported tag type: %s", tagType)
 }
 
-func LoadFromEthernetIP(cfg *config.Config, plc *PLC) (map[string]interface{}, error) {
-	tag := cfg.Values["PLC_TAG"]
+// ethernetIPLength returns the configured length of the PLC_TAG array.
+func ethernetIPLength(cfg *config.Config) int {
 	length := 100 // default fallback
 	if lstr, ok := cfg.Values["ETHERNET_IP_LENGTH"]; ok {
 		if l, err := strconv.Atoi(lstr); err == nil && l > 0 {
 			length = l
 		}
 	}
-	rawDataAny, err := plc.ReadTag(tag, "[]int", length)
+	return length
+}
+
+// ReadEthernetIPRegisters reads the configured PLC_TAG integer array and
+// returns it as a block of registers.
+func ReadEthernetIPRegisters(cfg *config.Config, plc *PLC) ([]uint16, error) {
+	tag := cfg.Values["PLC_TAG"]
+	rawDataAny, err := plc.ReadTag(tag, "[]int", ethernetIPLength(cfg))
 	if err != nil {
 		log.Printf("Error reading from Ethernet/IP: %v", err)
 		return nil, err
@@ -155,7 +174,44 @@ func LoadFromEthernetIP(cfg *config.Config, plc *PLC) (map[string]interface{}, e
 	rawData, ok := rawDataAny.([]uint16)
 	if !ok {
 		log.Printf("Invalid data type returned from Ethernet/IP read")
+		return nil, fmt.Errorf("invalid data type returned from Ethernet/IP read: %T", rawDataAny)
+	}
+	return rawData, nil
+}
+
+// ReadEthernetIPRegisterSpan reads elements lo through hi of the PLC_TAG array
+// into the matching positions of registers, leaving the rest untouched.
+func ReadEthernetIPRegisterSpan(cfg *config.Config, plc *PLC, registers []uint16, lo, hi int) error {
+	if lo < 0 || hi >= len(registers) || lo > hi {
+		return fmt.Errorf("register span %d-%d outside array of length %d", lo, hi, len(registers))
+	}
+	values, err := plc.ReadIntRange(cfg.Values["PLC_TAG"], lo, hi-lo+1)
+	if err != nil {
+		log.Printf("Error reading from Ethernet/IP: %v", err)
+		return err
+	}
+	copy(registers[lo:], values)
+	return nil
+}
+
+func LoadFromEthernetIP(cfg *config.Config, plc *PLC) (map[string]interface{}, error) {
+	rawData, err := ReadEthernetIPRegisters(cfg, plc)
+	if err != nil {
 		return nil, err
 	}
 	return ParsePLCDataFromRegisters(rawData)
 }
+
+// ReadClock reads the controller's wall clock (WallClockTime object, current
+// UTC value in microseconds since the Unix epoch).
+func (plc *PLC) ReadClock() (time.Time, error) {
+	item, err := plc.client.GetAttrSingle(wallClockTimeClass, 1, wallClockCurrentUTC)
+	if err != nil {
+		return time.Time{}, err
+	}
+	us, err := item.Uint64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("problem parsing wall clock value: %v", err)
+	}
+	return time.UnixMicro(int64(us)), nil
+}
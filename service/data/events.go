@@ -0,0 +1,247 @@
+// file: service/data/events.go
+// Trigger/acknowledge handshake event capture for per-part traceability
+package data
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+// handshakeIO abstracts how handshake bits and tag-based event fields are
+// accessed for a particular data source.
+type handshakeIO interface {
+	ReadBit(ref HandshakeBitYAML, registers []uint16) (bool, error)
+	WriteBit(ref HandshakeBitYAML, value bool) error
+	ReadField(field EventFieldYAML) (interface{}, error)
+}
+
+// captureState tracks where one event capture is in its handshake.
+type captureState struct {
+	captured bool // a record was written for the current trigger
+	acked    bool // the acknowledge bit has been set for it
+}
+
+// EventCapturer runs the event_captures handshakes from architect.yaml. On a
+// rising trigger it writes one record holding the configured fields, sets the
+// acknowledge bit, and waits for the trigger to clear before clearing the
+// acknowledge and arming again.
+type EventCapturer struct {
+	io     handshakeIO
+	states map[string]*captureState
+}
+
+// NewModbusEventCapturer creates an EventCapturer that reads triggers from the
+// holding register block starting at start and writes acknowledge bits back
+// into the server's holding registers for the master to read.
+func NewModbusEventCapturer(server *ModbusServer, start int) *EventCapturer {
+	return &EventCapturer{
+		io:     &modbusHandshake{server: server, start: start},
+		states: make(map[string]*captureState),
+	}
+}
+
+// NewEthernetIPEventCapturer creates an EventCapturer that reads and writes
+// handshake bits on the PLC. Bits given by address refer to elements of the
+// array tag configured in PLC_TAG.
+func NewEthernetIPEventCapturer(cfg *config.Config, plc *PLC) *EventCapturer {
+	return &EventCapturer{
+		io:     &ethernetIPHandshake{plc: plc, arrayTag: cfg.Values["PLC_TAG"]},
+		states: make(map[string]*captureState),
+	}
+}
+
+// Poll advances every configured handshake using the registers and parsed data
+// from the current poll cycle, stamping captured records with t.
+func (ec *EventCapturer) Poll(cfg *config.Config, registers []uint16, plcData map[string]interface{}, tags map[string]string, t time.Time, batchWriter *store.ChannelBatchWriter) {
+	arch, err := GetArchitectYAML()
+	if err != nil {
+		return
+	}
+	for _, ev := range arch.EventCaptures {
+		st, ok := ec.states[ev.Name]
+		if !ok {
+			st = &captureState{}
+			ec.states[ev.Name] = st
+		}
+
+		trigger, err := ec.io.ReadBit(ev.Trigger, registers)
+		if err != nil {
+			log.Printf("DATA: Error reading trigger for event '%s': %v", ev.Name, err)
+			continue
+		}
+
+		if trigger && !st.captured {
+			fields := ec.snapshot(ev, plcData)
+			if len(fields) == 0 {
+				log.Printf("DATA: Event '%s' triggered but no fields could be captured", ev.Name)
+			} else {
+				store.ProcessAndLogEvent(cfg, ev.Measurement, ev.Name, tags, fields, t, batchWriter)
+			}
+			st.captured, st.acked = true, false
+		}
+
+		if !trigger && st.captured {
+			if err := ec.io.WriteBit(ev.Ack, false); err != nil {
+				log.Printf("DATA: Error clearing acknowledge for event '%s': %v", ev.Name, err)
+				continue
+			}
+			st.captured, st.acked = false, false
+			continue
+		}
+
+		if st.captured && !st.acked {
+			if err := ec.io.WriteBit(ev.Ack, true); err != nil {
+				log.Printf("DATA: Error setting acknowledge for event '%s': %v", ev.Name, err)
+				continue
+			}
+			st.acked = true
+		}
+	}
+}
+
+// snapshot collects the configured fields for an event record.
+func (ec *EventCapturer) snapshot(ev EventCaptureYAML, plcData map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(ev.Fields))
+	for _, f := range ev.Fields {
+		if f.Tag != "" {
+			val, err := ec.io.ReadField(f)
+			if err != nil {
+				log.Printf("DATA: Error reading field '%s' for event '%s': %v", f.Name, ev.Name, err)
+				continue
+			}
+			fields[f.Name] = val
+			continue
+		}
+		val, ok := plcData[f.Name]
+		if !ok {
+			log.Printf("DATA: Field '%s' for event '%s' not found in PLC data", f.Name, ev.Name)
+			continue
+		}
+		fields[f.Name] = val
+	}
+	return fields
+}
+
+// Validate checks that the event has a name, a trigger and an acknowledge.
+func (ev EventCaptureYAML) Validate() error {
+	if ev.Name == "" {
+		return fmt.Errorf("event capture without a name")
+	}
+	if err := ev.Trigger.validate(); err != nil {
+		return fmt.Errorf("event '%s' trigger: %w", ev.Name, err)
+	}
+	if err := ev.Ack.validate(); err != nil {
+		return fmt.Errorf("event '%s' ack: %w", ev.Name, err)
+	}
+	return nil
+}
+
+// validate checks that a handshake bit is given by tag or by a non-negative
+// address and a bit from 0 to 15.
+func (ref HandshakeBitYAML) validate() error {
+	if ref.Tag != "" {
+		if ref.Address != nil || ref.Bit != nil {
+			return fmt.Errorf("give either a tag or an address and bit, not both")
+		}
+		return nil
+	}
+	if ref.Address == nil {
+		return fmt.Errorf("needs an address or a tag")
+	}
+	if *ref.Address < 0 {
+		return fmt.Errorf("address %d is negative", *ref.Address)
+	}
+	if ref.Bit != nil && (*ref.Bit < 0 || *ref.Bit > 15) {
+		return fmt.Errorf("bit %d out of range 0-15", *ref.Bit)
+	}
+	return nil
+}
+
+// registerBit reads an address/bit handshake reference from a register block.
+func registerBit(ref HandshakeBitYAML, registers []uint16) (bool, error) {
+	if ref.Address == nil {
+		return false, fmt.Errorf("handshake bit has no address")
+	}
+	if *ref.Address < 0 || *ref.Address >= len(registers) {
+		return false, fmt.Errorf("address %d outside register block", *ref.Address)
+	}
+	bit := 0
+	if ref.Bit != nil {
+		bit = *ref.Bit
+	}
+	if bit < 0 || bit > 15 {
+		return false, fmt.Errorf("bit %d out of range 0-15", bit)
+	}
+	return registers[*ref.Address]&(1<<bit) != 0, nil
+}
+
+// modbusHandshake implements handshakeIO for Modbus slave mode.
+type modbusHandshake struct {
+	server *ModbusServer
+	start  int
+}
+
+func (m *modbusHandshake) ReadBit(ref HandshakeBitYAML, registers []uint16) (bool, error) {
+	if ref.Tag != "" {
+		return false, fmt.Errorf("tag handshakes are not supported over Modbus")
+	}
+	return registerBit(ref, registers)
+}
+
+func (m *modbusHandshake) WriteBit(ref HandshakeBitYAML, value bool) error {
+	if ref.Tag != "" {
+		return fmt.Errorf("tag handshakes are not supported over Modbus")
+	}
+	if ref.Address == nil {
+		return fmt.Errorf("handshake bit has no address")
+	}
+	bit := 0
+	if ref.Bit != nil {
+		bit = *ref.Bit
+	}
+	return m.server.WriteBit(m.start+*ref.Address, bit, value)
+}
+
+func (m *modbusHandshake) ReadField(field EventFieldYAML) (interface{}, error) {
+	return nil, fmt.Errorf("tag fields are not supported over Modbus")
+}
+
+// ethernetIPHandshake implements handshakeIO for EtherNet/IP client mode.
+type ethernetIPHandshake struct {
+	plc      *PLC
+	arrayTag string
+}
+
+func (e *ethernetIPHandshake) ReadBit(ref HandshakeBitYAML, registers []uint16) (bool, error) {
+	if ref.Tag != "" {
+		return e.plc.ReadTrigger(ref.Tag)
+	}
+	return registerBit(ref, registers)
+}
+
+func (e *ethernetIPHandshake) WriteBit(ref HandshakeBitYAML, value bool) error {
+	tag := ref.Tag
+	if tag == "" {
+		if ref.Address == nil {
+			return fmt.Errorf("handshake bit has no address or tag")
+		}
+		bit := 0
+		if ref.Bit != nil {
+			bit = *ref.Bit
+		}
+		tag = fmt.Sprintf("%s[%d].%d", e.arrayTag, *ref.Address, bit)
+	}
+	return e.plc.WriteResponse(tag, value)
+}
+
+func (e *ethernetIPHandshake) ReadField(field EventFieldYAML) (interface{}, error) {
+	tagType := field.Type
+	if tagType == "" {
+		tagType = "real"
+	}
+	return e.plc.ReadTag(field.Tag, tagType, 0)
+}
@@ -0,0 +1,87 @@
+// file: service/data/events_test.go
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func intp(v int) *int { return &v }
+
+func TestEventCaptureValidate(t *testing.T) {
+	ack := HandshakeBitYAML{Address: intp(31)}
+	tests := []struct {
+		name    string
+		trigger HandshakeBitYAML
+		wantErr string
+	}{
+		{name: "address and bit", trigger: HandshakeBitYAML{Address: intp(30), Bit: intp(15)}},
+		{name: "address only", trigger: HandshakeBitYAML{Address: intp(0)}},
+		{name: "tag", trigger: HandshakeBitYAML{Tag: "Program:Main.Trigger"}},
+		{name: "negative bit", trigger: HandshakeBitYAML{Address: intp(30), Bit: intp(-1)}, wantErr: "bit -1 out of range"},
+		{name: "bit above 15", trigger: HandshakeBitYAML{Address: intp(30), Bit: intp(16)}, wantErr: "bit 16 out of range"},
+		{name: "negative address", trigger: HandshakeBitYAML{Address: intp(-2)}, wantErr: "address -2 is negative"},
+		{name: "nothing", trigger: HandshakeBitYAML{}, wantErr: "needs an address or a tag"},
+		{name: "bit without address", trigger: HandshakeBitYAML{Bit: intp(3)}, wantErr: "needs an address or a tag"},
+		{name: "tag and address", trigger: HandshakeBitYAML{Tag: "T", Address: intp(1)}, wantErr: "not both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EventCaptureYAML{Name: "PartComplete", Trigger: tt.trigger, Ack: ack}.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsInvalidHandshake(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "architect.yaml")
+	yaml := `event_captures:
+  - name: "PartComplete"
+    trigger:
+      address: 30
+      bit: 0
+    ack:
+      address: 31
+      bit: 16
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadArchitectYAMLFromPath(path)
+	if err == nil || !strings.Contains(err.Error(), "event 'PartComplete' ack: bit 16") {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestRegisterBit(t *testing.T) {
+	registers := []uint16{0x8001, 0}
+	tests := []struct {
+		name    string
+		ref     HandshakeBitYAML
+		want    bool
+		wantErr bool
+	}{
+		{name: "default bit 0", ref: HandshakeBitYAML{Address: intp(0)}, want: true},
+		{name: "bit 15", ref: HandshakeBitYAML{Address: intp(0), Bit: intp(15)}, want: true},
+		{name: "clear bit", ref: HandshakeBitYAML{Address: intp(0), Bit: intp(1)}},
+		{name: "second register", ref: HandshakeBitYAML{Address: intp(1), Bit: intp(15)}},
+		{name: "outside block", ref: HandshakeBitYAML{Address: intp(2)}, wantErr: true},
+		{name: "negative bit", ref: HandshakeBitYAML{Address: intp(0), Bit: intp(-1)}, wantErr: true},
+		{name: "bit above 15", ref: HandshakeBitYAML{Address: intp(0), Bit: intp(16)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := registerBit(tt.ref, registers)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %v, %v", got, err)
+			}
+		})
+	}
+}
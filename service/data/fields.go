@@ -4,7 +4,10 @@
 // queries.
 package data
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+)
 
 // GetBooleanFieldNames retrieves the list of boolean field names from the cached
 // architect.yaml configuration. These names are used for constructing InfluxDB
@@ -36,6 +39,49 @@ func GetFaultFieldNames() ([]string, error) {
 	return fields, nil
 }
 
+// GetFaultSeverities retrieves the severity of each fault field from the
+// cached architect.yaml configuration, defaulting to "warning" for
+// WarningBits fields and "fault" for the rest.
+func GetFaultSeverities() (map[string]string, error) {
+	mapping, err := GetArchitectYAML()
+	if err != nil {
+		return nil, err
+	}
+	severities := make(map[string]string, len(mapping.FaultFields))
+	for _, f := range mapping.FaultFields {
+		switch {
+		case f.Severity != "":
+			severities[f.Name] = f.Severity
+		case strings.HasPrefix(f.Name, "WarningBits"):
+			severities[f.Name] = "warning"
+		default:
+			severities[f.Name] = "fault"
+		}
+	}
+	return severities, nil
+}
+
+// GetFaultCategories retrieves the category of each fault field from the
+// cached architect.yaml configuration, defaulting to the field name up to its
+// last dot.
+func GetFaultCategories() (map[string]string, error) {
+	mapping, err := GetArchitectYAML()
+	if err != nil {
+		return nil, err
+	}
+	categories := make(map[string]string, len(mapping.FaultFields))
+	for _, f := range mapping.FaultFields {
+		if f.Category != "" {
+			categories[f.Name] = f.Category
+		} else if i := strings.LastIndex(f.Name, "."); i > 0 {
+			categories[f.Name] = f.Name[:i]
+		} else {
+			categories[f.Name] = f.Name
+		}
+	}
+	return categories, nil
+}
+
 // GetCombinedFloatFields generates the namespaced float field names for InfluxDB queries.
 // It combines group names with field names (e.g., "Performance.PartsPerMinute").
 func GetCombinedFloatFields(arch *ArchitectYAML) []string {
@@ -54,3 +100,48 @@ func GetCombinedFloatFields(arch *ArchitectYAML) []string {
 	}
 	return result
 }
+
+// IsFaultField reports whether name is one of the fault fields in the cached
+// architect.yaml configuration.
+func IsFaultField(name string) bool {
+	mapping, err := GetArchitectYAML()
+	if err != nil {
+		return false
+	}
+	for _, f := range mapping.FaultFields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBooleanField reports whether name is one of the boolean fields in the
+// cached architect.yaml configuration.
+func IsBooleanField(name string) bool {
+	mapping, err := GetArchitectYAML()
+	if err != nil {
+		return false
+	}
+	for _, f := range mapping.BooleanFields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFloatField reports whether name is one of the namespaced float fields in
+// the cached architect.yaml configuration.
+func IsFloatField(name string) bool {
+	mapping, err := GetArchitectYAML()
+	if err != nil {
+		return false
+	}
+	for _, f := range GetCombinedFloatFields(mapping) {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
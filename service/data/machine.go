@@ -0,0 +1,72 @@
+// file: service/data/machine.go
+// Fields describing the state of the machine as a whole
+package data
+
+import "time"
+
+// DefaultRunningField is the running bit used when machine_state does not
+// name one, provided it is one of the boolean fields.
+const DefaultRunningField = "SystemStatusBits.MachineRunning"
+
+// MachineStateYAML names the boolean fields that describe what the machine
+// as a whole is doing.
+type MachineStateYAML struct {
+	// RunningField is true while the machine is running.
+	RunningField string `yaml:"running_field,omitempty"`
+	// ReasonFields are booleans that explain a stop when no fault does,
+	// such as waiting for material or an operator pause.
+	ReasonFields []string `yaml:"reason_fields,omitempty"`
+	// ReasonWindowSeconds is how long after a stop a fault or reason may
+	// come on and still be blamed for it, allowing for fields read on
+	// different polls. Defaults to 2.
+	ReasonWindowSeconds float64 `yaml:"reason_window_seconds,omitempty"`
+}
+
+// ReasonWindow returns machine_state.reason_window_seconds as a duration.
+func (m MachineStateYAML) ReasonWindow() time.Duration {
+	if m.ReasonWindowSeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(m.ReasonWindowSeconds * float64(time.Second))
+}
+
+// RunningField returns the boolean field that is true while the machine is
+// running: machine_state.running_field, or DefaultRunningField if it is one
+// of the boolean fields. It returns "" if there is neither.
+func (arch *ArchitectYAML) RunningField() string {
+	if arch.MachineState.RunningField != "" {
+		return arch.MachineState.RunningField
+	}
+	for _, f := range arch.BooleanFields {
+		if f.Name == DefaultRunningField {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// OEEYAML configures the OEE calculation. Counts are cumulative counters,
+// read as the increase over each period; a counter that goes down is taken
+// to have been reset.
+type OEEYAML struct {
+	// RunningField overrides machine_state.running_field for OEE.
+	RunningField string `yaml:"running_field,omitempty"`
+	// PlannedStopField is true during planned stops, such as breaks and
+	// changeovers, which are left out of planned production time.
+	PlannedStopField string `yaml:"planned_stop_field,omitempty"`
+	TotalCountField  string `yaml:"total_count_field"`
+	// Good parts are counted by GoodCountField or, failing that, as total
+	// parts less RejectCountField.
+	GoodCountField   string  `yaml:"good_count_field,omitempty"`
+	RejectCountField string  `yaml:"reject_count_field,omitempty"`
+	IdealCycleSecs   float64 `yaml:"ideal_cycle_seconds"`
+}
+
+// OEERunningField returns the running field used for OEE: oee.running_field
+// or, failing that, the machine running field.
+func (arch *ArchitectYAML) OEERunningField() string {
+	if arch.OEE != nil && arch.OEE.RunningField != "" {
+		return arch.OEE.RunningField
+	}
+	return arch.RunningField()
+}
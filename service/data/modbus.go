@@ -0,0 +1,141 @@
+// file: service/data/modbus.go
+// Modbus TCP slave wrapper that tracks when the PLC master last wrote data
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+
+	"github.com/tbrandon/mbserver"
+)
+
+// ModbusServer wraps an mbserver.Server and records the time of the last
+// write to every holding register, so the acquisition loop can tell live data
+// from values left behind by a master that stopped writing.
+type ModbusServer struct {
+	*mbserver.Server
+	mu        sync.RWMutex
+	lastWrite []time.Time
+}
+
+// NewModbusServer creates a Modbus server whose holding register write
+// functions (6 and 16) are hooked to record write times.
+func NewModbusServer() *ModbusServer {
+	m := &ModbusServer{Server: mbserver.NewServer()}
+	m.lastWrite = make([]time.Time, len(m.HoldingRegisters))
+	m.RegisterFunctionHandler(6, m.wrapWrite(mbserver.WriteHoldingRegister))
+	m.RegisterFunctionHandler(16, m.wrapWrite(mbserver.WriteHoldingRegisters))
+	return m
+}
+
+// wrapWrite returns a function handler that runs the default mbserver write
+// under the register lock and stamps the written range on success.
+func (m *ModbusServer) wrapWrite(fn func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception)) func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception) {
+	return func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		res, exception := fn(s, frame)
+		if exception != &mbserver.Success {
+			return res, exception
+		}
+		d := frame.GetData()
+		if len(d) < 4 {
+			return res, exception
+		}
+		register := int(binary.BigEndian.Uint16(d[0:2]))
+		count := 1
+		if frame.GetFunction() == 16 {
+			count = int(binary.BigEndian.Uint16(d[2:4]))
+		}
+		now := time.Now()
+		for i := register; i < register+count && i < len(m.lastWrite); i++ {
+			m.lastWrite[i] = now
+		}
+		return res, exception
+	}
+}
+
+// ReadHoldingRegisters returns a copy of the holding registers from start to
+// end inclusive, taken under the same lock used by master writes.
+func (m *ModbusServer) ReadHoldingRegisters(start, end int) []uint16 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]uint16, end-start+1)
+	copy(out, m.HoldingRegisters[start:end+1])
+	return out
+}
+
+// OldestWrite returns the register between start and end (inclusive) that
+// the master wrote longest ago, and when. Registers the master has never
+// written, such as acknowledge registers only the service sets, are skipped.
+// It returns -1 and the zero time if the range has never been written.
+func (m *ModbusServer) OldestWrite(start, end int) (int, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	register, oldest := -1, time.Time{}
+	for i := start; i <= end && i < len(m.lastWrite); i++ {
+		if m.lastWrite[i].IsZero() {
+			continue
+		}
+		if register < 0 || m.lastWrite[i].Before(oldest) {
+			register, oldest = i, m.lastWrite[i]
+		}
+	}
+	return register, oldest
+}
+
+// WriteBit sets or clears a single bit of a holding register so the PLC
+// master can read it back, e.g. as a handshake acknowledge. Writes made by
+// the service itself do not count as master writes.
+func (m *ModbusServer) WriteBit(register, bit int, value bool) error {
+	if register < 0 || register >= len(m.HoldingRegisters) || bit < 0 || bit > 15 {
+		return fmt.Errorf("register %d bit %d out of range", register, bit)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value {
+		m.HoldingRegisters[register] |= 1 << bit
+	} else {
+		m.HoldingRegisters[register] &^= 1 << bit
+	}
+	return nil
+}
+
+// commMonitor tracks whether the Modbus master is still writing the register
+// range, and records comm_status when that changes.
+type commMonitor struct {
+	server     *ModbusServer
+	start, end int
+	timeout    time.Duration
+	known, ok  bool
+}
+
+// Check reports whether every register the master writes between start and
+// end was written within the stale timeout at now. On the first stale check,
+// whether at startup or after fresh data, it writes comm_status=false with
+// tags. resumed is true on the first fresh check after stale data.
+func (c *commMonitor) Check(cfg *config.Config, now time.Time, tags map[string]string, batchWriter *store.ChannelBatchWriter) (fresh, resumed bool) {
+	register, oldest := c.server.OldestWrite(c.start, c.end)
+	fresh = register >= 0 && now.Sub(oldest) <= c.timeout
+	if !fresh {
+		if !c.known || c.ok {
+			if register < 0 {
+				log.Println("DATA: No Modbus writes received yet, data marked stale")
+			} else {
+				log.Printf("DATA: Modbus register %d not written since %s, data marked stale", register, oldest.Format(time.RFC3339))
+			}
+			store.ProcessAndLogCommStatus(cfg, false, tags, batchWriter)
+		}
+		c.known, c.ok = true, false
+		return false, false
+	}
+	resumed = c.known && !c.ok
+	c.known, c.ok = true, true
+	return true, resumed
+}
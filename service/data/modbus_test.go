@@ -0,0 +1,175 @@
+// file: service/data/modbus_test.go
+package data
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+
+	"github.com/tbrandon/mbserver"
+)
+
+// recordingStore keeps every point written to it.
+type recordingStore struct {
+	store.Store
+	mu     sync.Mutex
+	points []store.Point
+}
+
+func (s *recordingStore) WritePoints(ctx context.Context, points []store.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.points = append(s.points, points...)
+	return nil
+}
+
+// newRecorder returns a batch writer over a recordingStore. Closing the
+// writer flushes every buffered point to the store.
+func newRecorder() (*recordingStore, *store.ChannelBatchWriter) {
+	rec := &recordingStore{}
+	opts := store.BatchWriterOptions{BatchSize: 100, FlushInterval: time.Hour, QueueSize: 100, MaxInFlight: 1, Overflow: store.OverflowBlock}
+	return rec, store.NewChannelBatchWriter(rec, opts, nil)
+}
+
+// listenModbus starts server on a free local port and returns a connection
+// to it.
+func listenModbus(t *testing.T, server *ModbusServer) net.Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	if err := server.ListenTCP(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// request sends one Modbus TCP request and waits for its response.
+func request(t *testing.T, conn net.Conn, function uint8, data []byte) {
+	t.Helper()
+	frame := &mbserver.TCPFrame{TransactionIdentifier: 1, Device: 1, Function: function, Data: data}
+	if _, err := conn.Write(frame.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 6)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+	if body[1] != function {
+		t.Fatalf("function %d answered with exception %d", function, body[2])
+	}
+}
+
+func TestModbusWritesStamped(t *testing.T) {
+	server := NewModbusServer()
+	conn := listenModbus(t, server)
+	if register, _ := server.OldestWrite(0, 20); register != -1 {
+		t.Fatalf("register %d written before any request", register)
+	}
+
+	before := time.Now()
+	// Function 6 writes register 3.
+	request(t, conn, 6, []byte{0, 3, 0, 42})
+	if register, at := server.OldestWrite(0, 20); register != 3 || at.Before(before) {
+		t.Errorf("after function 6: register %d at %v", register, at)
+	}
+
+	// Function 16 writes registers 10 to 12.
+	time.Sleep(5 * time.Millisecond)
+	mid := time.Now()
+	request(t, conn, 16, []byte{0, 10, 0, 3, 6, 0, 1, 0, 2, 0, 3})
+	if register, at := server.OldestWrite(10, 20); register != 10 || at.Before(mid) {
+		t.Errorf("after function 16: register %d at %v", register, at)
+	}
+	if got := server.ReadHoldingRegisters(10, 12); got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("registers hold %v", got)
+	}
+	// The oldest write across both requests is register 3.
+	if register, _ := server.OldestWrite(0, 20); register != 3 {
+		t.Errorf("oldest register %d, want 3", register)
+	}
+
+	// Writes made by the service are not master writes.
+	if err := server.WriteBit(15, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if register, _ := server.OldestWrite(13, 20); register != -1 {
+		t.Errorf("service write stamped register %d", register)
+	}
+}
+
+func TestCommMonitor(t *testing.T) {
+	cfg := &config.Config{Values: map[string]string{}}
+	server := NewModbusServer()
+	now := time.Now()
+	stamp := func(register int, age time.Duration) {
+		server.mu.Lock()
+		server.lastWrite[register] = now.Add(-age)
+		server.mu.Unlock()
+	}
+	rec, batchWriter := newRecorder()
+	comm := &commMonitor{server: server, start: 0, end: 9, timeout: 30 * time.Second}
+	tags := map[string]string{"line": "1"}
+	steps := []struct {
+		name        string
+		setup       func()
+		wantFresh   bool
+		wantResumed bool
+	}{
+		{name: "never written", wantFresh: false},
+		{name: "still never written", wantFresh: false},
+		{name: "first writes", setup: func() { stamp(0, time.Second); stamp(1, time.Second) }, wantFresh: true, wantResumed: true},
+		{name: "writes continue", wantFresh: true},
+		{name: "one register stops", setup: func() { stamp(0, time.Second); stamp(1, time.Minute) }, wantFresh: false},
+		{name: "still stopped", wantFresh: false},
+		{name: "register written again", setup: func() { stamp(1, 0) }, wantFresh: true, wantResumed: true},
+	}
+	for _, s := range steps {
+		if s.setup != nil {
+			s.setup()
+		}
+		fresh, resumed := comm.Check(cfg, now, tags, batchWriter)
+		if fresh != s.wantFresh || resumed != s.wantResumed {
+			t.Errorf("%s: fresh %t resumed %t, want %t and %t", s.name, fresh, resumed, s.wantFresh, s.wantResumed)
+		}
+	}
+	if err := batchWriter.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// One comm_status=false at startup and one when register 1 stopped.
+	if len(rec.points) != 2 {
+		t.Fatalf("%d points written, want 2: %+v", len(rec.points), rec.points)
+	}
+	for _, p := range rec.points {
+		if p.Fields[store.CommStatusField] != false || p.Tags["line"] != "1" {
+			t.Errorf("wrote %+v", p)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// file: service/data/scan.go
+// Scan classes for reading groups of fields at independent poll rates
+package data
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/utils"
+)
+
+// DefaultScanClass is the scan class of every field not assigned to one of the
+// scan_classes in architect.yaml. It runs at PLC_POLL_MS.
+const DefaultScanClass = "default"
+
+// ScanClassYAML defines a named poll rate. Float groups listed in Groups are
+// assigned to the class; individual fields can also select it by name with
+// their scan_class key.
+type ScanClassYAML struct {
+	Name     string   `yaml:"name"`
+	Interval string   `yaml:"interval"`
+	Groups   []string `yaml:"groups,omitempty"`
+}
+
+// scanClassFor resolves the scan class of a field from its own scan_class
+// setting or its float group, falling back to DefaultScanClass.
+func (arch *ArchitectYAML) scanClassFor(fieldClass, group string) string {
+	for _, sc := range arch.ScanClasses {
+		if fieldClass != "" && sc.Name == fieldClass {
+			return sc.Name
+		}
+	}
+	if group != "" {
+		for _, sc := range arch.ScanClasses {
+			for _, g := range sc.Groups {
+				if g == group {
+					return sc.Name
+				}
+			}
+		}
+	}
+	return DefaultScanClass
+}
+
+// maxRegisterGap is the longest run of unused registers read to join two
+// ranges into a single request. Ranges further apart are read separately, so
+// a poll does not read the registers of classes that are not due.
+const maxRegisterGap = 16
+
+// registerRange is an inclusive range of register addresses.
+type registerRange struct {
+	lo, hi int
+}
+
+// registerRanges returns the registers used by the fields of the given scan
+// classes as ascending ranges, joining ranges at most maxRegisterGap apart.
+// Event capture handshake registers are counted with the default class, and
+// timestamp and tag registers with every read. It returns nil if no registers
+// are needed.
+func (arch *ArchitectYAML) registerRanges(classes map[string]bool) []registerRange {
+	used := make(map[int]bool)
+	for _, f := range arch.BooleanFields {
+		if classes[arch.scanClassFor(f.ScanClass, "")] {
+			used[f.Address] = true
+		}
+	}
+	for _, f := range arch.FaultFields {
+		if classes[arch.scanClassFor(f.ScanClass, "")] {
+			used[f.Address] = true
+		}
+	}
+	for group, fields := range arch.FloatFields {
+		for _, f := range fields {
+			if classes[arch.scanClassFor(f.ScanClass, group)] {
+				used[f.Address] = true
+			}
+		}
+	}
+	if classes[DefaultScanClass] {
+		for _, ev := range arch.EventCaptures {
+			if ev.Trigger.Tag == "" && ev.Trigger.Address != nil {
+				used[*ev.Trigger.Address] = true
+			}
+		}
+	}
+	if len(used) == 0 {
+		return nil
+	}
+	if arch.TimestampField != nil {
+		used[arch.TimestampField.High] = true
+		used[arch.TimestampField.Low] = true
+		if arch.TimestampField.Millis != nil {
+			used[*arch.TimestampField.Millis] = true
+		}
+	}
+	for _, f := range arch.TagFields {
+		for w := 0; w < f.Words || w == 0; w++ {
+			used[f.Address+w] = true
+		}
+	}
+
+	addrs := make([]int, 0, len(used))
+	for addr := range used {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	var ranges []registerRange
+	for _, addr := range addrs {
+		if n := len(ranges); n > 0 && addr-ranges[n-1].hi-1 <= maxRegisterGap {
+			ranges[n-1].hi = addr
+			continue
+		}
+		ranges = append(ranges, registerRange{lo: addr, hi: addr})
+	}
+	return ranges
+}
+
+// scanScheduler tracks when each scan class is next due to be read.
+type scanScheduler struct {
+	arch      *ArchitectYAML
+	intervals map[string]time.Duration
+	next      map[string]time.Time
+}
+
+// newScanScheduler builds a scheduler for the scan classes in arch, with every
+// class due immediately.
+func newScanScheduler(cfg *config.Config, arch *ArchitectYAML) *scanScheduler {
+	s := &scanScheduler{
+		arch:      arch,
+		intervals: map[string]time.Duration{DefaultScanClass: utils.GetPollInterval(cfg)},
+		next:      make(map[string]time.Time),
+	}
+	for _, sc := range arch.ScanClasses {
+		d, err := time.ParseDuration(sc.Interval)
+		if err != nil || d <= 0 {
+			log.Printf("DATA: Invalid interval '%s' for scan class '%s', using the default poll interval", sc.Interval, sc.Name)
+			d = s.intervals[DefaultScanClass]
+		}
+		s.intervals[sc.Name] = d
+	}
+	s.Reset()
+	return s
+}
+
+// refreshScanScheduler returns s, or a new scheduler if architect.yaml has been
+// reloaded since s was built.
+func refreshScanScheduler(cfg *config.Config, s *scanScheduler) (*scanScheduler, error) {
+	arch, err := GetArchitectYAML()
+	if err != nil {
+		return s, err
+	}
+	if s == nil || s.arch != arch {
+		s = newScanScheduler(cfg, arch)
+		log.Printf("DATA: Scan schedule built with %d scan class(es)", len(s.intervals))
+	}
+	return s, nil
+}
+
+// Reset makes every scan class due immediately.
+func (s *scanScheduler) Reset() {
+	now := time.Now()
+	for name := range s.intervals {
+		s.next[name] = now
+	}
+}
+
+// All returns the set of every scan class.
+func (s *scanScheduler) All() map[string]bool {
+	all := make(map[string]bool, len(s.intervals))
+	for name := range s.intervals {
+		all[name] = true
+	}
+	return all
+}
+
+// Due returns the scan classes due at now and schedules their next read.
+func (s *scanScheduler) Due(now time.Time) map[string]bool {
+	due := make(map[string]bool)
+	for name, t := range s.next {
+		if now.Before(t) {
+			continue
+		}
+		due[name] = true
+		next := t.Add(s.intervals[name])
+		if next.Before(now) {
+			// Skip missed slots instead of bursting to catch up.
+			next = now.Add(s.intervals[name])
+		}
+		s.next[name] = next
+	}
+	return due
+}
+
+// UntilNext returns how long to wait until the next scan class is due.
+func (s *scanScheduler) UntilNext() time.Duration {
+	var earliest time.Time
+	for _, t := range s.next {
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	wait := time.Until(earliest)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// mergeScan copies the freshly read fields into the change-detection state.
+func mergeScan(current, partial map[string]interface{}) {
+	for k, v := range partial {
+		current[k] = v
+	}
+}
+
+// cloneState returns a shallow copy of the change-detection state.
+func cloneState(state map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(state))
+	for k, v := range state {
+		out[k] = v
+	}
+	return out
+}
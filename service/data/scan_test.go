@@ -0,0 +1,83 @@
+// file: service/data/scan_test.go
+package data
+
+import (
+	"fmt"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const scanYAML = `
+scan_classes:
+  - name: "fast"
+    interval: "100ms"
+    groups: ["Hopper"]
+  - name: "slow"
+    interval: "1m"
+boolean_fields:
+  - name: "Run"
+    address: 0
+  - name: "Recipe"
+    address: 200
+    scan_class: "slow"
+fault_fields:
+  - name: "Jam"
+    address: 10
+float_fields:
+  Hopper:
+    - name: "Vibration(HighINT)"
+      address: 100
+    - name: "Vibration(LowINT)"
+      address: 101
+event_captures:
+  - name: "PartComplete"
+    trigger:
+      address: 30
+    ack:
+      address: 31
+`
+
+func TestRegisterRanges(t *testing.T) {
+	var arch ArchitectYAML
+	if err := yaml.Unmarshal([]byte(scanYAML), &arch); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		classes []string
+		extra   func(*ArchitectYAML)
+		want    string
+	}{
+		{name: "fast only", classes: []string{"fast"}, want: "[{100 101}]"},
+		{name: "slow only", classes: []string{"slow"}, want: "[{200 200}]"},
+		{name: "default joins small gaps", classes: []string{DefaultScanClass}, want: "[{0 10} {30 30}]"},
+		{name: "fast and slow stay apart", classes: []string{"fast", "slow"}, want: "[{100 101} {200 200}]"},
+		{name: "every class", classes: []string{DefaultScanClass, "fast", "slow"}, want: "[{0 10} {30 30} {100 101} {200 200}]"},
+		{name: "nothing due", want: "[]"},
+		{name: "timestamp and tags with every read", classes: []string{"fast"},
+			extra: func(a *ArchitectYAML) {
+				a.TimestampField = &TimestampFieldYAML{High: 102, Low: 103}
+				a.TagFields = []TagFieldYAML{{Name: "batch", Address: 150, Words: 2}}
+			},
+			want: "[{100 103} {150 151}]"},
+		{name: "tags alone need no read",
+			extra: func(a *ArchitectYAML) { a.TagFields = []TagFieldYAML{{Name: "batch", Address: 150}} },
+			want:  "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := arch
+			if tt.extra != nil {
+				tt.extra(&a)
+			}
+			classes := make(map[string]bool)
+			for _, c := range tt.classes {
+				classes[c] = true
+			}
+			if got := fmt.Sprint(a.registerRanges(classes)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// file: service/data/shifts.go
+// Shift calendar: named shifts on chosen weekdays, less holidays
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // shift calendar zones on hosts without zoneinfo
+)
+
+const (
+	dateLayout  = "2006-01-02"
+	clockLayout = "15:04"
+	// shiftSearchDays is how far back the previous shift is looked for,
+	// enough to span a long holiday shutdown.
+	shiftSearchDays = 31
+)
+
+// ShiftCalendarYAML defines the shifts worked at the plant. Times and dates
+// are in TimeZone, an IANA zone name such as "Europe/Berlin" (default UTC).
+// No shift starts on a date listed in Holidays.
+type ShiftCalendarYAML struct {
+	TimeZone string      `yaml:"time_zone,omitempty"`
+	Shifts   []ShiftYAML `yaml:"shifts"`
+	Holidays []string    `yaml:"holidays,omitempty"`
+}
+
+// ShiftYAML is one shift, from Start to End as HH:MM. A shift whose End is
+// not after its Start runs past midnight and belongs to the day it starts.
+// Days lists the weekdays it starts on, e.g. ["mon", "tue"]; empty means
+// every day.
+type ShiftYAML struct {
+	Name  string   `yaml:"name"`
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+	Days  []string `yaml:"days,omitempty"`
+}
+
+// Shift is one occurrence of a shift. Date is the day it starts, in the
+// calendar time zone.
+type Shift struct {
+	Name  string    `json:"name"`
+	Date  string    `json:"date"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// shiftRule is a parsed ShiftYAML.
+type shiftRule struct {
+	name       string
+	start, end time.Duration // since midnight
+	days       map[time.Weekday]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseWeekday accepts a weekday as its English name or first three letters,
+// in any case.
+func parseWeekday(s string) (time.Weekday, bool) {
+	lower := strings.ToLower(s)
+	for abbr, wd := range weekdays {
+		if lower == abbr || lower == strings.ToLower(wd.String()) {
+			return wd, true
+		}
+	}
+	return 0, false
+}
+
+// parse checks the calendar and resolves its zone, rules and holidays.
+func (c *ShiftCalendarYAML) parse() (*time.Location, []shiftRule, map[string]bool, error) {
+	if c == nil || len(c.Shifts) == 0 {
+		return nil, nil, nil, fmt.Errorf("no shift calendar is configured in architect.yaml")
+	}
+	loc := time.UTC
+	if c.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(c.TimeZone); err != nil {
+			return nil, nil, nil, fmt.Errorf("shift calendar time zone: %w", err)
+		}
+	}
+	rules := make([]shiftRule, 0, len(c.Shifts))
+	for _, s := range c.Shifts {
+		if s.Name == "" || strings.Contains(s.Name, "@") {
+			return nil, nil, nil, fmt.Errorf("invalid shift name '%s'", s.Name)
+		}
+		start, err1 := time.Parse(clockLayout, s.Start)
+		end, err2 := time.Parse(clockLayout, s.End)
+		if err1 != nil || err2 != nil {
+			return nil, nil, nil, fmt.Errorf("shift '%s' needs start and end as HH:MM", s.Name)
+		}
+		rule := shiftRule{
+			name:  s.Name,
+			start: time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+			end:   time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+		}
+		if len(s.Days) > 0 {
+			rule.days = make(map[time.Weekday]bool)
+			for _, d := range s.Days {
+				wd, ok := parseWeekday(d)
+				if !ok {
+					return nil, nil, nil, fmt.Errorf("shift '%s' has unknown day '%s'", s.Name, d)
+				}
+				rule.days[wd] = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+	holidays := make(map[string]bool, len(c.Holidays))
+	for _, h := range c.Holidays {
+		if _, err := time.Parse(dateLayout, h); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid holiday '%s', expected YYYY-MM-DD", h)
+		}
+		holidays[h] = true
+	}
+	return loc, rules, holidays, nil
+}
+
+// on returns the occurrence of the rule starting on the given local day, if
+// the rule applies to it.
+func (r shiftRule) on(day time.Time) (Shift, bool) {
+	if r.days != nil && !r.days[day.Weekday()] {
+		return Shift{}, false
+	}
+	y, m, d := day.Date()
+	loc := day.Location()
+	start := time.Date(y, m, d, int(r.start/time.Hour), int(r.start%time.Hour/time.Minute), 0, 0, loc)
+	endDay := d
+	if r.end <= r.start {
+		endDay++
+	}
+	end := time.Date(y, m, endDay, int(r.end/time.Hour), int(r.end%time.Hour/time.Minute), 0, 0, loc)
+	return Shift{Name: r.name, Date: day.Format(dateLayout), Start: start, End: end}, true
+}
+
+// Between returns the shifts overlapping [from, to), ordered by start.
+func (c *ShiftCalendarYAML) Between(from, to time.Time) ([]Shift, error) {
+	loc, rules, holidays, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+	var shifts []Shift
+	first := from.In(loc)
+	y, m, d := first.Date()
+	for day := time.Date(y, m, d-1, 0, 0, 0, 0, loc); day.Before(to); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+		if holidays[day.Format(dateLayout)] {
+			continue
+		}
+		for _, r := range rules {
+			if s, ok := r.on(day); ok && s.End.After(from) && s.Start.Before(to) {
+				shifts = append(shifts, s)
+			}
+		}
+	}
+	sort.SliceStable(shifts, func(i, j int) bool { return shifts[i].Start.Before(shifts[j].Start) })
+	return shifts, nil
+}
+
+// Current returns the shift running at now.
+func (c *ShiftCalendarYAML) Current(now time.Time) (Shift, error) {
+	shifts, err := c.Between(now, now.Add(time.Nanosecond))
+	if err != nil {
+		return Shift{}, err
+	}
+	if len(shifts) == 0 {
+		return Shift{}, fmt.Errorf("no shift is running at %s", now.Format(time.RFC3339))
+	}
+	return shifts[len(shifts)-1], nil
+}
+
+// Previous returns the latest shift to have ended by now.
+func (c *ShiftCalendarYAML) Previous(now time.Time) (Shift, error) {
+	shifts, err := c.Between(now.AddDate(0, 0, -shiftSearchDays), now)
+	if err != nil {
+		return Shift{}, err
+	}
+	for i := len(shifts) - 1; i >= 0; i-- {
+		if !shifts[i].End.After(now) {
+			return shifts[i], nil
+		}
+	}
+	return Shift{}, fmt.Errorf("no shift ended in the last %d days", shiftSearchDays)
+}
+
+// Find returns the shift called name that starts on date, given as
+// YYYY-MM-DD in the calendar time zone.
+func (c *ShiftCalendarYAML) Find(name, date string) (Shift, error) {
+	loc, rules, holidays, err := c.parse()
+	if err != nil {
+		return Shift{}, err
+	}
+	day, err := time.ParseInLocation(dateLayout, date, loc)
+	if err != nil {
+		return Shift{}, fmt.Errorf("invalid shift date '%s', expected YYYY-MM-DD", date)
+	}
+	for _, r := range rules {
+		if r.name != name {
+			continue
+		}
+		if holidays[date] {
+			return Shift{}, fmt.Errorf("%s is a holiday", date)
+		}
+		if s, ok := r.on(day); ok {
+			return s, nil
+		}
+		return Shift{}, fmt.Errorf("shift '%s' is not worked on %s", name, day.Weekday())
+	}
+	return Shift{}, fmt.Errorf("unknown shift '%s'", name)
+}
@@ -0,0 +1,239 @@
+// file: service/data/shifts_test.go
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// testCalendar works a day and a night shift on weekdays, the night running
+// past midnight, with Christmas Day off.
+func testCalendar(zone string) *ShiftCalendarYAML {
+	return &ShiftCalendarYAML{
+		TimeZone: zone,
+		Shifts: []ShiftYAML{
+			{Name: "Day", Start: "06:00", End: "14:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}},
+			{Name: "Night", Start: "22:00", End: "06:00", Days: []string{"Monday", "TUE", "wed", "thu", "fri"}},
+		},
+		Holidays: []string{"2026-12-25"},
+	}
+}
+
+func mustLoad(t *testing.T, zone string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestShiftCalendarBetween(t *testing.T) {
+	cal := testCalendar("UTC")
+	at := func(day, hour int) time.Time { return time.Date(2026, 10, day, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"one shift", at(14, 8), at(14, 9), []string{"Day@2026-10-14"}},
+		{"night from the day before", at(15, 2), at(15, 3), []string{"Night@2026-10-14"}},
+		{"across midnight", at(14, 12), at(15, 7), []string{"Day@2026-10-14", "Night@2026-10-14", "Day@2026-10-15"}},
+		{"gap between shifts", at(14, 15), at(14, 21), nil},
+		{"range end is exclusive", at(14, 5), at(14, 6), []string{"Night@2026-10-13"}},
+		{"weekend", at(17, 0), at(19, 0), []string{"Night@2026-10-16"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shifts, err := cal.Between(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range shifts {
+				got = append(got, s.Name+"@"+s.Date)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestShiftCalendarHolidays(t *testing.T) {
+	cal := testCalendar("UTC")
+	shifts, err := cal.Between(time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 26, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range shifts {
+		if s.Date == "2026-12-25" {
+			t.Errorf("shift %s starts on a holiday", s.Name)
+		}
+	}
+	// The night starting on Christmas Eve still runs into Christmas Day.
+	if last := shifts[len(shifts)-1]; last.Name != "Night" || last.Date != "2026-12-24" {
+		t.Errorf("last shift %s@%s, want Night@2026-12-24", last.Name, last.Date)
+	}
+	if _, err := cal.Find("Day", "2026-12-25"); err == nil {
+		t.Error("Find returned a shift on a holiday")
+	}
+	if _, err := cal.Current(time.Date(2026, 12, 25, 8, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("Current returned a shift on a holiday")
+	}
+	prev, err := cal.Previous(time.Date(2026, 12, 28, 7, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.Name != "Night" || prev.Date != "2026-12-24" {
+		t.Errorf("Previous after the holiday weekend is %s@%s, want Night@2026-12-24", prev.Name, prev.Date)
+	}
+}
+
+func TestShiftCalendarCurrentAndPrevious(t *testing.T) {
+	cal := testCalendar("UTC")
+	tests := []struct {
+		name          string
+		now           time.Time
+		current, prev string
+	}{
+		{"during the day", time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC), "Day@2026-10-14", "Night@2026-10-13"},
+		{"after midnight", time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC), "Night@2026-10-14", "Day@2026-10-14"},
+		{"at a shift start", time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC), "Night@2026-10-14", "Day@2026-10-14"},
+		{"between shifts", time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC), "", "Day@2026-10-14"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur, err := cal.Current(tt.now)
+			switch {
+			case tt.current == "" && err == nil:
+				t.Errorf("Current is %s@%s, want none", cur.Name, cur.Date)
+			case tt.current != "" && err != nil:
+				t.Errorf("Current: %v", err)
+			case tt.current != "" && cur.Name+"@"+cur.Date != tt.current:
+				t.Errorf("Current is %s@%s, want %s", cur.Name, cur.Date, tt.current)
+			}
+			prev, err := cal.Previous(tt.now)
+			if err != nil {
+				t.Fatalf("Previous: %v", err)
+			}
+			if got := prev.Name + "@" + prev.Date; got != tt.prev {
+				t.Errorf("Previous is %s, want %s", got, tt.prev)
+			}
+		})
+	}
+}
+
+func TestShiftCalendarFind(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	cal := testCalendar("Europe/Berlin")
+	tests := []struct {
+		name, shift, date string
+		start, end        time.Time
+		wantErr           bool
+	}{
+		{name: "day", shift: "Day", date: "2026-10-14",
+			start: time.Date(2026, 10, 14, 6, 0, 0, 0, berlin), end: time.Date(2026, 10, 14, 14, 0, 0, 0, berlin)},
+		{name: "night ends the next day", shift: "Night", date: "2026-10-14",
+			start: time.Date(2026, 10, 14, 22, 0, 0, 0, berlin), end: time.Date(2026, 10, 15, 6, 0, 0, 0, berlin)},
+		{name: "night ends next month", shift: "Night", date: "2026-09-30",
+			start: time.Date(2026, 9, 30, 22, 0, 0, 0, berlin), end: time.Date(2026, 10, 1, 6, 0, 0, 0, berlin)},
+		{name: "not worked that day", shift: "Day", date: "2026-10-17", wantErr: true},
+		{name: "unknown shift", shift: "Swing", date: "2026-10-14", wantErr: true},
+		{name: "bad date", shift: "Day", date: "14/10/2026", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := cal.Find(tt.shift, tt.date)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.Start.Equal(tt.start) || !s.End.Equal(tt.end) {
+				t.Errorf("got %v to %v, want %v to %v", s.Start, s.End, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+// TestShiftCalendarDST checks that shifts keep their wall clock times across
+// daylight saving changes, so a night shift loses or gains an hour.
+func TestShiftCalendarDST(t *testing.T) {
+	cal := &ShiftCalendarYAML{
+		TimeZone: "Europe/Berlin",
+		Shifts:   []ShiftYAML{{Name: "Night", Start: "22:00", End: "06:00"}},
+	}
+	tests := []struct {
+		date string
+		want time.Duration
+	}{
+		{"2026-03-28", 7 * time.Hour},
+		{"2026-03-29", 8 * time.Hour},
+		{"2026-10-24", 9 * time.Hour},
+		{"2026-10-25", 8 * time.Hour},
+	}
+	for _, tt := range tests {
+		s, err := cal.Find("Night", tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.End.Sub(s.Start); got != tt.want {
+			t.Errorf("night of %s lasts %v, want %v", tt.date, got, tt.want)
+		}
+	}
+
+	// Consecutive nights across the change neither overlap nor leave a gap
+	// of other than the 16 hours off.
+	shifts, err := cal.Between(time.Date(2026, 10, 23, 12, 0, 0, 0, time.UTC), time.Date(2026, 10, 27, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shifts) != 4 {
+		t.Fatalf("got %d nights, want 4", len(shifts))
+	}
+	for i := 1; i < len(shifts); i++ {
+		if gap := shifts[i].Start.Sub(shifts[i-1].End); gap != 16*time.Hour {
+			t.Errorf("gap before night of %s is %v, want 16h", shifts[i].Date, gap)
+		}
+	}
+
+	// Shift times are wall clock times in the calendar zone.
+	cur, err := cal.Current(time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.Date != "2026-10-24" {
+		t.Errorf("current night is %s, want 2026-10-24", cur.Date)
+	}
+}
+
+func TestShiftCalendarInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cal  *ShiftCalendarYAML
+	}{
+		{"missing", nil},
+		{"no shifts", &ShiftCalendarYAML{}},
+		{"bad zone", &ShiftCalendarYAML{TimeZone: "Mars/Olympus", Shifts: []ShiftYAML{{Name: "A", Start: "06:00", End: "14:00"}}}},
+		{"bad time", &ShiftCalendarYAML{Shifts: []ShiftYAML{{Name: "A", Start: "6am", End: "14:00"}}}},
+		{"bad day", &ShiftCalendarYAML{Shifts: []ShiftYAML{{Name: "A", Start: "06:00", End: "14:00", Days: []string{"funday"}}}}},
+		{"name with @", &ShiftCalendarYAML{Shifts: []ShiftYAML{{Name: "A@B", Start: "06:00", End: "14:00"}}}},
+		{"bad holiday", &ShiftCalendarYAML{Shifts: []ShiftYAML{{Name: "A", Start: "06:00", End: "14:00"}}, Holidays: []string{"25.12.2026"}}},
+	}
+	now := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		if _, err := tt.cal.Between(now, now.Add(time.Hour)); err == nil {
+			t.Errorf("%s: Between accepted the calendar", tt.name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// file: service/data/spc.go
+// Specification limits and control chart settings for float fields
+package data
+
+import "fmt"
+
+// MaxSubgroupSize is the largest subgroup an X-bar/R chart may use.
+const MaxSubgroupSize = 10
+
+// SPCYAML configures statistical process control of float fields.
+type SPCYAML struct {
+	// EventMeasurement receives out-of-control points written back as
+	// events. Defaults to the event measurement.
+	EventMeasurement string         `yaml:"event_measurement,omitempty"`
+	Fields           []SPCFieldYAML `yaml:"fields"`
+}
+
+// SPCFieldYAML holds the specification limits of one namespaced float field
+// and the subgroup size its chart uses: 1 for an individuals and moving
+// range (I-MR) chart, 2 to MaxSubgroupSize for X-bar/R. Either limit may be
+// left out.
+type SPCFieldYAML struct {
+	Field        string   `yaml:"field"`
+	LSL          *float64 `yaml:"lsl,omitempty"`
+	USL          *float64 `yaml:"usl,omitempty"`
+	Target       *float64 `yaml:"target,omitempty"`
+	SubgroupSize int      `yaml:"subgroup_size,omitempty"`
+}
+
+// SPCField returns the SPC settings of field, or false if it has none.
+func (arch *ArchitectYAML) SPCField(field string) (SPCFieldYAML, bool) {
+	if arch.SPC == nil {
+		return SPCFieldYAML{}, false
+	}
+	for _, f := range arch.SPC.Fields {
+		if f.Field == field {
+			return f, true
+		}
+	}
+	return SPCFieldYAML{}, false
+}
+
+// Validate checks the subgroup size and that the limits are in order.
+func (f SPCFieldYAML) Validate() error {
+	if f.SubgroupSize < 0 || f.SubgroupSize > MaxSubgroupSize {
+		return fmt.Errorf("spc field '%s' needs a subgroup_size from 1 to %d", f.Field, MaxSubgroupSize)
+	}
+	if f.LSL != nil && f.USL != nil && *f.LSL >= *f.USL {
+		return fmt.Errorf("spc field '%s' needs lsl below usl", f.Field)
+	}
+	if f.Target != nil && (f.LSL != nil && *f.Target < *f.LSL || f.USL != nil && *f.Target > *f.USL) {
+		return fmt.Errorf("spc field '%s' has a target outside its limits", f.Field)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// file: service/data/tags.go
+// Static and PLC-sourced tags attached to every point written to InfluxDB
+package data
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"vtarchitect/config"
+)
+
+// TagFieldYAML declares a tag whose value is read from the PLC on every scan,
+// such as the active recipe ID or the logged-in operator's badge number. The
+// value is the unsigned integer held in Words consecutive registers starting
+// at Address (high word first), optionally translated through Values.
+type TagFieldYAML struct {
+	Name    string         `yaml:"name"`
+	Address int            `yaml:"address"`
+	Words   int            `yaml:"words,omitempty"`
+	Values  map[int]string `yaml:"values,omitempty"`
+}
+
+// value decodes the tag from a register block. ok is false if the registers
+// are out of range.
+func (f *TagFieldYAML) value(registers []uint16) (string, bool) {
+	words := f.Words
+	if words <= 0 {
+		words = 1
+	}
+	if words > 2 || f.Address < 0 || f.Address+words > len(registers) {
+		return "", false
+	}
+	var raw uint32
+	for i := 0; i < words; i++ {
+		raw = raw<<16 | uint32(registers[f.Address+i])
+	}
+	if name, ok := f.Values[int(raw)]; ok {
+		return name, true
+	}
+	return strconv.FormatUint(uint64(raw), 10), true
+}
+
+// parseStaticTags parses INFLUXDB_TAGS, a comma-separated list of key=value
+// pairs such as "site=plant1,line=2". Malformed entries are logged and skipped.
+func parseStaticTags(cfg *config.Config) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(cfg.Values["INFLUXDB_TAGS"], ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			log.Printf("DATA: Ignoring malformed INFLUXDB_TAGS entry '%s'", pair)
+			continue
+		}
+		tags[key] = value
+	}
+	return tags
+}
+
+// parseMetaTags parses INFLUXDB_META_TAGS, a comma-separated list of the
+// project_meta keys attached as tags. Other project_meta entries, often free
+// text, are not attached.
+func parseMetaTags(cfg *config.Config) []string {
+	var keys []string
+	for _, key := range strings.Split(cfg.Values["INFLUXDB_META_TAGS"], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// pointTags returns the tags for points written from a register block: the
+// project_meta entries named in metaKeys, overridden by the static
+// INFLUXDB_TAGS, overridden in turn by the tag_fields read from the registers.
+func pointTags(static map[string]string, metaKeys []string, registers []uint16) map[string]string {
+	tags := make(map[string]string)
+	arch, err := GetArchitectYAML()
+	if err != nil {
+		return static
+	}
+	for _, k := range metaKeys {
+		if v := arch.ProjectMeta[k]; v != "" {
+			tags[k] = v
+		}
+	}
+	for k, v := range static {
+		tags[k] = v
+	}
+	for _, f := range arch.TagFields {
+		if v, ok := f.value(registers); ok {
+			tags[f.Name] = v
+		}
+	}
+	return tags
+}
@@ -0,0 +1,47 @@
+// file: service/data/tags_test.go
+package data
+
+import (
+	"maps"
+	"testing"
+
+	"vtarchitect/config"
+)
+
+func TestPointTags(t *testing.T) {
+	saved := CachedArchitectYAML
+	t.Cleanup(func() { CachedArchitectYAML = saved })
+	CachedArchitectYAML = &ArchitectYAML{
+		ProjectMeta: map[string]string{
+			"machine_name": "Filler 2",
+			"line":         "3",
+			"notes":        "Rebuilt gearbox, see work order 1142",
+			"empty":        "",
+		},
+		TagFields: []TagFieldYAML{{Name: "recipe", Address: 0, Values: map[int]string{1: "Standard"}}},
+	}
+
+	tests := []struct {
+		name      string
+		metaTags  string
+		static    map[string]string
+		registers []uint16
+		want      map[string]string
+	}{
+		{name: "no meta tags", want: map[string]string{}},
+		{name: "allowed keys only", metaTags: "machine_name, line,empty,missing",
+			want: map[string]string{"machine_name": "Filler 2", "line": "3"}},
+		{name: "static overrides meta", metaTags: "line", static: map[string]string{"line": "4", "site": "plant1"},
+			want: map[string]string{"line": "4", "site": "plant1"}},
+		{name: "tag field overrides static", static: map[string]string{"recipe": "none"}, registers: []uint16{1},
+			want: map[string]string{"recipe": "Standard"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Values: map[string]string{"INFLUXDB_META_TAGS": tt.metaTags}}
+			if got := pointTags(tt.static, parseMetaTags(cfg), tt.registers); !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// file: service/data/timesync.go
+// Snapshot timestamps from the host or the PLC, and PLC clock drift detection
+package data
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+// Timestamp sources selectable with PLC_TIMESTAMP_SOURCE.
+const (
+	TimestampSourceHost  = "host"  // time the snapshot read started
+	TimestampSourceField = "field" // timestamp_field registers in architect.yaml
+	TimestampSourceCIP   = "cip"   // controller wall clock over EtherNet/IP
+)
+
+// TimestampFieldYAML locates a PLC-supplied Unix timestamp in the register
+// block: the seconds split across a high and low register, plus an optional
+// register holding milliseconds.
+type TimestampFieldYAML struct {
+	High   int  `yaml:"high"`
+	Low    int  `yaml:"low"`
+	Millis *int `yaml:"millis,omitempty"`
+}
+
+// Validate checks that the registers are not negative.
+func (f *TimestampFieldYAML) Validate() error {
+	if f.High < 0 || f.Low < 0 || f.Millis != nil && *f.Millis < 0 {
+		return fmt.Errorf("timestamp_field registers must not be negative")
+	}
+	return nil
+}
+
+// parse decodes the timestamp from a register block. ok is false if the
+// registers are out of range or hold no timestamp.
+func (f *TimestampFieldYAML) parse(registers []uint16) (t time.Time, ok bool) {
+	if f.High >= len(registers) || f.Low >= len(registers) {
+		return time.Time{}, false
+	}
+	secs := int64(uint32(registers[f.High])<<16 | uint32(registers[f.Low]))
+	if secs == 0 {
+		return time.Time{}, false
+	}
+	var ms int64
+	if f.Millis != nil && *f.Millis < len(registers) {
+		ms = int64(registers[*f.Millis] % 1000)
+	}
+	return time.Unix(secs, ms*int64(time.Millisecond)), true
+}
+
+// ClockSyncStatus describes the most recent comparison between the PLC clock
+// and the host clock.
+type ClockSyncStatus struct {
+	Source      string    `json:"source"`
+	Measured    bool      `json:"measured"`
+	PLCTime     time.Time `json:"plc_time"`
+	HostTime    time.Time `json:"host_time"`
+	DriftMs     float64   `json:"drift_ms"`
+	ThresholdMs float64   `json:"threshold_ms"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
+var (
+	clockSyncMu     sync.RWMutex
+	clockSyncStatus ClockSyncStatus
+)
+
+// GetClockSyncStatus returns the latest PLC/host clock comparison.
+func GetClockSyncStatus() ClockSyncStatus {
+	clockSyncMu.RLock()
+	defer clockSyncMu.RUnlock()
+	return clockSyncStatus
+}
+
+// timestamper chooses the timestamp of each snapshot and tracks clock drift
+// whenever a PLC time is available.
+type timestamper struct {
+	source     string
+	threshold  time.Duration
+	checkEvery time.Duration
+	lastCheck  time.Time
+	plc        *PLC // nil in Modbus mode
+	exceeded   bool
+}
+
+// newTimestamper reads PLC_TIMESTAMP_SOURCE, PLC_CLOCK_DRIFT_WARN_MS and
+// PLC_CLOCK_CHECK_SECONDS. plc is nil when the source is Modbus.
+func newTimestamper(cfg *config.Config, plc *PLC) *timestamper {
+	ts := &timestamper{
+		source:     cfg.Values["PLC_TIMESTAMP_SOURCE"],
+		threshold:  2 * time.Second,
+		checkEvery: time.Minute,
+		plc:        plc,
+	}
+	switch ts.source {
+	case "":
+		ts.source = TimestampSourceHost
+	case TimestampSourceHost, TimestampSourceField, TimestampSourceCIP:
+	default:
+		log.Printf("DATA: Unknown PLC_TIMESTAMP_SOURCE '%s', using '%s'", ts.source, TimestampSourceHost)
+		ts.source = TimestampSourceHost
+	}
+	if ts.source == TimestampSourceCIP && plc == nil {
+		log.Println("DATA: PLC_TIMESTAMP_SOURCE=cip requires EtherNet/IP, using host timestamps")
+		ts.source = TimestampSourceHost
+	}
+	if ms, err := strconv.Atoi(cfg.Values["PLC_CLOCK_DRIFT_WARN_MS"]); err == nil && ms > 0 {
+		ts.threshold = time.Duration(ms) * time.Millisecond
+	}
+	if secs, err := strconv.Atoi(cfg.Values["PLC_CLOCK_CHECK_SECONDS"]); err == nil && secs > 0 {
+		ts.checkEvery = time.Duration(secs) * time.Second
+	}
+
+	clockSyncMu.Lock()
+	clockSyncStatus = ClockSyncStatus{Source: ts.source, ThresholdMs: float64(ts.threshold) / float64(time.Millisecond)}
+	clockSyncMu.Unlock()
+	log.Printf("DATA: Using %s timestamps, clock drift warning at %s", ts.source, ts.threshold)
+	return ts
+}
+
+// Stamp returns the timestamp for a snapshot whose read started at readStart.
+// PLC-sourced timestamps fall back to readStart when unavailable. tags are
+// attached to any clock_drift event written.
+func (ts *timestamper) Stamp(cfg *config.Config, registers []uint16, tags map[string]string, readStart time.Time, batchWriter *store.ChannelBatchWriter) time.Time {
+	switch ts.source {
+	case TimestampSourceField:
+		if arch, err := GetArchitectYAML(); err == nil && arch.TimestampField != nil {
+			if t, ok := arch.TimestampField.parse(registers); ok {
+				ts.observe(cfg, t, readStart, tags, batchWriter)
+				return t
+			}
+		}
+	case TimestampSourceCIP:
+		t, err := ts.plc.ReadClock()
+		if err == nil {
+			ts.observe(cfg, t, readStart, tags, batchWriter)
+			return t
+		}
+		log.Printf("DATA: Error reading PLC clock, using host timestamp: %v", err)
+	}
+
+	// Host timestamps: still check the PLC clock periodically when we can.
+	if ts.plc != nil && time.Since(ts.lastCheck) >= ts.checkEvery {
+		ts.lastCheck = time.Now()
+		hostTime := time.Now()
+		if t, err := ts.plc.ReadClock(); err == nil {
+			ts.observe(cfg, t, hostTime, tags, batchWriter)
+		} else {
+			log.Printf("DATA: Error reading PLC clock for drift check: %v", err)
+		}
+	}
+	return readStart
+}
+
+// observe records the drift between a PLC time and the host time it was read
+// at, and writes a clock_drift event when the drift first exceeds the threshold.
+func (ts *timestamper) observe(cfg *config.Config, plcTime, hostTime time.Time, tags map[string]string, batchWriter *store.ChannelBatchWriter) {
+	drift := plcTime.Sub(hostTime)
+	driftMs := float64(drift) / float64(time.Millisecond)
+	exceeded := math.Abs(float64(drift)) > float64(ts.threshold)
+
+	clockSyncMu.Lock()
+	clockSyncStatus.Measured = true
+	clockSyncStatus.PLCTime = plcTime
+	clockSyncStatus.HostTime = hostTime
+	clockSyncStatus.DriftMs = driftMs
+	clockSyncStatus.Exceeded = exceeded
+	clockSyncMu.Unlock()
+
+	if exceeded && !ts.exceeded {
+		log.Printf("DATA: WARNING: PLC clock drift of %s exceeds %s", drift, ts.threshold)
+		store.ProcessAndLogEvent(cfg, "", "clock_drift", tags, map[string]interface{}{
+			"drift_ms":     driftMs,
+			"threshold_ms": float64(ts.threshold) / float64(time.Millisecond),
+			"plc_time":     plcTime.UTC().Format(time.RFC3339Nano),
+		}, hostTime, batchWriter)
+	} else if !exceeded && ts.exceeded {
+		log.Printf("DATA: PLC clock drift back within %s (%s)", ts.threshold, drift)
+	}
+	ts.exceeded = exceeded
+}
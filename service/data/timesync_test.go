@@ -0,0 +1,74 @@
+// file: service/data/timesync_test.go
+package data
+
+import (
+	"testing"
+	"time"
+
+	"vtarchitect/config"
+)
+
+func TestTimestampFieldValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   TimestampFieldYAML
+		wantErr bool
+	}{
+		{name: "high and low", field: TimestampFieldYAML{High: 40, Low: 41}},
+		{name: "with millis", field: TimestampFieldYAML{High: 0, Low: 1, Millis: intp(2)}},
+		{name: "negative high", field: TimestampFieldYAML{High: -1, Low: 41}, wantErr: true},
+		{name: "negative low", field: TimestampFieldYAML{High: 40, Low: -1}, wantErr: true},
+		{name: "negative millis", field: TimestampFieldYAML{High: 40, Low: 41, Millis: intp(-1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.field.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("error %v", err)
+			}
+		})
+	}
+}
+
+func TestTimestampFieldParse(t *testing.T) {
+	secs := uint32(1700000000)
+	registers := []uint16{uint16(secs >> 16), uint16(secs), 1250, 0}
+	tests := []struct {
+		name   string
+		field  TimestampFieldYAML
+		want   time.Time
+		wantOK bool
+	}{
+		{name: "seconds", field: TimestampFieldYAML{High: 0, Low: 1}, want: time.Unix(1700000000, 0), wantOK: true},
+		{name: "millis wrap", field: TimestampFieldYAML{High: 0, Low: 1, Millis: intp(2)}, want: time.Unix(1700000000, 250*int64(time.Millisecond)), wantOK: true},
+		{name: "millis outside block", field: TimestampFieldYAML{High: 0, Low: 1, Millis: intp(4)}, want: time.Unix(1700000000, 0), wantOK: true},
+		{name: "outside block", field: TimestampFieldYAML{High: 0, Low: 4}},
+		{name: "no timestamp", field: TimestampFieldYAML{High: 3, Low: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.field.parse(registers)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("got %v, %v", got, ok)
+			}
+		})
+	}
+}
+
+func TestTimestampSource(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", TimestampSourceHost},
+		{"host", TimestampSourceHost},
+		{"field", TimestampSourceField},
+		{"cip", TimestampSourceHost}, // needs EtherNet/IP
+		{"plc", TimestampSourceHost},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{Values: map[string]string{"PLC_TIMESTAMP_SOURCE": tt.source}}
+		if got := newTimestamper(cfg, nil).source; got != tt.want {
+			t.Errorf("PLC_TIMESTAMP_SOURCE '%s' gave %s, want %s", tt.source, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// file: service/influx/flux/builder.go
+// Builder assembles Flux scripts from pipelines of Exprs
+package flux
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Builder assembles one Flux script. With params enabled, strings and times
+// are sent to InfluxDB as query params instead of literals; InfluxDB Cloud
+// supports params, InfluxDB OSS does not. Invalid durations or identifiers
+// are recorded and returned by Build.
+type Builder struct {
+	useParams bool
+	params    map[string]interface{}
+	stmts     []string
+	err       error
+}
+
+// NewBuilder creates a builder, passing values as query params if useParams
+// is set.
+func NewBuilder(useParams bool) *Builder {
+	return &Builder{useParams: useParams}
+}
+
+// param records v as a new query param and returns a reference to it.
+func (b *Builder) param(v interface{}) Expr {
+	if b.params == nil {
+		b.params = make(map[string]interface{})
+	}
+	name := "p" + strconv.Itoa(len(b.params))
+	b.params[name] = v
+	return Expr{"params." + name}
+}
+
+// String returns s as a param reference or a quoted literal.
+func (b *Builder) String(s string) Expr {
+	if b.useParams {
+		return b.param(s)
+	}
+	return String(s)
+}
+
+// Time returns t as a param reference or a time literal.
+func (b *Builder) Time(t time.Time) Expr {
+	if b.useParams {
+		return Expr{"time(v: " + b.param(t.UTC()).s + ")"}
+	}
+	return Time(t)
+}
+
+// Duration returns d as a duration literal, recording an error if d is not
+// positive.
+func (b *Builder) Duration(d time.Duration) Expr {
+	e, err := Duration(d)
+	b.fail(err)
+	return e
+}
+
+// Ident returns name as an identifier, recording an error if it is not one.
+func (b *Builder) Ident(name string) Expr {
+	e, err := Ident(name)
+	b.fail(err)
+	return e
+}
+
+func (b *Builder) fail(err error) {
+	if b.err == nil && err != nil {
+		b.err = err
+	}
+}
+
+// FieldIn returns a predicate matching rows whose _field is one of fields,
+// or the zero Expr if fields is empty.
+func (b *Builder) FieldIn(fields []string) Expr {
+	return b.OneOf("_field", fields)
+}
+
+// OneOf returns a predicate matching rows whose column holds one of values,
+// or the zero Expr if values is empty.
+func (b *Builder) OneOf(column string, values []string) Expr {
+	preds := make([]Expr, 0, len(values))
+	for _, v := range values {
+		preds = append(preds, Eq(Column(column), b.String(v)))
+	}
+	return Or(preds...)
+}
+
+// TagsMatch returns a predicate matching rows carrying every tag in tags, or
+// the zero Expr if tags is empty.
+func (b *Builder) TagsMatch(tags map[string]string) Expr {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	preds := make([]Expr, 0, len(keys))
+	for _, k := range keys {
+		preds = append(preds, Eq(Column(k), b.String(tags[k])))
+	}
+	return And(preds...)
+}
+
+// Pipe starts a pipeline from a source expression. format is constant Flux
+// text; every value in it must be supplied as an Expr argument.
+func (b *Builder) Pipe(format string, args ...Expr) *Pipe {
+	p := &Pipe{}
+	p.src.WriteString(sprintf(format, args))
+	return p
+}
+
+// From starts a pipeline reading bucket.
+func (b *Builder) From(bucket string) *Pipe {
+	return b.Pipe("from(bucket: %s)", b.String(bucket))
+}
+
+// Let adds the statement "name = p" and returns name for use in later
+// pipelines.
+func (b *Builder) Let(name string, p *Pipe) Expr {
+	id := b.Ident(name)
+	b.stmts = append(b.stmts, id.s+" = "+p.src.String())
+	return id
+}
+
+// Yield adds p as a statement whose tables are returned by the query.
+func (b *Builder) Yield(p *Pipe) {
+	b.stmts = append(b.stmts, p.src.String())
+}
+
+// Build returns the script and its params, or the first error recorded while
+// building it. params is nil when the script has none.
+func (b *Builder) Build() (string, map[string]interface{}, error) {
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return strings.Join(b.stmts, "\n\n") + "\n", b.params, nil
+}
+
+// Pipe is a pipeline of Flux transformations.
+type Pipe struct {
+	src strings.Builder
+}
+
+// Then appends the transformation "|> format". format is constant Flux text;
+// every value in it must be supplied as an Expr argument.
+func (p *Pipe) Then(format string, args ...Expr) *Pipe {
+	p.src.WriteString("\n  |> ")
+	p.src.WriteString(sprintf(format, args))
+	return p
+}
+
+// Range limits the pipeline to [start, stop).
+func (p *Pipe) Range(start, stop Expr) *Pipe {
+	return p.Then("range(start: %s, stop: %s)", start, stop)
+}
+
+// Filter keeps rows matching pred. A zero pred keeps every row and adds no
+// step.
+func (p *Pipe) Filter(pred Expr) *Pipe {
+	if pred.IsZero() {
+		return p
+	}
+	return p.Then("filter(fn: (r) => %s)", pred)
+}
+
+// sprintf substitutes the source of args into format.
+func sprintf(format string, args []Expr) string {
+	vals := make([]interface{}, len(args))
+	for i, a := range args {
+		vals[i] = a.s
+	}
+	return fmt.Sprintf(format, vals...)
+}
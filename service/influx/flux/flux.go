@@ -0,0 +1,167 @@
+// file: service/influx/flux/flux.go
+// Package flux builds Flux queries without splicing untrusted text into them.
+// String values are quoted or passed as query params, times and durations are
+// formatted from typed Go values, and identifiers are validated, so a query
+// assembled from constant Flux text and Exprs cannot be altered by its inputs.
+package flux
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a Flux expression. It can only be created by this package, from
+// values it has quoted, formatted or validated.
+type Expr struct {
+	s string
+}
+
+// String returns the Flux source of e.
+func (e Expr) String() string {
+	return e.s
+}
+
+// IsZero reports whether e is the empty expression.
+func (e Expr) IsZero() bool {
+	return e.s == ""
+}
+
+var stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", `\${`)
+
+// String returns s as a Flux string literal.
+func String(s string) Expr {
+	return Expr{`"` + stringEscaper.Replace(s) + `"`}
+}
+
+// Time returns t as a Flux time literal.
+func Time(t time.Time) Expr {
+	return Expr{t.UTC().Format(time.RFC3339Nano)}
+}
+
+// Duration returns d as a Flux duration literal. d must be positive.
+func Duration(d time.Duration) (Expr, error) {
+	if d <= 0 {
+		return Expr{}, fmt.Errorf("flux duration must be positive, got %s", d)
+	}
+	var sb strings.Builder
+	for _, u := range durationUnits {
+		if n := d / u.size; n > 0 {
+			sb.WriteString(strconv.FormatInt(int64(n), 10))
+			sb.WriteString(u.name)
+			d -= n * u.size
+		}
+	}
+	return Expr{sb.String()}, nil
+}
+
+// durationUnits are the fixed-length Flux duration units, largest first.
+// Months and years vary in length and are not accepted.
+var durationUnits = []struct {
+	name string
+	size time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+	{"us", time.Microsecond},
+	{"ns", time.Nanosecond},
+}
+
+var durationPartRegex = regexp.MustCompile(`^(\d{1,9})(ns|us|µs|ms|s|m|h|d|w)`)
+
+// ParseDuration parses a Flux duration literal made of fixed-length units,
+// such as "90s" or "1h30m". Months, years, signs and anything else are
+// rejected.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var total time.Duration
+	for rest := s; rest != ""; {
+		m := durationPartRegex.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		unit := m[2]
+		if unit == "µs" {
+			unit = "us"
+		}
+		for _, u := range durationUnits {
+			if u.name != unit {
+				continue
+			}
+			if n > int64((1<<63-1-total)/u.size) {
+				return 0, fmt.Errorf("duration '%s' is too long", s)
+			}
+			total += time.Duration(n) * u.size
+		}
+		rest = rest[len(m[0]):]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("duration '%s' must be positive", s)
+	}
+	return total, nil
+}
+
+var identRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// keywords are the Flux keywords, which cannot be used as identifiers.
+var keywords = map[string]bool{
+	"and": true, "builtin": true, "else": true, "empty": true, "exists": true,
+	"if": true, "import": true, "in": true, "not": true, "option": true,
+	"or": true, "package": true, "return": true, "testing": true, "then": true,
+}
+
+// Ident returns name as a Flux identifier, such as a variable or function
+// name, after checking that it is one.
+func Ident(name string) (Expr, error) {
+	if !identRegex.MatchString(name) || keywords[name] {
+		return Expr{}, fmt.Errorf("invalid flux identifier '%s'", name)
+	}
+	return Expr{name}, nil
+}
+
+// Column returns an expression reading the named column of the row r.
+func Column(name string) Expr {
+	return Expr{"r[" + String(name).s + "]"}
+}
+
+// Eq returns the comparison a == b.
+func Eq(a, b Expr) Expr {
+	return Expr{a.s + " == " + b.s}
+}
+
+// And joins the non-zero predicates with "and". It returns the zero Expr if
+// there are none.
+func And(preds ...Expr) Expr {
+	return join(" and ", preds)
+}
+
+// Or joins the non-zero predicates with "or". It returns the zero Expr if
+// there are none.
+func Or(preds ...Expr) Expr {
+	return join(" or ", preds)
+}
+
+func join(op string, preds []Expr) Expr {
+	var parts []string
+	for _, p := range preds {
+		if !p.IsZero() {
+			parts = append(parts, p.s)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return Expr{}
+	case 1:
+		return Expr{parts[0]}
+	}
+	return Expr{"(" + strings.Join(parts, op) + ")"}
+}
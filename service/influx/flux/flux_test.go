@@ -0,0 +1,231 @@
+// file: service/influx/flux/flux_test.go
+package flux
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// maliciousStrings try to close a string literal, start an interpolation or
+// break the statement onto a new line.
+var maliciousStrings = []string{
+	`x") |> drop(columns: ["_value"]) //`,
+	`x" or true or r._field == "`,
+	`\" or true //`,
+	`\\" or true //`,
+	`${import "sql"}`,
+	"a\n|> yield(name: \"leak\")",
+	"a\r\nb\tc",
+	`"`,
+	`\`,
+	``,
+	"µs ünïcödé \u2028",
+}
+
+// unquote parses a Flux string literal, failing the test unless lit is
+// exactly one literal.
+func unquote(t *testing.T, lit string) string {
+	t.Helper()
+	if len(lit) < 2 || lit[0] != '"' {
+		t.Fatalf("%q is not a string literal", lit)
+	}
+	var sb strings.Builder
+	for i := 1; i < len(lit); i++ {
+		switch c := lit[i]; c {
+		case '"':
+			if i != len(lit)-1 {
+				t.Fatalf("%q closes its literal early, at byte %d", lit, i)
+			}
+			return sb.String()
+		case '\n', '\r':
+			t.Fatalf("%q contains a raw line break", lit)
+		case '$':
+			if i+1 < len(lit) && lit[i+1] == '{' {
+				t.Fatalf("%q contains an interpolation", lit)
+			}
+			sb.WriteByte(c)
+		case '\\':
+			i++
+			if i == len(lit) {
+				t.Fatalf("%q ends inside an escape", lit)
+			}
+			switch e := lit[i]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\', '"', '$':
+				sb.WriteByte(e)
+			default:
+				t.Fatalf("%q has unknown escape \\%c", lit, e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	t.Fatalf("%q is not terminated", lit)
+	return ""
+}
+
+func TestStringQuotesMaliciousInput(t *testing.T) {
+	for _, s := range maliciousStrings {
+		if got := unquote(t, String(s).String()); got != s {
+			t.Errorf("String(%q) round-trips to %q", s, got)
+		}
+	}
+}
+
+func TestColumnQuotesName(t *testing.T) {
+	for _, s := range maliciousStrings {
+		col := Column(s).String()
+		if !strings.HasPrefix(col, "r[") || !strings.HasSuffix(col, "]") {
+			t.Fatalf("Column(%q) = %q", s, col)
+		}
+		if got := unquote(t, col[2:len(col)-1]); got != s {
+			t.Errorf("Column(%q) reads column %q", s, got)
+		}
+	}
+}
+
+func TestBuilderInlinesQuotedValues(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	stop := start.Add(time.Hour)
+	b := NewBuilder(false)
+	b.Yield(b.From(`b") |> drop() //`).
+		Range(b.Time(start), b.Time(stop)).
+		Filter(b.FieldIn([]string{"ok", `x" or true or "`})).
+		Filter(b.TagsMatch(map[string]string{`k"]`: `v${x}`})).
+		Then(`last()`))
+	query, params, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != nil {
+		t.Errorf("params = %v, want none", params)
+	}
+	want := `from(bucket: "b\") |> drop() //")
+  |> range(start: 2025-01-02T03:04:05.000000006Z, stop: 2025-01-02T04:04:05.000000006Z)
+  |> filter(fn: (r) => (r["_field"] == "ok" or r["_field"] == "x\" or true or \""))
+  |> filter(fn: (r) => r["k\"]"] == "v\${x}")
+  |> last()
+`
+	if query != want {
+		t.Errorf("query =\n%s\nwant\n%s", query, want)
+	}
+}
+
+func TestBuilderPassesParams(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	b := NewBuilder(true)
+	b.Yield(b.From(maliciousStrings[0]).
+		Range(b.Time(start), b.Time(start.Add(time.Minute))).
+		Filter(b.FieldIn(maliciousStrings[1:3])))
+	query, params, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range maliciousStrings[:3] {
+		if strings.Contains(query, s) {
+			t.Errorf("query contains value %q:\n%s", s, query)
+		}
+	}
+	want := `from(bucket: params.p0)
+  |> range(start: time(v: params.p1), stop: time(v: params.p2))
+  |> filter(fn: (r) => (r["_field"] == params.p3 or r["_field"] == params.p4))
+`
+	if query != want {
+		t.Errorf("query =\n%s\nwant\n%s", query, want)
+	}
+	if params["p0"] != maliciousStrings[0] || params["p4"] != maliciousStrings[2] {
+		t.Errorf("params = %v", params)
+	}
+	if got, ok := params["p1"].(time.Time); !ok || !got.Equal(start) || got.Location() != time.UTC {
+		t.Errorf("start param = %v, want %v in UTC", params["p1"], start)
+	}
+}
+
+func TestEmptyPredicatesAddNoFilter(t *testing.T) {
+	b := NewBuilder(false)
+	b.Yield(b.From("b").Filter(b.FieldIn(nil)).Filter(b.TagsMatch(nil)).Filter(And(Expr{}, Or())))
+	query, _, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "from(bucket: \"b\")\n" {
+		t.Errorf("query = %q", query)
+	}
+}
+
+func TestIdent(t *testing.T) {
+	for _, name := range []string{"mean", "initial_trues", "_x", "A1"} {
+		if e, err := Ident(name); err != nil || e.String() != name {
+			t.Errorf("Ident(%q) = %q, %v", name, e, err)
+		}
+	}
+	for _, name := range []string{
+		"", "1x", "mean)", "mean, createEmpty: true", "mean |> drop()",
+		"x.y", "x-y", "x y", "mëan", "mean\n", "or", "import", "if",
+	} {
+		if _, err := Ident(name); err == nil {
+			t.Errorf("Ident(%q) accepted", name)
+		}
+	}
+}
+
+func TestBuilderReportsInvalidIdent(t *testing.T) {
+	b := NewBuilder(false)
+	b.Yield(b.From("b").Then("aggregateWindow(every: 1m, fn: %s)", b.Ident("mean) |> yield(")))
+	if query, _, err := b.Build(); err == nil {
+		t.Errorf("Build accepted an invalid identifier:\n%s", query)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		time.Second:                        "1s",
+		90 * time.Minute:                   "1h30m",
+		1500 * time.Millisecond:            "1s500ms",
+		8*24*time.Hour + time.Nanosecond:   "1w1d1ns",
+		time.Duration(1<<63 - 1):           "15250w1d23h47m16s854ms775us807ns",
+		2*time.Minute + 3*time.Microsecond: "2m3us",
+		24*time.Hour - time.Nanosecond:     "23h59m59s999ms999us999ns",
+	} {
+		e, err := Duration(d)
+		if err != nil || e.String() != want {
+			t.Errorf("Duration(%v) = %q, %v; want %q", d, e, err, want)
+		}
+		if back, err := ParseDuration(want); err != nil || back != d {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", want, back, err, d)
+		}
+	}
+	for _, d := range []time.Duration{0, -time.Second} {
+		if _, err := Duration(d); err == nil {
+			t.Errorf("Duration(%v) accepted", d)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"90s":   90 * time.Second,
+		"1h30m": 90 * time.Minute,
+		"5µs":   5 * time.Microsecond,
+		"1d1w":  8 * 24 * time.Hour,
+	} {
+		if got, err := ParseDuration(s); err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{
+		"", "0s", "-1h", "+1h", "1", "h", "1.5h", "1mo", "1y", "1H", " 1h", "1h ",
+		"1h, fn: last", "1m) |> drop(columns: [\"_value\"]", "1m\n|> yield()",
+		"1000000000s", "999999999w999999999w", "1h${x}",
+	} {
+		if d, err := ParseDuration(s); err == nil {
+			t.Errorf("ParseDuration(%q) = %v, want error", s, d)
+		}
+	}
+}
@@ -4,26 +4,39 @@ package influx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
 	"strings"
 	"time"
 	"vtarchitect/config"
+	"vtarchitect/influx/flux"
+	"vtarchitect/store"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/query"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
 
+// Client is the InfluxDB implementation of store.Store.
 type Client struct {
 	influxClient influxdb2.Client
 	writeAPI     api.WriteAPIBlocking
 	queryAPI     api.QueryAPI
 	org          string
 	bucket       string
+	useParams    bool
+	rollups      *rollups
 }
 
+var (
+	_ store.Store             = (*Client)(nil)
+	_ store.BooleanSummariser = (*Client)(nil)
+)
+
 func NewClient(cfg *config.Config) (*Client, error) {
 	url := cfg.Values["INFLUXDB_URL"]
 	token := cfg.Values["INFLUXDB_TOKEN"]
@@ -33,13 +46,26 @@ func NewClient(cfg *config.Config) (*Client, error) {
 		return nil, fmt.Errorf("missing required InfluxDB configuration values")
 	}
 	client := influxdb2.NewClient(url, token)
-	return &Client{
+	c := &Client{
 		influxClient: client,
 		writeAPI:     client.WriteAPIBlocking(org, bucket),
 		queryAPI:     client.QueryAPI(org),
 		org:          org,
 		bucket:       bucket,
-	}, nil
+		useParams:    cfg.Values["INFLUXDB_QUERY_PARAMS"] == "true",
+	}
+	if cfg.Values["INFLUXDB_ROLLUPS"] == "true" {
+		c.rollups = newRollups(c, cfg)
+	}
+	return c, nil
+}
+
+// StartRollups starts the background job filling the rollup tiers, if
+// INFLUXDB_ROLLUPS is enabled. It stops when ctx is cancelled.
+func (c *Client) StartRollups(ctx context.Context) {
+	if c.rollups != nil {
+		c.rollups.start(ctx)
+	}
 }
 
 func (c *Client) WritePoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
@@ -47,12 +73,57 @@ func (c *Client) WritePoint(measurement string, tags map[string]string, fields m
 	return c.writeAPI.WritePoint(context.Background(), p)
 }
 
+// WritePoints writes a batch of points. Requests InfluxDB rejects as
+// malformed are returned as store.Rejected errors. Points landing in windows
+// already rolled up have those windows rolled up again.
+func (c *Client) WritePoints(ctx context.Context, points []store.Point) error {
+	if err := writePoints(ctx, c.writeAPI, points); err != nil {
+		return err
+	}
+	if c.rollups != nil {
+		c.rollups.noteWritten(points)
+	}
+	return nil
+}
+
+// writePoints writes a batch of points through writeAPI, returning requests
+// InfluxDB rejects as malformed as store.Rejected errors.
+func writePoints(ctx context.Context, writeAPI api.WriteAPIBlocking, points []store.Point) error {
+	ps := make([]*write.Point, 0, len(points))
+	for _, p := range points {
+		ps = append(ps, influxdb2.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time))
+	}
+	err := writeAPI.WritePoint(ctx, ps...)
+	if err != nil && !isRetryable(err) {
+		return store.Rejected(err)
+	}
+	return err
+}
+
+// isRetryable reports whether a write error may succeed later. Requests that
+// InfluxDB rejected as malformed will fail the same way on every retry.
+func isRetryable(err error) bool {
+	var httpErr *ihttp.Error
+	if errors.As(err, &httpErr) && httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 {
+		switch httpErr.StatusCode {
+		case 401, 403, 404, 408, 429:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
 func (c *Client) Query(queryStr string) (*api.QueryTableResult, error) {
 	return c.queryAPI.Query(context.Background(), queryStr)
 }
 
-func (c *Client) Close() {
+// Close waits for the rollup job, which stops when its context is cancelled,
+// and closes the client.
+func (c *Client) Close() error {
+	c.rollups.wait()
 	c.influxClient.Close()
+	return nil
 }
 
 func StructToInfluxFields(input any, prefix string) map[string]interface{} {
@@ -103,25 +174,31 @@ func StructToInfluxFields(input any, prefix string) map[string]interface{} {
 }
 
 // AggregateBooleanPercentages calculates the percentage of true values for specified boolean fields
-// in a given time range from the specified InfluxDB bucket.
-func (c *Client) AggregateBooleanPercentages(measurement, bucket string, fields []string, start, stop string) (map[string]float64, error) {
-	var filters []string
-	for _, f := range fields {
-		filters = append(filters, fmt.Sprintf(`r["_field"] == "%s"`, f))
-	}
-
-	query := fmt.Sprintf(`
-from(bucket: "%s")
-  |> range(start: %s, stop: %s)
-  |> filter(fn: (r) => r["_measurement"] == "%s")
-  |> filter(fn: (r) => %s)
-  |> map(fn: (r) => ({ r with _value: if r._value then 1.0 else 0.0 }))
-  |> group(columns: ["_field"])
-  |> mean()
-  |> map(fn: (r) => ({ r with _value: r._value * 100.0 }))
-`, bucket, start, stop, measurement, strings.Join(filters, " or "))
-
-	res, err := c.queryAPI.Query(context.Background(), query)
+// in a given time range from the specified InfluxDB bucket, optionally limited to points carrying tags.
+func (c *Client) AggregateBooleanPercentages(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	if totals, ok, err := c.rollups.totals(ctx, q); ok {
+		if err != nil {
+			return nil, err
+		}
+		percentages := make(map[string]float64)
+		for field, t := range totals {
+			if t.isBool {
+				percentages[field] = float64(t.trues) / float64(t.count) * 100
+			}
+		}
+		return percentages, nil
+	}
+	b := flux.NewBuilder(c.useParams)
+	b.Yield(source(b, q).
+		Then(`map(fn: (r) => ({ r with _value: if r._value then 1.0 else 0.0 }))`).
+		Then(`group(columns: ["_field"])`).
+		Then(`mean()`).
+		Then(`map(fn: (r) => ({ r with _value: r._value * 100.0 }))`))
+
+	res, err := c.run(ctx, b)
 	if err != nil {
 		return nil, err
 	}
@@ -138,149 +215,13 @@ from(bucket: "%s")
 	return percentages, res.Err()
 }
 
-// AggregateBooleanStats calculates the percentage true and time-in-true (in seconds) for specified boolean fields.
-func (c *Client) AggregateBooleanStats(measurement, bucket string, fields []string, start, stop string) (map[string]struct {
-	Percentage float64
-	Seconds    float64
-}, error) {
-	var filters []string
-	for _, f := range fields {
-		filters = append(filters, fmt.Sprintf(`r["_field"] == "%s"`, f))
-	}
-
-	query := fmt.Sprintf(`
-from(bucket: "%s")
-  |> range(start: %s, stop: %s)
-  |> filter(fn: (r) => r["_measurement"] == "%s")
-  |> filter(fn: (r) => %s)
-  |> aggregateWindow(every: 1m, fn: last)
-  |> fill(usePrevious: true)
-  |> map(fn: (r) => ({
-      _field: r._field,
-      _value: if r._value == true then 60 else 0
-  }))
-  |> group(columns: ["_field"])
-  |> reduce(
-      identity: {field: "", totalSeconds: 0, count: 0},
-      fn: (r, accumulator) => ({
-          field: r._field,
-          totalSeconds: accumulator.totalSeconds + int(v: r._value),
-          count: accumulator.count + 60
-      })
-  )
-  |> map(fn: (r) => ({
-      _field: r.field,
-      percentageTrue: (float(v: r.totalSeconds) / float(v: r.count)) * 100.0,
-      timeInTrue: float(v: r.totalSeconds)
-  }))
-`, bucket, start, stop, measurement, strings.Join(filters, " or "))
-
-	res, err := c.queryAPI.Query(context.Background(), query)
-	if err != nil {
-		return nil, err
-	}
-
-	stats := make(map[string]struct {
-		Percentage float64
-		Seconds    float64
-	})
-
-	for res.Next() {
-		// fmt.Printf("DEBUG: Record: Field=%v, Value=%v, Values=%v\n", res.Record().Field(), res.Record().Value(), res.Record().Values())
-		field, ok := res.Record().ValueByKey("_field").(string)
-		if !ok {
-			// fmt.Printf("DEBUG: Skipping record with missing _field: %v\n", res.Record().Values())
-			continue
-		}
-		vals := res.Record().Values()
-		percentage, _ := vals["percentageTrue"].(float64)
-		timeInTrue, _ := vals["timeInTrue"].(float64)
-		stats[field] = struct {
-			Percentage float64
-			Seconds    float64
-		}{
-			Percentage: percentage,
-			Seconds:    timeInTrue,
-		}
-	}
-	return stats, res.Err()
-}
-
-type ChannelBatchWriter struct {
-	writeAPI api.WriteAPIBlocking
-	buffer   []*write.Point
-	maxSize  int
-	flushCh  chan struct{}
-	closeCh  chan struct{}
-}
-
-func NewChannelBatchWriter(writeAPI api.WriteAPIBlocking, maxSize int) *ChannelBatchWriter {
-	cbw := &ChannelBatchWriter{
-		writeAPI: writeAPI,
-		buffer:   make([]*write.Point, 0, maxSize),
-		maxSize:  maxSize,
-		flushCh:  make(chan struct{}, 1),
-		closeCh:  make(chan struct{}),
-	}
-	go cbw.run(5 * time.Second) // Default flush interval
-	return cbw
-}
-
-func (cbw *ChannelBatchWriter) AddPoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) {
-	p := influxdb2.NewPoint(measurement, tags, fields, t)
-	cbw.buffer = append(cbw.buffer, p)
-	log.Printf("INFLUX: Added point to buffer. Current buffer size: %d", len(cbw.buffer))
-	if len(cbw.buffer) >= cbw.maxSize {
-		log.Println("INFLUX: Buffer size reached max capacity. Triggering flush.")
-		select {
-		case cbw.flushCh <- struct{}{}:
-		default:
-		}
-	}
-}
-
-func (cbw *ChannelBatchWriter) Flush() {
-	if len(cbw.buffer) == 0 {
-		log.Println("INFLUX: Flush called but buffer is empty. No action taken.")
-		return
-	}
-	log.Printf("INFLUX: Flushing %d points from buffer.", len(cbw.buffer))
-	points := cbw.buffer
-	cbw.buffer = make([]*write.Point, 0, cbw.maxSize)
-	if err := cbw.writeAPI.WritePoint(context.Background(), points...); err != nil {
-		log.Printf("INFLUX: Error writing points in batch: %v", err)
-	}
-	log.Println("INFLUX: Flush completed.")
-}
-
-func (cbw *ChannelBatchWriter) Close() {
-	close(cbw.closeCh)
-}
-
-func (cbw *ChannelBatchWriter) run(flushInterval time.Duration) {
-	log.Println("INFLUX: ChannelBatchWriter run loop started.")
-	ticker := time.NewTicker(flushInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-cbw.flushCh:
-			log.Println("INFLUX: Received flush signal.")
-			cbw.Flush()
-		case <-ticker.C:
-			log.Println("INFLUX: Flush interval reached. Checking buffer.")
-			if len(cbw.buffer) > 0 {
-				log.Printf("INFLUX: Buffer has %d points. Triggering flush.", len(cbw.buffer))
-				cbw.Flush()
-			} else {
-				log.Println("INFLUX: Buffer is empty. No flush needed.")
-			}
-		case <-cbw.closeCh:
-			log.Println("INFLUX: Received close signal. Flushing remaining points and exiting.")
-			cbw.Flush()
-			return
-		}
+// SummariseBooleans summarises the boolean fields of q from the rollup tiers,
+// for ranges long enough to be routed to them.
+func (c *Client) SummariseBooleans(ctx context.Context, q store.Query) (map[string]store.BooleanSummary, bool, error) {
+	if len(q.Fields) == 0 {
+		return nil, false, nil
 	}
+	return c.rollups.booleanSummaries(ctx, q)
 }
 
 func (c *Client) GetWriteAPI() api.WriteAPIBlocking {
@@ -299,14 +240,26 @@ type StatsResult struct {
 
 // AggregateFaultCounts counts the number of false-to-true transitions for each fault field.
 // This accurately reflects the number of times a fault occurred, rather than how many
-// polling cycles it was active for.
-func (c *Client) AggregateFaultCounts(measurement, bucket string, fields []string, start, stop string) (map[string]float64, error) {
-	if len(fields) == 0 {
+// polling cycles it was active for. If tags is non-empty only points carrying those tags are counted.
+func (c *Client) AggregateFaultCounts(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
 		return map[string]float64{}, nil
 	}
-	var filters []string
-	for _, f := range fields {
-		filters = append(filters, fmt.Sprintf(`r["_field"] == "%s"`, f))
+	if totals, ok, err := c.rollups.totals(ctx, q); ok {
+		if err != nil {
+			return nil, err
+		}
+		counts := make(map[string]float64)
+		for field, t := range totals {
+			if !t.isBool {
+				continue
+			}
+			counts[field] = float64(t.rises)
+			if t.first {
+				counts[field]++
+			}
+		}
+		return counts, nil
 	}
 	// This query correctly counts fault occurrences, including faults that are
 	// persistently true throughout the time range. It works by combining two sets of data:
@@ -314,35 +267,32 @@ func (c *Client) AggregateFaultCounts(measurement, bucket string, fields []strin
 	// 2. `initial_trues`: Identifies faults that were already in a `true` state at the
 	//    very beginning of the time range.
 	// By summing these two counts, we get a total number of fault occurrences.
-	query := fmt.Sprintf(`
-transitions = from(bucket: "%[1]s")
-  |> range(start: %[2]s, stop: %[3]s)
-  |> filter(fn: (r) => r["_measurement"] == "%[4]s" and (%[5]s))
-  |> sort(columns: ["_time"])
-  |> map(fn: (r) => ({ r with _value: if r._value then 1 else 0 }))
-  |> difference(nonNegative: false, columns: ["_value"])
-  |> filter(fn: (r) => r._value == 1)
-  |> group(columns: ["_field"])
-  |> count()
-  |> rename(columns: {_value: "count"})
-
-initial_trues = from(bucket: "%[1]s")
-  |> range(start: %[2]s, stop: %[3]s)
-  |> filter(fn: (r) => r["_measurement"] == "%[4]s" and (%[5]s))
-  |> group(columns: ["_field"])
-  |> first()
-  |> filter(fn: (r) => r._value == true)
-  |> map(fn: (r) => ({_field: r._field, count: 1}))
-  |> keep(columns: ["_field", "count"])
-
-union(tables: [transitions, initial_trues])
-  |> group(columns: ["_field"])
-  |> sum(column: "count")
-  |> rename(columns: {count: "_value"})
-  |> group()
-`, bucket, start, stop, measurement, strings.Join(filters, " or "))
-
-	res, err := c.queryAPI.Query(context.Background(), query)
+	// Each field is merged into one time-ordered table first, so a change of
+	// tag values part way through the range does not split its transitions.
+	b := flux.NewBuilder(c.useParams)
+	transitions := b.Let("transitions", source(b, q).
+		Then(`group(columns: ["_field"])`).
+		Then(`sort(columns: ["_time"])`).
+		Then(`map(fn: (r) => ({ r with _value: if r._value then 1 else 0 }))`).
+		Then(`difference(nonNegative: false, columns: ["_value"])`).
+		Then(`filter(fn: (r) => r._value == 1)`).
+		Then(`group(columns: ["_field"])`).
+		Then(`count()`).
+		Then(`rename(columns: {_value: "count"})`))
+	initialTrues := b.Let("initial_trues", source(b, q).
+		Then(`group(columns: ["_field"])`).
+		Then(`sort(columns: ["_time"])`).
+		Then(`first()`).
+		Then(`filter(fn: (r) => r._value == true)`).
+		Then(`map(fn: (r) => ({_field: r._field, count: 1}))`).
+		Then(`keep(columns: ["_field", "count"])`))
+	b.Yield(b.Pipe(`union(tables: [%s, %s])`, transitions, initialTrues).
+		Then(`group(columns: ["_field"])`).
+		Then(`sum(column: "count")`).
+		Then(`rename(columns: {count: "_value"})`).
+		Then(`group()`))
+
+	res, err := c.run(ctx, b)
 	if err != nil {
 		return nil, err
 	}
@@ -363,25 +313,30 @@ union(tables: [transitions, initial_trues])
 	return counts, res.Err()
 }
 
-// AggregateFloatMeans computes the mean value for each float field in the given time range.
-func (c *Client) AggregateFloatMeans(measurement, bucket string, fields []string, start, stop string) (map[string]float64, error) {
-	if len(fields) == 0 {
+// AggregateFloatMeans computes the mean value for each float field in the given time range,
+// optionally limited to points carrying tags.
+func (c *Client) AggregateFloatMeans(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
 		return map[string]float64{}, nil
 	}
-	var filters []string
-	for _, f := range fields {
-		filters = append(filters, fmt.Sprintf(`r["_field"] == "%s"`, f))
+	if totals, ok, err := c.rollups.totals(ctx, q); ok {
+		if err != nil {
+			return nil, err
+		}
+		means := make(map[string]float64)
+		for field, t := range totals {
+			if !t.isBool {
+				means[field] = t.sum / float64(t.count)
+			}
+		}
+		return means, nil
 	}
-	query := fmt.Sprintf(`
-from(bucket: "%s")
-  |> range(start: %s, stop: %s)
-  |> filter(fn: (r) => r["_measurement"] == "%s")
-  |> filter(fn: (r) => %s)
-  |> group(columns: ["_field"])
-  |> mean()
-`, bucket, start, stop, measurement, strings.Join(filters, " or "))
+	b := flux.NewBuilder(c.useParams)
+	b.Yield(source(b, q).
+		Then(`group(columns: ["_field"])`).
+		Then(`mean()`))
 
-	res, err := c.queryAPI.Query(context.Background(), query)
+	res, err := c.run(ctx, b)
 	if err != nil {
 		return nil, err
 	}
@@ -397,89 +352,152 @@ from(bucket: "%s")
 	return means, res.Err()
 }
 
-// GetFloatRange queries a specific float field over a given time range and returns time-value pairs.
-func (c *Client) GetFloatRange(bucket, field, start, stop string) ([]map[string]interface{}, error) {
-	window := inferWindowSize(start) // 👈 new logic here
-
-	query := fmt.Sprintf(`
-from(bucket: "%s")
-  |> range(start: %s, stop: %s)
-  |> filter(fn: (r) => r["_measurement"] == "status_data" and r["_field"] == "%s")
-  |> aggregateWindow(every: %s, fn: mean, createEmpty: false)
-  |> keep(columns: ["_time", "_value"])
-`, bucket, start, stop, field, window)
+// GetFloatRange queries the first field in q over the query range and returns time-value pairs,
+// averaged over a window sized to the range.
+func (c *Client) GetFloatRange(ctx context.Context, q store.Query) ([]store.TimeValue, error) {
+	if len(q.Fields) == 0 {
+		return nil, fmt.Errorf("no field given for float range query")
+	}
+	q.Fields = q.Fields[:1]
+	field := q.Fields[0]
 
-	res, err := c.queryAPI.Query(context.Background(), query)
+	series, err := c.GetSeries(ctx, q, store.FloatRangeWindow(q.Stop.Sub(q.Start)), store.AggMean)
 	if err != nil {
 		log.Printf("ERROR: Error running float range query for field '%s': %v", field, err)
 		return nil, fmt.Errorf("ERROR: float range query error: %w", err)
 	}
+	data := series[field]
+	log.Printf("INFLUX: Successfully fetched %d points for float field '%s'", len(data), field)
+	return data, nil
+}
+
+// GetSeries applies fn to each field in q over windows of length every,
+// reading long ranges from the rollup tiers where they can answer fn.
+func (c *Client) GetSeries(ctx context.Context, q store.Query, every time.Duration, fn string) (map[string][]store.TimeValue, error) {
+	if !store.ValidAggregate(fn) {
+		return nil, fmt.Errorf("unknown aggregate function '%s'", fn)
+	}
+	if len(q.Fields) == 0 {
+		return map[string][]store.TimeValue{}, nil
+	}
+	if series, ok, err := c.rollups.windowAggregates(ctx, q, every, fn); ok {
+		return series, err
+	}
 
-	var data []map[string]interface{}
+	b := flux.NewBuilder(c.useParams)
+	b.Yield(source(b, q).
+		Then(`group(columns: ["_field"])`).
+		Then(`sort(columns: ["_time"])`).
+		Then(`map(fn: (r) => ({ r with _value: float(v: r._value) }))`).
+		Then(`aggregateWindow(every: %s, fn: %s, createEmpty: false)`, b.Duration(every), b.Ident(fn)).
+		Then(`keep(columns: ["_time", "_field", "_value"])`))
+
+	res, err := c.run(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	series := make(map[string][]store.TimeValue)
 	for res.Next() {
 		record := res.Record()
-		row := map[string]interface{}{
-			"time":  record.Time(),
-			"value": record.Value(),
-		}
-		data = append(data, row)
+		series[record.Field()] = append(series[record.Field()], store.TimeValue{Time: record.Time(), Value: record.Value()})
 	}
-	if res.Err() != nil {
-		log.Printf("ERROR: Error parsing float range query results for field '%s': %v", field, res.Err())
-		return nil, fmt.Errorf("ERROR: Float range parse error: %w", res.Err())
+	if err := res.Err(); err != nil {
+		return nil, err
 	}
-	log.Printf("INFLUX: Successfully fetched %d points for float field '%s'", len(data), field)
-	return data, nil
+	return series, nil
 }
 
-func inferWindowSize(start string) string {
-	switch {
-	case strings.HasPrefix(start, "-1h"):
-		return "1s"
-	case strings.HasPrefix(start, "-3h"):
-		return "30s"
-	case strings.HasPrefix(start, "-6h"):
-		return "10s"
-	case strings.HasPrefix(start, "-12h"):
-		return "1m"
-	case strings.HasPrefix(start, "-1d"):
-		return "1m"
-	case strings.HasPrefix(start, "-2d"):
-		return "2m"
-	case strings.HasPrefix(start, "-3d"):
-		return "3m"
-	case strings.HasPrefix(start, "-1w"):
-		return "10m"
-	case strings.HasPrefix(start, "-2w"):
-		return "10m"
-	case strings.HasPrefix(start, "-3w"):
-		return "10m"
-	case strings.HasPrefix(start, "-1mo"):
-		return "10m"
-	default:
-		return "5m" // fallback for short or malformed inputs
+// QueryRaw returns every sample matching q, ordered by time.
+func (c *Client) QueryRaw(ctx context.Context, q store.Query) ([]store.Sample, error) {
+	b := flux.NewBuilder(c.useParams)
+	b.Yield(source(b, q).
+		Then(`group()`).
+		Then(`sort(columns: ["_time"])`))
+
+	res, err := c.run(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	var samples []store.Sample
+	for res.Next() {
+		samples = append(samples, recordSample(res.Record()))
 	}
+	return samples, res.Err()
 }
 
-// GetSystemStatus retrieves the most recent boolean value for each of the system status fields.
-func (c *Client) GetSystemStatus(measurement, bucket string, fields []string) (map[string]bool, error) {
-	if len(fields) == 0 {
-		return map[string]bool{}, nil
+// LatestSamples returns the most recent sample of each field in q.
+func (c *Client) LatestSamples(ctx context.Context, q store.Query) (map[string]store.Sample, error) {
+	b := flux.NewBuilder(c.useParams)
+	b.Yield(source(b, q).
+		Then(`group(columns: ["_field"])`).
+		Then(`sort(columns: ["_time"])`).
+		Then(`last()`))
+
+	res, err := c.run(ctx, b)
+	if err != nil {
+		return nil, err
 	}
-	var filters []string
-	for _, f := range fields {
-		filters = append(filters, fmt.Sprintf(`r["_field"] == "%s"`, f))
+	latest := make(map[string]store.Sample)
+	for res.Next() {
+		sample := recordSample(res.Record())
+		latest[sample.Field] = sample
+	}
+	return latest, res.Err()
+}
+
+// recordSample converts a query result record to a sample, taking every
+// string column not reserved by InfluxDB as a tag.
+func recordSample(record *query.FluxRecord) store.Sample {
+	sample := store.Sample{Time: record.Time(), Field: record.Field(), Value: record.Value()}
+	for k, v := range record.Values() {
+		if strings.HasPrefix(k, "_") || k == "result" || k == "table" {
+			continue
+		}
+		if tag, ok := v.(string); ok {
+			if sample.Tags == nil {
+				sample.Tags = make(map[string]string)
+			}
+			sample.Tags[k] = tag
+		}
+	}
+	return sample
+}
+
+// source starts a pipeline reading the bucket, time range, measurement,
+// fields and tags selected by q. With no fields, every field is read.
+func source(b *flux.Builder, q store.Query) *flux.Pipe {
+	return b.From(q.Bucket).
+		Range(b.Time(q.Start), b.Time(q.Stop)).
+		Filter(flux.Eq(flux.Column("_measurement"), b.String(q.Measurement))).
+		Filter(b.FieldIn(q.Fields)).
+		Filter(b.TagsMatch(q.Tags))
+}
+
+// run builds and executes a query, passing its params if it has any.
+func (c *Client) run(ctx context.Context, b *flux.Builder) (*api.QueryTableResult, error) {
+	query, params, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if params != nil {
+		return c.queryAPI.QueryWithParams(ctx, query, params)
+	}
+	return c.queryAPI.Query(ctx, query)
+}
+
+// GetSystemStatus retrieves the most recent boolean value for each of the system status fields
+// within the query range, optionally limited to points carrying tags.
+func (c *Client) GetSystemStatus(ctx context.Context, q store.Query) (map[string]bool, error) {
+	if len(q.Fields) == 0 {
+		return map[string]bool{}, nil
 	}
-	query := fmt.Sprintf(`
-from(bucket: "%s")
-  |> range(start: -1d) // Limit to the last day to make the query faster
-  |> filter(fn: (r) => r["_measurement"] == "%s")
-  |> filter(fn: (r) => %s)
-  |> group(columns: ["_field"])
-  |> last()
-`, bucket, measurement, strings.Join(filters, " or "))
+	b := flux.NewBuilder(c.useParams)
+	b.Yield(source(b, q).
+		Then(`group(columns: ["_field"])`).
+		Then(`sort(columns: ["_time"])`).
+		Then(`last()`))
 
-	res, err := c.queryAPI.Query(context.Background(), query)
+	res, err := c.run(ctx, b)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,708 @@
+// file: service/influx/rollup.go
+// Background rollup of raw data into 1m, 1h and 1d summary buckets
+package influx
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vtarchitect/config"
+	"vtarchitect/influx/flux"
+	"vtarchitect/store"
+
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+)
+
+// tier is one rollup resolution, stored in the bucket <INFLUXDB_BUCKET>_<name>.
+type tier struct {
+	name      string
+	period    time.Duration
+	retention time.Duration // zero keeps data forever
+	chunk     time.Duration // range rolled up per query
+}
+
+// tiers are ordered finest first. The 1m tier is rolled up from raw data and
+// each coarser tier from the one before it.
+var tiers = []tier{
+	{"1m", time.Minute, 35 * 24 * time.Hour, 15 * time.Minute},
+	{"1h", time.Hour, 400 * 24 * time.Hour, 24 * time.Hour},
+	{"1d", 24 * time.Hour, 0, 30 * 24 * time.Hour},
+}
+
+const (
+	// sourceFieldTag holds the name of the summarised field in rollup points.
+	sourceFieldTag = "source_field"
+	// settleDelay is how long a minute is left for late points before it is
+	// rolled up. Points arriving later still, such as replayed batches, mark
+	// their windows to be rolled up again.
+	settleDelay = 2 * time.Minute
+	// maxBackfill bounds how far back an empty 1m tier is filled from raw data.
+	maxBackfill = 30 * 24 * time.Hour
+)
+
+// rollups runs the rollup job and records how much of each tier is complete.
+type rollups struct {
+	c           *Client
+	measurement string
+	backfill    time.Duration
+
+	mu     sync.RWMutex
+	ready  bool
+	since  []time.Time // tier i holds every window in [since[i], until[i])
+	until  []time.Time
+	writes []func(ctx context.Context, points []store.Point) error
+	// late holds the 1m windows that points were written to after they had
+	// been rolled up, or before the extents were loaded. rolling is the end
+	// of the 1m range being rolled up, so points written while it is read
+	// are caught too.
+	late    map[time.Time]bool
+	rolling time.Time
+
+	// raw and read fetch the source of the first tier and of the coarser
+	// tiers.
+	raw  func(ctx context.Context, q store.Query) ([]store.Sample, error)
+	read func(ctx context.Context, i int, q store.Query) ([]seriesStats, error)
+
+	done chan struct{}
+}
+
+// newRollups configures the rollup job for the data measurement, backfilling
+// INFLUXDB_ROLLUP_BACKFILL_DAYS (default and maximum 30) of raw data.
+func newRollups(c *Client, cfg *config.Config) *rollups {
+	measurement := cfg.Values["INFLUXDB_MEASUREMENT"]
+	if measurement == "" {
+		measurement = "status_data"
+	}
+	backfill := maxBackfill
+	if days, err := strconv.Atoi(cfg.Values["INFLUXDB_ROLLUP_BACKFILL_DAYS"]); err == nil && days > 0 && time.Duration(days)*24*time.Hour < maxBackfill {
+		backfill = time.Duration(days) * 24 * time.Hour
+	}
+	r := &rollups{
+		c:           c,
+		measurement: measurement,
+		backfill:    backfill,
+		since:       make([]time.Time, len(tiers)),
+		until:       make([]time.Time, len(tiers)),
+		late:        make(map[time.Time]bool),
+		raw:         c.QueryRaw,
+	}
+	r.read = r.readTier
+	return r
+}
+
+// noteWritten marks the 1m windows of points written to the data
+// measurement behind the first tier, so they are rolled up again.
+func (r *rollups) noteWritten(points []store.Point) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range points {
+		if p.Measurement != r.measurement {
+			continue
+		}
+		if w := floorTime(p.Time, tiers[0].period); !r.ready || w.Before(r.until[0]) || w.Before(r.rolling) {
+			r.late[w] = true
+		}
+	}
+}
+
+// tierBucket returns the name of the bucket holding tier i.
+func (r *rollups) tierBucket(i int) string {
+	return r.c.bucket + "_" + tiers[i].name
+}
+
+// start runs the job in the background until ctx is cancelled.
+func (r *rollups) start(ctx context.Context) {
+	r.done = make(chan struct{})
+	go r.run(ctx)
+}
+
+// wait blocks until a started job has stopped.
+func (r *rollups) wait() {
+	if r != nil && r.done != nil {
+		<-r.done
+	}
+}
+
+func (r *rollups) run(ctx context.Context) {
+	defer close(r.done)
+	log.Printf("INFLUX: Rollups enabled for measurement '%s', backfilling %s", r.measurement, r.backfill)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		if err := r.step(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: Rollup failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// step brings every tier up to date, first creating the tier buckets and
+// loading their extents if that has not yet succeeded.
+func (r *rollups) step(ctx context.Context) error {
+	if !r.ready {
+		if err := r.provision(ctx); err != nil {
+			return err
+		}
+		if err := r.load(ctx); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.ready = true
+		r.mu.Unlock()
+	}
+	if err := r.rerollLate(ctx); err != nil {
+		return err
+	}
+	for i, t := range tiers {
+		r.mu.RLock()
+		from := r.until[i]
+		limit := floorTime(time.Now().Add(-settleDelay), t.period)
+		if i > 0 {
+			limit = floorTime(r.until[i-1], t.period)
+		}
+		r.mu.RUnlock()
+
+		chunks := 0
+		for from.Before(limit) {
+			to := from.Add(t.chunk)
+			if to.After(limit) {
+				to = limit
+			}
+			if i == 0 {
+				r.mu.Lock()
+				r.rolling = to
+				r.mu.Unlock()
+			}
+			if err := r.rollUp(ctx, i, from, to); err != nil {
+				return fmt.Errorf("tier %s at %s: %w", t.name, from.Format(time.RFC3339), err)
+			}
+			r.mu.Lock()
+			r.until[i] = to
+			r.mu.Unlock()
+			from = to
+			chunks++
+		}
+		if chunks > 1 {
+			log.Printf("INFLUX: Rollup tier %s caught up to %s", t.name, from.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// rerollLate rolls up again the windows of every tier holding a late point,
+// finest first so each coarser window is combined from corrected ones.
+// Windows not yet rolled up are left to the regular job.
+func (r *rollups) rerollLate(ctx context.Context) error {
+	r.mu.Lock()
+	late := r.late
+	r.late = make(map[time.Time]bool)
+	since := append([]time.Time(nil), r.since...)
+	until := append([]time.Time(nil), r.until...)
+	r.mu.Unlock()
+	if len(late) == 0 {
+		return nil
+	}
+
+	windows := make([]time.Time, 0, len(late))
+	for w := range late {
+		windows = append(windows, w)
+	}
+	minutes := 0
+	for i, t := range tiers {
+		due := make(map[time.Time]bool)
+		for _, w := range windows {
+			if w = floorTime(w, t.period); !w.Before(since[i]) && w.Before(until[i]) {
+				due[w] = true
+			}
+		}
+		windows = windows[:0]
+		for w := range due {
+			windows = append(windows, w)
+		}
+		sort.Slice(windows, func(a, b int) bool { return windows[a].Before(windows[b]) })
+		if i == 0 {
+			minutes = len(windows)
+		}
+		// Roll up runs of adjacent windows together, a chunk at a time.
+		for j := 0; j < len(windows); {
+			from, to := windows[j], windows[j].Add(t.period)
+			for j++; j < len(windows) && windows[j].Equal(to) && to.Sub(from) < t.chunk; j++ {
+				to = to.Add(t.period)
+			}
+			if err := r.rollUp(ctx, i, from, to); err != nil {
+				// Try every late window again on the next step.
+				r.mu.Lock()
+				for w := range late {
+					r.late[w] = true
+				}
+				r.mu.Unlock()
+				return fmt.Errorf("tier %s again at %s: %w", t.name, from.Format(time.RFC3339), err)
+			}
+		}
+	}
+	if minutes > 0 {
+		log.Printf("INFLUX: Rolled up %d minute(s) again for late points", minutes)
+	}
+	return nil
+}
+
+// provision creates any missing tier buckets with their retention periods.
+func (r *rollups) provision(ctx context.Context) error {
+	buckets := r.c.influxClient.BucketsAPI()
+	var org *domain.Organization
+	r.writes = make([]func(context.Context, []store.Point) error, len(tiers))
+	for i, t := range tiers {
+		name := r.tierBucket(i)
+		if _, err := buckets.FindBucketByName(ctx, name); err != nil {
+			if org == nil {
+				if org, err = r.c.influxClient.OrganizationsAPI().FindOrganizationByName(ctx, r.c.org); err != nil {
+					return fmt.Errorf("finding organization '%s': %w", r.c.org, err)
+				}
+			}
+			rule := domain.RetentionRule{EverySeconds: int64(t.retention / time.Second)}
+			if _, err := buckets.CreateBucketWithName(ctx, org, name, rule); err != nil {
+				return fmt.Errorf("creating rollup bucket '%s': %w", name, err)
+			}
+			log.Printf("INFLUX: Created rollup bucket '%s'", name)
+		}
+		writeAPI := r.c.influxClient.WriteAPIBlocking(r.c.org, name)
+		r.writes[i] = func(ctx context.Context, points []store.Point) error {
+			return writePoints(ctx, writeAPI, points)
+		}
+	}
+	return nil
+}
+
+// load finds how far each tier has been filled. A tier resumes after its last
+// stored window. An empty tier, or one whose source no longer covers the gap
+// since it was last filled, starts afresh where its source begins.
+func (r *rollups) load(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range tiers {
+		origin := floorTime(time.Now().Add(-r.backfill), t.period)
+		if i > 0 {
+			origin = ceilTime(r.since[i-1], t.period)
+		}
+		first, last, found, err := r.extent(ctx, i)
+		if err != nil {
+			return err
+		}
+		r.since[i], r.until[i] = first, last.Add(t.period)
+		if !found || r.until[i].Before(origin) {
+			r.since[i], r.until[i] = origin, origin
+		}
+		log.Printf("INFLUX: Rollup tier %s holds %s to %s", t.name, r.since[i].Format(time.RFC3339), r.until[i].Format(time.RFC3339))
+	}
+	return nil
+}
+
+// extent returns the start times of the first and last windows stored in
+// tier i. found is false if the tier is empty.
+func (r *rollups) extent(ctx context.Context, i int) (first, last time.Time, found bool, err error) {
+	lookback := time.Unix(0, 0)
+	if tiers[i].retention > 0 {
+		lookback = time.Now().Add(-tiers[i].retention)
+	}
+	for _, desc := range []bool{false, true} {
+		b := flux.NewBuilder(r.c.useParams)
+		selector, order := "first()", "false"
+		if desc {
+			selector, order = "last()", "true"
+		}
+		b.Yield(b.From(r.tierBucket(i)).
+			Then(`range(start: %s)`, b.Time(lookback)).
+			Filter(flux.And(
+				flux.Eq(flux.Column("_measurement"), b.String(r.measurement)),
+				flux.Eq(flux.Column("_field"), flux.String("count")))).
+			Then(selector).
+			Then(`group()`).
+			Then(`sort(columns: ["_time"], desc: ` + order + `)`).
+			Then(`limit(n: 1)`))
+		res, err := r.c.run(ctx, b)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+		for res.Next() {
+			found = true
+			if desc {
+				last = res.Record().Time()
+			} else {
+				first = res.Record().Time()
+			}
+		}
+		if err := res.Err(); err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+	}
+	return first.UTC(), last.UTC(), found, nil
+}
+
+// rollUp summarises [from, to) into tier i, from raw data for the first tier
+// and from the tier before it otherwise.
+func (r *rollups) rollUp(ctx context.Context, i int, from, to time.Time) error {
+	q := store.Query{Bucket: r.c.bucket, Measurement: r.measurement, Start: from, Stop: to}
+	var stats []seriesStats
+	if i == 0 {
+		samples, err := r.raw(ctx, q)
+		if err != nil {
+			return err
+		}
+		stats = summarise(samples, tiers[i].period, to)
+	} else {
+		children, err := r.read(ctx, i-1, q)
+		if err != nil {
+			return err
+		}
+		stats = combine(children, tiers[i].period)
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+	points := make([]store.Point, 0, len(stats))
+	for _, s := range stats {
+		points = append(points, s.point(r.measurement))
+	}
+	return r.writes[i](ctx, points)
+}
+
+// readTier returns the windows of tier i in the range, fields and tags of q,
+// ordered by time.
+func (r *rollups) readTier(ctx context.Context, i int, q store.Query) ([]seriesStats, error) {
+	b := flux.NewBuilder(r.c.useParams)
+	b.Yield(b.From(r.tierBucket(i)).
+		Range(b.Time(q.Start), b.Time(q.Stop)).
+		Filter(flux.Eq(flux.Column("_measurement"), b.String(q.Measurement))).
+		Filter(b.OneOf(sourceFieldTag, q.Fields)).
+		Filter(b.TagsMatch(q.Tags)))
+	res, err := r.c.run(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	type row struct {
+		stats seriesStats
+		mean  float64
+	}
+	rows := make(map[string]*row)
+	for res.Next() {
+		record := res.Record()
+		tags := make(map[string]string)
+		var field string
+		for k, v := range record.Values() {
+			tag, ok := v.(string)
+			if !ok || strings.HasPrefix(k, "_") || k == "result" || k == "table" {
+				continue
+			}
+			if k == sourceFieldTag {
+				field = tag
+			} else {
+				tags[k] = tag
+			}
+		}
+		key := seriesKey(field, tags) + "\x00" + strconv.FormatInt(record.Time().UnixNano(), 10)
+		rw := rows[key]
+		if rw == nil {
+			start := record.Time().UTC()
+			rw = &row{stats: seriesStats{field: field, tags: tags, windowStats: windowStats{start: start, end: start.Add(tiers[i].period)}}}
+			rows[key] = rw
+		}
+		w := &rw.stats.windowStats
+		switch record.Field() {
+		case "count":
+			w.count = toInt(record.Value())
+		case "mean":
+			rw.mean, _ = store.AsFloat(record.Value())
+		case "min":
+			w.min, _ = store.AsFloat(record.Value())
+		case "max":
+			w.max, _ = store.AsFloat(record.Value())
+		case "trues":
+			w.isBool = true
+			w.trues = toInt(record.Value())
+		case "true_s":
+			w.trueSeconds, _ = store.AsFloat(record.Value())
+		case "rises":
+			w.rises = toInt(record.Value())
+		case "first":
+			w.first, _ = record.Value().(bool)
+		case "last":
+			w.last, _ = record.Value().(bool)
+		case "lead_s":
+			w.lead, _ = store.AsFloat(record.Value())
+			w.hasLead = true
+		}
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := make([]seriesStats, 0, len(rows))
+	for _, rw := range rows {
+		if !rw.stats.isBool {
+			rw.stats.sum = rw.mean * float64(rw.stats.count)
+		}
+		stats = append(stats, rw.stats)
+	}
+	sortStats(stats)
+	return stats, nil
+}
+
+// windowStats summarises the samples of one series in one window. Numeric
+// series use count, sum, min and max; boolean series use count, trues,
+// trueSeconds, rises, first, last and lead.
+type windowStats struct {
+	start    time.Time
+	end      time.Time // end of the window, not stored
+	isBool   bool
+	count    int64
+	sum      float64
+	min, max float64
+	trues    int64
+	// trueSeconds is the time the series was true from its first sample to
+	// the end of the window, taking each sample to hold until the next one.
+	trueSeconds float64
+	// rises counts false-to-true transitions between samples in the window.
+	rises       int64
+	first, last bool
+	lastTime    time.Time
+	// lead is the time from the start of the window to its first sample.
+	// hasLead is false for windows rolled up before lead was recorded.
+	lead    float64
+	hasLead bool
+}
+
+// observe adds one sample. Samples must arrive in time order; values of the
+// wrong type for the series, and non-numeric values, are ignored.
+func (w *windowStats) observe(t time.Time, v interface{}) {
+	if b, ok := v.(bool); ok {
+		if w.count > 0 && !w.isBool {
+			return
+		}
+		if w.count == 0 {
+			w.isBool, w.first = true, b
+			w.lead, w.hasLead = t.Sub(w.start).Seconds(), true
+		} else {
+			if w.last {
+				w.trueSeconds += t.Sub(w.lastTime).Seconds()
+			}
+			if b && !w.last {
+				w.rises++
+			}
+		}
+		w.count++
+		if b {
+			w.trues++
+		}
+		w.last, w.lastTime = b, t
+		return
+	}
+	f, ok := store.AsFloat(v)
+	if !ok || w.isBool {
+		return
+	}
+	if w.count == 0 || f < w.min {
+		w.min = f
+	}
+	if w.count == 0 || f > w.max {
+		w.max = f
+	}
+	w.sum += f
+	w.count++
+}
+
+// add folds o, a later window of the same field, into w. A rise is counted
+// where o starts true after w ended false, and the time from the end of w to
+// the first sample of o counts as true if w ended true. w keeps its start
+// time.
+func (w *windowStats) add(o windowStats) {
+	if o.count == 0 {
+		return
+	}
+	if w.count == 0 {
+		start := w.start
+		*w = o
+		w.start = start
+		w.lead += o.start.Sub(start).Seconds()
+		return
+	}
+	if o.isBool != w.isBool {
+		return
+	}
+	if o.isBool {
+		if o.first && !w.last {
+			w.rises++
+		}
+		if w.last && !o.start.Before(w.end) {
+			w.trueSeconds += o.start.Sub(w.end).Seconds() + o.lead
+		}
+		w.rises += o.rises
+		w.trues += o.trues
+		w.trueSeconds += o.trueSeconds
+		w.last = o.last
+		w.hasLead = w.hasLead && o.hasLead
+	} else {
+		w.sum += o.sum
+		w.min = math.Min(w.min, o.min)
+		w.max = math.Max(w.max, o.max)
+	}
+	w.count += o.count
+	if o.end.After(w.end) {
+		w.end = o.end
+	}
+}
+
+// seriesStats is a window of one field and tag set.
+type seriesStats struct {
+	field string
+	tags  map[string]string
+	windowStats
+}
+
+// point converts s to a rollup point, stamped with its window start.
+func (s seriesStats) point(measurement string) store.Point {
+	tags := make(map[string]string, len(s.tags)+1)
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	tags[sourceFieldTag] = s.field
+	fields := map[string]interface{}{"count": s.count}
+	if s.isBool {
+		fields["trues"] = s.trues
+		fields["true_s"] = s.trueSeconds
+		fields["rises"] = s.rises
+		fields["first"] = s.first
+		fields["last"] = s.last
+		if s.hasLead {
+			fields["lead_s"] = s.lead
+		}
+	} else {
+		fields["mean"] = s.sum / float64(s.count)
+		fields["min"] = s.min
+		fields["max"] = s.max
+	}
+	return store.Point{Measurement: measurement, Tags: tags, Fields: fields, Time: s.start}
+}
+
+// summarise splits time-ordered samples into windows of length period per
+// series, cutting the last window short at stop.
+func summarise(samples []store.Sample, period time.Duration, stop time.Time) []seriesStats {
+	open := make(map[string]*seriesStats)
+	var stats []seriesStats
+	closeWindow := func(s *seriesStats) {
+		s.end = s.start.Add(period)
+		if s.end.After(stop) {
+			s.end = stop
+		}
+		if s.isBool && s.last {
+			s.trueSeconds += s.end.Sub(s.lastTime).Seconds()
+		}
+		if s.count > 0 {
+			stats = append(stats, *s)
+		}
+	}
+	for _, smp := range samples {
+		key := seriesKey(smp.Field, smp.Tags)
+		start := floorTime(smp.Time, period)
+		s := open[key]
+		if s != nil && !s.start.Equal(start) {
+			closeWindow(s)
+			s = nil
+		}
+		if s == nil {
+			s = &seriesStats{field: smp.Field, tags: smp.Tags, windowStats: windowStats{start: start}}
+			open[key] = s
+		}
+		s.observe(smp.Time, smp.Value)
+	}
+	for _, s := range open {
+		closeWindow(s)
+	}
+	sortStats(stats)
+	return stats
+}
+
+// combine merges time-ordered windows into windows of length period per
+// series.
+func combine(children []seriesStats, period time.Duration) []seriesStats {
+	open := make(map[string]*seriesStats)
+	var stats []seriesStats
+	closeWindow := func(s *seriesStats) {
+		end := s.start.Add(period)
+		if s.isBool && s.last && end.After(s.end) {
+			s.trueSeconds += end.Sub(s.end).Seconds()
+		}
+		s.end = end
+		if s.count > 0 {
+			stats = append(stats, *s)
+		}
+	}
+	for _, c := range children {
+		key := seriesKey(c.field, c.tags)
+		start := floorTime(c.start, period)
+		s := open[key]
+		if s != nil && !s.start.Equal(start) {
+			closeWindow(s)
+			s = nil
+		}
+		if s == nil {
+			s = &seriesStats{field: c.field, tags: c.tags, windowStats: windowStats{start: start}}
+			open[key] = s
+		}
+		s.add(c.windowStats)
+	}
+	for _, s := range open {
+		closeWindow(s)
+	}
+	sortStats(stats)
+	return stats
+}
+
+func sortStats(stats []seriesStats) {
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].start.Before(stats[j].start) })
+}
+
+// seriesKey identifies a field and tag set.
+func seriesKey(field string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(field)
+	for _, k := range keys {
+		sb.WriteString("\x00" + k + "=" + tags[k])
+	}
+	return sb.String()
+}
+
+func toInt(v interface{}) int64 {
+	f, _ := store.AsFloat(v)
+	return int64(f)
+}
+
+// floorTime rounds t down to a multiple of d since the Unix epoch.
+func floorTime(t time.Time, d time.Duration) time.Time {
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(d)).UTC()
+}
+
+// ceilTime rounds t up to a multiple of d since the Unix epoch.
+func ceilTime(t time.Time, d time.Duration) time.Time {
+	f := floorTime(t, d)
+	if f.Before(t) {
+		return f.Add(d)
+	}
+	return f
+}
@@ -0,0 +1,116 @@
+// file: service/influx/rollup_test.go
+package influx
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+// memRollups is a rollup job over raw samples and tiers held in memory.
+type memRollups struct {
+	*rollups
+	samples []store.Sample
+	tiers   []map[string]store.Point
+}
+
+func newMemRollups(since time.Time) *memRollups {
+	m := &memRollups{rollups: &rollups{
+		c:           &Client{bucket: "vt"},
+		measurement: "status_data",
+		ready:       true,
+		since:       make([]time.Time, len(tiers)),
+		until:       make([]time.Time, len(tiers)),
+		late:        make(map[time.Time]bool),
+	}}
+	for i, t := range tiers {
+		m.since[i] = floorTime(since, t.period)
+		m.until[i] = m.since[i]
+		m.tiers = append(m.tiers, make(map[string]store.Point))
+		stored := m.tiers[i]
+		m.writes = append(m.writes, func(ctx context.Context, points []store.Point) error {
+			for _, p := range points {
+				// Like InfluxDB, a point replaces one with the same series and time.
+				stored[seriesKey(p.Tags[sourceFieldTag], p.Tags)+p.Time.String()] = p
+			}
+			return nil
+		})
+	}
+	m.raw = func(ctx context.Context, q store.Query) ([]store.Sample, error) {
+		return between(m.samples, q.Start, q.Stop), nil
+	}
+	m.read = func(ctx context.Context, i int, q store.Query) ([]seriesStats, error) {
+		return m.window(i, q.Start, q.Stop), nil
+	}
+	return m
+}
+
+// window returns the float windows of tier i in [start, stop).
+func (m *memRollups) window(i int, start, stop time.Time) []seriesStats {
+	var stats []seriesStats
+	for _, p := range m.tiers[i] {
+		if p.Time.Before(start) || !p.Time.Before(stop) {
+			continue
+		}
+		count := p.Fields["count"].(int64)
+		stats = append(stats, seriesStats{field: p.Tags[sourceFieldTag], tags: map[string]string{}, windowStats: windowStats{
+			start: p.Time, end: p.Time.Add(tiers[i].period), count: count,
+			sum: p.Fields["mean"].(float64) * float64(count), min: p.Fields["min"].(float64), max: p.Fields["max"].(float64),
+		}})
+	}
+	sortStats(stats)
+	return stats
+}
+
+// write adds a raw sample and tells the job, as Client.WritePoints does.
+func (m *memRollups) write(t time.Time, v float64) {
+	m.samples = append(m.samples, store.Sample{Time: t, Field: "T", Value: v})
+	sort.SliceStable(m.samples, func(i, j int) bool { return m.samples[i].Time.Before(m.samples[j].Time) })
+	m.noteWritten([]store.Point{{Measurement: "status_data", Fields: map[string]interface{}{"T": v}, Time: t}})
+}
+
+func TestLatePointsRolledUpAgain(t *testing.T) {
+	hour := floorTime(time.Now().Add(-3*time.Hour), time.Hour)
+	m := newMemRollups(hour)
+	m.write(hour.Add(30*time.Second), 1)
+	m.write(hour.Add(90*time.Second), 3)
+	if err := m.step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, i int, start time.Time, count int64, mean float64) {
+		t.Helper()
+		ws := m.window(i, start, start.Add(tiers[i].period))
+		if len(ws) != 1 || ws[0].count != count || ws[0].sum/float64(ws[0].count) != mean {
+			t.Errorf("%s: tier %s at %s holds %+v, want %d values of mean %g", name, tiers[i].name, start.Format(time.Kitchen), ws, count, mean)
+		}
+	}
+	check("on time", 1, hour, 2, 2)
+	if !m.until[0].After(hour.Add(2 * time.Hour)) {
+		t.Fatalf("1m tier rolled up to %s only", m.until[0])
+	}
+
+	// A point replayed long after its minute was rolled up.
+	m.write(hour.Add(100*time.Second), 8)
+	m.write(hour.Add(61*time.Minute), 4)
+	if len(m.late) != 2 {
+		t.Errorf("%d late windows, want 2", len(m.late))
+	}
+	if err := m.step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	check("late minute", 0, hour.Add(time.Minute), 2, 5.5)
+	check("late hour", 1, hour, 3, 4)
+	check("late point in the next hour", 1, hour.Add(time.Hour), 1, 4)
+	if len(m.late) != 0 {
+		t.Errorf("%d late windows left", len(m.late))
+	}
+
+	// Points ahead of the rollups are left to the regular job.
+	m.noteWritten([]store.Point{{Measurement: "status_data", Time: m.until[0]}, {Measurement: "other", Time: hour}})
+	if len(m.late) != 0 {
+		t.Errorf("marked %v late", m.late)
+	}
+}
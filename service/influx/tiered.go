@@ -0,0 +1,258 @@
+// file: service/influx/tiered.go
+// Routing of long-range queries to the rollup tiers
+package influx
+
+import (
+	"context"
+	"sort"
+	"time"
+	"vtarchitect/store"
+)
+
+// maxRawSpan is the longest range read from raw data when answering from the
+// rollup tiers. Shorter queries are not routed to the tiers, and a query
+// whose tiers lag so far behind that more raw data than this would be needed
+// is answered from raw data alone.
+const maxRawSpan = time.Hour
+
+// segment is part of a query range served by tier, or by raw data if tier is
+// negative.
+type segment struct {
+	tier        int
+	start, stop time.Time
+}
+
+// coverage returns the range tier i can answer for, allowing for data that
+// has expired from the tier bucket.
+func (r *rollups) coverage(i int, now time.Time) (since, until time.Time) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.ready {
+		return time.Time{}, time.Time{}
+	}
+	since, until = r.since[i], r.until[i]
+	if t := tiers[i]; t.retention > 0 && since.Before(now.Add(-t.retention+t.period)) {
+		since = now.Add(-t.retention + t.period)
+	}
+	return since, until
+}
+
+// plan splits [start, stop) into the largest whole windows of tier top or
+// finer tiers that are complete, and raw data for the rest.
+func (r *rollups) plan(start, stop time.Time, top int, now time.Time) []segment {
+	if !start.Before(stop) {
+		return nil
+	}
+	if top < 0 {
+		return []segment{{tier: -1, start: start, stop: stop}}
+	}
+	since, until := r.coverage(top, now)
+	p := tiers[top].period
+	a, b := start, stop
+	if a.Before(since) {
+		a = since
+	}
+	if b.After(until) {
+		b = until
+	}
+	a, b = ceilTime(a, p), floorTime(b, p)
+	if !a.Before(b) {
+		return r.plan(start, stop, top-1, now)
+	}
+	segs := r.plan(start, a, top-1, now)
+	segs = append(segs, segment{tier: top, start: a, stop: b})
+	return append(segs, r.plan(b, stop, top-1, now)...)
+}
+
+// segments plans q over tiers up to top. ok is false if q should be answered
+// from raw data instead.
+func (r *rollups) segments(q store.Query, top int) (segs []segment, ok bool) {
+	if r == nil || top < 0 || q.Bucket != r.c.bucket || q.Measurement != r.measurement || q.Stop.Sub(q.Start) <= maxRawSpan {
+		return nil, false
+	}
+	segs = r.plan(q.Start, q.Stop, top, time.Now())
+	var raw time.Duration
+	tiered := false
+	for _, s := range segs {
+		if s.tier < 0 {
+			raw += s.stop.Sub(s.start)
+		} else {
+			tiered = true
+		}
+	}
+	return segs, tiered && raw <= maxRawSpan
+}
+
+// collect reads the windows of every segment, ordered by time. Raw data is
+// summarised in windows of rawPeriod.
+func (r *rollups) collect(ctx context.Context, q store.Query, segs []segment, rawPeriod time.Duration) ([]seriesStats, error) {
+	var stats []seriesStats
+	for _, s := range segs {
+		sq := q
+		sq.Start, sq.Stop = s.start, s.stop
+		if s.tier < 0 {
+			samples, err := r.c.QueryRaw(ctx, sq)
+			if err != nil {
+				return nil, err
+			}
+			stats = append(stats, summarise(samples, rawPeriod, s.stop)...)
+			continue
+		}
+		tierStats, err := r.readTier(ctx, s.tier, sq)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, tierStats...)
+	}
+	sortStats(stats)
+	return stats, nil
+}
+
+// totals returns the fields of q summarised over its whole range from the
+// rollup tiers. ok is false if q should be answered from raw data instead.
+func (r *rollups) totals(ctx context.Context, q store.Query) (totals map[string]windowStats, ok bool, err error) {
+	segs, ok := r.segments(q, len(tiers)-1)
+	if !ok {
+		return nil, false, nil
+	}
+	stats, err := r.collect(ctx, q, segs, time.Minute)
+	if err != nil {
+		return nil, true, err
+	}
+	totals = make(map[string]windowStats)
+	for _, s := range stats {
+		t, seen := totals[s.field]
+		if !seen {
+			t.start = s.start
+		}
+		t.add(s.windowStats)
+		totals[s.field] = t
+	}
+	return totals, true, nil
+}
+
+// booleanSummaries summarises the boolean fields of q over its whole range
+// from the rollup tiers. ok is false if q should be answered from raw data
+// instead, as it is when the tiers hold windows rolled up before their lead
+// times were recorded.
+func (r *rollups) booleanSummaries(ctx context.Context, q store.Query) (summaries map[string]store.BooleanSummary, ok bool, err error) {
+	segs, ok := r.segments(q, len(tiers)-1)
+	if !ok {
+		return nil, false, nil
+	}
+	stats, err := r.collect(ctx, q, segs, time.Minute)
+	if err != nil {
+		return nil, true, err
+	}
+	summaries, ok = booleanTotals(stats, q.Stop)
+	return summaries, ok, nil
+}
+
+// booleanTotals merges the time-ordered windows of boolean series into a
+// summary of each field up to stop. ok is false if any window lacks its lead
+// time.
+func booleanTotals(stats []seriesStats, stop time.Time) (summaries map[string]store.BooleanSummary, ok bool) {
+	totals := make(map[string]*windowStats)
+	for _, s := range stats {
+		if !s.isBool {
+			continue
+		}
+		if !s.hasLead {
+			return nil, false
+		}
+		t := totals[s.field]
+		if t == nil {
+			t = &windowStats{start: s.start}
+			totals[s.field] = t
+		}
+		t.add(s.windowStats)
+	}
+	summaries = make(map[string]store.BooleanSummary, len(totals))
+	for field, t := range totals {
+		sum := store.BooleanSummary{
+			FirstTime:   t.start.Add(time.Duration(t.lead * float64(time.Second))),
+			First:       t.first,
+			Last:        t.last,
+			SecondsTrue: t.trueSeconds,
+			Rises:       int(t.rises),
+		}
+		if t.last && stop.After(t.end) {
+			sum.SecondsTrue += stop.Sub(t.end).Seconds()
+		}
+		summaries[field] = sum
+	}
+	return summaries, true
+}
+
+// windowAggregates applies fn to the fields of q over windows of length
+// every, from the coarsest tiers whose period divides every. Each value is
+// stamped with its window end, clipped to the end of the range. Booleans
+// count as 1 and 0. The tiers can answer mean, min and max; ok is false for
+// other functions, or if q should be answered from raw data instead.
+func (r *rollups) windowAggregates(ctx context.Context, q store.Query, every time.Duration, fn string) (series map[string][]store.TimeValue, ok bool, err error) {
+	if fn != store.AggMean && fn != store.AggMin && fn != store.AggMax {
+		return nil, false, nil
+	}
+	top := -1
+	for i, t := range tiers {
+		if every%t.period == 0 {
+			top = i
+		}
+	}
+	segs, ok := r.segments(q, top)
+	if !ok {
+		return nil, false, nil
+	}
+	stats, err := r.collect(ctx, q, segs, every)
+	if err != nil {
+		return nil, true, err
+	}
+
+	type window struct {
+		field string
+		start time.Time
+	}
+	windows := make(map[window]*windowStats)
+	for _, s := range stats {
+		key := window{s.field, floorTime(s.start, every)}
+		w := windows[key]
+		if w == nil {
+			w = &windowStats{start: key.start}
+			windows[key] = w
+		}
+		w.add(s.windowStats)
+	}
+	series = make(map[string][]store.TimeValue)
+	for key, w := range windows {
+		var v float64
+		switch {
+		case fn == store.AggMean && w.isBool:
+			v = float64(w.trues) / float64(w.count)
+		case fn == store.AggMean:
+			v = w.sum / float64(w.count)
+		case fn == store.AggMin && w.isBool:
+			v = 1
+			if w.trues < w.count {
+				v = 0
+			}
+		case fn == store.AggMax && w.isBool:
+			v = 0
+			if w.trues > 0 {
+				v = 1
+			}
+		case fn == store.AggMin:
+			v = w.min
+		default:
+			v = w.max
+		}
+		end := key.start.Add(every)
+		if end.After(q.Stop) {
+			end = q.Stop
+		}
+		series[key.field] = append(series[key.field], store.TimeValue{Time: end, Value: v})
+	}
+	for _, s := range series {
+		sort.Slice(s, func(i, j int) bool { return s[i].Time.Before(s[j].Time) })
+	}
+	return series, true, nil
+}
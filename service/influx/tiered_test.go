@@ -0,0 +1,197 @@
+// file: service/influx/tiered_test.go
+package influx
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"vtarchitect/analytics"
+	"vtarchitect/store"
+)
+
+// changeOnly returns the samples of a boolean field written only when it
+// changes, at most maxGap apart, between start and stop. A full write repeats
+// the state every fullEvery unless it is zero.
+func changeOnly(rng *rand.Rand, field string, start, stop time.Time, maxGap, fullEvery time.Duration) []store.Sample {
+	var samples []store.Sample
+	state := rng.Intn(2) == 0
+	nextFull := start
+	for t := start.Add(time.Duration(rng.Intn(300)) * time.Second); t.Before(stop); t = t.Add(time.Second + time.Duration(rng.Int63n(int64(maxGap)))) {
+		for fullEvery > 0 && !nextFull.After(t) {
+			samples = append(samples, store.Sample{Time: nextFull, Field: field, Value: state})
+			nextFull = nextFull.Add(fullEvery)
+		}
+		state = !state
+		samples = append(samples, store.Sample{Time: t, Field: field, Value: state})
+	}
+	return samples
+}
+
+// between returns the samples in [start, stop).
+func between(samples []store.Sample, start, stop time.Time) []store.Sample {
+	var out []store.Sample
+	for _, s := range samples {
+		if !s.Time.Before(start) && s.Time.Before(stop) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// stored drops what the tiers do not store, as reading them back does.
+func stored(stats []seriesStats, period time.Duration) []seriesStats {
+	for i := range stats {
+		stats[i].end = stats[i].start.Add(period)
+		stats[i].lastTime = time.Time{}
+	}
+	return stats
+}
+
+func TestBooleanTotalsMatchRawData(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hour := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	start, stop := hour.Add(-23*time.Minute-17*time.Second), hour.Add(5*time.Hour+41*time.Minute+3*time.Second)
+	var samples []store.Sample
+	samples = append(samples, changeOnly(rng, "A", start, stop, 15*time.Minute, time.Hour)...)
+	samples = append(samples, changeOnly(rng, "B", start.Add(2*time.Hour), stop, 15*time.Minute, time.Hour)...)
+	samples = append(samples, changeOnly(rng, "C", start, stop, 3*time.Hour, 0)...)
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+	// Raw data at each end, whole minutes, then whole hours in the middle.
+	m0, m1 := ceilTime(start, time.Minute), hour
+	h1 := hour.Add(5 * time.Hour)
+	m2 := floorTime(stop, time.Minute)
+	var stats []seriesStats
+	stats = append(stats, summarise(between(samples, start, m0), time.Minute, m0)...)
+	stats = append(stats, stored(summarise(between(samples, m0, m1), time.Minute, m1), time.Minute)...)
+	hours := stored(summarise(between(samples, m1, h1), time.Minute, h1), time.Minute)
+	stats = append(stats, stored(combine(hours, time.Hour), time.Hour)...)
+	stats = append(stats, stored(summarise(between(samples, h1, m2), time.Minute, m2), time.Minute)...)
+	stats = append(stats, summarise(between(samples, m2, stop), time.Minute, stop)...)
+	sortStats(stats)
+
+	summaries, ok := booleanTotals(stats, stop)
+	if !ok {
+		t.Fatal("windows lack lead times")
+	}
+	history := &analytics.History{Start: start, Stop: stop, Fields: []string{"A", "B", "C"}, Samples: samples}
+	want := history.BooleanStats()
+	if len(summaries) != len(want) {
+		t.Fatalf("summarised %d fields, want %d", len(summaries), len(want))
+	}
+	for field, want := range want {
+		sum := summaries[field]
+		rises := sum.Rises
+		if sum.First {
+			rises++
+		}
+		if math.Abs(sum.SecondsTrue-want.SecondsTrue) > 1e-6 || rises != want.Rises || math.Abs(stop.Sub(sum.FirstTime).Seconds()-want.SecondsKnown) > 1e-6 {
+			t.Errorf("%s: summary %+v, want %+v", field, sum, want)
+		}
+	}
+}
+
+func TestBooleanTotalsNeedLeadTimes(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	stats := summarise([]store.Sample{{Time: start.Add(time.Second), Field: "A", Value: true}}, time.Minute, start.Add(time.Minute))
+	stats[0].hasLead = false
+	if _, ok := booleanTotals(stats, start.Add(time.Hour)); ok {
+		t.Error("summarised windows without lead times")
+	}
+}
+
+// testRollups returns ready rollups for bucket "plc" whose tiers hold every
+// window from since to the given untils.
+func testRollups(since time.Time, until ...time.Time) *rollups {
+	return &rollups{
+		c:           &Client{bucket: "plc"},
+		measurement: "status_data",
+		ready:       true,
+		since:       []time.Time{since, since, since},
+		until:       until,
+	}
+}
+
+// describe lists segments as tier and offsets from base, with tier -1 for raw
+// data.
+func describe(segs []segment, base time.Time) string {
+	var parts []string
+	for _, s := range segs {
+		parts = append(parts, fmt.Sprintf("%d:%s-%s", s.tier, s.start.Sub(base), s.stop.Sub(base)))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestPlan(t *testing.T) {
+	day := 24 * time.Hour
+	d := floorTime(time.Now(), day).Add(-10 * day)
+	r := testRollups(d.Add(-5*day), d.Add(2*day+12*time.Hour+30*time.Minute), d.Add(2*day+12*time.Hour), d.Add(2*day))
+	tests := []struct {
+		name        string
+		r           *rollups
+		start, stop time.Time
+		top         int
+		now         time.Time
+		want        string
+	}{
+		{name: "every tier", r: r, start: d.Add(3*time.Hour + 17*time.Minute + 20*time.Second), stop: d.Add(2*day + 12*time.Hour + 40*time.Minute), top: 2, now: d,
+			want: "-1:3h17m20s-3h18m0s 0:3h18m0s-4h0m0s 1:4h0m0s-24h0m0s 2:24h0m0s-48h0m0s 1:48h0m0s-60h0m0s 0:60h0m0s-60h30m0s -1:60h30m0s-60h40m0s"},
+		{name: "whole windows only", r: r, start: d, stop: d.Add(day), top: 2, now: d,
+			want: "2:0s-24h0m0s"},
+		{name: "top tier limits", r: r, start: d, stop: d.Add(day + 30*time.Second), top: 1, now: d,
+			want: "1:0s-24h0m0s -1:24h0m0s-24h0m30s"},
+		{name: "before the tiers", r: r, start: d.Add(-6 * day), stop: d.Add(-4 * day), top: 2, now: d,
+			want: "-1:-144h0m0s--120h0m0s 2:-120h0m0s--96h0m0s"},
+		{name: "expired from the minute tier", r: r, start: d.Add(-5 * day), stop: d.Add(-5*day + 2*time.Hour), top: 0, now: d.Add(31 * day),
+			want: "-1:-120h0m0s--118h0m0s"},
+		{name: "raw only", r: r, start: d, stop: d.Add(time.Hour), top: -1, now: d,
+			want: "-1:0s-1h0m0s"},
+		{name: "not ready", r: &rollups{}, start: d, stop: d.Add(day), top: 2, now: d,
+			want: "-1:0s-24h0m0s"},
+		{name: "empty range", r: r, start: d, stop: d, top: 2, now: d},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describe(tt.r.plan(tt.start, tt.stop, tt.top, tt.now), d); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegments(t *testing.T) {
+	day := 24 * time.Hour
+	d := floorTime(time.Now(), day).Add(-10 * day)
+	r := testRollups(d, d.Add(2*day), d.Add(2*day), d.Add(2*day))
+	q := store.Query{Bucket: "plc", Measurement: "status_data", Start: d.Add(-30 * time.Minute), Stop: d.Add(day + 30*time.Minute)}
+	tests := []struct {
+		name   string
+		r      *rollups
+		q      func(store.Query) store.Query
+		top    int
+		wantOK bool
+	}{
+		{name: "tiered", r: r, q: func(q store.Query) store.Query { return q }, top: 2, wantOK: true},
+		{name: "an hour of raw data", r: r, q: func(q store.Query) store.Query { q.Start = d.Add(-time.Hour); return q }, top: 2, wantOK: true},
+		{name: "too much raw data", r: r, q: func(q store.Query) store.Query { q.Start = d.Add(-time.Hour - time.Second); return q }, top: 2},
+		{name: "tiers lag behind", r: r, q: func(q store.Query) store.Query { q.Start, q.Stop = d.Add(day), d.Add(2*day+2*time.Hour); return q }, top: 2},
+		{name: "short range", r: r, q: func(q store.Query) store.Query { q.Start, q.Stop = d, d.Add(time.Hour); return q }, top: 2},
+		{name: "other bucket", r: r, q: func(q store.Query) store.Query { q.Bucket = "other"; return q }, top: 2},
+		{name: "other measurement", r: r, q: func(q store.Query) store.Query { q.Measurement = "event_data"; return q }, top: 2},
+		{name: "no tier", r: r, q: func(q store.Query) store.Query { return q }, top: -1},
+		{name: "not ready", r: &rollups{c: r.c, measurement: r.measurement}, q: func(q store.Query) store.Query { return q }, top: 2},
+		{name: "rollups off", q: func(q store.Query) store.Query { return q }, top: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tt.r.segments(tt.q(q), tt.top); ok != tt.wantOK {
+				t.Errorf("ok %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
@@ -2,18 +2,34 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"vtarchitect/api"
 	"vtarchitect/config"
 	"vtarchitect/data"
 	"vtarchitect/influx"
-
-	"github.com/tbrandon/mbserver"
+	"vtarchitect/store"
+	"vtarchitect/store/filestore"
+	"vtarchitect/store/timescale"
+	"vtarchitect/utils"
+	"vtarchitect/wal"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the service and blocks until SIGINT or SIGTERM, or until the API
+// server fails. It returns the process exit status: 0 for a clean shutdown,
+// 1 if anything failed or buffered data could not be written.
+func run() int {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("FATAL: Failed to load config: %v", err)
@@ -30,21 +46,41 @@ func main() {
 	plcSource := cfg.Values["PLC_DATA_SOURCE"]
 	log.Printf("STARTUP: PLC data source: %s", plcSource)
 
-	influxClient, err := influx.NewClient(cfg)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	st, err := openStore(ctx, cfg)
 	if err != nil {
-		log.Fatalf("FATAL: Failed to connect to InfluxDB: %v", err)
+		log.Fatalf("FATAL: Failed to open storage backend: %v", err)
 	}
-	defer influxClient.Close()
+	defer st.Close()
 
-	batchWriter := influx.NewChannelBatchWriter(influxClient.GetWriteAPI(), 100)
-	defer batchWriter.Close()
+	status := 0
+	shutdownTimeout := utils.GetShutdownTimeout(cfg)
+	queue, err := wal.NewFromConfig(cfg)
+	if err != nil {
+		log.Printf("ERROR: Write-ahead queue unavailable, failed writes will be lost: %v", err)
+	}
+	batchWriter := store.NewChannelBatchWriter(st, store.NewBatchWriterOptions(cfg), queue)
+	detector := data.NewAnomalyDetector(ctx, cfg, st)
 
-	go api.StartAPIServer(cfg, influxClient)
+	apiErr := make(chan error, 1)
+	go func() {
+		err := api.StartAPIServer(ctx, cfg, st, batchWriter, detector)
+		if err != nil {
+			log.Printf("ERROR: API server failed: %v", err)
+			cancel()
+		}
+		apiErr <- err
+	}()
 
+	var cycle func()
 	if plcSource == "ethernet-ip" {
-		data.RunEthernetIPCycle(cfg, batchWriter)
+		cycle = func() { data.RunEthernetIPCycle(ctx, cfg, detector, batchWriter) }
 	} else {
-		server := mbserver.NewServer()
+		server := data.NewModbusServer()
 		port := cfg.Values["MODBUS_TCP_PORT"]
 		if port == "" {
 			port = "5020"
@@ -56,6 +92,75 @@ func main() {
 		defer server.Close()
 		log.Printf("DATA: Modbus server listening on port %s", port)
 
-		data.RunModbusCycle(cfg, server, batchWriter)
+		cycle = func() { data.RunModbusCycle(ctx, cfg, server, detector, batchWriter) }
+	}
+
+	if err := acquire(ctx, cycle, batchWriter, shutdownTimeout); err != nil {
+		log.Printf("ERROR: Failed to write buffered points on shutdown: %v", err)
+		status = 1
+	}
+	cancel()
+	if err := <-apiErr; err != nil {
+		status = 1
+	}
+	log.Printf("SHUTDOWN: Service stopped with status %d", status)
+	return status
+}
+
+// acquire runs cycle, an acquisition loop that returns once ctx is cancelled,
+// and then drains batchWriter. Shutdown takes at most timeout from the moment
+// ctx ends: a cycle still running after that, e.g. blocked on a full batch
+// writer, is left behind and released when the writer closes.
+func acquire(ctx context.Context, cycle func(), batchWriter *store.ChannelBatchWriter, timeout time.Duration) error {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		cycle()
+	}()
+	select {
+	case <-ctx.Done():
+	case <-stopped:
+	}
+	deadline := time.Now().Add(timeout)
+	select {
+	case <-stopped:
+		log.Println("SHUTDOWN: Acquisition stopped, draining batch writer...")
+	case <-time.After(timeout):
+		batchWriter.Close(0)
+		return fmt.Errorf("acquisition did not stop within %s", timeout)
+	}
+	return batchWriter.Close(time.Until(deadline))
+}
+
+// openStore opens the storage backend selected by STORAGE_BACKEND: "influxdb"
+// (the default), "file" or "timescale".
+func openStore(ctx context.Context, cfg *config.Config) (store.Store, error) {
+	backend := cfg.Values["STORAGE_BACKEND"]
+	if backend == "" {
+		backend = "influxdb"
+	}
+	log.Printf("STARTUP: Storage backend: %s", backend)
+	switch backend {
+	case "influxdb":
+		client, err := influx.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client.StartRollups(ctx)
+		return client, nil
+	case "file":
+		fs, err := filestore.NewFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return fs, nil
+	case "timescale":
+		ts, err := timescale.NewFromConfig(ctx, cfg, data.IsFaultField)
+		if err != nil {
+			return nil, err
+		}
+		return ts, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND '%s'", backend)
 	}
 }
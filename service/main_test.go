@@ -0,0 +1,111 @@
+// file: service/main_test.go
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/data"
+	"vtarchitect/store"
+)
+
+// hangingStore never finishes a write until its context ends, like an
+// unreachable backend.
+type hangingStore struct {
+	store.Store
+}
+
+func (hangingStore) WritePoints(ctx context.Context, points []store.Point) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// countingStore accepts every write.
+type countingStore struct {
+	store.Store
+	written atomic.Int64
+}
+
+func (s *countingStore) WritePoints(ctx context.Context, points []store.Point) error {
+	s.written.Add(int64(len(points)))
+	return nil
+}
+
+func blockingOptions() store.BatchWriterOptions {
+	return store.BatchWriterOptions{BatchSize: 1, FlushInterval: time.Hour, QueueSize: 1, MaxInFlight: 1, Overflow: store.OverflowBlock, WriteTimeout: time.Hour}
+}
+
+func TestAcquireStopsModbusCycle(t *testing.T) {
+	cfg := &config.Config{Values: map[string]string{
+		"MODBUS_REGISTER_START": "0",
+		"MODBUS_REGISTER_END":   "9",
+		"PLC_POLL_MS":           "10",
+	}}
+	path := filepath.Join(t.TempDir(), "architect.yaml")
+	if err := os.WriteFile(path, []byte("project_meta: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.LoadAndCacheArchitectYAML(path); err != nil {
+		t.Fatal(err)
+	}
+	st := &countingStore{}
+	batchWriter := store.NewChannelBatchWriter(st, blockingOptions(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cycle := func() { data.RunModbusCycle(ctx, cfg, data.NewModbusServer(), nil, batchWriter) }
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := acquire(ctx, cycle, batchWriter, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+	// The master never wrote, so the cycle recorded comm_status=false.
+	if st.written.Load() != 1 {
+		t.Errorf("%d points written, want 1", st.written.Load())
+	}
+}
+
+func TestAcquireBoundedByTimeout(t *testing.T) {
+	batchWriter := store.NewChannelBatchWriter(hangingStore{}, blockingOptions(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stuck := make(chan struct{})
+	var returned atomic.Bool
+	cycle := func() {
+		defer returned.Store(true)
+		for i := 0; ctx.Err() == nil; i++ {
+			if i == 3 {
+				// One point in flight and one pending, so this add blocks.
+				close(stuck)
+			}
+			batchWriter.AddPoint("status_data", nil, map[string]interface{}{"seq": int64(i)}, time.Now())
+		}
+	}
+	go func() {
+		<-stuck
+		cancel()
+	}()
+
+	const timeout = 100 * time.Millisecond
+	start := time.Now()
+	if err := acquire(ctx, cycle, batchWriter, timeout); err == nil {
+		t.Error("no error although points were not written")
+	}
+	if elapsed := time.Since(start); elapsed > timeout+200*time.Millisecond {
+		t.Errorf("shutdown took %s, want about %s", elapsed, timeout)
+	}
+	// Closing the writer released the blocked cycle.
+	deadline := time.Now().Add(time.Second)
+	for !returned.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("cycle still blocked after shutdown")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,180 @@
+// file: service/store/aggregate.go
+// Aggregations computed in Go from raw samples, for backends without a query
+// engine of their own
+package store
+
+import (
+	"math"
+	"time"
+)
+
+// AsFloat converts a numeric or boolean sample value to float64. ok is false
+// for any other type.
+func AsFloat(v interface{}) (f float64, ok bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// BooleanPercentages returns, for each field with boolean samples, the
+// percentage of samples that are true.
+func BooleanPercentages(samples []Sample) map[string]float64 {
+	trues := make(map[string]int)
+	totals := make(map[string]int)
+	for _, s := range samples {
+		b, ok := s.Value.(bool)
+		if !ok {
+			continue
+		}
+		totals[s.Field]++
+		if b {
+			trues[s.Field]++
+		}
+	}
+	result := make(map[string]float64, len(totals))
+	for field, total := range totals {
+		result[field] = float64(trues[field]) / float64(total) * 100
+	}
+	return result
+}
+
+// FaultCounts returns, for each field with boolean samples, the number of
+// false-to-true transitions plus one if the first sample is true. samples
+// must be ordered by time.
+func FaultCounts(samples []Sample) map[string]float64 {
+	counts := make(map[string]float64)
+	last := make(map[string]bool)
+	for _, s := range samples {
+		b, ok := s.Value.(bool)
+		if !ok {
+			continue
+		}
+		if prev, seen := last[s.Field]; b && (!seen || !prev) {
+			counts[s.Field]++
+		}
+		last[s.Field] = b
+	}
+	return counts
+}
+
+// FloatMeans returns the mean of the numeric samples of each field.
+func FloatMeans(samples []Sample) map[string]float64 {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, s := range samples {
+		if _, isBool := s.Value.(bool); isBool {
+			continue
+		}
+		f, ok := AsFloat(s.Value)
+		if !ok {
+			continue
+		}
+		sums[s.Field] += f
+		counts[s.Field]++
+	}
+	means := make(map[string]float64, len(counts))
+	for field, n := range counts {
+		means[field] = sums[field] / float64(n)
+	}
+	return means
+}
+
+// WindowAggregates applies fn, one of the Agg constants, to the numeric and
+// boolean samples of each field over consecutive windows of length every
+// aligned to the Unix epoch, as InfluxDB's aggregateWindow does. Booleans
+// count as 1 and 0. Each value is stamped with its window end, clipped to
+// stop, and empty windows are omitted. samples must be ordered by time.
+func WindowAggregates(samples []Sample, every time.Duration, fn string, stop time.Time) map[string][]TimeValue {
+	type window struct {
+		start                      time.Time
+		n                          int
+		sum, min, max, first, last float64
+	}
+	series := make(map[string][]TimeValue)
+	open := make(map[string]*window)
+	flush := func(field string, w *window) {
+		if w.n == 0 {
+			return
+		}
+		var v float64
+		switch fn {
+		case AggMean:
+			v = w.sum / float64(w.n)
+		case AggMin:
+			v = w.min
+		case AggMax:
+			v = w.max
+		case AggFirst:
+			v = w.first
+		case AggLast:
+			v = w.last
+		}
+		end := w.start.Add(every)
+		if end.After(stop) {
+			end = stop
+		}
+		series[field] = append(series[field], TimeValue{Time: end, Value: v})
+	}
+	for _, s := range samples {
+		f, ok := AsFloat(s.Value)
+		if !ok {
+			continue
+		}
+		ns := s.Time.UnixNano()
+		start := time.Unix(0, ns-ns%int64(every)).UTC()
+		w := open[s.Field]
+		if w == nil || !w.start.Equal(start) {
+			if w != nil {
+				flush(s.Field, w)
+			}
+			w = &window{start: start, min: f, max: f, first: f}
+			open[s.Field] = w
+		}
+		w.n++
+		w.sum += f
+		w.min = math.Min(w.min, f)
+		w.max = math.Max(w.max, f)
+		w.last = f
+	}
+	for field, w := range open {
+		flush(field, w)
+	}
+	return series
+}
+
+// LatestSamples returns the most recent sample of each field. samples must
+// be ordered by time.
+func LatestSamples(samples []Sample) map[string]Sample {
+	latest := make(map[string]Sample)
+	for _, s := range samples {
+		latest[s.Field] = s
+	}
+	return latest
+}
+
+// LatestBooleans returns the most recent boolean value of each field.
+// samples must be ordered by time.
+func LatestBooleans(samples []Sample) map[string]bool {
+	latest := make(map[string]bool)
+	for _, s := range samples {
+		if b, ok := s.Value.(bool); ok {
+			latest[s.Field] = b
+		}
+	}
+	return latest
+}
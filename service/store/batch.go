@@ -0,0 +1,487 @@
+// file: service/store/batch.go
+// Batched writes to the storage backend with a durable store-and-forward queue
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/wal"
+)
+
+// Replay backoff bounds for batches waiting in the write-ahead queue. They
+// are variables so tests can shorten them.
+var (
+	replayBackoffMin = time.Second
+	replayBackoffMax = 5 * time.Minute
+)
+
+// OverflowPolicy selects what AddPoint does when the writer's input channel
+// is full because the storage backend cannot keep up.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes AddPoint wait for room, slowing the caller down.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest pending point to make room.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowSpill moves a batch of the oldest pending points to the
+	// write-ahead queue to make room.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// BatchWriterOptions configures a ChannelBatchWriter.
+type BatchWriterOptions struct {
+	BatchSize     int            // points per write request
+	FlushInterval time.Duration  // maximum time a point waits for its batch to fill
+	QueueSize     int            // capacity of the input channel, in points
+	MaxInFlight   int            // concurrent write requests
+	Overflow      OverflowPolicy // behaviour when the input channel is full
+	WriteTimeout  time.Duration  // maximum time one write request may take
+}
+
+// NewBatchWriterOptions reads the batch writer settings from the configuration:
+// INFLUXDB_BATCH_SIZE, INFLUXDB_FLUSH_MS, INFLUXDB_QUEUE_SIZE,
+// INFLUXDB_MAX_IN_FLIGHT, INFLUXDB_OVERFLOW and INFLUXDB_WRITE_TIMEOUT_MS.
+func NewBatchWriterOptions(cfg *config.Config) BatchWriterOptions {
+	positive := func(key string, def int) int {
+		v, err := strconv.Atoi(cfg.Values[key])
+		if err != nil || v <= 0 {
+			return def
+		}
+		return v
+	}
+	opts := BatchWriterOptions{
+		BatchSize:     positive("INFLUXDB_BATCH_SIZE", 100),
+		FlushInterval: time.Duration(positive("INFLUXDB_FLUSH_MS", 5000)) * time.Millisecond,
+		QueueSize:     positive("INFLUXDB_QUEUE_SIZE", 10000),
+		MaxInFlight:   positive("INFLUXDB_MAX_IN_FLIGHT", 2),
+		Overflow:      OverflowPolicy(cfg.Values["INFLUXDB_OVERFLOW"]),
+		WriteTimeout:  time.Duration(positive("INFLUXDB_WRITE_TIMEOUT_MS", 10000)) * time.Millisecond,
+	}
+	switch opts.Overflow {
+	case OverflowBlock, OverflowDropOldest, OverflowSpill:
+	case "":
+		opts.Overflow = OverflowSpill
+	default:
+		log.Printf("STORE: Unknown INFLUXDB_OVERFLOW '%s', using '%s'", opts.Overflow, OverflowSpill)
+		opts.Overflow = OverflowSpill
+	}
+	return opts
+}
+
+// WriterStats reports the state of a ChannelBatchWriter for the API.
+type WriterStats struct {
+	Overflow        OverflowPolicy `json:"overflow"`
+	PointsAccepted  int64          `json:"points_accepted"`
+	PointsWritten   int64          `json:"points_written"`
+	PointsFailed    int64          `json:"points_failed"`
+	PointsDropped   int64          `json:"points_dropped"`
+	PointsSpilled   int64          `json:"points_spilled"`
+	PointsPending   int            `json:"points_pending"`
+	BatchesInFlight int64          `json:"batches_in_flight"`
+	Queue           wal.Stats      `json:"queue"`
+}
+
+// ChannelBatchWriter batches points for a Store. A single goroutine owns the
+// batch being built; producers hand points to it over a bounded channel, and
+// at most MaxInFlight batches are written concurrently. Batches that fail to
+// write are persisted to the write-ahead queue and replayed in order once
+// the backend is reachable again.
+type ChannelBatchWriter struct {
+	store Store
+	queue *wal.Queue
+	opts  BatchWriterOptions
+
+	points   chan Point
+	replayCh chan struct{}
+	doneCh   chan error
+	// replayDone is closed once the replayer has stopped.
+	replayDone chan struct{}
+
+	// closeCh is closed when Close is called, before closeMu is taken, so
+	// an AddPoint blocked on a full channel gives up and releases its
+	// read lock.
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	closeMu   sync.RWMutex
+	closed    bool
+	spillMu   sync.Mutex // orders spilled batches in the queue
+
+	// writeCtx bounds the writes of batches; it is cancelled once Close
+	// times out. replayCtx bounds replayed writes; it is cancelled as soon
+	// as Close is called, since replayed batches stay on disk.
+	writeCtx     context.Context
+	cancelWrites context.CancelFunc
+	replayCtx    context.Context
+	cancelReplay context.CancelFunc
+
+	accepted atomic.Int64
+	written  atomic.Int64
+	failed   atomic.Int64
+	dropped  atomic.Int64
+	spilled  atomic.Int64
+	inFlight atomic.Int64
+}
+
+// NewChannelBatchWriter creates a batch writer and starts its goroutines.
+// A nil queue disables store-and-forward: failed batches are discarded, and
+// the spill overflow policy falls back to dropping the oldest point.
+func NewChannelBatchWriter(store Store, opts BatchWriterOptions, queue *wal.Queue) *ChannelBatchWriter {
+	if opts.Overflow == OverflowSpill && queue == nil {
+		log.Printf("STORE: No write-ahead queue available, overflow policy '%s' used instead of '%s'", OverflowDropOldest, OverflowSpill)
+		opts.Overflow = OverflowDropOldest
+	}
+	cbw := &ChannelBatchWriter{
+		store:    store,
+		queue:    queue,
+		opts:     opts,
+		points:   make(chan Point, opts.QueueSize),
+		replayCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+		doneCh:   make(chan error, 1),
+
+		replayDone: make(chan struct{}),
+	}
+	if cbw.opts.WriteTimeout <= 0 {
+		cbw.opts.WriteTimeout = 10 * time.Second
+	}
+	cbw.writeCtx, cbw.cancelWrites = context.WithCancel(context.Background())
+	cbw.replayCtx, cbw.cancelReplay = context.WithCancel(context.Background())
+	log.Printf("STORE: Batch writer started (batch size %d, flush every %s, queue %d points, %d in flight, overflow '%s', write timeout %s)",
+		opts.BatchSize, opts.FlushInterval, opts.QueueSize, opts.MaxInFlight, opts.Overflow, cbw.opts.WriteTimeout)
+	go cbw.run()
+	if queue != nil {
+		go cbw.replay()
+	} else {
+		close(cbw.replayDone)
+	}
+	return cbw
+}
+
+// AddPoint hands a point to the writer. When the input channel is full the
+// configured overflow policy applies. Points added after Close are dropped,
+// including those blocked waiting for room when Close is called.
+func (cbw *ChannelBatchWriter) AddPoint(measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) {
+	p := Point{Measurement: measurement, Tags: tags, Fields: fields, Time: t}
+
+	cbw.closeMu.RLock()
+	defer cbw.closeMu.RUnlock()
+	if cbw.closed {
+		cbw.dropped.Add(1)
+		return
+	}
+
+	select {
+	case cbw.points <- p:
+		cbw.accepted.Add(1)
+		return
+	default:
+	}
+
+	switch cbw.opts.Overflow {
+	case OverflowBlock:
+		select {
+		case cbw.points <- p:
+			cbw.accepted.Add(1)
+		case <-cbw.closeCh:
+			cbw.dropped.Add(1)
+		}
+	case OverflowSpill:
+		cbw.spillMu.Lock()
+		defer cbw.spillMu.Unlock()
+		for {
+			select {
+			case cbw.points <- p:
+				cbw.accepted.Add(1)
+				return
+			default:
+			}
+			cbw.spillOldest()
+		}
+	default:
+		for {
+			select {
+			case cbw.points <- p:
+				cbw.accepted.Add(1)
+				return
+			default:
+			}
+			select {
+			case <-cbw.points:
+				cbw.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+// spillOldest moves up to a batch of the oldest pending points from the input
+// channel to the write-ahead queue, as one queued batch. Newer points stay in
+// the channel and are queued behind them while the queue is not empty, so
+// spilled points keep their order. The caller must hold spillMu.
+func (cbw *ChannelBatchWriter) spillOldest() {
+	points := make([]Point, 0, cbw.opts.BatchSize)
+drain:
+	for len(points) < cbw.opts.BatchSize {
+		select {
+		case p := <-cbw.points:
+			points = append(points, p)
+		default:
+			break drain
+		}
+	}
+	if len(points) == 0 {
+		return
+	}
+	if err := cbw.enqueue(points); err != nil {
+		return
+	}
+	cbw.spilled.Add(int64(len(points)))
+}
+
+// Stats returns the current writer statistics.
+func (cbw *ChannelBatchWriter) Stats() WriterStats {
+	st := WriterStats{
+		Overflow:        cbw.opts.Overflow,
+		PointsAccepted:  cbw.accepted.Load(),
+		PointsWritten:   cbw.written.Load(),
+		PointsFailed:    cbw.failed.Load(),
+		PointsDropped:   cbw.dropped.Load(),
+		PointsSpilled:   cbw.spilled.Load(),
+		PointsPending:   len(cbw.points),
+		BatchesInFlight: cbw.inFlight.Load(),
+	}
+	if cbw.queue != nil {
+		st.Queue = cbw.queue.Stats()
+	}
+	return st
+}
+
+// Close stops accepting points, writes everything still pending, and waits
+// up to timeout for the writes to finish. A replay in progress is cancelled;
+// queued batches not yet replayed stay on disk for the next start. It
+// returns an error if pending points could be neither written nor queued,
+// or if the writes did not finish in time, in which case they are cancelled.
+func (cbw *ChannelBatchWriter) Close(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	cbw.closeOnce.Do(func() {
+		close(cbw.closeCh)
+		cbw.cancelReplay()
+	})
+	cbw.closeMu.Lock()
+	if !cbw.closed {
+		cbw.closed = true
+		close(cbw.points)
+	}
+	cbw.closeMu.Unlock()
+	var err error
+	select {
+	case err = <-cbw.doneCh:
+	case <-deadline:
+		cbw.cancelWrites()
+		return fmt.Errorf("timed out after %s draining batch writer", timeout)
+	}
+	select {
+	case <-cbw.replayDone:
+		return err
+	case <-deadline:
+		cbw.cancelWrites()
+		return fmt.Errorf("timed out after %s waiting for write-ahead replay", timeout)
+	}
+}
+
+// run owns the batch being built and dispatches full or expired batches.
+func (cbw *ChannelBatchWriter) run() {
+	ticker := time.NewTicker(cbw.opts.FlushInterval)
+	defer ticker.Stop()
+	sem := make(chan struct{}, cbw.opts.MaxInFlight)
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var closeErr error
+	closing := false
+
+	batch := make([]Point, 0, cbw.opts.BatchSize)
+	dispatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		points := batch
+		final := closing
+		batch = make([]Point, 0, cbw.opts.BatchSize)
+		sem <- struct{}{}
+		cbw.inFlight.Add(1)
+		wg.Add(1)
+		go func() {
+			defer func() {
+				cbw.inFlight.Add(-1)
+				<-sem
+				wg.Done()
+			}()
+			if err := cbw.writeBatch(points); err != nil && final {
+				errMu.Lock()
+				closeErr = err
+				errMu.Unlock()
+			}
+		}()
+	}
+
+	for {
+		select {
+		case p, ok := <-cbw.points:
+			if !ok {
+				closing = true
+				dispatch()
+				wg.Wait()
+				cbw.doneCh <- closeErr
+				return
+			}
+			batch = append(batch, p)
+			if len(batch) >= cbw.opts.BatchSize {
+				dispatch()
+			}
+		case <-ticker.C:
+			dispatch()
+		}
+	}
+}
+
+// writeBatch writes one batch, queueing it on disk if the backend is unavailable.
+func (cbw *ChannelBatchWriter) writeBatch(points []Point) error {
+	if cbw.queue != nil && cbw.queue.Len() > 0 {
+		// Older batches are still waiting to be replayed; queue behind them
+		// to keep write order.
+		return cbw.enqueue(points)
+	}
+	err := cbw.write(cbw.writeCtx, points)
+	if err == nil {
+		cbw.written.Add(int64(len(points)))
+		return nil
+	}
+	cbw.failed.Add(int64(len(points)))
+	log.Printf("STORE: Error writing batch of %d points: %v", len(points), err)
+	if cbw.queue == nil || IsRejected(err) {
+		cbw.dropped.Add(int64(len(points)))
+		return err
+	}
+	return cbw.enqueue(points)
+}
+
+// write writes points to the store, giving up after the write timeout or
+// once ctx is cancelled.
+func (cbw *ChannelBatchWriter) write(ctx context.Context, points []Point) error {
+	ctx, cancel := context.WithTimeout(ctx, cbw.opts.WriteTimeout)
+	defer cancel()
+	return cbw.store.WritePoints(ctx, points)
+}
+
+// enqueue persists points to the write-ahead queue and wakes the replayer.
+func (cbw *ChannelBatchWriter) enqueue(points []Point) error {
+	records, err := EncodeLineProtocol(points)
+	if err != nil {
+		cbw.dropped.Add(int64(len(points)))
+		return fmt.Errorf("encoding batch for write-ahead queue: %w", err)
+	}
+	if err := cbw.queue.Append(records); err != nil {
+		log.Printf("STORE: Error persisting %d points to write-ahead queue: %v", len(points), err)
+		cbw.dropped.Add(int64(len(points)))
+		return err
+	}
+	select {
+	case cbw.replayCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// isClosing reports whether Close has been called.
+func (cbw *ChannelBatchWriter) isClosing() bool {
+	select {
+	case <-cbw.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// replay drains the write-ahead queue oldest batch first, backing off
+// exponentially while the backend keeps failing writes. It stops once the
+// writer is closing. Retries do not count towards PointsFailed: a queued
+// point counts as failed when its first write fails, and again if the
+// backend rejects it and it is discarded.
+func (cbw *ChannelBatchWriter) replay() {
+	defer close(cbw.replayDone)
+	backoff := replayBackoffMin
+	var retryAt time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-cbw.closeCh:
+			return
+		case <-cbw.replayCh:
+			if time.Now().Before(retryAt) {
+				// Still backing off; the timer will retry.
+				continue
+			}
+		case <-timer.C:
+		}
+
+		isolate := false
+		for !cbw.isClosing() {
+			limit := cbw.opts.BatchSize
+			if isolate {
+				// Retry one batch at a time to find the one being rejected.
+				limit = 0
+			}
+			records, names := cbw.queue.Peek(limit)
+			if len(names) == 0 {
+				backoff = replayBackoffMin
+				break
+			}
+			points, err := DecodeLineProtocol(records)
+			if err == nil {
+				err = cbw.write(cbw.replayCtx, points)
+			} else {
+				err = Rejected(err)
+			}
+			if err != nil && !IsRejected(err) {
+				if cbw.isClosing() {
+					return
+				}
+				log.Printf("STORE: Replay of queued points failed, retrying in %s: %v", backoff, err)
+				retryAt = time.Now().Add(backoff)
+				timer.Reset(backoff)
+				backoff *= 2
+				if backoff > replayBackoffMax {
+					backoff = replayBackoffMax
+				}
+				break
+			}
+			if err != nil && len(names) > 1 {
+				isolate = true
+				continue
+			}
+			if err != nil {
+				cbw.failed.Add(int64(len(records)))
+				cbw.dropped.Add(int64(len(records)))
+				log.Printf("STORE: %d queued points rejected, dropping them: %v", len(records), err)
+				cbw.queue.Discard(names)
+				continue
+			}
+			isolate = false
+			if err := cbw.queue.Remove(names); err != nil {
+				log.Printf("STORE: Error removing replayed points from write-ahead queue: %v", err)
+			}
+			cbw.written.Add(int64(len(records)))
+			log.Printf("STORE: Replayed %d queued points.", len(records))
+			backoff = replayBackoffMin
+		}
+	}
+}
@@ -0,0 +1,399 @@
+// file: service/store/batch_test.go
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vtarchitect/wal"
+)
+
+// gatedStore holds every write until its gate is opened, then records the
+// points written.
+type gatedStore struct {
+	Store
+	gate chan struct{}
+
+	mu      sync.Mutex
+	written []Point
+}
+
+func newGatedStore() *gatedStore {
+	return &gatedStore{gate: make(chan struct{})}
+}
+
+func (s *gatedStore) WritePoints(ctx context.Context, points []Point) error {
+	<-s.gate
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, points...)
+	return nil
+}
+
+// sequence returns the seq field of each written point.
+func (s *gatedStore) sequence() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := make([]int64, len(s.written))
+	for i, p := range s.written {
+		seq[i] = p.Fields["seq"].(int64)
+	}
+	return seq
+}
+
+// testOptions gives batches of two points, one write at a time, and an
+// input channel of four points, flushing only full batches.
+func testOptions(overflow OverflowPolicy) BatchWriterOptions {
+	return BatchWriterOptions{BatchSize: 2, FlushInterval: time.Hour, QueueSize: 4, MaxInFlight: 1, Overflow: overflow}
+}
+
+func addPoint(cbw *ChannelBatchWriter, seq int) {
+	t := time.Unix(1700000000, 0).Add(time.Duration(seq) * time.Second)
+	cbw.AddPoint("status_data", map[string]string{"line": "1"}, map[string]interface{}{"seq": int64(seq)}, t)
+}
+
+// eventually waits up to a second for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// saturate adds points until the writer has one batch writing, one waiting
+// for a write slot and a full input channel, and returns the next sequence
+// number.
+func saturate(t *testing.T, cbw *ChannelBatchWriter) int {
+	t.Helper()
+	for seq := 0; seq < 4; seq++ {
+		addPoint(cbw, seq)
+	}
+	eventually(t, "two batches dispatched", func() bool { return len(cbw.points) == 0 && cbw.inFlight.Load() == 1 })
+	for seq := 4; seq < 8; seq++ {
+		addPoint(cbw, seq)
+	}
+	return 8
+}
+
+func TestOverflowBlock(t *testing.T) {
+	st := newGatedStore()
+	cbw := NewChannelBatchWriter(st, testOptions(OverflowBlock), nil)
+	next := saturate(t, cbw)
+
+	added := make(chan struct{})
+	go func() {
+		addPoint(cbw, next)
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("AddPoint returned with the channel full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(st.gate)
+	<-added
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(st.sequence()); got != "[0 1 2 3 4 5 6 7 8]" {
+		t.Errorf("wrote %s", got)
+	}
+	if stats := cbw.Stats(); stats.PointsDropped != 0 || stats.PointsAccepted != 9 || stats.PointsWritten != 9 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	st := newGatedStore()
+	cbw := NewChannelBatchWriter(st, testOptions(OverflowDropOldest), nil)
+	next := saturate(t, cbw)
+	for seq := next; seq < next+3; seq++ {
+		addPoint(cbw, seq)
+	}
+
+	close(st.gate)
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(st.sequence()); got != "[0 1 2 3 7 8 9 10]" {
+		t.Errorf("wrote %s", got)
+	}
+	if stats := cbw.Stats(); stats.PointsDropped != 3 || stats.PointsAccepted != 11 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestOverflowSpill(t *testing.T) {
+	queue, err := wal.Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newGatedStore()
+	cbw := NewChannelBatchWriter(st, testOptions(OverflowSpill), queue)
+	next := saturate(t, cbw)
+	for seq := next; seq < next+3; seq++ {
+		addPoint(cbw, seq)
+	}
+
+	// Each overflow moves a whole batch of the oldest pending points to
+	// the queue: 4 and 5 for point 8, then 6 and 7 for point 10.
+	stats := cbw.Stats()
+	if stats.PointsSpilled != 4 || stats.Queue.Batches != 2 || stats.Queue.Records != 4 || stats.PointsPending != 3 {
+		t.Fatalf("stats %+v", stats)
+	}
+	records, _ := queue.Peek(10)
+	points, err := DecodeLineProtocol(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range points {
+		if seq := p.Fields["seq"].(int64); seq != int64(4+i) {
+			t.Errorf("queued point %d is %d, want %d", i, seq, 4+i)
+		}
+	}
+
+	close(st.gate)
+	eventually(t, "queue replayed", func() bool { return queue.Len() == 0 && len(cbw.points) == 0 && cbw.inFlight.Load() == 0 })
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int64]int)
+	for _, seq := range st.sequence() {
+		seen[seq]++
+	}
+	for seq := int64(0); seq < 11; seq++ {
+		if seen[seq] != 1 {
+			t.Errorf("point %d written %d times", seq, seen[seq])
+		}
+	}
+	// Points newer than the spilled ones queue behind them.
+	last := int64(-1)
+	for _, seq := range st.sequence() {
+		if seq >= 4 {
+			if seq < last {
+				t.Errorf("wrote %v, spilled points out of order", st.sequence())
+				break
+			}
+			last = seq
+		}
+	}
+	if stats := cbw.Stats(); stats.PointsDropped != 0 || stats.PointsAccepted != 11 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestSpillFallsBackWithoutQueue(t *testing.T) {
+	cbw := NewChannelBatchWriter(newGatedStore(), testOptions(OverflowSpill), nil)
+	defer cbw.Close(0)
+	if got := cbw.Stats().Overflow; got != OverflowDropOldest {
+		t.Errorf("overflow %s, want %s", got, OverflowDropOldest)
+	}
+}
+
+// flakyStore fails its first failures writes as if the backend were down,
+// and rejects any batch holding a point whose seq is in reject.
+type flakyStore struct {
+	Store
+	reject map[int64]bool
+
+	mu       sync.Mutex
+	failures int
+	written  []int64
+}
+
+func (s *flakyStore) WritePoints(ctx context.Context, points []Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection refused")
+	}
+	for _, p := range points {
+		if seq := p.Fields["seq"].(int64); s.reject[seq] {
+			return Rejected(fmt.Errorf("point %d invalid", seq))
+		}
+	}
+	for _, p := range points {
+		s.written = append(s.written, p.Fields["seq"].(int64))
+	}
+	return nil
+}
+
+func (s *flakyStore) sequence() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprint(s.written)
+}
+
+// queueBatches appends batches of two points, numbered from 0, to queue.
+func queueBatches(t *testing.T, queue *wal.Queue, batches int) {
+	t.Helper()
+	for b := 0; b < batches; b++ {
+		var points []Point
+		for seq := 2 * b; seq < 2*b+2; seq++ {
+			points = append(points, Point{Measurement: "status_data", Fields: map[string]interface{}{"seq": int64(seq)},
+				Time: time.Unix(1700000000, 0).Add(time.Duration(seq) * time.Second)})
+		}
+		records, err := EncodeLineProtocol(points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := queue.Append(records); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFailedBatchQueuedAndReplayed(t *testing.T) {
+	queue, err := wal.Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &flakyStore{failures: 1}
+	cbw := NewChannelBatchWriter(st, testOptions(OverflowBlock), queue)
+	for seq := 0; seq < 4; seq++ {
+		addPoint(cbw, seq)
+	}
+	eventually(t, "queue replayed", func() bool { return st.sequence() == "[0 1 2 3]" && queue.Len() == 0 })
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cbw.Stats(); stats.PointsFailed != 2 || stats.PointsWritten != 4 || stats.PointsDropped != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestRejectedBatchNotQueued(t *testing.T) {
+	queue, err := wal.Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &flakyStore{reject: map[int64]bool{1: true}}
+	cbw := NewChannelBatchWriter(st, testOptions(OverflowBlock), queue)
+	for seq := 0; seq < 4; seq++ {
+		addPoint(cbw, seq)
+	}
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := st.sequence(); got != "[2 3]" || queue.Len() != 0 {
+		t.Errorf("wrote %s with %d batches queued", got, queue.Len())
+	}
+	if stats := cbw.Stats(); stats.PointsFailed != 2 || stats.PointsDropped != 2 || stats.PointsWritten != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestReplayIsolatesRejectedBatch(t *testing.T) {
+	queue, err := wal.Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queueBatches(t, queue, 3)
+	st := &flakyStore{reject: map[int64]bool{3: true}}
+	// Batches of ten replay all three queued batches in one write, until
+	// the rejection makes the replayer retry them one at a time.
+	opts := testOptions(OverflowBlock)
+	opts.BatchSize = 10
+	cbw := NewChannelBatchWriter(st, opts, queue)
+	eventually(t, "queue replayed", func() bool { return queue.Len() == 0 })
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := st.sequence(); got != "[0 1 4 5]" {
+		t.Errorf("wrote %s", got)
+	}
+	if qs := queue.Stats(); qs.DroppedBatches != 1 || qs.DroppedRecords != 2 {
+		t.Errorf("queue stats %+v", qs)
+	}
+	if stats := cbw.Stats(); stats.PointsFailed != 2 || stats.PointsDropped != 2 || stats.PointsWritten != 4 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestCloseUnblocksAddPoint(t *testing.T) {
+	st := newGatedStore()
+	defer close(st.gate)
+	cbw := NewChannelBatchWriter(st, testOptions(OverflowBlock), nil)
+	next := saturate(t, cbw)
+
+	added := make(chan struct{})
+	go func() {
+		addPoint(cbw, next)
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("AddPoint returned with the channel full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	closed := make(chan error)
+	go func() { closed <- cbw.Close(50 * time.Millisecond) }()
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Error("Close reported success with writes hanging")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return")
+	}
+	<-added
+	if stats := cbw.Stats(); stats.PointsDropped != 1 || stats.PointsAccepted != 8 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+// hangingStore never finishes a write until its context ends.
+type hangingStore struct {
+	Store
+	calls atomic.Int64
+}
+
+func (s *hangingStore) WritePoints(ctx context.Context, points []Point) error {
+	s.calls.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWriteTimeout(t *testing.T) {
+	defer func(min, max time.Duration) { replayBackoffMin, replayBackoffMax = min, max }(replayBackoffMin, replayBackoffMax)
+	replayBackoffMin, replayBackoffMax = time.Millisecond, 2*time.Millisecond
+
+	queue, err := wal.Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &hangingStore{}
+	opts := testOptions(OverflowBlock)
+	opts.WriteTimeout = 10 * time.Millisecond
+	cbw := NewChannelBatchWriter(st, opts, queue)
+	addPoint(cbw, 0)
+	addPoint(cbw, 1)
+	// The timed out batch is queued, and its replay keeps timing out.
+	eventually(t, "replay retried", func() bool { return queue.Len() == 1 && st.calls.Load() >= 4 })
+	if stats := cbw.Stats(); stats.PointsFailed != 2 || stats.PointsDropped != 0 {
+		t.Errorf("retries counted as failures: %+v", stats)
+	}
+
+	start := time.Now()
+	if err := cbw.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > 200*time.Millisecond {
+		t.Errorf("Close waited %s for a hanging replay", waited)
+	}
+	if queue.Len() != 1 {
+		t.Errorf("%d batches queued after Close, want 1", queue.Len())
+	}
+}
@@ -0,0 +1,330 @@
+// file: service/store/filestore/filestore.go
+// Package filestore is an embedded, dependency-free storage backend that keeps
+// points as line protocol in one append-only file per UTC day. It suits small
+// sites without an InfluxDB server; queries scan the files covering their
+// range, so long ranges are slower than on a database.
+package filestore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+)
+
+const dayLayout = "2006-01-02"
+
+// Store keeps points in dir as <YYYY-MM-DD>.lp files.
+type Store struct {
+	dir       string
+	retention time.Duration
+
+	mu         sync.RWMutex
+	prunedDate string
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open opens the file store in dir, creating the directory if needed. Day
+// files older than retention are deleted; zero keeps them forever.
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating file store directory: %w", err)
+	}
+	s := &Store{dir: dir, retention: retention}
+	s.mu.Lock()
+	s.prune(time.Now())
+	s.mu.Unlock()
+	log.Printf("STORE: File store opened at %s", dir)
+	return s, nil
+}
+
+// NewFromConfig opens the file store configured by FILESTORE_DIR (default
+// ./history) and FILESTORE_RETENTION_DAYS (default 90).
+func NewFromConfig(cfg *config.Config) (*Store, error) {
+	dir := cfg.Values["FILESTORE_DIR"]
+	if dir == "" {
+		dir = "./history"
+	}
+	days, err := strconv.Atoi(cfg.Values["FILESTORE_RETENTION_DAYS"])
+	if err != nil || days <= 0 {
+		days = 90
+	}
+	return Open(dir, time.Duration(days)*24*time.Hour)
+}
+
+// WritePoints appends points to the files of the days they fall on, and
+// fsyncs each file before returning.
+func (s *Store) WritePoints(ctx context.Context, points []store.Point) error {
+	records, err := store.EncodeLineProtocol(points)
+	if err != nil {
+		return store.Rejected(err)
+	}
+	byDay := make(map[string][]string)
+	for i, p := range points {
+		day := p.Time.UTC().Format(dayLayout)
+		byDay[day] = append(byDay[day], records[i])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for day, lines := range byDay {
+		if err := s.appendDay(day, lines); err != nil {
+			return err
+		}
+	}
+	s.prune(time.Now())
+	return nil
+}
+
+// appendDay appends lines to one day file. The caller must hold s.mu.
+func (s *Store) appendDay(day string, lines []string) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, day+".lp"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prune deletes day files older than the retention period, at most once per
+// day. The caller must hold s.mu.
+func (s *Store) prune(now time.Time) {
+	today := now.UTC().Format(dayLayout)
+	if s.retention <= 0 || s.prunedDate == today {
+		return
+	}
+	s.prunedDate = today
+	cutoff := now.UTC().Add(-s.retention).Format(dayLayout)
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("STORE: Error reading file store directory: %v", err)
+		return
+	}
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".lp")
+		if !ok || e.IsDir() || day >= cutoff {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, e.Name())); err != nil {
+			log.Printf("STORE: Error removing expired file %s: %v", e.Name(), err)
+			continue
+		}
+		log.Printf("STORE: Removed expired file %s", e.Name())
+	}
+}
+
+// QueryRaw returns every sample matching q, ordered by time.
+func (s *Store) QueryRaw(ctx context.Context, q store.Query) ([]store.Sample, error) {
+	fields := make(map[string]bool, len(q.Fields))
+	for _, f := range q.Fields {
+		fields[f] = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var samples []store.Sample
+	decoder := store.NewLineProtocolDecoder()
+	skipped := 0
+	for day := q.Start.UTC().Truncate(24 * time.Hour); day.Before(q.Stop); day = day.Add(24 * time.Hour) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(filepath.Join(s.dir, day.Format(dayLayout)+".lp"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			points, err := decoder.Decode(line)
+			if err != nil {
+				skipped++
+				continue
+			}
+			for _, p := range points {
+				if !matches(p, q) {
+					continue
+				}
+				for field, value := range p.Fields {
+					if len(fields) > 0 && !fields[field] {
+						continue
+					}
+					samples = append(samples, store.Sample{Time: p.Time, Field: field, Value: value, Tags: p.Tags})
+				}
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if skipped > 0 {
+		log.Printf("STORE: Skipped %d unreadable line(s) in the file store", skipped)
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// matches reports whether a point falls in the measurement, time range and
+// tags selected by q.
+func matches(p store.Point, q store.Query) bool {
+	if p.Measurement != q.Measurement || p.Time.Before(q.Start) || !p.Time.Before(q.Stop) {
+		return false
+	}
+	for k, v := range q.Tags {
+		if p.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AggregateBooleanPercentages returns the percentage of samples in which each
+// boolean field was true.
+func (s *Store) AggregateBooleanPercentages(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	samples, err := s.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.BooleanPercentages(samples), nil
+}
+
+// AggregateFaultCounts counts the false-to-true transitions of each fault
+// field, plus one for a fault already true at the start of the range.
+func (s *Store) AggregateFaultCounts(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	samples, err := s.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.FaultCounts(samples), nil
+}
+
+// AggregateFloatMeans computes the mean value of each float field.
+func (s *Store) AggregateFloatMeans(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	samples, err := s.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.FloatMeans(samples), nil
+}
+
+// GetFloatRange returns the first field in q averaged over windows sized to
+// the query range.
+func (s *Store) GetFloatRange(ctx context.Context, q store.Query) ([]store.TimeValue, error) {
+	if len(q.Fields) == 0 {
+		return nil, fmt.Errorf("no field given for float range query")
+	}
+	q.Fields = q.Fields[:1]
+	series, err := s.GetSeries(ctx, q, store.FloatRangeWindow(q.Stop.Sub(q.Start)), store.AggMean)
+	if err != nil {
+		return nil, err
+	}
+	return series[q.Fields[0]], nil
+}
+
+// GetSeries applies fn to each field in q over windows of length every.
+func (s *Store) GetSeries(ctx context.Context, q store.Query, every time.Duration, fn string) (map[string][]store.TimeValue, error) {
+	if !store.ValidAggregate(fn) {
+		return nil, fmt.Errorf("unknown aggregate function '%s'", fn)
+	}
+	if len(q.Fields) == 0 {
+		return map[string][]store.TimeValue{}, nil
+	}
+	samples, err := s.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.WindowAggregates(samples, every, fn, q.Stop), nil
+}
+
+// GetSystemStatus returns the latest value of each boolean field.
+func (s *Store) GetSystemStatus(ctx context.Context, q store.Query) (map[string]bool, error) {
+	if len(q.Fields) == 0 {
+		return map[string]bool{}, nil
+	}
+	samples, err := s.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return store.LatestBooleans(samples), nil
+}
+
+// LatestSamples returns the most recent sample of each field in q, reading
+// day files back from the end of the range until every field is found.
+func (s *Store) LatestSamples(ctx context.Context, q store.Query) (map[string]store.Sample, error) {
+	latest := make(map[string]store.Sample)
+	for day := q.Stop.UTC().Truncate(24 * time.Hour); q.Start.Before(day.Add(24 * time.Hour)); day = day.Add(-24 * time.Hour) {
+		dq := q
+		if day.After(dq.Start) {
+			dq.Start = day
+		}
+		if end := day.Add(24 * time.Hour); end.Before(dq.Stop) {
+			dq.Stop = end
+		}
+		if len(q.Fields) > 0 {
+			dq.Fields = nil
+			for _, f := range q.Fields {
+				if _, found := latest[f]; !found {
+					dq.Fields = append(dq.Fields, f)
+				}
+			}
+			if len(dq.Fields) == 0 {
+				break
+			}
+		}
+		samples, err := s.QueryRaw(ctx, dq)
+		if err != nil {
+			return nil, err
+		}
+		for field, sample := range store.LatestSamples(samples) {
+			if _, found := latest[field]; !found {
+				latest[field] = sample
+			}
+		}
+	}
+	return latest, nil
+}
+
+// Close releases the store. Writes are durable as soon as WritePoints
+// returns, so there is nothing to flush.
+func (s *Store) Close() error {
+	return nil
+}
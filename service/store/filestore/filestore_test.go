@@ -0,0 +1,152 @@
+// file: service/store/filestore/filestore_test.go
+package filestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+)
+
+var day0 = time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+
+func point(t time.Time, tags map[string]string, fields map[string]interface{}) store.Point {
+	return store.Point{Measurement: "status_data", Tags: tags, Fields: fields, Time: t}
+}
+
+// openWith opens a store without retention and writes points to it.
+func openWith(t *testing.T, points ...store.Point) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WritePoints(context.Background(), points); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRoundTrip(t *testing.T) {
+	line1 := map[string]string{"line": "1"}
+	s := openWith(t,
+		point(day0.Add(23*time.Hour+59*time.Minute), line1, map[string]interface{}{"Run": true, "Speed": 12.5, "Recipe": "Standard"}),
+		point(day0.Add(24*time.Hour+time.Minute), line1, map[string]interface{}{"Run": false, "Parts": int64(7)}),
+		point(day0.Add(24*time.Hour+2*time.Minute), map[string]string{"line": "2"}, map[string]interface{}{"Run": true}),
+	)
+	for _, name := range []string{"2026-10-14.lp", "2026-10-15.lp"} {
+		if _, err := os.Stat(filepath.Join(s.dir, name)); err != nil {
+			t.Errorf("day file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    store.Query
+		want []store.Sample
+	}{
+		{name: "one field across days",
+			q: store.Query{Measurement: "status_data", Start: day0, Stop: day0.Add(48 * time.Hour), Fields: []string{"Run"}, Tags: line1},
+			want: []store.Sample{
+				{Time: day0.Add(23*time.Hour + 59*time.Minute), Field: "Run", Value: true, Tags: line1},
+				{Time: day0.Add(24*time.Hour + time.Minute), Field: "Run", Value: false, Tags: line1},
+			}},
+		{name: "field types",
+			q: store.Query{Measurement: "status_data", Start: day0, Stop: day0.Add(48 * time.Hour), Fields: []string{"Speed", "Recipe", "Parts"}, Tags: line1},
+			want: []store.Sample{
+				{Time: day0.Add(23*time.Hour + 59*time.Minute), Field: "Recipe", Value: "Standard", Tags: line1},
+				{Time: day0.Add(23*time.Hour + 59*time.Minute), Field: "Speed", Value: 12.5, Tags: line1},
+				{Time: day0.Add(24*time.Hour + time.Minute), Field: "Parts", Value: int64(7), Tags: line1},
+			}},
+		{name: "stop is exclusive",
+			q: store.Query{Measurement: "status_data", Start: day0, Stop: day0.Add(24*time.Hour + 2*time.Minute), Fields: []string{"Run"}},
+			want: []store.Sample{
+				{Time: day0.Add(23*time.Hour + 59*time.Minute), Field: "Run", Value: true, Tags: line1},
+				{Time: day0.Add(24*time.Hour + time.Minute), Field: "Run", Value: false, Tags: line1},
+			}},
+		{name: "other measurement",
+			q: store.Query{Measurement: "event_data", Start: day0, Stop: day0.Add(48 * time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.QueryRaw(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Fields of one point come back in map order.
+			if len(got) == 3 && got[0].Field == "Speed" {
+				got[0], got[1] = got[1], got[0]
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i, g := range got {
+				w := tt.want[i]
+				if !g.Time.Equal(w.Time) || g.Field != w.Field || g.Value != w.Value || !reflect.DeepEqual(g.Tags, w.Tags) {
+					t.Errorf("sample %d is %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipsUnreadableLines(t *testing.T) {
+	s := openWith(t, point(day0.Add(time.Hour), nil, map[string]interface{}{"Run": true}))
+	f, err := os.OpenFile(filepath.Join(s.dir, "2026-10-14.lp"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("status_data Run=tru\n\n")
+	f.Close()
+	if err := s.WritePoints(context.Background(), []store.Point{point(day0.Add(2*time.Hour), nil, map[string]interface{}{"Run": false})}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.QueryRaw(context.Background(), store.Query{Measurement: "status_data", Start: day0, Stop: day0.Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != true || got[1].Value != false {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestLatestSamples(t *testing.T) {
+	s := openWith(t,
+		point(day0.Add(time.Hour), nil, map[string]interface{}{"A": true, "B": 1.0}),
+		point(day0.Add(25*time.Hour), nil, map[string]interface{}{"A": false}),
+		point(day0.Add(73*time.Hour), nil, map[string]interface{}{"A": true}),
+	)
+	q := store.Query{Measurement: "status_data", Start: day0, Stop: day0.Add(48 * time.Hour), Fields: []string{"A", "B", "C"}}
+	got, err := s.LatestSamples(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["A"].Value != false || !got["A"].Time.Equal(day0.Add(25*time.Hour)) || got["B"].Value != 1.0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().UTC()
+	old := today.Add(-10 * 24 * time.Hour).Format(dayLayout)
+	recent := today.Add(-2 * 24 * time.Hour).Format(dayLayout)
+	for _, name := range []string{old + ".lp", recent + ".lp", "notes.lp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Open(dir, 5*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{old + ".lp": false, recent + ".lp": true, "notes.lp": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s: exists %v, want %v", name, err == nil, want)
+		}
+	}
+}
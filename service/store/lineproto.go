@@ -0,0 +1,120 @@
+// file: service/store/lineproto.go
+// Line protocol encoding of points, used by the write-ahead queue and the file store
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	lp "github.com/influxdata/line-protocol"
+)
+
+// controlReplacer replaces the whitespace control characters that the encoder
+// escapes but the parser cannot read back.
+var controlReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\f", " ", "\r", " ")
+
+// EncodeLineProtocol converts points to InfluxDB line protocol records, one
+// per point. Line protocol keeps field types, so records decode to the same
+// values. Fields that cannot be encoded, such as NaN floats, are omitted, and
+// tabs and line breaks in names, tags and strings become spaces.
+func EncodeLineProtocol(points []Point) ([]string, error) {
+	var buf bytes.Buffer
+	enc := lp.NewEncoder(&buf)
+	enc.SetFieldTypeSupport(lp.UintSupport)
+	records := make([]string, 0, len(points))
+	for _, p := range points {
+		tags, fields := p.Tags, p.Fields
+		if hasControl(p) {
+			tags = make(map[string]string, len(p.Tags))
+			for k, v := range p.Tags {
+				tags[controlReplacer.Replace(k)] = controlReplacer.Replace(v)
+			}
+			fields = make(map[string]interface{}, len(p.Fields))
+			for k, v := range p.Fields {
+				if str, ok := v.(string); ok {
+					v = controlReplacer.Replace(str)
+				}
+				fields[controlReplacer.Replace(k)] = v
+			}
+		}
+		m, err := lp.New(controlReplacer.Replace(p.Measurement), tags, fields, p.Time)
+		if err != nil {
+			return nil, err
+		}
+		buf.Reset()
+		if _, err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+		records = append(records, strings.TrimSuffix(buf.String(), "\n"))
+	}
+	return records, nil
+}
+
+// hasControl reports whether any tag, field name or string value of p holds a
+// character replaced by controlReplacer.
+func hasControl(p Point) bool {
+	const controls = "\t\n\f\r"
+	for k, v := range p.Tags {
+		if strings.ContainsAny(k, controls) || strings.ContainsAny(v, controls) {
+			return true
+		}
+	}
+	for k, v := range p.Fields {
+		if strings.ContainsAny(k, controls) {
+			return true
+		}
+		if str, ok := v.(string); ok && strings.ContainsAny(str, controls) {
+			return true
+		}
+	}
+	return false
+}
+
+// LineProtocolDecoder decodes line protocol records back into points, reusing
+// its parser between calls.
+type LineProtocolDecoder struct {
+	parser *lp.Parser
+}
+
+// NewLineProtocolDecoder creates a decoder.
+func NewLineProtocolDecoder() *LineProtocolDecoder {
+	return &LineProtocolDecoder{parser: lp.NewParser(lp.NewMetricHandler())}
+}
+
+// Decode parses one or more newline-separated records.
+func (d *LineProtocolDecoder) Decode(records []byte) ([]Point, error) {
+	metrics, err := d.parser.Parse(records)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, 0, len(metrics))
+	for _, m := range metrics {
+		p := Point{
+			Measurement: m.Name(),
+			Fields:      make(map[string]interface{}, len(m.FieldList())),
+			Time:        m.Time(),
+		}
+		if tags := m.TagList(); len(tags) > 0 {
+			p.Tags = make(map[string]string, len(tags))
+			for _, t := range tags {
+				p.Tags[t.Key] = t.Value
+			}
+		}
+		for _, f := range m.FieldList() {
+			p.Fields[f.Key] = f.Value
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// DecodeLineProtocol parses a batch of records, as returned by
+// EncodeLineProtocol, back into points.
+func DecodeLineProtocol(records []string) ([]Point, error) {
+	points, err := NewLineProtocolDecoder().Decode([]byte(strings.Join(records, "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("decoding line protocol: %w", err)
+	}
+	return points, nil
+}
@@ -0,0 +1,113 @@
+// file: service/store/lineproto_test.go
+package store
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLineProtocolRoundTrip(t *testing.T) {
+	ts := time.Date(2026, 10, 14, 6, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		name  string
+		point Point
+		want  Point // the point itself if empty
+	}{
+		{name: "every field type", point: Point{
+			Measurement: "status_data",
+			Fields: map[string]interface{}{
+				"Run":    true,
+				"Faults": int64(-3),
+				"Parts":  uint64(1 << 40),
+				"Speed":  12.5,
+				"Recipe": "Standard",
+			},
+			Time: ts,
+		}},
+		{name: "tags", point: Point{
+			Measurement: "status_data",
+			Tags:        map[string]string{"site": "plant1", "line": "2"},
+			Fields:      map[string]interface{}{"Run": false},
+			Time:        ts,
+		}},
+		{name: "escaped names and values", point: Point{
+			Measurement: "event data,1",
+			Tags:        map[string]string{"machine name": "Filler, 2", "a=b": "c=d"},
+			Fields:      map[string]interface{}{"Floats.Cycle Time": 1.0, "Note": "say \"hi\"\\ok"},
+			Time:        ts,
+		}},
+		{name: "line breaks become spaces", point: Point{
+			Measurement: "event_data",
+			Tags:        map[string]string{"operator": "J.\tSmith"},
+			Fields:      map[string]interface{}{"Note": "first\r\nsecond", "Count\n": int64(1)},
+			Time:        ts,
+		}, want: Point{
+			Measurement: "event_data",
+			Tags:        map[string]string{"operator": "J. Smith"},
+			Fields:      map[string]interface{}{"Note": "first  second", "Count ": int64(1)},
+			Time:        ts,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := EncodeLineProtocol([]Point{tt.point})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("encoded %d records", len(records))
+			}
+			points, err := DecodeLineProtocol(records)
+			if err != nil {
+				t.Fatalf("decoding %q: %v", records[0], err)
+			}
+			if len(points) != 1 {
+				t.Fatalf("decoded %d points from %q", len(points), records[0])
+			}
+			got, want := points[0], tt.want
+			if want.Measurement == "" {
+				want = tt.point
+			}
+			if got.Measurement != want.Measurement || !got.Time.Equal(want.Time) ||
+				!reflect.DeepEqual(got.Tags, want.Tags) || !reflect.DeepEqual(got.Fields, want.Fields) {
+				t.Errorf("decoded %+v from %q, want %+v", got, records[0], want)
+			}
+		})
+	}
+}
+
+func TestLineProtocolBatch(t *testing.T) {
+	ts := time.Date(2026, 10, 14, 6, 0, 0, 0, time.UTC)
+	points := []Point{
+		{Measurement: "status_data", Fields: map[string]interface{}{"seq": int64(1)}, Time: ts},
+		{Measurement: "status_data", Fields: map[string]interface{}{"seq": int64(2), "bad": math.NaN()}, Time: ts.Add(time.Second)},
+		{Measurement: "status_data", Fields: map[string]interface{}{"seq": int64(3)}, Time: ts.Add(2 * time.Second)},
+	}
+	records, err := EncodeLineProtocol(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeLineProtocol(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(points) {
+		t.Fatalf("decoded %d points, want %d", len(decoded), len(points))
+	}
+	for i, p := range decoded {
+		if p.Fields["seq"] != int64(i+1) || !p.Time.Equal(points[i].Time) {
+			t.Errorf("point %d decoded as %+v", i, p)
+		}
+		if _, ok := p.Fields["bad"]; ok {
+			t.Errorf("point %d kept a NaN field", i)
+		}
+	}
+}
+
+func TestDecodeLineProtocolRejectsGarbage(t *testing.T) {
+	if _, err := DecodeLineProtocol([]string{"status_data seq=1i 1700000000000000000", "not line protocol"}); err == nil {
+		t.Error("decoded an invalid record")
+	}
+}
@@ -0,0 +1,70 @@
+// file: service/store/process.go
+package store
+
+import (
+	"log"
+	"time"
+	"vtarchitect/config"
+	"vtarchitect/utils"
+)
+
+// ProcessAndLogChangedData writes only changed fields to the store using the YAML-driven map, recursively.
+// t is the timestamp of the snapshot the data was read in, and tags are attached to the point.
+func ProcessAndLogChangedData(cfg *config.Config, plcData, prev map[string]interface{}, tags map[string]string, t time.Time, batchWriter *ChannelBatchWriter) {
+	measurement := cfg.Values["INFLUXDB_MEASUREMENT"]
+	if measurement == "" {
+		measurement = "status_data"
+	}
+	changed := make(map[string]interface{})
+	utils.CollectChangedFields(plcData, prev, changed, "")
+	if len(changed) == 0 {
+		return // nothing to write
+	}
+	batchWriter.AddPoint(measurement, tags, changed, t)
+	log.Printf("STORE: Buffered changed fields: %s", changed)
+}
+
+// ProcessAndLogFullData writes the full PLC state to the store using the YAML-driven map.
+func ProcessAndLogFullData(cfg *config.Config, plcData map[string]interface{}, tags map[string]string, t time.Time, batchWriter *ChannelBatchWriter) {
+	measurement := cfg.Values["INFLUXDB_MEASUREMENT"]
+	if measurement == "" {
+		measurement = "status_data"
+	}
+	batchWriter.AddPoint(measurement, tags, plcData, t)
+	log.Println("STORE: Buffered full-state write")
+}
+
+// CommStatusField is the boolean field recording whether the data source is
+// delivering fresh data (true) or has gone stale (false).
+const CommStatusField = "comm_status"
+
+// ProcessAndLogCommStatus writes a communication status change to the store.
+func ProcessAndLogCommStatus(cfg *config.Config, ok bool, tags map[string]string, batchWriter *ChannelBatchWriter) {
+	measurement := cfg.Values["INFLUXDB_MEASUREMENT"]
+	if measurement == "" {
+		measurement = "status_data"
+	}
+	batchWriter.AddPoint(measurement, tags, map[string]interface{}{CommStatusField: ok}, time.Now())
+	log.Printf("STORE: Buffered communication status change: %s=%t", CommStatusField, ok)
+}
+
+// ProcessAndLogEvent writes a captured event record to the store. Events are
+// kept apart from the polled data in their own measurement, taken from the
+// event definition or INFLUXDB_EVENT_MEASUREMENT, and tagged with the event
+// name in addition to tags.
+func ProcessAndLogEvent(cfg *config.Config, measurement, name string, tags map[string]string, fields map[string]interface{}, t time.Time, batchWriter *ChannelBatchWriter) {
+	if measurement == "" {
+		measurement = cfg.Values["INFLUXDB_EVENT_MEASUREMENT"]
+	}
+	if measurement == "" {
+		measurement = "event_data"
+	}
+	eventTags := map[string]string{"event": name}
+	for k, v := range tags {
+		if k != "event" {
+			eventTags[k] = v
+		}
+	}
+	batchWriter.AddPoint(measurement, eventTags, fields, t)
+	log.Printf("STORE: Buffered event '%s': %v", name, fields)
+}
@@ -0,0 +1,162 @@
+// file: service/store/store.go
+// Package store defines the storage backend used by the acquisition loops and
+// the API, along with the point, query and result types shared by every
+// backend implementation.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Point is one timestamped set of field values written to a measurement.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// Query selects the data an aggregation or raw query runs over. Only points
+// in Measurement carrying every entry of Tags, with timestamps in
+// [Start, Stop), are considered.
+type Query struct {
+	Bucket      string // InfluxDB bucket; ignored by other backends
+	Measurement string
+	Fields      []string
+	Tags        map[string]string
+	Start       time.Time
+	Stop        time.Time
+}
+
+// Sample is a single field value returned by a raw query.
+type Sample struct {
+	Time  time.Time         `json:"time"`
+	Field string            `json:"field"`
+	Value interface{}       `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// TimeValue is one point of a time series.
+type TimeValue struct {
+	Time  time.Time   `json:"time"`
+	Value interface{} `json:"value"`
+}
+
+// Store is a storage backend for PLC data.
+type Store interface {
+	// WritePoints durably writes a batch of points. Errors wrapped with
+	// Rejected mark data the backend will never accept.
+	WritePoints(ctx context.Context, points []Point) error
+
+	// AggregateBooleanPercentages returns the percentage of samples in which
+	// each boolean field was true.
+	AggregateBooleanPercentages(ctx context.Context, q Query) (map[string]float64, error)
+	// AggregateFaultCounts returns the number of times each fault field
+	// became true, counting a fault already true at the start of the range.
+	AggregateFaultCounts(ctx context.Context, q Query) (map[string]float64, error)
+	// AggregateFloatMeans returns the mean of each float field.
+	AggregateFloatMeans(ctx context.Context, q Query) (map[string]float64, error)
+	// GetFloatRange returns the values of the first field in q averaged over
+	// windows sized to the query range, each stamped with its window end.
+	GetFloatRange(ctx context.Context, q Query) ([]TimeValue, error)
+	// GetSeries applies fn, one of the Agg constants, to each field in q over
+	// consecutive windows of length every aligned to the Unix epoch. Boolean
+	// values count as 1 and 0. Each value is a float64 stamped with its
+	// window end, clipped to q.Stop; empty windows are omitted.
+	GetSeries(ctx context.Context, q Query, every time.Duration, fn string) (map[string][]TimeValue, error)
+	// GetSystemStatus returns the latest value of each boolean field.
+	GetSystemStatus(ctx context.Context, q Query) (map[string]bool, error)
+	// QueryRaw returns every stored sample matching q, ordered by time. An
+	// empty q.Fields matches every field.
+	QueryRaw(ctx context.Context, q Query) ([]Sample, error)
+	// LatestSamples returns the most recent sample of each field in q, for
+	// example the state a field held before some time.
+	LatestSamples(ctx context.Context, q Query) (map[string]Sample, error)
+
+	Close() error
+}
+
+// BooleanSummary summarises the samples of one boolean field over a range,
+// from its first sample on.
+type BooleanSummary struct {
+	FirstTime   time.Time
+	First, Last bool
+	// SecondsTrue is the time the field was true from its first sample to
+	// the end of the range, taking each sample to hold until the next one.
+	SecondsTrue float64
+	// Rises counts false-to-true transitions after the first sample.
+	Rises int
+}
+
+// BooleanSummariser is implemented by stores that keep rollups from which
+// boolean fields can be summarised over long ranges without reading their
+// raw samples.
+type BooleanSummariser interface {
+	// SummariseBooleans summarises the boolean fields of q that have samples
+	// in its range. ok is false if q should be read from raw data instead.
+	SummariseBooleans(ctx context.Context, q Query) (summaries map[string]BooleanSummary, ok bool, err error)
+}
+
+// Window aggregate functions accepted by GetSeries.
+const (
+	AggMean  = "mean"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggFirst = "first"
+	AggLast  = "last"
+)
+
+// ValidAggregate reports whether fn is one of the window aggregate functions.
+func ValidAggregate(fn string) bool {
+	switch fn {
+	case AggMean, AggMin, AggMax, AggFirst, AggLast:
+		return true
+	}
+	return false
+}
+
+// RejectedError marks a write the backend refused because of the data itself,
+// so retrying it can never succeed.
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string { return "write rejected: " + e.Err.Error() }
+
+func (e *RejectedError) Unwrap() error { return e.Err }
+
+// Rejected wraps err as a RejectedError.
+func Rejected(err error) error {
+	return &RejectedError{Err: err}
+}
+
+// IsRejected reports whether err marks a write that should not be retried.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// FloatRangeWindow returns the averaging window GetFloatRange uses for a
+// query range: finer for short ranges, coarser for long ones.
+func FloatRangeWindow(span time.Duration) time.Duration {
+	switch {
+	case span <= time.Hour:
+		return time.Second
+	case span <= 3*time.Hour:
+		return 30 * time.Second
+	case span <= 6*time.Hour:
+		return 10 * time.Second
+	case span <= 24*time.Hour:
+		return time.Minute
+	case span <= 48*time.Hour:
+		return 2 * time.Minute
+	case span <= 72*time.Hour:
+		return 3 * time.Minute
+	case span <= 31*24*time.Hour:
+		return 10 * time.Minute
+	default:
+		return time.Hour
+	}
+}
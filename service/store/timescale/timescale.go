@@ -0,0 +1,556 @@
+// file: service/store/timescale/timescale.go
+// Package timescale is a PostgreSQL/TimescaleDB storage backend. Points are
+// split by value type into hypertables for booleans, faults, floats and text,
+// written with COPY, and aggregated in SQL. Hourly continuous aggregates
+// serve the bulk of long-range float and boolean aggregations.
+package timescale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"vtarchitect/config"
+	"vtarchitect/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Hypertables holding each kind of value, and the hourly continuous
+// aggregates built over them.
+const (
+	booleansTable  = "vt_booleans"
+	faultsTable    = "vt_faults"
+	floatsTable    = "vt_floats"
+	textTable      = "vt_text"
+	booleansHourly = "vt_booleans_1h"
+	floatsHourly   = "vt_floats_1h"
+)
+
+var copyColumns = []string{"time", "measurement", "field", "value", "tags"}
+
+// refreshLag is the end_offset of the continuous aggregate policies: hours
+// closer to now than this are not materialised, so they are read raw.
+const refreshLag = time.Hour
+
+// schema creates the hypertables and continuous aggregates if they do not
+// exist. The aggregates are real-time, so hours not yet materialised are
+// computed from the raw tables when read; the ALTER statements bring views
+// created before that up to date.
+var schema = []string{
+	`CREATE EXTENSION IF NOT EXISTS timescaledb`,
+	hypertable(booleansTable, "BOOLEAN"),
+	hypertable(faultsTable, "BOOLEAN"),
+	hypertable(floatsTable, "DOUBLE PRECISION"),
+	hypertable(textTable, "TEXT"),
+	`CREATE MATERIALIZED VIEW IF NOT EXISTS ` + booleansHourly + ` WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket(INTERVAL '1 hour', time) AS bucket, measurement, field, tags,
+       count(*) FILTER (WHERE value) AS trues, count(*) AS n
+FROM ` + booleansTable + `
+GROUP BY bucket, measurement, field, tags
+WITH NO DATA`,
+	`SELECT add_continuous_aggregate_policy('` + booleansHourly + `',
+  start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour',
+  schedule_interval => INTERVAL '30 minutes', if_not_exists => TRUE)`,
+	`CREATE MATERIALIZED VIEW IF NOT EXISTS ` + floatsHourly + ` WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket(INTERVAL '1 hour', time) AS bucket, measurement, field, tags,
+       sum(value) AS total, count(*) AS n
+FROM ` + floatsTable + `
+GROUP BY bucket, measurement, field, tags
+WITH NO DATA`,
+	`SELECT add_continuous_aggregate_policy('` + floatsHourly + `',
+  start_offset => INTERVAL '3 days', end_offset => INTERVAL '1 hour',
+  schedule_interval => INTERVAL '30 minutes', if_not_exists => TRUE)`,
+	`ALTER MATERIALIZED VIEW ` + booleansHourly + ` SET (timescaledb.materialized_only = false)`,
+	`ALTER MATERIALIZED VIEW ` + floatsHourly + ` SET (timescaledb.materialized_only = false)`,
+}
+
+// hypertable returns the statements creating one value table.
+func hypertable(table, valueType string) string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+  time        TIMESTAMPTZ NOT NULL,
+  measurement TEXT        NOT NULL,
+  field       TEXT        NOT NULL,
+  value       %[2]s       NOT NULL,
+  tags        JSONB       NOT NULL DEFAULT '{}'
+);
+SELECT create_hypertable('%[1]s', 'time', if_not_exists => TRUE);
+CREATE INDEX IF NOT EXISTS %[1]s_field_time ON %[1]s (measurement, field, time DESC);
+CREATE INDEX IF NOT EXISTS %[1]s_tags ON %[1]s USING GIN (tags)`, table, valueType)
+}
+
+// Store is the TimescaleDB implementation of store.Store.
+type Store struct {
+	pool        *pgxpool.Pool
+	isFault     func(field string) bool
+	rollupAfter time.Duration
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open connects to the database at url and creates the schema if needed.
+// isFault decides which boolean fields are stored as faults. Aggregations over
+// ranges of at least rollupAfter read whole hours from the continuous
+// aggregates and only the partial hours at each end from the raw tables.
+func Open(ctx context.Context, url string, isFault func(field string) bool, rollupAfter time.Duration) (*Store, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to TimescaleDB: %w", err)
+	}
+	for _, stmt := range schema {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("creating TimescaleDB schema: %w", err)
+		}
+	}
+	log.Println("STORE: Connected to TimescaleDB, schema ready")
+	return &Store{pool: pool, isFault: isFault, rollupAfter: rollupAfter}, nil
+}
+
+// NewFromConfig opens the database configured by TIMESCALE_URL, using
+// continuous aggregates for ranges of TIMESCALE_ROLLUP_AFTER_HOURS (default
+// 48) or longer.
+func NewFromConfig(ctx context.Context, cfg *config.Config, isFault func(field string) bool) (*Store, error) {
+	url := cfg.Values["TIMESCALE_URL"]
+	if url == "" {
+		return nil, fmt.Errorf("missing required TIMESCALE_URL")
+	}
+	hours, err := strconv.Atoi(cfg.Values["TIMESCALE_ROLLUP_AFTER_HOURS"])
+	if err != nil || hours <= 0 {
+		hours = 48
+	}
+	return Open(ctx, url, isFault, time.Duration(hours)*time.Hour)
+}
+
+// WritePoints copies the field values of points into the hypertables in a
+// single transaction. Values the database refuses as invalid are returned as
+// store.Rejected errors. Hours of the continuous aggregates that the points
+// land in behind the refresh horizon are refreshed straight away, so late
+// points such as replayed batches are not missed by the aggregates.
+func (s *Store) WritePoints(ctx context.Context, points []store.Point) error {
+	rows := make(map[string][][]any)
+	late := make(map[string]*hourRange)
+	horizon := time.Now().Add(-refreshLag)
+	for _, p := range points {
+		tags := p.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		for field, value := range p.Fields {
+			var table string
+			switch v := value.(type) {
+			case bool:
+				table = booleansTable
+				if s.isFault != nil && s.isFault(field) {
+					table = faultsTable
+				}
+			case string:
+				table = textTable
+			default:
+				f, ok := store.AsFloat(v)
+				if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+					continue
+				}
+				table, value = floatsTable, f
+			}
+			rows[table] = append(rows[table], []any{p.Time, p.Measurement, field, value, tags})
+			if view := hourlyViews[table]; view != "" && p.Time.Before(horizon) {
+				if late[view] == nil {
+					late[view] = &hourRange{}
+				}
+				late[view].add(p.Time)
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for table, rs := range rows {
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{table}, copyColumns, pgx.CopyFromRows(rs)); err != nil {
+			return classify(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return classify(err)
+	}
+	for view, hours := range late {
+		// The points are stored; a failed refresh is left to the policy.
+		if _, err := s.pool.Exec(ctx, `CALL refresh_continuous_aggregate($1::regclass, $2::timestamptz, $3::timestamptz)`, view, hours.from, hours.to); err != nil {
+			log.Printf("STORE: Failed to refresh %s from %s to %s for late points: %v", view, hours.from.Format(time.RFC3339), hours.to.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// hourlyViews maps each table to the continuous aggregate built over it.
+var hourlyViews = map[string]string{
+	booleansTable: booleansHourly,
+	floatsTable:   floatsHourly,
+}
+
+// hourRange is the span of whole hours holding a set of times.
+type hourRange struct {
+	from, to time.Time
+}
+
+// add widens r to the hour holding t.
+func (r *hourRange) add(t time.Time) {
+	from := t.Truncate(time.Hour)
+	if r.from.IsZero() || from.Before(r.from) {
+		r.from = from
+	}
+	if to := from.Add(time.Hour); to.After(r.to) {
+		r.to = to
+	}
+}
+
+// classify marks data exceptions and constraint violations as rejected, since
+// retrying them can never succeed.
+func classify(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")) {
+		return store.Rejected(err)
+	}
+	return err
+}
+
+// args collects positional query parameters.
+type args []any
+
+// add appends v and returns its placeholder.
+func (a *args) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// filter returns the WHERE conditions selecting the measurement, fields and
+// tags of q. An empty q.Fields selects every field.
+func filter(q store.Query, a *args) string {
+	conds := []string{"measurement = " + a.add(q.Measurement)}
+	if len(q.Fields) > 0 {
+		conds = append(conds, "field = ANY("+a.add(q.Fields)+")")
+	}
+	if len(q.Tags) > 0 {
+		conds = append(conds, "tags @> "+a.add(q.Tags))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// split divides the query range into the whole hours served by the continuous
+// aggregates, [inner0, inner1), and the partial hours at each end read from
+// the raw tables. Hours within refreshLag of now are read raw too, since the
+// policy has not materialised them. For short ranges inner0 and inner1 are
+// both q.Stop, so the raw tables cover everything.
+func (s *Store) split(q store.Query, now time.Time) (inner0, inner1 time.Time) {
+	inner0 = q.Start.Truncate(time.Hour)
+	if inner0.Before(q.Start) {
+		inner0 = inner0.Add(time.Hour)
+	}
+	inner1 = q.Stop.Truncate(time.Hour)
+	if horizon := now.Add(-refreshLag).Truncate(time.Hour); inner1.After(horizon) {
+		inner1 = horizon
+	}
+	if q.Stop.Sub(q.Start) < s.rollupAfter || !inner0.Before(inner1) {
+		return q.Stop, q.Stop
+	}
+	return inner0, inner1
+}
+
+// rawRange returns the condition selecting the raw rows outside [inner0, inner1).
+func rawRange(q store.Query, inner0, inner1 time.Time, a *args) string {
+	return fmt.Sprintf("((time >= %s AND time < %s) OR (time >= %s AND time < %s))",
+		a.add(q.Start), a.add(inner0), a.add(inner1), a.add(q.Stop))
+}
+
+// AggregateBooleanPercentages returns the percentage of samples in which each
+// boolean field was true.
+func (s *Store) AggregateBooleanPercentages(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	inner0, inner1 := s.split(q, time.Now())
+	var a args
+	f := filter(q, &a)
+	query := fmt.Sprintf(`
+SELECT field, sum(trues)::float8 / sum(n) * 100 FROM (
+  SELECT field, count(*) FILTER (WHERE value) AS trues, count(*) AS n
+  FROM %s WHERE %s AND %s GROUP BY field
+  UNION ALL
+  SELECT field, sum(trues), sum(n)
+  FROM %s WHERE %s AND bucket >= %s AND bucket < %s GROUP BY field
+) x GROUP BY field`,
+		booleansTable, f, rawRange(q, inner0, inner1, &a),
+		booleansHourly, f, a.add(inner0), a.add(inner1))
+	return s.queryFloats(ctx, query, a)
+}
+
+// AggregateFaultCounts counts the false-to-true transitions of each fault
+// field, plus one for a fault already true at the start of the range.
+func (s *Store) AggregateFaultCounts(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	var a args
+	query := fmt.Sprintf(`
+SELECT field, count(*)::float8 FROM (
+  SELECT field, value, lag(value) OVER (PARTITION BY field ORDER BY time) AS prev
+  FROM %s WHERE %s AND time >= %s AND time < %s
+) t WHERE value AND (prev IS NULL OR NOT prev) GROUP BY field`,
+		faultsTable, filter(q, &a), a.add(q.Start), a.add(q.Stop))
+	return s.queryFloats(ctx, query, a)
+}
+
+// AggregateFloatMeans computes the mean value of each float field.
+func (s *Store) AggregateFloatMeans(ctx context.Context, q store.Query) (map[string]float64, error) {
+	if len(q.Fields) == 0 {
+		return map[string]float64{}, nil
+	}
+	inner0, inner1 := s.split(q, time.Now())
+	var a args
+	f := filter(q, &a)
+	query := fmt.Sprintf(`
+SELECT field, sum(total) / sum(n) FROM (
+  SELECT field, sum(value) AS total, count(*) AS n
+  FROM %s WHERE %s AND %s GROUP BY field
+  UNION ALL
+  SELECT field, sum(total), sum(n)
+  FROM %s WHERE %s AND bucket >= %s AND bucket < %s GROUP BY field
+) x GROUP BY field`,
+		floatsTable, f, rawRange(q, inner0, inner1, &a),
+		floatsHourly, f, a.add(inner0), a.add(inner1))
+	return s.queryFloats(ctx, query, a)
+}
+
+// queryFloats runs a query returning (field, value) rows.
+func (s *Store) queryFloats(ctx context.Context, query string, a args) (map[string]float64, error) {
+	rows, err := s.pool.Query(ctx, query, a...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]float64)
+	for rows.Next() {
+		var field string
+		var value float64
+		if err := rows.Scan(&field, &value); err != nil {
+			return nil, err
+		}
+		result[field] = value
+	}
+	return result, rows.Err()
+}
+
+// GetFloatRange returns the first field in q averaged over windows sized to
+// the query range, each stamped with its window end.
+func (s *Store) GetFloatRange(ctx context.Context, q store.Query) ([]store.TimeValue, error) {
+	if len(q.Fields) == 0 {
+		return nil, fmt.Errorf("no field given for float range query")
+	}
+	q.Fields = q.Fields[:1]
+	series, err := s.GetSeries(ctx, q, store.FloatRangeWindow(q.Stop.Sub(q.Start)), store.AggMean)
+	if err != nil {
+		return nil, err
+	}
+	return series[q.Fields[0]], nil
+}
+
+// seriesAggregates maps each window aggregate function to SQL over a value
+// column ordered by a time column.
+var seriesAggregates = map[string]string{
+	store.AggMean:  "avg(value)",
+	store.AggMin:   "min(value)",
+	store.AggMax:   "max(value)",
+	store.AggFirst: "first(value, time)",
+	store.AggLast:  "last(value, time)",
+}
+
+// GetSeries applies fn to each field in q over windows of length every,
+// aligned to the Unix epoch. Booleans and faults count as 1 and 0. Means over
+// windows of whole hours read whole hours from the continuous aggregates.
+func (s *Store) GetSeries(ctx context.Context, q store.Query, every time.Duration, fn string) (map[string][]store.TimeValue, error) {
+	agg, ok := seriesAggregates[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregate function '%s'", fn)
+	}
+	if len(q.Fields) == 0 {
+		return map[string][]store.TimeValue{}, nil
+	}
+	inner0, inner1 := q.Stop, q.Stop
+	if fn == store.AggMean && every%time.Hour == 0 {
+		inner0, inner1 = s.split(q, time.Now())
+	}
+	var a args
+	f := filter(q, &a)
+	bucket := func(col string) string {
+		return fmt.Sprintf("time_bucket(%s::bigint * INTERVAL '1 microsecond', %s, origin => TIMESTAMPTZ '1970-01-01 00:00:00+00')",
+			a.add(every.Microseconds()), col)
+	}
+	// Floats and booleans outside [inner0, inner1) and all faults are read
+	// raw; the continuous aggregates cover the rest.
+	raw := fmt.Sprintf(`
+  SELECT time, field, value FROM %[1]s WHERE %[4]s AND %[5]s
+  UNION ALL
+  SELECT time, field, value::int::float8 FROM %[2]s WHERE %[4]s AND %[5]s
+  UNION ALL
+  SELECT time, field, value::int::float8 FROM %[3]s WHERE %[4]s AND time >= %[6]s AND time < %[7]s`,
+		floatsTable, booleansTable, faultsTable, f, rawRange(q, inner0, inner1, &a), a.add(q.Start), a.add(q.Stop))
+	var query string
+	if fn == store.AggMean {
+		query = fmt.Sprintf(`
+SELECT field, w, sum(total) / sum(n) FROM (
+  SELECT field, %[1]s AS w, sum(value) AS total, count(*) AS n FROM (%[2]s
+  ) v GROUP BY field, w
+  UNION ALL
+  SELECT field, %[3]s AS w, sum(total), sum(n)
+  FROM %[4]s WHERE %[6]s AND bucket >= %[7]s AND bucket < %[8]s GROUP BY field, w
+  UNION ALL
+  SELECT field, %[3]s AS w, sum(trues)::float8, sum(n)
+  FROM %[5]s WHERE %[6]s AND bucket >= %[7]s AND bucket < %[8]s GROUP BY field, w
+) x GROUP BY field, w ORDER BY w`,
+			bucket("time"), raw, bucket("bucket"), floatsHourly, booleansHourly, f, a.add(inner0), a.add(inner1))
+	} else {
+		query = fmt.Sprintf(`
+SELECT field, %s AS w, %s FROM (%s
+) v GROUP BY field, w ORDER BY w`,
+			bucket("time"), agg, raw)
+	}
+
+	rows, err := s.pool.Query(ctx, query, a...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	series := make(map[string][]store.TimeValue)
+	for rows.Next() {
+		var field string
+		var w time.Time
+		var v float64
+		if err := rows.Scan(&field, &w, &v); err != nil {
+			return nil, err
+		}
+		end := w.Add(every)
+		if end.After(q.Stop) {
+			end = q.Stop
+		}
+		series[field] = append(series[field], store.TimeValue{Time: end, Value: v})
+	}
+	return series, rows.Err()
+}
+
+// GetSystemStatus returns the latest value of each boolean field.
+func (s *Store) GetSystemStatus(ctx context.Context, q store.Query) (map[string]bool, error) {
+	if len(q.Fields) == 0 {
+		return map[string]bool{}, nil
+	}
+	var a args
+	query := fmt.Sprintf(`
+SELECT DISTINCT ON (field) field, value
+FROM %s WHERE %s AND time >= %s AND time < %s
+ORDER BY field, time DESC`,
+		booleansTable, filter(q, &a), a.add(q.Start), a.add(q.Stop))
+	rows, err := s.pool.Query(ctx, query, a...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := make(map[string]bool)
+	for rows.Next() {
+		var field string
+		var value bool
+		if err := rows.Scan(&field, &value); err != nil {
+			return nil, err
+		}
+		statuses[field] = value
+	}
+	return statuses, rows.Err()
+}
+
+// QueryRaw returns every sample matching q from all four tables, ordered by time.
+func (s *Store) QueryRaw(ctx context.Context, q store.Query) ([]store.Sample, error) {
+	var samples []store.Sample
+	for _, table := range []string{booleansTable, faultsTable, floatsTable, textTable} {
+		var a args
+		query := fmt.Sprintf(`
+SELECT time, field, value, tags FROM %s
+WHERE %s AND time >= %s AND time < %s ORDER BY time`,
+			table, filter(q, &a), a.add(q.Start), a.add(q.Stop))
+		rows, err := s.pool.Query(ctx, query, a...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var sample store.Sample
+			if err := rows.Scan(&sample.Time, &sample.Field, &sample.Value, &sample.Tags); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if len(sample.Tags) == 0 {
+				sample.Tags = nil
+			}
+			samples = append(samples, sample)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// LatestSamples returns the most recent sample of each field in q from all
+// four tables.
+func (s *Store) LatestSamples(ctx context.Context, q store.Query) (map[string]store.Sample, error) {
+	latest := make(map[string]store.Sample)
+	for _, table := range []string{booleansTable, faultsTable, floatsTable, textTable} {
+		var a args
+		query := fmt.Sprintf(`
+SELECT DISTINCT ON (field) time, field, value, tags FROM %s
+WHERE %s AND time >= %s AND time < %s
+ORDER BY field, time DESC`,
+			table, filter(q, &a), a.add(q.Start), a.add(q.Stop))
+		rows, err := s.pool.Query(ctx, query, a...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var sample store.Sample
+			if err := rows.Scan(&sample.Time, &sample.Field, &sample.Value, &sample.Tags); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if len(sample.Tags) == 0 {
+				sample.Tags = nil
+			}
+			if prev, ok := latest[sample.Field]; !ok || sample.Time.After(prev.Time) {
+				latest[sample.Field] = sample
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// Close closes the connection pool.
+func (s *Store) Close() error {
+	s.pool.Close()
+	return nil
+}
@@ -0,0 +1,132 @@
+// file: service/store/timescale/timescale_test.go
+package timescale
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"vtarchitect/store"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestSplit(t *testing.T) {
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	h := func(hours float64) time.Time { return day.Add(time.Duration(hours * float64(time.Hour))) }
+	s := &Store{rollupAfter: 48 * time.Hour}
+	tests := []struct {
+		name        string
+		start, stop time.Time
+		now         time.Time
+		want0       time.Time
+		want1       time.Time
+	}{
+		{name: "short range reads raw", start: h(0), stop: h(24), want0: h(24), want1: h(24)},
+		{name: "aligned", start: h(0), stop: h(72), want0: h(0), want1: h(72)},
+		{name: "partial hours at each end", start: h(0.5), stop: h(72.25), want0: h(1), want1: h(72)},
+		{name: "exactly the rollup length", start: h(0.5), stop: h(48.5), want0: h(1), want1: h(48)},
+		{name: "just short of the rollup length", start: h(0.5), stop: h(48.4), want0: h(48.4), want1: h(48.4)},
+		{name: "recent hours read raw", start: h(0), stop: h(72), now: h(71.5), want0: h(0), want1: h(70)},
+		{name: "nothing materialised", start: h(0), stop: h(72), now: h(0.5), want0: h(72), want1: h(72)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := tt.now
+			if now.IsZero() {
+				now = h(1000)
+			}
+			got0, got1 := s.split(store.Query{Start: tt.start, Stop: tt.stop}, now)
+			if !got0.Equal(tt.want0) || !got1.Equal(tt.want1) {
+				t.Errorf("split %v to %v, want %v to %v", got0, got1, tt.want0, tt.want1)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		q        store.Query
+		want     string
+		wantArgs args
+	}{
+		{name: "measurement only", q: store.Query{Measurement: "status_data"},
+			want: "measurement = $1", wantArgs: args{"status_data"}},
+		{name: "fields", q: store.Query{Measurement: "status_data", Fields: []string{"A", "B"}},
+			want: "measurement = $1 AND field = ANY($2)", wantArgs: args{"status_data", []string{"A", "B"}}},
+		{name: "fields and tags", q: store.Query{Measurement: "m", Fields: []string{"A"}, Tags: map[string]string{"line": "2"}},
+			want:     "measurement = $1 AND field = ANY($2) AND tags @> $3",
+			wantArgs: args{"m", []string{"A"}, map[string]string{"line": "2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a args
+			if got := filter(tt.q, &a); got != tt.want {
+				t.Errorf("filter %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(a, tt.wantArgs) {
+				t.Errorf("args %v, want %v", a, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRawRange(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 30, 0, 0, time.UTC)
+	q := store.Query{Start: start, Stop: start.Add(72 * time.Hour)}
+	inner0, inner1 := start.Add(30*time.Minute), start.Add(71*time.Hour+30*time.Minute)
+	a := args{"status_data"}
+	want := "((time >= $2 AND time < $3) OR (time >= $4 AND time < $5))"
+	if got := rawRange(q, inner0, inner1, &a); got != want {
+		t.Errorf("range %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(a[1:], args{q.Start, inner0, inner1, q.Stop}) {
+		t.Errorf("args %v", a)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantRejected bool
+	}{
+		{name: "data exception", err: &pgconn.PgError{Code: "22P02"}, wantRejected: true},
+		{name: "constraint violation", err: &pgconn.PgError{Code: "23502"}, wantRejected: true},
+		{name: "wrapped", err: fmt.Errorf("copy: %w", &pgconn.PgError{Code: "22003"}), wantRejected: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}},
+		{name: "not a database error", err: errors.New("timeout")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classify(tt.err)
+			if store.IsRejected(got) != tt.wantRejected {
+				t.Errorf("rejected %v, want %v", store.IsRejected(got), tt.wantRejected)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("%v does not wrap %v", got, tt.err)
+			}
+		})
+	}
+	if classify(nil) != nil {
+		t.Error("nil error classified")
+	}
+}
+
+func TestHourRange(t *testing.T) {
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	var r hourRange
+	r.add(day.Add(5*time.Hour + 10*time.Minute))
+	if !r.from.Equal(day.Add(5*time.Hour)) || !r.to.Equal(day.Add(6*time.Hour)) {
+		t.Errorf("one time: %v to %v", r.from, r.to)
+	}
+	r.add(day.Add(2*time.Hour + 59*time.Minute))
+	r.add(day.Add(9 * time.Hour))
+	r.add(day.Add(4 * time.Hour))
+	if !r.from.Equal(day.Add(2*time.Hour)) || !r.to.Equal(day.Add(10*time.Hour)) {
+		t.Errorf("several times: %v to %v", r.from, r.to)
+	}
+}
@@ -2,6 +2,7 @@
 package utils
 
 import (
+	"context"
 	"strconv"
 	"time"
 	"vtarchitect/config"
@@ -26,3 +27,63 @@ func GetFullWriteInterval(cfg *config.Config) time.Duration {
 	}
 	return time.Duration(intervalMin) * time.Minute
 }
+
+// GetStaleTimeout retrieves how long Modbus registers may go without a master
+// write before the data is considered stale (in seconds).
+func GetStaleTimeout(cfg *config.Config) time.Duration {
+	timeoutStr := cfg.Values["MODBUS_STALE_SECONDS"]
+	timeoutSec, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeoutSec <= 0 {
+		timeoutSec = 30 // default to 30 seconds
+	}
+	return time.Duration(timeoutSec) * time.Second
+}
+
+// GetShutdownTimeout retrieves how long the service waits for in-flight work
+// to finish during shutdown (in seconds).
+func GetShutdownTimeout(cfg *config.Config) time.Duration {
+	timeoutStr := cfg.Values["SHUTDOWN_TIMEOUT_SECONDS"]
+	timeoutSec, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeoutSec <= 0 {
+		timeoutSec = 10 // default to 10 seconds
+	}
+	return time.Duration(timeoutSec) * time.Second
+}
+
+// GetStateLookback retrieves how far before a range start the service looks
+// for the state each field held when the range began (in hours).
+func GetStateLookback(cfg *config.Config) time.Duration {
+	lookbackStr := cfg.Values["STATE_LOOKBACK_HOURS"]
+	lookbackHours, err := strconv.Atoi(lookbackStr)
+	if err != nil || lookbackHours <= 0 {
+		lookbackHours = 24 // default to 24 hours
+	}
+	return time.Duration(lookbackHours) * time.Hour
+}
+
+// GetMaxRawSpan retrieves the longest range endpoints computing from raw
+// samples may cover (in hours).
+func GetMaxRawSpan(cfg *config.Config) time.Duration {
+	spanStr := cfg.Values["RAW_QUERY_MAX_HOURS"]
+	spanHours, err := strconv.Atoi(spanStr)
+	if err != nil || spanHours <= 0 {
+		spanHours = 24 // default to 24 hours
+	}
+	return time.Duration(spanHours) * time.Hour
+}
+
+// SleepContext pauses for d or until ctx is cancelled. It reports whether the
+// full duration elapsed.
+func SleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,45 @@
+// file: service/utils/timing_test.go
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSleepContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name        string
+		ctx         func() context.Context
+		d           time.Duration
+		want        bool
+		maxDuration time.Duration
+	}{
+		{name: "full duration", ctx: context.Background, d: 20 * time.Millisecond, want: true, maxDuration: time.Second},
+		{name: "zero duration", ctx: context.Background, want: true, maxDuration: 100 * time.Millisecond},
+		{name: "already cancelled", ctx: func() context.Context { return cancelled }, d: time.Hour, maxDuration: 100 * time.Millisecond},
+		{name: "zero duration already cancelled", ctx: func() context.Context { return cancelled }, maxDuration: 100 * time.Millisecond},
+		{name: "cancelled while sleeping", ctx: func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			return ctx
+		}, d: time.Hour, maxDuration: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			if got := SleepContext(tt.ctx(), tt.d); got != tt.want {
+				t.Errorf("returned %t, want %t", got, tt.want)
+			}
+			elapsed := time.Since(start)
+			if elapsed > tt.maxDuration {
+				t.Errorf("took %s", elapsed)
+			}
+			if tt.want && elapsed < tt.d {
+				t.Errorf("woke after %s, want %s", elapsed, tt.d)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// file: service/wal/wal.go
+// Package wal provides a durable on-disk FIFO queue of write batches, used to
+// hold data while the database is unreachable.
+package wal
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vtarchitect/config"
+)
+
+// Stats describes the current state of a Queue.
+type Stats struct {
+	Enabled          bool    `json:"enabled"`
+	Batches          int     `json:"batches"`
+	Records          int     `json:"records"`
+	Bytes            int64   `json:"bytes"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	DroppedBatches   int64   `json:"dropped_batches"`
+	DroppedRecords   int64   `json:"dropped_records"`
+}
+
+// segment is one batch stored as a file named <enqueue-unix-nanos>-<records>.wal.
+type segment struct {
+	name    string
+	size    int64
+	created time.Time
+	records int
+}
+
+// Queue is a bounded FIFO of batches persisted one file per batch. Each batch
+// is fsynced before Append returns, so queued data survives a restart. When
+// the queue grows past its size limit, or batches outlive the age limit, the
+// oldest batches are dropped and counted.
+type Queue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu             sync.Mutex
+	segments       []segment
+	bytes          int64
+	lastNanos      int64
+	droppedBatches int64
+	droppedRecords int64
+}
+
+// Open opens the queue in dir, creating the directory if needed and loading
+// any batches left from a previous run. A maxBytes or maxAge of zero disables
+// that limit.
+func Open(dir string, maxBytes int64, maxAge time.Duration) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating queue directory: %w", err)
+	}
+	q := &Queue{dir: dir, maxBytes: maxBytes, maxAge: maxAge}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading queue directory: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, ".wal.tmp") {
+			// Interrupted append; the batch was never acknowledged.
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, ".wal") {
+			continue
+		}
+		seg, ok := parseSegmentName(name)
+		if !ok {
+			log.Printf("WAL: Ignoring unrecognised file %s", name)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		seg.size = info.Size()
+		q.segments = append(q.segments, seg)
+		q.bytes += seg.size
+		if n := seg.created.UnixNano(); n > q.lastNanos {
+			q.lastNanos = n
+		}
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].name < q.segments[j].name })
+	q.mu.Lock()
+	q.enforceLimits()
+	q.mu.Unlock()
+	if len(q.segments) > 0 {
+		log.Printf("WAL: Recovered %d pending batch(es) from %s", len(q.segments), dir)
+	}
+	return q, nil
+}
+
+// NewFromConfig opens the queue configured by WAL_DIR (default ./queue),
+// WAL_MAX_MB (default 256) and WAL_MAX_AGE_HOURS (default 168).
+func NewFromConfig(cfg *config.Config) (*Queue, error) {
+	dir := cfg.Values["WAL_DIR"]
+	if dir == "" {
+		dir = "./queue"
+	}
+	maxMB, err := strconv.Atoi(cfg.Values["WAL_MAX_MB"])
+	if err != nil || maxMB <= 0 {
+		maxMB = 256
+	}
+	maxAgeHours, err := strconv.Atoi(cfg.Values["WAL_MAX_AGE_HOURS"])
+	if err != nil || maxAgeHours <= 0 {
+		maxAgeHours = 168
+	}
+	return Open(dir, int64(maxMB)<<20, time.Duration(maxAgeHours)*time.Hour)
+}
+
+// parseSegmentName extracts the enqueue time and record count from a file name.
+func parseSegmentName(name string) (segment, bool) {
+	base := strings.TrimSuffix(name, ".wal")
+	parts := strings.SplitN(base, "-", 2)
+	if len(parts) != 2 {
+		return segment{}, false
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return segment{}, false
+	}
+	records, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return segment{}, false
+	}
+	return segment{name: name, created: time.Unix(0, nanos), records: records}, true
+}
+
+// Append durably stores a batch of records at the back of the queue.
+// Records must not contain newlines.
+func (q *Queue) Append(records []string) error {
+	if len(records) == 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	nanos := time.Now().UnixNano()
+	if nanos <= q.lastNanos {
+		nanos = q.lastNanos + 1
+	}
+	q.lastNanos = nanos
+	name := fmt.Sprintf("%020d-%d.wal", nanos, len(records))
+	payload := []byte(strings.Join(records, "\n") + "\n")
+
+	tmp := filepath.Join(q.dir, name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(payload); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	q.syncDir()
+
+	q.segments = append(q.segments, segment{
+		name:    name,
+		size:    int64(len(payload)),
+		created: time.Unix(0, nanos),
+		records: len(records),
+	})
+	q.bytes += int64(len(payload))
+	q.enforceLimits()
+	return nil
+}
+
+// Peek returns the records of the oldest batches without removing them,
+// combining consecutive batches while the total stays within maxRecords. At
+// least one batch is returned if the queue is not empty; names identifies
+// the batches included, for Remove or Discard, and is empty if the queue is
+// empty. Unreadable batches are dropped.
+func (q *Queue) Peek(maxRecords int) (records []string, names []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.enforceLimits()
+	for i := 0; i < len(q.segments); {
+		seg := q.segments[i]
+		if i > 0 && len(records)+seg.records > maxRecords {
+			break
+		}
+		segRecords, err := readSegment(filepath.Join(q.dir, seg.name))
+		if err != nil {
+			log.Printf("WAL: Dropping unreadable batch %s: %v", seg.name, err)
+			q.dropAt(i)
+			continue
+		}
+		records = append(records, segRecords...)
+		names = append(names, seg.name)
+		i++
+	}
+	return records, names
+}
+
+// Remove deletes the named batches, typically after they have been replayed.
+// Batches already gone, such as those dropped by the queue limits since they
+// were peeked, are skipped.
+func (q *Queue) Remove(names []string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var firstErr error
+	for _, name := range names {
+		i, ok := q.find(name)
+		if !ok {
+			continue
+		}
+		seg := q.segments[i]
+		q.segments = append(q.segments[:i], q.segments[i+1:]...)
+		q.bytes -= seg.size
+		if err := os.Remove(filepath.Join(q.dir, seg.name)); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	q.syncDir()
+	return firstErr
+}
+
+// Discard deletes the named batches and counts them as dropped, for batches
+// the database will never accept. Batches already gone are skipped.
+func (q *Queue) Discard(names []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, name := range names {
+		if i, ok := q.find(name); ok {
+			q.dropAt(i)
+		}
+	}
+}
+
+// find returns the index of the named batch. Names sort in queue order. The
+// caller must hold q.mu.
+func (q *Queue) find(name string) (int, bool) {
+	i := sort.Search(len(q.segments), func(i int) bool { return q.segments[i].name >= name })
+	return i, i < len(q.segments) && q.segments[i].name == name
+}
+
+// Len returns the number of queued batches.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.segments)
+}
+
+// Stats returns a snapshot of the queue's depth, age and drop counts.
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := Stats{
+		Enabled:        true,
+		Batches:        len(q.segments),
+		Bytes:          q.bytes,
+		DroppedBatches: q.droppedBatches,
+		DroppedRecords: q.droppedRecords,
+	}
+	for _, seg := range q.segments {
+		st.Records += seg.records
+	}
+	if len(q.segments) > 0 {
+		st.OldestAgeSeconds = time.Since(q.segments[0].created).Seconds()
+	}
+	return st
+}
+
+// enforceLimits drops the oldest batches until the queue is within its size
+// and age limits. The caller must hold q.mu.
+func (q *Queue) enforceLimits() {
+	for len(q.segments) > 0 {
+		oldest := q.segments[0]
+		overSize := q.maxBytes > 0 && q.bytes > q.maxBytes
+		overAge := q.maxAge > 0 && time.Since(oldest.created) > q.maxAge
+		if !overSize && !overAge {
+			return
+		}
+		log.Printf("WAL: Queue limit reached, dropping oldest batch %s (%d records)", oldest.name, oldest.records)
+		q.dropAt(0)
+	}
+}
+
+// dropAt removes the batch at index i and counts it as dropped. The caller
+// must hold q.mu.
+func (q *Queue) dropAt(i int) {
+	seg := q.segments[i]
+	q.segments = append(q.segments[:i], q.segments[i+1:]...)
+	q.bytes -= seg.size
+	q.droppedBatches++
+	q.droppedRecords += int64(seg.records)
+	if err := os.Remove(filepath.Join(q.dir, seg.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("WAL: Error removing %s: %v", seg.name, err)
+	}
+}
+
+// syncDir fsyncs the queue directory so renames and removals are durable.
+func (q *Queue) syncDir() {
+	d, err := os.Open(q.dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// readSegment reads the records of one batch file.
+func readSegment(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			records = append(records, line)
+		}
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,187 @@
+// file: service/wal/wal_test.go
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// batch returns n records of the form <prefix>-<i>.
+func batch(prefix string, n int) []string {
+	records := make([]string, n)
+	for i := range records {
+		records[i] = prefix + "-" + string(rune('a'+i))
+	}
+	return records
+}
+
+func mustAppend(t *testing.T, q *Queue, records []string) {
+	t.Helper()
+	if err := q.Append(records); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueReplayOrder(t *testing.T) {
+	q, err := Open(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, q, batch("one", 2))
+	mustAppend(t, q, batch("two", 2))
+	mustAppend(t, q, batch("three", 3))
+
+	tests := []struct {
+		maxRecords int
+		want       []string
+		batches    int
+	}{
+		{4, []string{"one-a", "one-b", "two-a", "two-b"}, 2},
+		{0, []string{"three-a", "three-b", "three-c"}, 1}, // one batch even over the limit
+		{10, nil, 0},
+	}
+	for _, tt := range tests {
+		records, names := q.Peek(tt.maxRecords)
+		if strings.Join(records, ",") != strings.Join(tt.want, ",") || len(names) != tt.batches {
+			t.Fatalf("Peek(%d) = %v in %d batches, want %v in %d", tt.maxRecords, records, len(names), tt.want, tt.batches)
+		}
+		if err := q.Remove(names); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := q.Stats(); st.Batches != 0 || st.Bytes != 0 || st.DroppedBatches != 0 {
+		t.Errorf("after replay: %+v", st)
+	}
+}
+
+func TestQueueSizeLimit(t *testing.T) {
+	record := strings.Repeat("x", 99) // 100 bytes with its newline
+	q, err := Open(t.TempDir(), 250, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		mustAppend(t, q, []string{record})
+	}
+	st := q.Stats()
+	if st.Batches != 2 || st.Bytes != 200 || st.DroppedBatches != 2 || st.DroppedRecords != 2 {
+		t.Errorf("got %+v, want 2 batches of 200 bytes with 2 dropped", st)
+	}
+}
+
+func TestQueueAgeLimit(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-1.wal"), []byte("ancient\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%020d-1.wal", old))
+	if err := os.WriteFile(name, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := Open(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := q.Stats(); st.Batches != 0 || st.DroppedBatches != 2 {
+		t.Errorf("got %+v, want both batches dropped", st)
+	}
+	mustAppend(t, q, []string{"new"})
+	if records, _ := q.Peek(10); len(records) != 1 || records[0] != "new" {
+		t.Errorf("Peek = %v, want [new]", records)
+	}
+}
+
+func TestQueueRecoversAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, q, batch("first", 1))
+	mustAppend(t, q, batch("second", 2))
+	// An append interrupted before its rename was never acknowledged.
+	if err := os.WriteFile(filepath.Join(dir, "99999999999999999999-1.wal.tmp"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, names := q.Peek(10)
+	if strings.Join(records, ",") != "first-a,second-a,second-b" || len(names) != 2 {
+		t.Errorf("recovered %v in %d batches", records, len(names))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "99999999999999999999-1.wal.tmp")); !os.IsNotExist(err) {
+		t.Error("interrupted append was left behind")
+	}
+	// Batches appended after a restart still sort after recovered ones.
+	mustAppend(t, q, batch("third", 1))
+	if records, _ := q.Peek(10); records[len(records)-1] != "third-a" {
+		t.Errorf("Peek = %v, want third-a last", records)
+	}
+}
+
+// TestQueueRemoveAfterLimitDrop checks that batches dropped by the size limit
+// between Peek and Remove do not make Remove delete batches that were never
+// replayed.
+func TestQueueRemoveAfterLimitDrop(t *testing.T) {
+	record := strings.Repeat("x", 99)
+	q, err := Open(t.TempDir(), 300, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, q, []string{"a" + record[1:]})
+	mustAppend(t, q, []string{"b" + record[1:]})
+	_, names := q.Peek(1)
+	if len(names) != 1 {
+		t.Fatalf("peeked %d batches, want 1", len(names))
+	}
+
+	// The replay is slow; meanwhile appends push the peeked batch out.
+	mustAppend(t, q, []string{"c" + record[1:]})
+	mustAppend(t, q, []string{"d" + record[1:]})
+	if err := q.Remove(names); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := q.Peek(10)
+	var firsts []string
+	for _, r := range records {
+		firsts = append(firsts, r[:1])
+	}
+	if got := strings.Join(firsts, ""); got != "bcd" {
+		t.Errorf("queue holds %s, want bcd", got)
+	}
+
+	// Discarding a batch that is already gone counts nothing more.
+	q.Discard(names)
+	if st := q.Stats(); st.Batches != 3 || st.DroppedBatches != 1 {
+		t.Errorf("got %+v, want 3 batches and 1 dropped", st)
+	}
+}
+
+func TestQueueDropsUnreadableBatch(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, q, []string{"lost"})
+	mustAppend(t, q, []string{"kept"})
+	_, names := q.Peek(0)
+	if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+		t.Fatal(err)
+	}
+	records, names := q.Peek(10)
+	if len(names) != 1 || records[0] != "kept" {
+		t.Errorf("Peek = %v, want [kept]", records)
+	}
+	if st := q.Stats(); st.DroppedBatches != 1 {
+		t.Errorf("dropped %d batches, want 1", st.DroppedBatches)
+	}
+}